@@ -1,37 +1,136 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/Dadil/project/config"
 	"github.com/Dadil/project/internal/agent/agent"
-	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"github.com/Dadil/project/internal/agent/grpcqueue"
+	"github.com/Dadil/project/internal/agent/httpqueue"
+	"github.com/Dadil/project/internal/notify"
+	"github.com/Dadil/project/internal/orchestra/domain"
+	"github.com/Dadil/project/internal/storage"
 )
 
 func main() {
-	postgresDB, err := config.NewPostgreSQLDB()
+	// Контекст отменяется по SIGINT/SIGTERM: агенты возвращают незавершенные операции в очередь
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	cfg, err := config.Load(os.Args[1:])
 	if err != nil {
-		log.Fatalf("Failed to initialize PostgreSQL database: %v", err)
+		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
-	err = postgresDB.Ping()
-	if err != nil {
-		log.Fatal("Ошибка при пинге базы данных PostgreSQL:", err)
+	appConfig := cfg.Agents
+
+	var queue agent.Queue
+	// events передает оркестратору смену статусов задач для потоков /expressions/stream
+	var events agent.EventPublisher
+	// shared — общий кеш результатов в базе, через который результаты видит оркестратор
+	var shared domain.SharedResults
+	pollInterval := agent.DefaultPollInterval
+	var wakeup *notify.Signal
+
+	remote := appConfig.OrchestratorURL != "" || appConfig.OrchestratorGRPC != ""
+	if remote && cfg.Auth.AgentToken == "" {
+		log.Fatal("Agent token is required: set AGENT_TOKEN or auth.agent_token")
 	}
 
-	log.Println("Connected to PostgreSQL database")
+	switch {
+	case appConfig.OrchestratorGRPC != "":
+		// Агенты берут операции через gRPC-сервис оркестратора, а подписка
+		// будит их сразу при появлении готовых операций
+		client, err := grpcqueue.Dial(appConfig.OrchestratorGRPC, cfg.Auth.AgentToken)
+		if err != nil {
+			log.Fatalf("Failed to connect to orchestrator: %v", err)
+		}
+		defer client.Close()
+		queue = client
+		events = client
+
+		hostname, _ := os.Hostname()
+		wakeup = notify.NewSignal()
+		go wakeup.Forward(client.Subscribe(ctx, fmt.Sprintf("%s/%d", hostname, os.Getpid())))
+		pollInterval = agent.DefaultListenPollInterval
+		log.Printf("Pulling operations from orchestrator at %s over gRPC", appConfig.OrchestratorGRPC)
+	case appConfig.OrchestratorURL != "":
+		// Агенты берут операции через HTTP API оркестратора и не знают о базе
+		client := httpqueue.New(appConfig.OrchestratorURL, cfg.Auth.AgentToken)
+		queue = client
+		events = client
+		log.Printf("Pulling operations from orchestrator at %s", appConfig.OrchestratorURL)
+	default:
+		// Агенты в отдельном процессе работают с общим хранилищем оркестратора
+		if cfg.Storage.Driver == config.StorageMemory {
+			log.Fatal("Memory storage is not shared between processes: run agents inside the orchestrator or set ORCHESTRATOR_URL")
+		}
 
-	// Convert postgresDB to *sqlx.DB
-	postgresDBx := sqlx.NewDb(postgresDB, "postgres")
+		store, err := storage.Open(ctx, cfg)
+		if err != nil {
+			log.Fatalf("Failed to open %s storage: %v", cfg.Storage.Driver, err)
+		}
+		defer store.Close()
+		queue = store
+		shared = store
+		// С PostgreSQL события уходят уведомлениями базы; SQLite их не передает
+		events, _ = store.(agent.EventPublisher)
 
-	appConfig := config.NewAppConfig()
+		log.Printf("Connected to %s storage", cfg.Storage.Driver)
+
+		// Уведомления о новых операциях будят агентов сразу; без них агенты
+		// находят операции опросом раз в секунду
+		notifications, err := storage.Listen(ctx, cfg)
+		if err != nil {
+			log.Printf("Failed to listen for notifications, falling back to polling: %v", err)
+		} else if notifications != nil {
+			wakeup = notify.NewSignal()
+			go wakeup.Forward(notifications)
+			pollInterval = agent.DefaultListenPollInterval
+		}
+	}
+
+	// Агенты процесса делят один кеш результатов, а работая с базой
+	// напрямую — ещё и общий кеш в базе с оркестратором и другими процессами
+	var cache *domain.ResultCache
+	if cfg.Cache.MaxEntries > 0 {
+		cache = domain.NewResultCache(cfg.Cache.MaxEntries, time.Duration(cfg.Cache.TTL)*time.Second)
+		cache.Shared = shared
+	}
 
 	// Создание и запуск агентов
+	var wg sync.WaitGroup
 	for i := 1; i <= appConfig.NumAgents; i++ {
-		agent := agent.NewAgent(i, postgresDBx, appConfig.WorkersPerAgent, appConfig.DurationMap)
-		go agent.Start()
+		a := agent.NewAgent(i, queue, appConfig.WorkersPerAgent, appConfig.DurationMap)
+		a.Retry = agent.RetryPolicy{
+			MaxAttempts: appConfig.MaxAttempts,
+			Backoff:     time.Duration(appConfig.RetryBackoff) * time.Millisecond,
+		}
+		a.Wakeup = wakeup
+		a.PollInterval = pollInterval
+		a.HeartbeatInterval = time.Duration(appConfig.HeartbeatInterval) * time.Millisecond
+		a.Cache = cache
+		a.Events = events
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.Start(ctx)
+		}()
 	}
 
-	select {}
+	<-ctx.Done()
+	log.Println("Shutting down agents...")
+	wg.Wait()
+	if cache != nil {
+		stats := cache.Stats()
+		log.Printf("Result cache: %d hits, %d misses, %d evictions", stats.Hits, stats.Misses, stats.Evictions)
+	}
+	log.Println("All agents stopped")
 }
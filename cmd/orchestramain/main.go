@@ -1,42 +1,192 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/Dadil/project/config"
+	"github.com/Dadil/project/internal/agent/agent"
 	"github.com/Dadil/project/internal/orchestra/api"
 	"github.com/Dadil/project/internal/orchestra/domain"
-	_ "github.com/lib/pq"
+	"github.com/Dadil/project/internal/orchestra/grpcapi"
+	"github.com/Dadil/project/internal/storage"
+	"google.golang.org/grpc"
 )
 
+// shutdownTimeout — сколько ждать завершения активных запросов при остановке
+const shutdownTimeout = 10 * time.Second
+
+// purgeInterval — период удаления истекших токенов сессий, ключей идемпотентности
+// и записей общего кеша результатов
+const purgeInterval = time.Hour
+
 func main() {
-	// Установка соединения с базой данных PostgreSQL
-	postgresDB, err := config.NewPostgreSQLDB()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(ctx, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	cfg, err := config.Load(os.Args[1:])
 	if err != nil {
-		log.Fatalf("Failed to initialize PostgreSQL database: %v", err)
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	if cfg.Auth.JWTSecret == "" {
+		log.Fatal("JWT secret is required: set JWT_SECRET or auth.jwt_secret")
 	}
 
-	// Проверка соединения с базой данных PostgreSQL
-	err = postgresDB.Ping()
+	store, err := storage.Open(ctx, cfg)
 	if err != nil {
-		log.Fatal("Ошибка при пинге базы данных PostgreSQL:", err)
+		log.Fatalf("Failed to open %s storage: %v", cfg.Storage.Driver, err)
+	}
+	defer store.Close()
+
+	// Через хранилище gRPC-сервиса оркестратор будит подписанных агентов,
+	// когда появляются готовые операции
+	agentService := grpcapi.New(store, store, cfg.Auth.AgentToken)
+	orchestrator := domain.NewOrchestrator(agentService.Tasks(), store, agentService.Agents())
+	orchestrator.MaxLoginAttempts = cfg.Auth.MaxLoginAttempts
+	orchestrator.LockoutDuration = time.Duration(cfg.Auth.LockoutDuration) * time.Second
+	orchestrator.IdempotencyKeyTTL = time.Duration(cfg.Server.IdempotencyKeyTTL) * time.Second
+	// Агенты по gRPC публикуют смену статусов задач в шину событий оркестратора
+	agentService.Events = orchestrator.Events
+
+	// Кеш результатов общий для оркестратора и агентов, запущенных внутри
+	// него. Результаты агентов, работающих с базой напрямую, оркестратор
+	// находит в общем кеше в базе; результаты, присланные по HTTP и gRPC,
+	// он запоминает сам.
+	if cfg.Cache.MaxEntries > 0 {
+		orchestrator.Cache = domain.NewResultCache(cfg.Cache.MaxEntries, time.Duration(cfg.Cache.TTL)*time.Second)
+		if cfg.Storage.Driver != config.StorageMemory {
+			orchestrator.Cache.Shared = store
+		}
+		agentService.Results = orchestrator
+	}
+
+	// Начальное время выполнения операторов берется из конфигурации
+	if err := orchestrator.InitDurations(ctx, cfg.Agents.DurationMap); err != nil {
+		log.Fatalf("Failed to initialize operator durations: %v", err)
+	}
+
+	if cfg.Auth.AdminLogin != "" {
+		if err := orchestrator.EnsureAdmin(ctx, cfg.Auth.AdminLogin, cfg.Auth.AdminPassword); err != nil {
+			log.Fatalf("Failed to create administrator: %v", err)
+		}
 	}
 
-	// Создание экземпляра Orchestrator с использованием PostgreSQL
-	orchestrator := domain.NewOrchestrator(postgresDB)
-	api := api.NewOrchestratorAPI(orchestrator)
+	// Агенты без сигнала жизни переводятся в offline, их операции возвращаются в очередь
+	go orchestrator.WatchAgents(ctx, time.Duration(cfg.Agents.HeartbeatTimeout)*time.Millisecond)
+	// Истекшие refresh-токены, записи отозванных access-токенов и ключи
+	// идемпотентности больше не нужны
+	go orchestrator.PurgeExpired(ctx, purgeInterval)
 
-	// Передача Router в HTTP-сервер
-	http.Handle("/", api.Router)
+	// Хранилище в памяти недоступно другим процессам, поэтому агенты
+	// запускаются внутри оркестратора
+	var agents sync.WaitGroup
+	if cfg.Storage.Driver == config.StorageMemory {
+		for i := 1; i <= cfg.Agents.NumAgents; i++ {
+			embedded := agent.NewAgent(i, store, cfg.Agents.WorkersPerAgent, cfg.Agents.DurationMap)
+			embedded.Retry = agent.RetryPolicy{
+				MaxAttempts: cfg.Agents.MaxAttempts,
+				Backoff:     time.Duration(cfg.Agents.RetryBackoff) * time.Millisecond,
+			}
+			embedded.HeartbeatInterval = time.Duration(cfg.Agents.HeartbeatInterval) * time.Millisecond
+			embedded.Cache = orchestrator.Cache
+			embedded.Events = orchestrator.Events
+			agents.Add(1)
+			go func() {
+				defer agents.Done()
+				embedded.Start(ctx)
+			}()
+		}
+	}
+
+	api := api.NewOrchestratorAPI(orchestrator, cfg.Auth.JWTSecret)
+	// Без токена агенты могут работать только напрямую с хранилищем
+	api.AgentToken = []byte(cfg.Auth.AgentToken)
+	api.AccessTokenTTL = time.Duration(cfg.Auth.AccessTokenTTL) * time.Second
+	api.RefreshTokenTTL = time.Duration(cfg.Auth.RefreshTokenTTL) * time.Second
+	api.MaxBatchSize = cfg.Server.MaxBatchSize
 
 	// Запуск HTTP-сервера
-	serverPort := os.Getenv("SERVER_PORT")
-	if serverPort == "" {
-		serverPort = "8080"
+	serverPort := strconv.Itoa(cfg.Server.Port)
+	server := &http.Server{
+		Addr:    ":" + serverPort,
+		Handler: api.Router,
 	}
+	server.RegisterOnShutdown(api.CloseStreams)
+
+	// Агенты, работающие с PostgreSQL напрямую, публикуют события задач
+	// уведомлениями базы
+	events, err := storage.ListenEvents(ctx, cfg)
+	if err != nil {
+		log.Printf("Failed to listen for task events: %v", err)
+	} else if events != nil {
+		go orchestrator.Events.Forward(events)
+	}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Printf("Запуск сервера на порту %s...", serverPort)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- err
+		}
+	}()
+
+	// gRPC-сервис для агентов
+	var grpcServer *grpc.Server
+	if cfg.Server.GRPCPort > 0 {
+		listener, err := net.Listen("tcp", ":"+strconv.Itoa(cfg.Server.GRPCPort))
+		if err != nil {
+			log.Fatalf("Failed to listen on gRPC port: %v", err)
+		}
+		grpcServer = agentService.GRPC()
+		go func() {
+			log.Printf("Запуск gRPC-сервера на порту %d...", cfg.Server.GRPCPort)
+			if err := grpcServer.Serve(listener); err != nil {
+				serverErr <- err
+			}
+		}()
 
-	log.Printf("Запуск сервера на порту %s...", serverPort)
-	log.Fatal(http.ListenAndServe(":"+serverPort, nil))
+		// Агенты, работающие с PostgreSQL напрямую, тоже делают операции
+		// готовыми; об этом сообщают уведомления базы
+		notifications, err := storage.Listen(ctx, cfg)
+		if err != nil {
+			log.Printf("Failed to listen for notifications: %v", err)
+		} else if notifications != nil {
+			go agentService.Forward(notifications)
+		}
+	}
+
+	select {
+	case err := <-serverErr:
+		log.Fatal(err)
+	case <-ctx.Done():
+	}
+
+	log.Println("Остановка сервера...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error shutting down server: %v", err)
+	}
+	if grpcServer != nil {
+		agentService.Close()
+		grpcServer.GracefulStop()
+	}
+	agents.Wait()
+	log.Println("Сервер остановлен")
 }
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Dadil/project/config"
+	"github.com/Dadil/project/internal/storage"
+	"github.com/Dadil/project/internal/storage/migrate"
+)
+
+const migrateUsage = "usage: orchestramain migrate up|down [N]|status [config flags]"
+
+// runMigrate выполняет подкоманду migrate. args — аргументы после "migrate":
+// команда, для down — необязательное число шагов (по умолчанию 1),
+// затем флаги конфигурации.
+func runMigrate(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+	command, args := args[0], args[1:]
+
+	steps := 1
+	if command == "down" && len(args) > 0 {
+		if n, err := strconv.Atoi(args[0]); err == nil {
+			if n <= 0 {
+				return fmt.Errorf("number of steps must be positive, got %d", n)
+			}
+			steps = n
+			args = args[1:]
+		}
+	}
+	if command != "up" && command != "down" && command != "status" {
+		return fmt.Errorf("unknown migrate command %q\n%s", command, migrateUsage)
+	}
+
+	cfg, err := config.Load(args)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	if cfg.Storage.Driver == config.StorageMemory {
+		return fmt.Errorf("storage driver %q has no schema to migrate", cfg.Storage.Driver)
+	}
+
+	db, err := storage.OpenDB(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := migrate.New(db)
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("Schema is up to date")
+		}
+	case "down":
+		rolledBack, err := migrator.Down(ctx, steps)
+		if err != nil {
+			return err
+		}
+		if len(rolledBack) == 0 {
+			fmt.Println("No migrations to roll back")
+		}
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		printStatus(statuses)
+	}
+	return nil
+}
+
+func printStatus(statuses []migrate.Status) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+	for _, status := range statuses {
+		state, appliedAt := "pending", "-"
+		if status.Applied() {
+			state, appliedAt = "applied", status.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\t%s\n", status.Version, status.Name, state, appliedAt)
+	}
+	w.Flush()
+}
@@ -2,17 +2,114 @@ package config
 
 import (
 	"database/sql"
-	"log"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	_ "github.com/lib/pq"
+	"gopkg.in/yaml.v3"
 )
 
+// Config — конфигурация оркестратора и агентов. Значения собираются
+// по возрастанию приоритета: значения по умолчанию, файл конфигурации
+// (YAML или JSON), переменные окружения, флаги командной строки.
+type Config struct {
+	Storage  StorageConfig  `json:"storage" yaml:"storage"`
+	Database DatabaseConfig `json:"database" yaml:"database"`
+	Server   ServerConfig   `json:"server" yaml:"server"`
+	Agents   AppConfig      `json:"agents" yaml:"agents"`
+	Auth     AuthConfig     `json:"auth" yaml:"auth"`
+	Cache    CacheConfig    `json:"cache" yaml:"cache"`
+}
+
+// Хранилища задач и пользователей
+const (
+	StoragePostgres = "postgres"
+	StorageSQLite   = "sqlite"
+	StorageMemory   = "memory" // только вместе с агентами внутри оркестратора
+)
+
+type StorageConfig struct {
+	Driver     string `json:"driver" yaml:"driver"`
+	SQLitePath string `json:"sqlite_path" yaml:"sqlite_path"`
+}
+
+type DatabaseConfig struct {
+	Host     string `json:"host" yaml:"host"`
+	Port     int    `json:"port" yaml:"port"`
+	User     string `json:"user" yaml:"user"`
+	Password string `json:"password" yaml:"password"`
+	Name     string `json:"name" yaml:"name"`
+	SSLMode  string `json:"sslmode" yaml:"sslmode"`
+}
+
+type ServerConfig struct {
+	Port int `json:"port" yaml:"port"`
+	// GRPCPort — порт gRPC-сервиса для агентов; ноль его отключает
+	GRPCPort int `json:"grpc_port" yaml:"grpc_port"`
+	// MaxBatchSize — наибольшее число выражений в одном POST /add/batch
+	MaxBatchSize int `json:"max_batch_size" yaml:"max_batch_size"`
+	// IdempotencyKeyTTL — сколько секунд хранится ключ Idempotency-Key
+	IdempotencyKeyTTL int `json:"idempotency_key_ttl" yaml:"idempotency_key_ttl"`
+}
+
+type AppConfig struct {
+	NumAgents       int            `json:"num_agents" yaml:"num_agents"`
+	WorkersPerAgent int            `json:"workers_per_agent" yaml:"workers_per_agent"`
+	DurationMap     map[string]int `json:"durations" yaml:"durations"`
+	// Повторы операции после временных ошибок: число попыток (0 — без
+	// ограничения) и пауза перед второй попыткой в миллисекундах
+	MaxAttempts  int `json:"max_attempts" yaml:"max_attempts"`
+	RetryBackoff int `json:"retry_backoff" yaml:"retry_backoff"`
+	// Реестр агентов: период сигнала жизни агента и время без сигнала,
+	// после которого оркестратор считает агента offline, в миллисекундах
+	HeartbeatInterval int `json:"heartbeat_interval" yaml:"heartbeat_interval"`
+	HeartbeatTimeout  int `json:"heartbeat_timeout" yaml:"heartbeat_timeout"`
+	// Адрес оркестратора: если задан, agentmain берет операции через его
+	// HTTP API и не подключается к хранилищу
+	OrchestratorURL string `json:"orchestrator_url" yaml:"orchestrator_url"`
+	// Адрес gRPC-сервиса оркестратора (host:port) — то же, но через gRPC
+	OrchestratorGRPC string `json:"orchestrator_grpc" yaml:"orchestrator_grpc"`
+}
+
+type AuthConfig struct {
+	JWTSecret string `json:"jwt_secret" yaml:"jwt_secret"`
+	// AgentToken — общий секрет оркестратора и агентов, работающих по HTTP
+	AgentToken string `json:"agent_token" yaml:"agent_token"`
+	// Срок действия access-токена (JWT) и refresh-токена сессии в секундах
+	AccessTokenTTL  int `json:"access_token_ttl" yaml:"access_token_ttl"`
+	RefreshTokenTTL int `json:"refresh_token_ttl" yaml:"refresh_token_ttl"`
+	// Администратор, которого оркестратор создает при запуске; существующий
+	// пользователь с этим логином получает роль admin без смены пароля
+	AdminLogin    string `json:"admin_login" yaml:"admin_login"`
+	AdminPassword string `json:"admin_password" yaml:"admin_password"`
+	// Число неудачных попыток входа подряд, после которого вход блокируется
+	// на LockoutDuration секунд; 0 отключает блокировку
+	MaxLoginAttempts int `json:"max_login_attempts" yaml:"max_login_attempts"`
+	LockoutDuration  int `json:"lockout_duration" yaml:"lockout_duration"`
+}
+
+// CacheConfig — кеш результатов вычисления. Оркестратор и каждый процесс
+// агентов держат свой кеш в памяти.
+type CacheConfig struct {
+	// MaxEntries — наибольшее число записей; 0 отключает кеш
+	MaxEntries int `json:"max_entries" yaml:"max_entries"`
+	// TTL — сколько секунд хранится результат
+	TTL int `json:"ttl" yaml:"ttl"`
+}
+
 // Функция для создания нового подключения к базе данных PostgreSQL
-func NewPostgreSQLDB() (*sql.DB, error) {
-	db, err := sql.Open("postgres", "user=postgres password=123456789 dbname=calc sslmode=disable")
+func NewPostgreSQLDB(cfg DatabaseConfig) (*sql.DB, error) {
+	db, err := sql.Open("postgres", cfg.DSN())
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	// Установите максимальное количество открытых соединений
@@ -23,21 +120,447 @@ func NewPostgreSQLDB() (*sql.DB, error) {
 	return db, nil
 }
 
-type AppConfig struct {
-	NumAgents       int
-	WorkersPerAgent int
-	DurationMap     map[string]int
+// DSN возвращает строку подключения к PostgreSQL в формате key=value
+func (c DatabaseConfig) DSN() string {
+	parts := []string{
+		"host=" + quoteDSN(c.Host),
+		"port=" + strconv.Itoa(c.Port),
+		"user=" + quoteDSN(c.User),
+		"password=" + quoteDSN(c.Password),
+		"dbname=" + quoteDSN(c.Name),
+		"sslmode=" + quoteDSN(c.SSLMode),
+	}
+	return strings.Join(parts, " ")
+}
+
+func quoteDSN(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `'`, `\'`)
+	return "'" + value + "'"
 }
 
 func NewAppConfig() *AppConfig {
 	return &AppConfig{
 		NumAgents:       3, // Настройка количества агентов
 		WorkersPerAgent: 5, // Настройка количества воркеров
+		MaxAttempts:     5,
+		RetryBackoff:    1000,
+		// Агент пропускает два сигнала, прежде чем считается offline
+		HeartbeatInterval: 5000,
+		HeartbeatTimeout:  15000,
+		// Время задержки операторов в миллисекундах. Это начальные значения:
+		// после первого запуска оркестратора они хранятся в таблице settings
+		// и меняются через PUT /settings/durations.
 		DurationMap: map[string]int{
-			"+": 40, // Пример времени задержки для сложения
-			"-": 40, // Пример времени задержки для вычитания
-			"*": 40, // Пример времени задержки для умножения
-			"/": 40, // Пример времени задержки для деления
+			"+": 40000, // Пример времени задержки для сложения
+			"-": 40000, // Пример времени задержки для вычитания
+			"*": 40000, // Пример времени задержки для умножения
+			"/": 40000, // Пример времени задержки для деления
+		},
+	}
+}
+
+// Default возвращает конфигурацию по умолчанию для локального запуска
+func Default() *Config {
+	return &Config{
+		Storage: StorageConfig{
+			Driver:     StoragePostgres,
+			SQLitePath: "calc.db",
+		},
+		Database: DatabaseConfig{
+			Host:    "localhost",
+			Port:    5432,
+			User:    "postgres",
+			Name:    "calc",
+			SSLMode: "disable",
 		},
+		Server: ServerConfig{Port: 8080, GRPCPort: 9090, MaxBatchSize: 1000, IdempotencyKeyTTL: 86400},
+		Agents: *NewAppConfig(),
+		Auth: AuthConfig{
+			AccessTokenTTL:   900,     // 15 минут
+			RefreshTokenTTL:  2592000, // 30 дней
+			MaxLoginAttempts: 5,
+			LockoutDuration:  900, // 15 минут
+		},
+		Cache: CacheConfig{MaxEntries: 10000, TTL: 3600},
+	}
+}
+
+// Поддерживаемые операторы в порядке вывода ошибок
+var operators = []string{"+", "-", "*", "/"}
+
+// Операторы и суффиксы их переменных окружения и флагов
+var operatorNames = map[string]string{
+	"+": "add",
+	"-": "sub",
+	"*": "mul",
+	"/": "div",
+}
+
+// Load собирает конфигурацию из файла, окружения и флагов командной строки
+// и проверяет её. Путь к файлу задается флагом -config или переменной
+// CONFIG_FILE; без них файл не читается.
+func Load(args []string) (*Config, error) {
+	cfg := Default()
+
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or JSON configuration file")
+	flags := newFlagValues(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *configFile != "" {
+		if err := cfg.loadFile(*configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+
+	flags.apply(fs, cfg)
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, c)
+	case ".json":
+		err = json.Unmarshal(data, c)
+	default:
+		return fmt.Errorf("unsupported config file format: %s", path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+func (c *Config) loadEnv() error {
+	envString("STORAGE_DRIVER", &c.Storage.Driver)
+	envString("SQLITE_PATH", &c.Storage.SQLitePath)
+	envString("DB_HOST", &c.Database.Host)
+	envString("DB_USER", &c.Database.User)
+	envString("DB_PASSWORD", &c.Database.Password)
+	envString("DB_NAME", &c.Database.Name)
+	envString("DB_SSLMODE", &c.Database.SSLMode)
+	envString("JWT_SECRET", &c.Auth.JWTSecret)
+	envString("AGENT_TOKEN", &c.Auth.AgentToken)
+	envString("ADMIN_LOGIN", &c.Auth.AdminLogin)
+	envString("ADMIN_PASSWORD", &c.Auth.AdminPassword)
+	envString("ORCHESTRATOR_URL", &c.Agents.OrchestratorURL)
+	envString("ORCHESTRATOR_GRPC", &c.Agents.OrchestratorGRPC)
+
+	ints := map[string]*int{
+		"DB_PORT":               &c.Database.Port,
+		"SERVER_PORT":           &c.Server.Port,
+		"MAX_BATCH_SIZE":        &c.Server.MaxBatchSize,
+		"IDEMPOTENCY_TTL_SEC":   &c.Server.IdempotencyKeyTTL,
+		"GRPC_PORT":             &c.Server.GRPCPort,
+		"NUM_AGENTS":            &c.Agents.NumAgents,
+		"WORKERS_PER_AGENT":     &c.Agents.WorkersPerAgent,
+		"MAX_ATTEMPTS":          &c.Agents.MaxAttempts,
+		"RETRY_BACKOFF_MS":      &c.Agents.RetryBackoff,
+		"HEARTBEAT_INTERVAL_MS": &c.Agents.HeartbeatInterval,
+		"HEARTBEAT_TIMEOUT_MS":  &c.Agents.HeartbeatTimeout,
+		"ACCESS_TOKEN_TTL_SEC":  &c.Auth.AccessTokenTTL,
+		"REFRESH_TOKEN_TTL_SEC": &c.Auth.RefreshTokenTTL,
+		"MAX_LOGIN_ATTEMPTS":    &c.Auth.MaxLoginAttempts,
+		"LOCKOUT_DURATION_SEC":  &c.Auth.LockoutDuration,
+		"CACHE_MAX_ENTRIES":     &c.Cache.MaxEntries,
+		"CACHE_TTL_SEC":         &c.Cache.TTL,
+	}
+	for name, target := range ints {
+		if err := envInt(name, target); err != nil {
+			return err
+		}
+	}
+
+	for operator, suffix := range operatorNames {
+		value, ok := os.LookupEnv("DURATION_" + strings.ToUpper(suffix))
+		if !ok {
+			continue
+		}
+		duration, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid DURATION_%s: %w", strings.ToUpper(suffix), err)
+		}
+		c.Agents.setDuration(operator, duration)
+	}
+
+	return nil
+}
+
+func envString(name string, target *string) {
+	if value, ok := os.LookupEnv(name); ok {
+		*target = value
+	}
+}
+
+func envInt(name string, target *int) error {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+	*target = parsed
+	return nil
+}
+
+func (a *AppConfig) setDuration(operator string, duration int) {
+	if a.DurationMap == nil {
+		a.DurationMap = make(map[string]int)
+	}
+	a.DurationMap[operator] = duration
+}
+
+// flagValues — флаги командной строки. Применяются только явно заданные
+// флаги, чтобы значения по умолчанию не перекрывали файл и окружение.
+type flagValues struct {
+	storage, sqlitePath                           string
+	dbHost, dbUser, dbPassword, dbName, dbSSLMode string
+	dbPort, serverPort, numAgents, workers        int
+	maxAttempts, retryBackoff                     int
+	heartbeatInterval, heartbeatTimeout           int
+	jwtSecret, agentToken                         string
+	accessTokenTTL, refreshTokenTTL               int
+	maxLoginAttempts, lockoutDuration             int
+	adminLogin, adminPassword                     string
+	orchestratorURL, orchestratorGRPC             string
+	grpcPort, maxBatchSize, idempotencyKeyTTL     int
+	cacheMaxEntries, cacheTTL                     int
+	durations                                     map[string]*int
+}
+
+func newFlagValues(fs *flag.FlagSet) *flagValues {
+	f := &flagValues{durations: make(map[string]*int)}
+	fs.StringVar(&f.storage, "storage", "", "storage driver: postgres, sqlite or memory")
+	fs.StringVar(&f.sqlitePath, "sqlite-path", "", "path to the SQLite database file")
+	fs.StringVar(&f.dbHost, "db-host", "", "PostgreSQL host")
+	fs.IntVar(&f.dbPort, "db-port", 0, "PostgreSQL port")
+	fs.StringVar(&f.dbUser, "db-user", "", "PostgreSQL user")
+	fs.StringVar(&f.dbPassword, "db-password", "", "PostgreSQL password")
+	fs.StringVar(&f.dbName, "db-name", "", "PostgreSQL database name")
+	fs.StringVar(&f.dbSSLMode, "db-sslmode", "", "PostgreSQL sslmode")
+	fs.IntVar(&f.serverPort, "port", 0, "HTTP server port")
+	fs.IntVar(&f.grpcPort, "grpc-port", 0, "gRPC server port for agents, 0 to disable")
+	fs.IntVar(&f.maxBatchSize, "max-batch-size", 0, "maximum number of expressions in one batch request")
+	fs.IntVar(&f.idempotencyKeyTTL, "idempotency-key-ttl", 0, "idempotency key lifetime in seconds")
+	fs.IntVar(&f.numAgents, "agents", 0, "number of agents")
+	fs.IntVar(&f.workers, "workers", 0, "number of workers per agent")
+	fs.IntVar(&f.maxAttempts, "max-attempts", 0, "attempts per operation before the task is dead, 0 for no limit")
+	fs.IntVar(&f.retryBackoff, "retry-backoff", 0, "delay before the first retry in milliseconds")
+	fs.IntVar(&f.heartbeatInterval, "heartbeat-interval", 0, "agent heartbeat interval in milliseconds")
+	fs.IntVar(&f.heartbeatTimeout, "heartbeat-timeout", 0, "time without heartbeats after which an agent is offline, in milliseconds")
+	fs.StringVar(&f.jwtSecret, "jwt-secret", "", "secret used to sign JWT tokens")
+	fs.StringVar(&f.agentToken, "agent-token", "", "shared secret of agents working over HTTP")
+	fs.IntVar(&f.accessTokenTTL, "access-token-ttl", 0, "access token lifetime in seconds")
+	fs.IntVar(&f.refreshTokenTTL, "refresh-token-ttl", 0, "refresh token lifetime in seconds")
+	fs.IntVar(&f.maxLoginAttempts, "max-login-attempts", 0, "failed logins in a row before the login is locked, 0 to disable")
+	fs.IntVar(&f.lockoutDuration, "lockout-duration", 0, "login lockout duration in seconds")
+	fs.StringVar(&f.adminLogin, "admin-login", "", "login of the administrator created at startup")
+	fs.StringVar(&f.adminPassword, "admin-password", "", "password of the administrator created at startup")
+	fs.StringVar(&f.orchestratorURL, "orchestrator-url", "", "orchestrator address for agents working over HTTP")
+	fs.IntVar(&f.cacheMaxEntries, "cache-max-entries", 0, "maximum number of cached results, 0 to disable the cache")
+	fs.IntVar(&f.cacheTTL, "cache-ttl", 0, "cached result lifetime in seconds")
+	fs.StringVar(&f.orchestratorGRPC, "orchestrator-grpc", "", "orchestrator gRPC address (host:port) for agents working over gRPC")
+	for operator, suffix := range operatorNames {
+		f.durations[operator] = fs.Int("duration-"+suffix, 0, fmt.Sprintf("duration of %s in milliseconds", operator))
+	}
+	return f
+}
+
+func (f *flagValues) apply(fs *flag.FlagSet, c *Config) {
+	fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "storage":
+			c.Storage.Driver = f.storage
+		case "sqlite-path":
+			c.Storage.SQLitePath = f.sqlitePath
+		case "db-host":
+			c.Database.Host = f.dbHost
+		case "db-port":
+			c.Database.Port = f.dbPort
+		case "db-user":
+			c.Database.User = f.dbUser
+		case "db-password":
+			c.Database.Password = f.dbPassword
+		case "db-name":
+			c.Database.Name = f.dbName
+		case "db-sslmode":
+			c.Database.SSLMode = f.dbSSLMode
+		case "port":
+			c.Server.Port = f.serverPort
+		case "grpc-port":
+			c.Server.GRPCPort = f.grpcPort
+		case "max-batch-size":
+			c.Server.MaxBatchSize = f.maxBatchSize
+		case "idempotency-key-ttl":
+			c.Server.IdempotencyKeyTTL = f.idempotencyKeyTTL
+		case "agents":
+			c.Agents.NumAgents = f.numAgents
+		case "workers":
+			c.Agents.WorkersPerAgent = f.workers
+		case "max-attempts":
+			c.Agents.MaxAttempts = f.maxAttempts
+		case "retry-backoff":
+			c.Agents.RetryBackoff = f.retryBackoff
+		case "heartbeat-interval":
+			c.Agents.HeartbeatInterval = f.heartbeatInterval
+		case "heartbeat-timeout":
+			c.Agents.HeartbeatTimeout = f.heartbeatTimeout
+		case "jwt-secret":
+			c.Auth.JWTSecret = f.jwtSecret
+		case "agent-token":
+			c.Auth.AgentToken = f.agentToken
+		case "access-token-ttl":
+			c.Auth.AccessTokenTTL = f.accessTokenTTL
+		case "refresh-token-ttl":
+			c.Auth.RefreshTokenTTL = f.refreshTokenTTL
+		case "max-login-attempts":
+			c.Auth.MaxLoginAttempts = f.maxLoginAttempts
+		case "lockout-duration":
+			c.Auth.LockoutDuration = f.lockoutDuration
+		case "admin-login":
+			c.Auth.AdminLogin = f.adminLogin
+		case "admin-password":
+			c.Auth.AdminPassword = f.adminPassword
+		case "orchestrator-url":
+			c.Agents.OrchestratorURL = f.orchestratorURL
+		case "orchestrator-grpc":
+			c.Agents.OrchestratorGRPC = f.orchestratorGRPC
+		case "cache-max-entries":
+			c.Cache.MaxEntries = f.cacheMaxEntries
+		case "cache-ttl":
+			c.Cache.TTL = f.cacheTTL
+		default:
+			for operator, suffix := range operatorNames {
+				if fl.Name == "duration-"+suffix {
+					c.Agents.setDuration(operator, *f.durations[operator])
+				}
+			}
+		}
+	})
+}
+
+// Validate проверяет согласованность конфигурации
+func (c *Config) Validate() error {
+	var errs []string
+
+	switch c.Storage.Driver {
+	case StoragePostgres:
+		// Параметры подключения нужны только для PostgreSQL
+		if c.Database.Host == "" {
+			errs = append(errs, "database host is required")
+		}
+		if c.Database.Port <= 0 || c.Database.Port > 65535 {
+			errs = append(errs, fmt.Sprintf("invalid database port: %d", c.Database.Port))
+		}
+		if c.Database.User == "" {
+			errs = append(errs, "database user is required")
+		}
+		if c.Database.Name == "" {
+			errs = append(errs, "database name is required")
+		}
+	case StorageSQLite:
+		if c.Storage.SQLitePath == "" {
+			errs = append(errs, "sqlite path is required")
+		}
+	case StorageMemory:
+	default:
+		errs = append(errs, fmt.Sprintf("unknown storage driver: %q", c.Storage.Driver))
+	}
+	if c.Server.Port <= 0 || c.Server.Port > 65535 {
+		errs = append(errs, fmt.Sprintf("invalid server port: %d", c.Server.Port))
+	}
+	if c.Server.GRPCPort < 0 || c.Server.GRPCPort > 65535 {
+		errs = append(errs, fmt.Sprintf("invalid grpc port: %d", c.Server.GRPCPort))
+	}
+	if c.Server.MaxBatchSize < 1 {
+		errs = append(errs, "max batch size must be positive")
+	}
+	if c.Server.IdempotencyKeyTTL <= 0 {
+		errs = append(errs, "idempotency key ttl must be positive")
+	}
+	if c.Agents.NumAgents < 1 {
+		errs = append(errs, "at least one agent is required")
+	}
+	if c.Agents.WorkersPerAgent < 1 {
+		errs = append(errs, "at least one worker per agent is required")
+	}
+	if c.Agents.MaxAttempts < 0 {
+		errs = append(errs, "max attempts must not be negative")
+	}
+	if c.Agents.RetryBackoff < 0 {
+		errs = append(errs, "retry backoff must not be negative")
+	}
+	if c.Agents.HeartbeatInterval <= 0 {
+		errs = append(errs, "heartbeat interval must be positive")
+	} else if c.Agents.HeartbeatTimeout <= c.Agents.HeartbeatInterval {
+		errs = append(errs, "heartbeat timeout must be longer than heartbeat interval")
+	}
+	if c.Auth.AccessTokenTTL <= 0 {
+		errs = append(errs, "access token ttl must be positive")
+	}
+	if c.Auth.RefreshTokenTTL <= 0 {
+		errs = append(errs, "refresh token ttl must be positive")
+	}
+	if c.Auth.MaxLoginAttempts < 0 {
+		errs = append(errs, "max login attempts must not be negative")
+	}
+	if c.Auth.LockoutDuration <= 0 {
+		errs = append(errs, "lockout duration must be positive")
+	}
+	if c.Cache.MaxEntries < 0 {
+		errs = append(errs, "cache max entries must not be negative")
+	}
+	if c.Cache.TTL <= 0 {
+		errs = append(errs, "cache ttl must be positive")
+	}
+	if (c.Auth.AdminLogin == "") != (c.Auth.AdminPassword == "") {
+		errs = append(errs, "admin login and admin password must be set together")
+	}
+	if c.Agents.OrchestratorURL != "" {
+		if u, err := url.Parse(c.Agents.OrchestratorURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Sprintf("invalid orchestrator url: %q", c.Agents.OrchestratorURL))
+		}
+	}
+	if c.Agents.OrchestratorURL != "" && c.Agents.OrchestratorGRPC != "" {
+		errs = append(errs, "set either orchestrator url or orchestrator grpc address, not both")
+	}
+	for _, operator := range operators {
+		duration, ok := c.Agents.DurationMap[operator]
+		if !ok {
+			errs = append(errs, fmt.Sprintf("duration for operator %s is required", operator))
+		} else if duration < 0 {
+			errs = append(errs, fmt.Sprintf("duration for operator %s must not be negative", operator))
+		}
+	}
+	for operator := range c.Agents.DurationMap {
+		if _, ok := operatorNames[operator]; !ok {
+			errs = append(errs, fmt.Sprintf("unknown operator in durations: %s", operator))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New("invalid configuration: " + strings.Join(errs, "; "))
 	}
+	return nil
 }
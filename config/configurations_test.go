@@ -0,0 +1,236 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Dadil/project/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	cfg, err := config.Load(nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, config.StoragePostgres, cfg.Storage.Driver)
+	assert.Equal(t, "localhost", cfg.Database.Host)
+	assert.Equal(t, 5432, cfg.Database.Port)
+	assert.Equal(t, 8080, cfg.Server.Port)
+	assert.Equal(t, 9090, cfg.Server.GRPCPort)
+	assert.Equal(t, 1000, cfg.Server.MaxBatchSize)
+	assert.Equal(t, 86400, cfg.Server.IdempotencyKeyTTL)
+	assert.Equal(t, 3, cfg.Agents.NumAgents)
+	assert.Equal(t, 5, cfg.Agents.WorkersPerAgent)
+	assert.Equal(t, 40000, cfg.Agents.DurationMap["+"])
+	assert.Equal(t, 5, cfg.Agents.MaxAttempts)
+	assert.Equal(t, 1000, cfg.Agents.RetryBackoff)
+	assert.Equal(t, 5000, cfg.Agents.HeartbeatInterval)
+	assert.Equal(t, 15000, cfg.Agents.HeartbeatTimeout)
+	assert.Equal(t, 900, cfg.Auth.AccessTokenTTL)
+	assert.Equal(t, 2592000, cfg.Auth.RefreshTokenTTL)
+	assert.Equal(t, 5, cfg.Auth.MaxLoginAttempts)
+	assert.Equal(t, 900, cfg.Auth.LockoutDuration)
+	assert.Equal(t, 10000, cfg.Cache.MaxEntries)
+	assert.Equal(t, 3600, cfg.Cache.TTL)
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	path := writeFile(t, "config.yaml", `
+database:
+  host: file-host
+  name: file-db
+server:
+  port: 9000
+agents:
+  workers_per_agent: 2
+  max_attempts: 3
+  durations:
+    "+": 1
+`)
+
+	// Окружение перекрывает файл, флаги перекрывают окружение
+	t.Setenv("DB_HOST", "env-host")
+	t.Setenv("SERVER_PORT", "9100")
+	t.Setenv("DURATION_ADD", "5")
+	t.Setenv("RETRY_BACKOFF_MS", "250")
+	t.Setenv("MAX_BATCH_SIZE", "50")
+
+	cfg, err := config.Load([]string{"-config", path, "-port", "9200", "-max-batch-size", "100"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "env-host", cfg.Database.Host, "env should override file")
+	assert.Equal(t, "file-db", cfg.Database.Name, "file should override defaults")
+	assert.Equal(t, 9200, cfg.Server.Port, "flags should override env")
+	assert.Equal(t, 2, cfg.Agents.WorkersPerAgent)
+	assert.Equal(t, 3, cfg.Agents.MaxAttempts)
+	assert.Equal(t, 250, cfg.Agents.RetryBackoff)
+	assert.Equal(t, 100, cfg.Server.MaxBatchSize)
+	assert.Equal(t, 5, cfg.Agents.DurationMap["+"])
+	assert.Equal(t, 40000, cfg.Agents.DurationMap["*"], "durations missing in the file keep defaults")
+}
+
+func TestLoad_JSONFileFromEnv(t *testing.T) {
+	path := writeFile(t, "config.json", `{"auth": {"jwt_secret": "secret"}, "agents": {"num_agents": 7}}`)
+	t.Setenv("CONFIG_FILE", path)
+
+	cfg, err := config.Load(nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, "secret", cfg.Auth.JWTSecret)
+	assert.Equal(t, 7, cfg.Agents.NumAgents)
+}
+
+func TestLoad_Validation(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want string
+	}{
+		{name: "negative duration", args: []string{"-duration-mul", "-1"}, want: "operator * must not be negative"},
+		{name: "no workers", args: []string{"-workers", "0"}, want: "at least one worker"},
+		{name: "bad port", args: []string{"-port", "70000"}, want: "invalid server port"},
+		{name: "bad env number", env: map[string]string{"NUM_AGENTS": "many"}, want: "invalid NUM_AGENTS"},
+		{name: "unknown flag", args: []string{"-unknown"}, want: "flag provided but not defined"},
+		{name: "negative attempts", args: []string{"-max-attempts", "-1"}, want: "max attempts must not be negative"},
+		{name: "negative backoff", env: map[string]string{"RETRY_BACKOFF_MS": "-5"}, want: "retry backoff must not be negative"},
+		{name: "unknown storage", env: map[string]string{"STORAGE_DRIVER": "mysql"}, want: "unknown storage driver"},
+		{name: "empty sqlite path", args: []string{"-storage", "sqlite", "-sqlite-path", ""}, want: "sqlite path is required"},
+		{name: "orchestrator url without scheme", args: []string{"-orchestrator-url", "orchestra:8080"}, want: "invalid orchestrator url"},
+		{name: "zero batch size", env: map[string]string{"MAX_BATCH_SIZE": "0"}, want: "max batch size must be positive"},
+		{name: "zero idempotency key ttl", args: []string{"-idempotency-key-ttl", "0"}, want: "idempotency key ttl must be positive"},
+		{name: "bad grpc port", env: map[string]string{"GRPC_PORT": "-1"}, want: "invalid grpc port"},
+		{name: "zero heartbeat interval", args: []string{"-heartbeat-interval", "0"}, want: "heartbeat interval must be positive"},
+		{name: "short heartbeat timeout", env: map[string]string{"HEARTBEAT_TIMEOUT_MS": "5000"}, want: "heartbeat timeout must be longer"},
+		{name: "zero access token ttl", args: []string{"-access-token-ttl", "0"}, want: "access token ttl must be positive"},
+		{name: "negative refresh token ttl", env: map[string]string{"REFRESH_TOKEN_TTL_SEC": "-1"}, want: "refresh token ttl must be positive"},
+		{name: "negative login attempts", args: []string{"-max-login-attempts", "-1"}, want: "max login attempts must not be negative"},
+		{name: "zero lockout duration", env: map[string]string{"LOCKOUT_DURATION_SEC": "0"}, want: "lockout duration must be positive"},
+		{name: "negative cache size", args: []string{"-cache-max-entries", "-1"}, want: "cache max entries must not be negative"},
+		{name: "zero cache ttl", env: map[string]string{"CACHE_TTL_SEC": "0"}, want: "cache ttl must be positive"},
+		{name: "admin login without password", env: map[string]string{"ADMIN_LOGIN": "admin"}, want: "admin login and admin password must be set together"},
+		{name: "both transports", args: []string{"-orchestrator-url", "http://orchestra:8080", "-orchestrator-grpc", "orchestra:9090"}, want: "not both"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+			_, err := config.Load(test.args)
+			require.Error(t, err)
+			assert.True(t, strings.Contains(err.Error(), test.want), "unexpected error: %v", err)
+		})
+	}
+}
+
+func TestLoad_Storage(t *testing.T) {
+	t.Setenv("STORAGE_DRIVER", "sqlite")
+
+	cfg, err := config.Load([]string{"-sqlite-path", "/tmp/calc.db"})
+	require.NoError(t, err)
+	assert.Equal(t, config.StorageSQLite, cfg.Storage.Driver)
+	assert.Equal(t, "/tmp/calc.db", cfg.Storage.SQLitePath)
+
+	// Без PostgreSQL параметры подключения к нему не проверяются
+	cfg, err = config.Load([]string{"-storage", "memory", "-db-host", ""})
+	require.NoError(t, err)
+	assert.Equal(t, config.StorageMemory, cfg.Storage.Driver)
+
+	_, err = config.Load([]string{"-storage", "postgres", "-db-host", ""})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "database host is required")
+}
+
+func TestLoad_OrchestratorURL(t *testing.T) {
+	t.Setenv("AGENT_TOKEN", "agent-secret")
+
+	cfg, err := config.Load([]string{"-orchestrator-url", "http://orchestra:8080"})
+	require.NoError(t, err)
+	assert.Equal(t, "http://orchestra:8080", cfg.Agents.OrchestratorURL)
+	assert.Equal(t, "agent-secret", cfg.Auth.AgentToken)
+
+	cfg, err = config.Load([]string{"-orchestrator-grpc", "orchestra:9090", "-grpc-port", "0"})
+	require.NoError(t, err)
+	assert.Equal(t, "orchestra:9090", cfg.Agents.OrchestratorGRPC)
+	assert.Equal(t, 0, cfg.Server.GRPCPort)
+}
+
+func TestLoad_Admin(t *testing.T) {
+	path := writeFile(t, "config.yaml", `
+auth:
+  admin_login: root
+  admin_password: file-secret
+`)
+	t.Setenv("ADMIN_PASSWORD", "env-secret")
+
+	cfg, err := config.Load([]string{"-config", path})
+	require.NoError(t, err)
+	assert.Equal(t, "root", cfg.Auth.AdminLogin)
+	assert.Equal(t, "env-secret", cfg.Auth.AdminPassword)
+}
+
+func TestLoad_LoginLockout(t *testing.T) {
+	path := writeFile(t, "config.yaml", `
+auth:
+  max_login_attempts: 3
+  lockout_duration: 60
+`)
+	t.Setenv("LOCKOUT_DURATION_SEC", "120")
+
+	cfg, err := config.Load([]string{"-config", path})
+	require.NoError(t, err)
+	assert.Equal(t, 3, cfg.Auth.MaxLoginAttempts)
+	assert.Equal(t, 120, cfg.Auth.LockoutDuration)
+
+	// 0 отключает блокировку
+	cfg, err = config.Load([]string{"-max-login-attempts", "0"})
+	require.NoError(t, err)
+	assert.Equal(t, 0, cfg.Auth.MaxLoginAttempts)
+}
+
+func TestLoad_Cache(t *testing.T) {
+	path := writeFile(t, "config.yaml", `
+cache:
+  max_entries: 100
+  ttl: 60
+`)
+	t.Setenv("CACHE_TTL_SEC", "120")
+
+	cfg, err := config.Load([]string{"-config", path})
+	require.NoError(t, err)
+	assert.Equal(t, 100, cfg.Cache.MaxEntries)
+	assert.Equal(t, 120, cfg.Cache.TTL)
+
+	// 0 отключает кеш
+	cfg, err = config.Load([]string{"-cache-max-entries", "0"})
+	require.NoError(t, err)
+	assert.Equal(t, 0, cfg.Cache.MaxEntries)
+}
+
+func TestLoad_UnknownOperatorInFile(t *testing.T) {
+	path := writeFile(t, "config.yml", `
+agents:
+  durations:
+    "^": 3
+`)
+
+	_, err := config.Load([]string{"-config", path})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown operator")
+}
+
+func TestDatabaseConfig_DSN(t *testing.T) {
+	dsn := config.DatabaseConfig{Host: "db", Port: 5432, User: "postgres", Password: "it's", Name: "calc", SSLMode: "disable"}.DSN()
+
+	assert.Equal(t, `host='db' port=5432 user='postgres' password='it\'s' dbname='calc' sslmode='disable'`, dsn)
+}
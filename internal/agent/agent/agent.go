@@ -1,93 +1,347 @@
 package agent
 
 import (
-	"hash/fnv"
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"os"
 	"sync"
 	"time"
 
 	"github.com/Dadil/project/internal/agent/expression"
-	"github.com/jmoiron/sqlx"
+	"github.com/Dadil/project/internal/notify"
+	"github.com/Dadil/project/internal/orchestra/domain"
 )
 
-type Task struct {
-	ID         string  `json:"id"`
-	Expression string  `json:"expression"`
-	Status     string  `json:"status"`
-	Result     float64 `json:"result"`
+const (
+	// DefaultLeaseDuration — срок аренды операции. Воркер продлевает аренду,
+	// пока вычисляет операцию; если агент упал, по истечении срока операцию
+	// заберет другой воркер.
+	DefaultLeaseDuration = 30 * time.Second
+	// DefaultPollInterval — пауза между попытками захвата, когда готовых операций нет
+	DefaultPollInterval = time.Second
+	// DefaultListenPollInterval — пауза между проверками очереди, когда агента
+	// будят уведомления хранилища: опрос нужен только для операций с истекшей
+	// арендой и отложенных повторов, о которых уведомлений нет
+	DefaultListenPollInterval = 30 * time.Second
+	// DefaultCheckInterval — как часто воркер во время вычисления проверяет,
+	// что операция всё ещё за ним: задачу могли отменить
+	DefaultCheckInterval = time.Second
+	// DefaultSettingsInterval — как часто агент перечитывает время выполнения операторов
+	DefaultSettingsInterval = 5 * time.Second
+	// DefaultHeartbeatInterval — как часто агент присылает сигнал жизни в реестр агентов
+	DefaultHeartbeatInterval = 5 * time.Second
+	// MaxRetryBackoff — предел паузы между повторными попытками операции
+	MaxRetryBackoff = 5 * time.Minute
+)
+
+// RetryPolicy задает повторные попытки операции после временных ошибок:
+// сбоев хранилища или падения агента во время вычисления. Ошибки самого
+// выражения (деление на ноль) постоянны и сразу переводят задачу в error.
+type RetryPolicy struct {
+	// MaxAttempts — сколько раз операцию можно захватить, прежде чем задача
+	// перейдет в статус dead; ноль снимает ограничение
+	MaxAttempts int
+	// Backoff — пауза перед второй попыткой, каждая следующая вдвое длиннее,
+	// но не больше MaxRetryBackoff
+	Backoff time.Duration
+}
+
+// DefaultRetryPolicy — политика повторов агента по умолчанию
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 5, Backoff: time.Second}
+
+// Delay возвращает паузу перед следующей попыткой после неудачной попытки attempt
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	delay := p.Backoff
+	for i := 1; i < attempt && delay < MaxRetryBackoff; i++ {
+		delay *= 2
+	}
+	if delay > MaxRetryBackoff {
+		delay = MaxRetryBackoff
+	}
+	return delay
+}
+
+// exhausted сообщает, что после attempts захватов новых попыток не будет
+func (p RetryPolicy) exhausted(attempts int) bool {
+	return p.MaxAttempts > 0 && attempts >= p.MaxAttempts
+}
+
+// ErrLeaseLost — аренда операции истекла, и её забрал другой агент
+var ErrLeaseLost = domain.ErrLeaseLost
+
+// Version — версия агента в реестре агентов. Задается при сборке:
+// go build -ldflags "-X github.com/Dadil/project/internal/agent/agent.Version=1.2.0"
+var Version = "dev"
+
+// SupportedOperators возвращает операторы, которые умеет вычислять агент
+func SupportedOperators() []string {
+	return append(append([]string(nil), expression.Operators...), expression.OperatorNegate)
+}
+
+// Queue — хранилище, из которого агент берет операции. Его реализуют
+// все хранилища оркестратора (см. domain.TaskStore).
+type Queue interface {
+	ClaimOperation(ctx context.Context, owner string, lease time.Duration) (*domain.Operation, error)
+	RenewLease(ctx context.Context, operationID, owner string, lease time.Duration) error
+	CompleteOperation(ctx context.Context, operationID, owner string, result float64) error
+	FailOperation(ctx context.Context, operationID, owner, message string) error
+	ReleaseOperation(ctx context.Context, operationID, owner string) error
+	RetryOperation(ctx context.Context, operationID, owner, message string, nextAttemptAt time.Time) error
+	DeadLetterOperation(ctx context.Context, operationID, owner, message string) error
+	GetDurations(ctx context.Context) (map[string]int, error)
+	RegisterAgent(ctx context.Context, agent domain.AgentInfo) error
+	HeartbeatAgent(ctx context.Context, agentID string) error
+}
+
+// EventPublisher принимает события о смене статусов задач. Его реализуют
+// шина событий оркестратора (domain.EventBus) для встроенных агентов,
+// клиенты httpqueue и grpcqueue и хранилище PostgreSQL (sqlstore).
+type EventPublisher interface {
+	PublishTaskEvent(ctx context.Context, event domain.TaskEvent) error
 }
 
 type Agent struct {
 	ID            int
-	Postgres      *sqlx.DB
-	TaskQueues    []chan Task
+	OwnerID       string // уникальное имя агента среди всех процессов, владелец аренды
+	Hostname      string
+	Queue         Queue
 	Workers       int
 	ExecutingLock sync.Map
-	DurationMap   map[string]int
+	DurationMap   map[string]int // время выполнения операторов в миллисекундах
+	LeaseDuration time.Duration
+	PollInterval  time.Duration
+	// CheckInterval — период продления аренды во время вычисления. Если
+	// аренда потеряна (задачу отменили или операцию забрал другой агент),
+	// вычисление прерывается. Ноль означает треть LeaseDuration.
+	CheckInterval time.Duration
+	Retry         RetryPolicy
+	// Wakeup будит простаивающих воркеров при появлении готовых операций,
+	// не дожидаясь PollInterval; nil — только опрос
+	Wakeup *notify.Signal
+	// SettingsInterval — период обновления DurationMap из хранилища;
+	// ноль отключает обновление
+	SettingsInterval time.Duration
+	// HeartbeatInterval — период сигнала жизни в реестре агентов; ноль
+	// отключает регистрацию в реестре
+	HeartbeatInterval time.Duration
+	// Cache — кеш результатов операций, общий для агентов процесса; nil
+	// отключает кеш
+	Cache *domain.ResultCache
+	// Events получает статусы задач, которые меняет агент; nil — события
+	// не публикуются
+	Events EventPublisher
+
+	durationsMu sync.RWMutex
+	// storedDurations — настройки из хранилища без значений конфигурации
+	// агента; по ним считается версия ключей кеша, как у оркестратора
+	storedDurations map[string]int
 }
 
-func NewAgent(id int, postgres *sqlx.DB, workers int, durationMap map[string]int) *Agent {
+func NewAgent(id int, queue Queue, workers int, durationMap map[string]int) *Agent {
 	log.Printf("Initializing agent with ID: %d", id)
-	agent := &Agent{
-		ID:          id,
-		Postgres:    postgres,
-		TaskQueues:  make([]chan Task, workers),
-		Workers:     workers,
-		DurationMap: durationMap,
-	}
 
-	// Инициализируем каналы задач для воркеров
-	for i := 0; i < workers; i++ {
-		agent.TaskQueues[i] = make(chan Task)
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
 	}
 
-	return agent
+	return &Agent{
+		ID:                id,
+		OwnerID:           fmt.Sprintf("%s/%d/%d", hostname, os.Getpid(), id),
+		Hostname:          hostname,
+		Queue:             queue,
+		Workers:           workers,
+		DurationMap:       durationMap,
+		LeaseDuration:     DefaultLeaseDuration,
+		PollInterval:      DefaultPollInterval,
+		CheckInterval:     DefaultCheckInterval,
+		Retry:             DefaultRetryPolicy,
+		SettingsInterval:  DefaultSettingsInterval,
+		HeartbeatInterval: DefaultHeartbeatInterval,
+	}
 }
 
-func (a *Agent) Start() {
+// Start запускает воркеров и блокируется, пока все они не завершатся
+// после отмены ctx.
+func (a *Agent) Start(ctx context.Context) {
 	log.Printf("Agent %d is starting %d workers", a.ID, a.Workers)
-	// Запуск воркеров
+
+	var wg sync.WaitGroup
+	if a.SettingsInterval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.watchDurations(ctx)
+		}()
+	}
+	if a.HeartbeatInterval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.heartbeat(ctx)
+		}()
+	}
+
+	// Запуск воркеров: каждый сам захватывает готовые операции
 	for i := 0; i < a.Workers; i++ {
-		go a.Worker(i) // Передаем индекс воркера в качестве аргумента
+		wg.Add(1)
+		go func(workerID int) {
+			defer wg.Done()
+			a.Worker(ctx, workerID)
+		}(i) // Передаем индекс воркера в качестве аргумента
 	}
 
-	// Начало проверки задач из PostgreSQL
-	go func() {
-		// Бесконечный цикл для периодической проверки
-		for {
-			// Проверяем задачи в базе данных PostgreSQL
-			a.checkTasks()
-			// Ждем 5 секунд перед следующей проверкой
-			time.Sleep(5 * time.Second)
-		}
-	}()
+	wg.Wait()
+	log.Printf("Agent %d stopped", a.ID)
 }
 
-func (a *Agent) Worker(workerID int) {
-	for task := range a.TaskQueues[workerID] {
-		// Пытаемся заблокировать задачу
-		_, err := a.Postgres.Exec("INSERT INTO locks (id, status) VALUES ($1, 'locked') ON CONFLICT(id) DO NOTHING", task.ID)
+func (a *Agent) Worker(ctx context.Context, workerID int) {
+	for ctx.Err() == nil {
+		// Канал пробуждения берется до захвата, чтобы не пропустить
+		// уведомление, пришедшее сразу после пустой попытки
+		var wakeup <-chan struct{}
+		if a.Wakeup != nil {
+			wakeup = a.Wakeup.Wait()
+		}
+
+		op, err := a.ClaimOperation(ctx)
 		if err != nil {
-			log.Printf("Error setting lock for task %s: %v", task.ID, err)
+			if ctx.Err() == nil {
+				log.Printf("Agent %d: Worker %d failed to claim operation: %v", a.ID, workerID, err)
+			}
+			a.wait(ctx, wakeup)
+			continue
+		}
+		if op == nil {
+			// Готовых операций нет — ждем уведомления или следующей попытки
+			a.wait(ctx, wakeup)
 			continue
 		}
 
-		// Помечаем задачу как обрабатываемую этим воркером
-		a.MarkTaskAsBeingProcessed(task.ID)
+		// Помечаем операцию как обрабатываемую этим воркером
+		a.MarkTaskAsBeingProcessed(op.ID)
 
-		log.Printf("Agent %d: Worker %d started processing task %s", a.ID, workerID, task.ID)
-		a.ProcessTask(task)
-		log.Printf("Agent %d: Worker %d finished processing task %s", a.ID, workerID, task.ID)
+		log.Printf("Agent %d: Worker %d started processing operation %s of task %s", a.ID, workerID, op.ID, op.TaskID)
+		a.ProcessOperation(ctx, *op)
+		log.Printf("Agent %d: Worker %d finished processing operation %s of task %s", a.ID, workerID, op.ID, op.TaskID)
 
-		// Снимаем блокировку
-		_, err = a.Postgres.Exec("DELETE FROM locks WHERE id = $1", task.ID)
-		if err != nil {
-			log.Printf("Error removing lock for task %s: %v", task.ID, err)
+		// По завершении обработки операции освобождаем её
+		a.MarkTaskAsFinished(op.ID)
+	}
+}
+
+// wait выдерживает паузу между попытками захвата или прерывается при отмене
+// ctx либо при уведомлении из wakeup
+func (a *Agent) wait(ctx context.Context, wakeup <-chan struct{}) {
+	timer := time.NewTimer(a.PollInterval)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	case <-wakeup:
+	}
+}
+
+// watchDurations периодически перечитывает время выполнения операторов,
+// чтобы изменения через API применялись без перезапуска агента
+func (a *Agent) watchDurations(ctx context.Context) {
+	ticker := time.NewTicker(a.SettingsInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := a.RefreshDurations(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Agent %d: error refreshing operator durations: %v", a.ID, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// heartbeat регистрирует агента в реестре и затем периодически присылает
+// сигнал жизни. Если оркестратор не знает агента (например, сигналы не
+// доходили, пока он был недоступен, и реестр очистили), агент регистрируется заново.
+func (a *Agent) heartbeat(ctx context.Context) {
+	ticker := time.NewTicker(a.HeartbeatInterval)
+	defer ticker.Stop()
+
+	registered := false
+	for {
+		var err error
+		if registered {
+			err = a.Queue.HeartbeatAgent(ctx, a.OwnerID)
+		}
+		if !registered || errors.Is(err, domain.ErrAgentNotFound) {
+			err = a.Register(ctx)
+			registered = err == nil
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Agent %d: error sending heartbeat: %v", a.ID, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
 		}
+	}
+}
+
+// Register записывает агента в реестр агентов оркестратора
+func (a *Agent) Register(ctx context.Context) error {
+	return a.Queue.RegisterAgent(ctx, domain.AgentInfo{
+		ID:        a.OwnerID,
+		Hostname:  a.Hostname,
+		Workers:   a.Workers,
+		Operators: SupportedOperators(),
+		Version:   Version,
+	})
+}
+
+// RefreshDurations загружает время выполнения операторов из хранилища.
+// Операторы, которых там нет, сохраняют текущее значение.
+func (a *Agent) RefreshDurations(ctx context.Context) error {
+	durations, err := a.Queue.GetDurations(ctx)
+	if err != nil {
+		return err
+	}
 
-		// По завершении обработки задачи освобождаем её
-		a.MarkTaskAsFinished(task.ID)
+	a.durationsMu.Lock()
+	defer a.durationsMu.Unlock()
+	updated := make(map[string]int, len(a.DurationMap)+len(durations))
+	for operator, duration := range a.DurationMap {
+		updated[operator] = duration
 	}
+	for operator, duration := range durations {
+		updated[operator] = duration
+	}
+	a.DurationMap = updated
+	a.storedDurations = durations
+
+	return nil
+}
+
+// DurationsVersion возвращает версию настроек времени операторов для ключей
+// кеша результатов. Версия считается по последним настройкам из хранилища,
+// а не по DurationMap: значения из конфигурации агента оркестратору не
+// видны, и иначе ключи агента и оркестратора не совпадали бы.
+func (a *Agent) DurationsVersion() string {
+	a.durationsMu.RLock()
+	defer a.durationsMu.RUnlock()
+	return domain.DurationsVersion(a.storedDurations)
+}
+
+// OperatorDuration возвращает текущее время выполнения оператора
+func (a *Agent) OperatorDuration(operator string) time.Duration {
+	a.durationsMu.RLock()
+	defer a.durationsMu.RUnlock()
+	return time.Duration(a.DurationMap[operator]) * time.Millisecond
 }
 
 func (a *Agent) MarkTaskAsBeingProcessed(taskID string) {
@@ -98,53 +352,190 @@ func (a *Agent) MarkTaskAsFinished(taskID string) {
 	a.ExecutingLock.Delete(taskID)
 }
 
-func (a *Agent) checkTasks() {
-	rows, err := a.Postgres.Query("SELECT id, expression, status FROM tasks WHERE status != 'completed' AND status != 'error'")
-	if err != nil {
-		log.Printf("Error getting tasks from PostgreSQL: %v", err)
-		return
+// ClaimOperation захватывает одну готовую операцию: хранилище переводит её
+// в processing, записывает владельца и срок аренды. Операции с истекшей
+// арендой захватываются повторно, одну операцию не получат двое.
+// Возвращает nil, если готовых операций нет.
+func (a *Agent) ClaimOperation(ctx context.Context) (*domain.Operation, error) {
+	op, err := a.Queue.ClaimOperation(ctx, a.OwnerID, a.LeaseDuration)
+	if err == nil && op != nil {
+		a.publish(op.TaskID, "processing")
 	}
-	defer rows.Close()
+	return op, err
+}
 
-	for rows.Next() {
-		var task Task
-		if err := rows.Scan(&task.ID, &task.Expression, &task.Status); err != nil {
-			log.Printf("Error scanning task from PostgreSQL: %v", err)
-			continue
-		}
-		// Определяем индекс очереди задач
-		queueIndex := a.GetQueueIndex(task.ID)
-		a.TaskQueues[queueIndex] <- task
+// publish сообщает о смене статуса задачи. Событие лишь ускоряет
+// уведомление клиентов, поэтому ошибка публикации только записывается в лог.
+func (a *Agent) publish(taskID, status string) {
+	if a.Events == nil {
+		return
 	}
-	if err := rows.Err(); err != nil {
-		log.Printf("Error iterating over task rows: %v", err)
+	event := domain.TaskEvent{TaskID: taskID, Status: status, At: domain.Now()}
+	if err := a.Events.PublishTaskEvent(context.Background(), event); err != nil {
+		log.Printf("Agent %d: error publishing %s event of task %s: %v", a.ID, status, taskID, err)
 	}
 }
 
-func (a *Agent) GetQueueIndex(taskID string) int {
-	return int(hash(taskID)) % a.Workers
+// RenewLease продлевает аренду операции. Возвращает ErrLeaseLost, если
+// операция уже принадлежит другому агенту.
+func (a *Agent) RenewLease(ctx context.Context, operationID string) error {
+	return a.Queue.RenewLease(ctx, operationID, a.OwnerID, a.LeaseDuration)
 }
 
-func hash(s string) int {
-	h := fnv.New32a()
-	h.Write([]byte(s))
-	return int(h.Sum32())
+// keepLease периодически продлевает аренду, пока воркер вычисляет операцию.
+// Если аренда потеряна, вызывается abort, чтобы воркер прервал вычисление.
+func (a *Agent) keepLease(ctx context.Context, operationID string, abort context.CancelFunc) {
+	if a.LeaseDuration <= 0 {
+		return
+	}
+
+	interval := a.LeaseDuration / 3
+	if a.CheckInterval > 0 && a.CheckInterval < interval {
+		interval = a.CheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := a.RenewLease(ctx, operationID)
+			if errors.Is(err, ErrLeaseLost) {
+				log.Printf("Agent %d: lost lease for operation %s, aborting evaluation", a.ID, operationID)
+				abort()
+				return
+			}
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Agent %d: error renewing lease for operation %s: %v", a.ID, operationID, err)
+			}
+		}
+	}
 }
 
-func (a *Agent) ProcessTask(task Task) {
-	result, err := expression.ParseExpression(task.Expression, a.DurationMap)
-	if err != nil {
-		log.Printf("Error parsing expression for task %s: %s", task.ID, err)
-		task.Status = "error"
-	} else {
-		task.Result = result
-		task.Status = "completed"
+// ProcessOperation вычисляет одну операцию и передаёт её результат
+// родительской операции. Когда завершается корневая операция, задача
+// получает итоговый результат. Если ctx отменен во время вычисления
+// (агент останавливается), операция возвращается в очередь. Если аренда
+// потеряна (задачу отменили), вычисление прерывается без записи результата.
+func (a *Agent) ProcessOperation(ctx context.Context, op domain.Operation) {
+	// Операцию захватывают больше MaxAttempts раз, только если прошлые
+	// попытки не завершились вовсе: агенты падали, не вернув её в очередь
+	if a.Retry.exhausted(op.Attempts - 1) {
+		message := fmt.Sprintf("operation abandoned after %d attempts", op.Attempts-1)
+		if op.LastError != "" {
+			message += ": " + op.LastError
+		}
+		a.deadLetter(op, message)
+		return
+	}
+
+	evalCtx, abort := context.WithCancel(ctx)
+	defer abort()
+	go a.keepLease(evalCtx, op.ID, abort)
+
+	var result float64
+	var err error
+	switch {
+	case op.LeftValue == nil || op.RightValue == nil:
+		err = fmt.Errorf("operation %s has unknown operands", op.ID)
+	case op.Operator == expression.OperatorNegate:
+		result, err = expression.EvaluateUnary(*op.LeftValue, "-")
+	default:
+		result, err = a.evaluate(evalCtx, op)
+	}
+
+	// Агент останавливается — возвращаем операцию в очередь. Здесь и ниже
+	// запись идёт с отдельным контекстом: ctx к этому моменту может быть отменен.
+	if ctx.Err() != nil {
+		log.Printf("Agent %d: returning operation %s of task %s to the queue", a.ID, op.ID, op.TaskID)
+		if err := a.Queue.ReleaseOperation(context.Background(), op.ID, a.OwnerID); err != nil {
+			log.Printf("Error releasing operation %s: %v", op.ID, err)
+			return
+		}
+		a.publish(op.TaskID, "pending")
+		return
 	}
 
-	// Обновляем задачу в базе данных PostgreSQL
-	_, err = a.Postgres.Exec("UPDATE tasks SET result = $1, status = $2 WHERE id = $3", task.Result, task.Status, task.ID)
+	if evalCtx.Err() != nil {
+		log.Printf("Agent %d: operation %s of task %s was cancelled", a.ID, op.ID, op.TaskID)
+		return
+	}
+
+	// Ошибка вычисления постоянна: повтор даст тот же результат
 	if err != nil {
-		log.Printf("Error updating task %s in PostgreSQL: %v", task.ID, err)
+		log.Printf("Error evaluating operation %s of task %s: %s", op.ID, op.TaskID, err)
+		if err := a.Queue.FailOperation(context.Background(), op.ID, a.OwnerID, err.Error()); err != nil {
+			a.handleStoreError(op, err)
+			return
+		}
+		a.publish(op.TaskID, "error")
+		return
+	}
+
+	if err := a.Queue.CompleteOperation(context.Background(), op.ID, a.OwnerID, result); err != nil {
+		a.handleStoreError(op, err)
+		return
+	}
+	// Задача завершается вместе с корневой операцией
+	if op.ParentID == "" {
+		a.publish(op.TaskID, "completed")
+	}
+}
+
+// evaluate вычисляет бинарную операцию. Результат, уже найденный в кеше,
+// возвращается без задержки оператора, если задача не отказалась от кеша;
+// вычисленный результат сохраняется в кеш.
+func (a *Agent) evaluate(ctx context.Context, op domain.Operation) (float64, error) {
+	var key string
+	if a.Cache != nil {
+		key, _ = domain.OperationCacheKey(a.DurationsVersion(), op)
+		if !op.NoCache {
+			if result, ok := a.Cache.Get(ctx, key); ok {
+				log.Printf("Agent %d: result of operation %s of task %s found in cache", a.ID, op.ID, op.TaskID)
+				return result, nil
+			}
+		}
+	}
+
+	result, err := expression.EvaluateExpression(ctx, *op.LeftValue, *op.RightValue, op.Operator, a.OperatorDuration(op.Operator))
+	if err == nil && a.Cache != nil {
+		a.Cache.Put(ctx, key, result)
+	}
+	return result, err
+}
+
+// handleStoreError обрабатывает ошибку записи результата. Потерянная аренда
+// означает, что операцию отменили или забрали, остальные ошибки хранилища
+// временные: операция возвращается в очередь с паузой, а когда попытки
+// исчерпаны, задача переходит в dead.
+func (a *Agent) handleStoreError(op domain.Operation, err error) {
+	if errors.Is(err, ErrLeaseLost) {
+		log.Printf("Agent %d: operation %s of task %s is no longer ours: %v", a.ID, op.ID, op.TaskID, err)
+		return
+	}
+
+	if a.Retry.exhausted(op.Attempts) {
+		a.deadLetter(op, fmt.Sprintf("operation failed after %d attempts: %v", op.Attempts, err))
+		return
+	}
+
+	delay := a.Retry.Delay(op.Attempts)
+	log.Printf("Agent %d: retrying operation %s of task %s in %s after error: %v", a.ID, op.ID, op.TaskID, delay, err)
+	if err := a.Queue.RetryOperation(context.Background(), op.ID, a.OwnerID, err.Error(), domain.Now().Add(delay)); err != nil {
+		// Хранилище недоступно: операцию заберет другой агент по истечении аренды
+		log.Printf("Error scheduling retry of operation %s: %v", op.ID, err)
+		return
+	}
+	a.publish(op.TaskID, "pending")
+}
+
+func (a *Agent) deadLetter(op domain.Operation, message string) {
+	log.Printf("Agent %d: task %s is dead: %s", a.ID, op.TaskID, message)
+	if err := a.Queue.DeadLetterOperation(context.Background(), op.ID, a.OwnerID, message); err != nil {
+		log.Printf("Error moving task %s to dead letters: %v", op.TaskID, err)
 		return
 	}
+	a.publish(op.TaskID, "dead")
 }
@@ -1,48 +1,149 @@
 package agent_test
 
 import (
+	"context"
+	"errors"
 	"testing"
 	"time"
 
-	sqlmock "github.com/DATA-DOG/go-sqlmock"
 	"github.com/Dadil/project/internal/agent/agent"
-	"github.com/jmoiron/sqlx"
+	"github.com/Dadil/project/internal/agent/expression"
+	"github.com/Dadil/project/internal/notify"
+	"github.com/Dadil/project/internal/orchestra/domain"
+	"github.com/Dadil/project/internal/storage/memstore"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-func TestAgent(t *testing.T) {
-	// Создаем мок базы данных
-	mockDB, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("Error creating mock database: %v", err)
-	}
-	defer mockDB.Close()
+const (
+	testLogin  = "user"
+	testTaskID = "test_task_id"
+)
 
-	// Создаем экземпляр *sql.DB
-	sqlDB := sqlx.NewDb(mockDB, "sqlmock")
+// newStore создает хранилище в памяти с одной задачей пользователя testLogin
+func newStore(t *testing.T, expr string) *memstore.Store {
+	t.Helper()
+
+	store := memstore.New()
+	require.NoError(t, store.CreateUser(context.Background(), testLogin, "hash"))
+	operations, _, err := domain.DecomposeExpression(testTaskID, expr)
+	require.NoError(t, err)
+	task := domain.Task{ID: testTaskID, Expression: expr, Status: "pending", CreatedAt: domain.Now()}
+	require.NoError(t, store.CreateTask(context.Background(), testLogin, task, operations))
+	return store
+}
 
-	// Создаем экземпляр агента с моком базы данных
-	testAgent := &agent.Agent{Postgres: sqlDB}
+func getTask(t *testing.T, store *memstore.Store) *domain.Task {
+	t.Helper()
+	task, err := store.GetTaskForUser(context.Background(), testLogin, testTaskID)
+	require.NoError(t, err)
+	return task
+}
 
-	// Устанавливаем ожидания для запросов к базе данных
-	rows := sqlmock.NewRows([]string{"id", "expression", "status", "result"}).
-		AddRow(1, "test1", "completed", 1.0).
-		AddRow(2, "test2", "completed", 1.0).
-		AddRow(3, "test3", "completed", 1.0)
+// claim захватывает готовую операцию от имени агента
+func claim(t *testing.T, testAgent *agent.Agent) domain.Operation {
+	t.Helper()
+	op, err := testAgent.ClaimOperation(context.Background())
+	require.NoError(t, err)
+	require.NotNil(t, op)
+	return *op
+}
 
-	mock.ExpectQuery("SELECT id, expression, status FROM tasks").
-		WillReturnRows(rows)
+func TestAgent(t *testing.T) {
+	store := newStore(t, "(1 + 2) * (3 + 4)")
+
+	// Создаем экземпляр агента с двумя воркерами
+	testAgent := &agent.Agent{Queue: store, OwnerID: "owner", Workers: 2, LeaseDuration: time.Minute, PollInterval: 10 * time.Millisecond}
 
 	// Запускаем агента
-	testAgent.Start()
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		testAgent.Start(ctx)
+		close(stopped)
+	}()
+
+	// Ждем, пока агент вычислит все операции выражения
+	assert.Eventually(t, func() bool {
+		return getTask(t, store).Status == "completed"
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 21.0, getTask(t, store).Result)
+
+	// Агент должен остановиться сразу после отмены контекста, не дожидаясь PollInterval
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Agent did not stop after context cancellation")
+	}
+}
 
-	// Ждем, чтобы агент обработал задачи
-	time.Sleep(1 * time.Second)
+func TestAgent_Wakeup(t *testing.T) {
+	store := memstore.New()
+	require.NoError(t, store.CreateUser(context.Background(), testLogin, "hash"))
+
+	// Опрос раз в минуту: задачу может взять только разбуженный воркер
+	wakeup := notify.NewSignal()
+	testAgent := &agent.Agent{Queue: store, OwnerID: "owner", Workers: 2, LeaseDuration: time.Minute, PollInterval: time.Minute, Wakeup: wakeup}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go testAgent.Start(ctx)
+	time.Sleep(50 * time.Millisecond)
+
+	operations, _, err := domain.DecomposeExpression(testTaskID, "(1 + 2) * (3 + 4)")
+	require.NoError(t, err)
+	task := domain.Task{ID: testTaskID, Expression: "(1 + 2) * (3 + 4)", Status: "pending", CreatedAt: domain.Now()}
+	require.NoError(t, store.CreateTask(context.Background(), testLogin, task, operations))
+	wakeup.Broadcast()
+
+	// Умножение становится готовым после сложений — будим воркеров и на него
+	assert.Eventually(t, func() bool {
+		wakeup.Broadcast()
+		return getTask(t, store).Status == "completed"
+	}, time.Second, 20*time.Millisecond)
+	assert.Equal(t, 21.0, getTask(t, store).Result)
+}
 
-	// Проверяем, что все ожидания были выполнены
-	if err := mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("Unfulfilled expectations: %s", err)
-	}
+func TestAgent_Heartbeat(t *testing.T) {
+	store := memstore.New()
+	testAgent := agent.NewAgent(1, store, 2, nil)
+	testAgent.SettingsInterval = 0
+	testAgent.HeartbeatInterval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		testAgent.Start(ctx)
+		close(stopped)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	// Агент регистрируется при запуске
+	var agents []domain.AgentInfo
+	require.Eventually(t, func() bool {
+		var err error
+		agents, err = store.ListAgents(context.Background())
+		require.NoError(t, err)
+		return len(agents) == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, testAgent.OwnerID, agents[0].ID)
+	assert.Equal(t, testAgent.Hostname, agents[0].Hostname)
+	assert.Equal(t, 2, agents[0].Workers)
+	assert.Equal(t, agent.SupportedOperators(), agents[0].Operators)
+	assert.Equal(t, agent.Version, agents[0].Version)
+
+	// Следующий сигнал возвращает агента, ошибочно признанного offline, в online
+	_, err := store.ExpireAgents(context.Background(), domain.Now().Add(time.Second))
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		agents, err := store.ListAgents(context.Background())
+		require.NoError(t, err)
+		return agents[0].Status == domain.AgentOnline
+	}, time.Second, 10*time.Millisecond)
 }
 
 func TestMarkTaskAsBeingProcessed(t *testing.T) {
@@ -82,71 +183,401 @@ func TestNewAgent(t *testing.T) {
 	// Подготовка
 	id := 1
 	workers := 3
-	postgres := &sqlx.DB{}
+	store := memstore.New()
 	durationMap := map[string]int{"+": 1, "-": 2, "*": 3, "/": 4}
 
 	// Выполнение
-	testAgent := agent.NewAgent(id, postgres, workers, durationMap)
+	testAgent := agent.NewAgent(id, store, workers, durationMap)
 
 	// Проверка
 	assert.NotNil(t, testAgent, "The agent should not be nil")
 	assert.Equal(t, id, testAgent.ID, "The agent ID should match the provided ID")
-	assert.Equal(t, postgres, testAgent.Postgres, "The agent Postgres should match the provided postgres instance")
-	assert.Len(t, testAgent.TaskQueues, workers, "The length of TaskQueues should be equal to workers")
+	assert.NotEmpty(t, testAgent.OwnerID, "The agent should have a unique owner ID for leases")
+	assert.Equal(t, store, testAgent.Queue, "The agent Queue should match the provided store")
 	assert.Equal(t, workers, testAgent.Workers, "The number of workers should match the provided workers")
 	assert.Equal(t, durationMap, testAgent.DurationMap, "The durationMap should match the provided durationMap")
+	assert.Equal(t, agent.DefaultLeaseDuration, testAgent.LeaseDuration, "The lease duration should default to DefaultLeaseDuration")
+	assert.Equal(t, agent.DefaultPollInterval, testAgent.PollInterval, "The poll interval should default to DefaultPollInterval")
+	assert.Equal(t, agent.DefaultRetryPolicy, testAgent.Retry, "The retry policy should default to DefaultRetryPolicy")
+	assert.Equal(t, agent.DefaultHeartbeatInterval, testAgent.HeartbeatInterval, "The heartbeat interval should default to DefaultHeartbeatInterval")
+	assert.NotEmpty(t, testAgent.Hostname, "The agent should report its hostname to the registry")
+}
+
+func TestNewAgent_UniqueOwner(t *testing.T) {
+	first := agent.NewAgent(1, nil, 1, nil)
+	second := agent.NewAgent(2, nil, 1, nil)
+
+	assert.NotEqual(t, first.OwnerID, second.OwnerID, "Agents in one process should have different owner IDs")
+}
+
+func TestClaimOperation(t *testing.T) {
+	store := newStore(t, "1 + 2")
+	testAgent := &agent.Agent{Queue: store, OwnerID: "owner", LeaseDuration: 30 * time.Second}
+
+	// Захват переводит операцию в processing и задачу — тоже
+	op := claim(t, testAgent)
+	assert.Equal(t, testTaskID, op.TaskID)
+	assert.Empty(t, op.ParentID)
+	assert.Equal(t, "owner", op.OwnerAgent)
+	assert.Equal(t, 2.0, *op.RightValue)
+
+	task := getTask(t, store)
+	assert.Equal(t, "processing", task.Status)
+	assert.Equal(t, "owner", task.AgentID)
+
+	// Больше готовых операций нет
+	none, err := testAgent.ClaimOperation(context.Background())
+	assert.NoError(t, err)
+	assert.Nil(t, none)
+}
+
+func TestRenewLease_Lost(t *testing.T) {
+	store := newStore(t, "1 + 2")
+	owner := &agent.Agent{Queue: store, OwnerID: "owner", LeaseDuration: time.Minute}
+	other := &agent.Agent{Queue: store, OwnerID: "other", LeaseDuration: time.Minute}
+
+	op := claim(t, owner)
+
+	// Операцию вычисляет другой агент — продлить её аренду нельзя
+	assert.ErrorIs(t, other.RenewLease(context.Background(), op.ID), agent.ErrLeaseLost)
+	assert.NoError(t, owner.RenewLease(context.Background(), op.ID))
+}
+
+func TestProcessOperation(t *testing.T) {
+	store := newStore(t, "1 + 2")
+	testAgent := &agent.Agent{Queue: store, OwnerID: "owner"}
+
+	// Корневая операция записывает результат в задачу
+	testAgent.ProcessOperation(context.Background(), claim(t, testAgent))
+
+	task := getTask(t, store)
+	assert.Equal(t, "completed", task.Status)
+	assert.Equal(t, 3.0, task.Result)
+	assert.NotNil(t, task.FinishedAt)
+}
+
+func TestProcessOperation_Parent(t *testing.T) {
+	store := newStore(t, "-(2 * -3)")
+	testAgent := &agent.Agent{Queue: store, OwnerID: "owner"}
+
+	// Результат дочерней операции подставляется в родителя
+	child := claim(t, testAgent)
+	assert.Equal(t, "*", child.Operator)
+	testAgent.ProcessOperation(context.Background(), child)
+
+	parent := claim(t, testAgent)
+	assert.Equal(t, "neg", parent.Operator)
+	assert.Equal(t, -6.0, *parent.LeftValue)
+	testAgent.ProcessOperation(context.Background(), parent)
+
+	task := getTask(t, store)
+	assert.Equal(t, "completed", task.Status)
+	assert.Equal(t, 6.0, task.Result)
+}
+
+func TestProcessOperation_Cache(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cache := domain.NewResultCache(10, time.Hour)
+	testAgent := &agent.Agent{OwnerID: "owner", Cache: cache, DurationMap: map[string]int{"+": 0}}
+
+	// Вычисленный результат сохраняется в кеш
+	store := newStore(t, "2 + 3")
+	testAgent.Queue = store
+	testAgent.ProcessOperation(ctx, claim(t, testAgent))
+	assert.Equal(t, 5.0, getTask(t, store).Result)
+	stats := cache.Stats()
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, 1, stats.Entries)
+
+	// Найденный в кеше результат используется без задержки оператора;
+	// порядок операндов сложения не важен
+	testAgent.DurationMap["+"] = 60000
+	node, err := expression.Parse("4 + 3")
+	require.NoError(t, err)
+	cache.Put(ctx, domain.ResultCacheKey(testAgent.DurationsVersion(), node), 100)
+	store = newStore(t, "3 + 4")
+	testAgent.Queue = store
+	testAgent.ProcessOperation(ctx, claim(t, testAgent))
+	task := getTask(t, store)
+	assert.Equal(t, "completed", task.Status)
+	assert.Equal(t, 100.0, task.Result)
+	assert.Equal(t, uint64(1), cache.Stats().Hits)
+
+	// Задача, отказавшаяся от кеша, вычисляется заново и обновляет кеш
+	testAgent.DurationMap["+"] = 0
+	cache.Put(ctx, domain.ResultCacheKey(testAgent.DurationsVersion(), node), 100)
+	store = newStore(t, "3 + 4")
+	testAgent.Queue = store
+	op := claim(t, testAgent)
+	op.NoCache = true
+	testAgent.ProcessOperation(ctx, op)
+	assert.Equal(t, 7.0, getTask(t, store).Result)
+	result, ok := cache.Get(ctx, domain.ResultCacheKey(testAgent.DurationsVersion(), node))
+	assert.True(t, ok)
+	assert.Equal(t, 7.0, result)
+}
+
+// eventRecorder запоминает статусы задач, которые публикует агент
+type eventRecorder struct {
+	statuses []string
+}
+
+func (r *eventRecorder) PublishTaskEvent(ctx context.Context, event domain.TaskEvent) error {
+	r.statuses = append(r.statuses, event.TaskID+":"+event.Status)
+	return nil
+}
+
+func TestProcessOperation_Events(t *testing.T) {
+	events := &eventRecorder{}
+	store := newStore(t, "-(2 * -3)")
+	testAgent := &agent.Agent{Queue: store, OwnerID: "owner", Events: events}
+
+	// Захват каждой операции сообщает о processing, а завершение задачи —
+	// только корневая операция
+	testAgent.ProcessOperation(context.Background(), claim(t, testAgent))
+	testAgent.ProcessOperation(context.Background(), claim(t, testAgent))
+	assert.Equal(t, []string{testTaskID + ":processing", testTaskID + ":processing", testTaskID + ":completed"}, events.statuses)
+
+	events.statuses = nil
+	testAgent.Queue = newStore(t, "1 / 0")
+	testAgent.ProcessOperation(context.Background(), claim(t, testAgent))
+	assert.Equal(t, []string{testTaskID + ":processing", testTaskID + ":error"}, events.statuses)
+}
+
+func TestProcessOperation_DivisionByZero(t *testing.T) {
+	store := newStore(t, "1 / 0 + 2")
+	testAgent := &agent.Agent{Queue: store, OwnerID: "owner"}
+
+	// Ошибка операции переводит в ошибку всю задачу
+	testAgent.ProcessOperation(context.Background(), claim(t, testAgent))
 
-	// Проверка, что каждый канал в TaskQueues не равен nil
-	for i := 0; i < workers; i++ {
-		assert.NotNil(t, testAgent.TaskQueues[i], "The TaskQueues[%d] channel should not be nil", i)
+	task := getTask(t, store)
+	assert.Equal(t, "error", task.Status)
+	assert.Equal(t, "division by zero", task.ErrorMessage)
+
+	none, err := testAgent.ClaimOperation(context.Background())
+	assert.NoError(t, err)
+	assert.Nil(t, none, "Remaining operations of a failed task should not be claimed")
+}
+
+func TestProcessOperation_LeaseLost(t *testing.T) {
+	store := newStore(t, "1 + 2")
+	stale := &agent.Agent{Queue: store, OwnerID: "stale", LeaseDuration: time.Millisecond}
+	fresh := &agent.Agent{Queue: store, OwnerID: "fresh", LeaseDuration: time.Minute}
+
+	op := claim(t, stale)
+	time.Sleep(10 * time.Millisecond)
+	claim(t, fresh)
+
+	// Аренда истекла: результат не записывается, задача не трогается
+	stale.ProcessOperation(context.Background(), op)
+
+	task := getTask(t, store)
+	assert.Equal(t, "processing", task.Status)
+	assert.Equal(t, "fresh", task.AgentID)
+}
+
+func TestProcessOperation_Shutdown(t *testing.T) {
+	store := newStore(t, "1 + 2")
+	testAgent := &agent.Agent{
+		Queue:       store,
+		OwnerID:     "owner",
+		DurationMap: map[string]int{"+": 60000},
 	}
+	op := claim(t, testAgent)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	testAgent.ProcessOperation(ctx, op)
+	assert.Less(t, time.Since(start), 5*time.Second, "Evaluation should be interrupted by shutdown")
+
+	// При остановке агента операция возвращается в очередь
+	assert.Equal(t, "pending", getTask(t, store).Status)
+	assert.Equal(t, op.ID, claim(t, testAgent).ID)
 }
 
-func TestGetQueueIndex(t *testing.T) {
-	// Создаем тестовый агент
-	testAgent := &agent.Agent{Workers: 3}
+func TestProcessOperation_Cancel(t *testing.T) {
+	store := newStore(t, "1 + 2")
+	testAgent := &agent.Agent{
+		Queue:         store,
+		OwnerID:       "owner",
+		DurationMap:   map[string]int{"+": 60000},
+		LeaseDuration: time.Minute,
+		CheckInterval: 20 * time.Millisecond,
+	}
+	op := claim(t, testAgent)
 
-	// Тестовые данные
-	testTaskID := "test_task_id"
+	time.AfterFunc(100*time.Millisecond, func() {
+		_, err := store.CancelTaskForUser(context.Background(), testLogin, testTaskID)
+		assert.NoError(t, err)
+	})
+
+	// Вычисление прерывается при следующей проверке аренды, а не через минуту
+	start := time.Now()
+	testAgent.ProcessOperation(context.Background(), op)
+	assert.Less(t, time.Since(start), time.Second, "Evaluation should be interrupted by cancellation")
 
-	// Получаем индекс очереди для задачи
-	queueIndex := testAgent.GetQueueIndex(testTaskID)
+	task := getTask(t, store)
+	assert.Equal(t, "cancelled", task.Status)
+	assert.Zero(t, task.Result)
+}
 
-	// Проверяем, что индекс находится в диапазоне от 0 до (Workers - 1)
-	if queueIndex < 0 || queueIndex >= testAgent.Workers {
-		t.Errorf("Unexpected queue index: %d", queueIndex)
+func TestAgent_CancelFreesWorker(t *testing.T) {
+	store := newStore(t, "1 + 2")
+
+	// Единственный воркер: сложение длится минуту, умножение — мгновенно
+	testAgent := &agent.Agent{
+		Queue:         store,
+		OwnerID:       "owner",
+		Workers:       1,
+		DurationMap:   map[string]int{"+": 60000, "*": 0},
+		LeaseDuration: time.Minute,
+		PollInterval:  10 * time.Millisecond,
+		CheckInterval: 20 * time.Millisecond,
 	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go testAgent.Start(ctx)
+
+	assert.Eventually(t, func() bool {
+		return getTask(t, store).Status == "processing"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	_, err := store.CancelTaskForUser(context.Background(), testLogin, testTaskID)
+	require.NoError(t, err)
+
+	// Освободившийся воркер берет следующую задачу, не дожидаясь конца сложения
+	operations, _, err := domain.DecomposeExpression("next_task_id", "2 * 3")
+	require.NoError(t, err)
+	next := domain.Task{ID: "next_task_id", Expression: "2 * 3", Status: "pending", CreatedAt: domain.Now()}
+	require.NoError(t, store.CreateTask(context.Background(), testLogin, next, operations))
+
+	assert.Eventually(t, func() bool {
+		task, err := store.GetTaskForUser(context.Background(), testLogin, "next_task_id")
+		return err == nil && task.Status == "completed"
+	}, time.Second, 10*time.Millisecond)
+}
+
+// flakyQueue — хранилище, запись результата в которое не удается failures раз
+type flakyQueue struct {
+	*memstore.Store
+	failures int
 }
 
-func TestProcessTask(t *testing.T) {
-	// Создаем мок базы данных
-	mockDB, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("Error creating mock database: %v", err)
+func (q *flakyQueue) CompleteOperation(ctx context.Context, operationID, owner string, result float64) error {
+	if q.failures > 0 {
+		q.failures--
+		return errors.New("connection refused")
 	}
-	defer mockDB.Close()
+	return q.Store.CompleteOperation(ctx, operationID, owner, result)
+}
 
-	// Создаем экземпляр *sql.DB
-	sqlDB := sqlx.NewDb(mockDB, "sqlmock")
+func TestProcessOperation_TransientError(t *testing.T) {
+	store := newStore(t, "1 + 2")
+	queue := &flakyQueue{Store: store, failures: 1}
+	testAgent := &agent.Agent{
+		Queue:   queue,
+		OwnerID: "owner",
+		Retry:   agent.RetryPolicy{MaxAttempts: 3, Backoff: 200 * time.Millisecond},
+	}
 
-	// Создаем экземпляр агента с моком базы данных
-	testAgent := &agent.Agent{Postgres: sqlDB}
+	// Временная ошибка возвращает операцию в очередь с паузой
+	testAgent.ProcessOperation(context.Background(), claim(t, testAgent))
+	assert.Equal(t, "pending", getTask(t, store).Status)
+
+	none, err := testAgent.ClaimOperation(context.Background())
+	require.NoError(t, err)
+	assert.Nil(t, none, "The operation should not be claimed before its backoff expires")
+
+	// После паузы вторая попытка завершает задачу
+	var op *domain.Operation
+	require.Eventually(t, func() bool {
+		op, err = testAgent.ClaimOperation(context.Background())
+		return err == nil && op != nil
+	}, 2*time.Second, 20*time.Millisecond)
+	assert.Equal(t, 2, op.Attempts)
+	assert.Equal(t, "connection refused", op.LastError)
+
+	testAgent.ProcessOperation(context.Background(), *op)
+	task := getTask(t, store)
+	assert.Equal(t, "completed", task.Status)
+	assert.Equal(t, 3.0, task.Result)
+}
 
-	// Устанавливаем ожидания для запроса к базе данных
-	mock.ExpectExec("UPDATE tasks SET result = (.+), status = (.+) WHERE id = (.+)").
-		WillReturnResult(sqlmock.NewResult(1, 1))
+func TestProcessOperation_DeadLetter(t *testing.T) {
+	store := newStore(t, "1 + 2")
+	queue := &flakyQueue{Store: store, failures: 10}
+	testAgent := &agent.Agent{
+		Queue:   queue,
+		OwnerID: "owner",
+		Retry:   agent.RetryPolicy{MaxAttempts: 2},
+	}
 
-	// Создаем тестовую задачу
-	testTask := agent.Task{
-		ID:         "test_task_id",
-		Expression: "test_expression",
+	// Обе попытки заканчиваются временной ошибкой — задача переходит в dead
+	for attempt := 1; attempt <= 2; attempt++ {
+		op := claim(t, testAgent)
+		assert.Equal(t, attempt, op.Attempts)
+		testAgent.ProcessOperation(context.Background(), op)
 	}
 
-	// Обрабатываем тестовую задачу
-	testAgent.ProcessTask(testTask)
+	task := getTask(t, store)
+	assert.Equal(t, "dead", task.Status)
+	assert.Contains(t, task.ErrorMessage, "after 2 attempts")
+
+	none, err := testAgent.ClaimOperation(context.Background())
+	assert.NoError(t, err)
+	assert.Nil(t, none)
+}
+
+func TestProcessOperation_AbandonedAttempts(t *testing.T) {
+	store := newStore(t, "1 + 2")
+	crashing := &agent.Agent{Queue: store, OwnerID: "crashing", LeaseDuration: time.Millisecond}
+	testAgent := &agent.Agent{Queue: store, OwnerID: "owner", LeaseDuration: time.Minute, Retry: agent.RetryPolicy{MaxAttempts: 1}}
 
-	// Проверяем, что все ожидания выполнены
-	if err := mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("Unfulfilled expectations: %s", err)
+	// Агент упал, не завершив единственную разрешенную попытку
+	claim(t, crashing)
+	time.Sleep(10 * time.Millisecond)
+
+	// Следующий захват превышает лимит: операция не вычисляется, задача переходит в dead
+	op := claim(t, testAgent)
+	assert.Equal(t, 2, op.Attempts)
+	testAgent.ProcessOperation(context.Background(), op)
+
+	task := getTask(t, store)
+	assert.Equal(t, "dead", task.Status)
+	assert.Zero(t, task.Result)
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	policy := agent.RetryPolicy{Backoff: time.Second}
+
+	assert.Equal(t, time.Second, policy.Delay(1))
+	assert.Equal(t, 2*time.Second, policy.Delay(2))
+	assert.Equal(t, 8*time.Second, policy.Delay(4))
+	assert.Equal(t, agent.MaxRetryBackoff, policy.Delay(100), "Backoff should be capped")
+}
+
+func TestRefreshDurations(t *testing.T) {
+	store := memstore.New()
+	require.NoError(t, store.SetDurations(context.Background(), map[string]int{"+": 250}))
+
+	testAgent := &agent.Agent{
+		Queue:       store,
+		DurationMap: map[string]int{"+": 40000, "-": 40000},
 	}
+
+	// Новое значение из хранилища заменяет значение из конфигурации
+	assert.NoError(t, testAgent.RefreshDurations(context.Background()))
+	assert.Equal(t, 250*time.Millisecond, testAgent.OperatorDuration("+"))
+	assert.Equal(t, 40*time.Second, testAgent.OperatorDuration("-"), "Operators missing in settings keep their duration")
+
+	// Версия для ключей кеша совпадает с версией оркестратора, который
+	// видит только настройки из хранилища
+	durations, err := store.GetDurations(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, domain.DurationsVersion(durations), testAgent.DurationsVersion())
 }
@@ -1,6 +1,7 @@
 package expression
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strconv"
@@ -18,79 +19,351 @@ type Operation struct {
 	DurationSeconds int
 }
 
+// Node — узел дерева разбора выражения
+type Node interface {
+	String() string
+}
+
+// NumberNode — числовой литерал
+type NumberNode struct {
+	Value float64
+}
+
+// UnaryNode — унарная операция над одним операндом
+type UnaryNode struct {
+	Operator string
+	Operand  Node
+}
+
+// BinaryNode — бинарная операция над двумя операндами
+type BinaryNode struct {
+	Operator string
+	Left     Node
+	Right    Node
+}
+
+func (n *NumberNode) String() string {
+	return strconv.FormatFloat(n.Value, 'f', -1, 64)
+}
+
+func (n *UnaryNode) String() string {
+	return fmt.Sprintf("(%s%s)", n.Operator, n.Operand)
+}
+
+func (n *BinaryNode) String() string {
+	return fmt.Sprintf("(%s %s %s)", n.Left, n.Operator, n.Right)
+}
+
+// Canonical возвращает каноническую запись дерева разбора: скобки вокруг
+// каждой операции, унарный плюс опущен, а операнды коммутативных операторов
+// + и * упорядочены. Поэтому "3+2", "2 + 3" и "+(2) + 3" записываются
+// одинаково. Ассоциативность не используется: для чисел с плавающей точкой
+// (1 + 2) + 3 и 1 + (2 + 3) могут различаться.
+func Canonical(node Node) string {
+	switch n := node.(type) {
+	case *UnaryNode:
+		if n.Operator == "+" {
+			return Canonical(n.Operand)
+		}
+		return "(" + n.Operator + Canonical(n.Operand) + ")"
+	case *BinaryNode:
+		left, right := Canonical(n.Left), Canonical(n.Right)
+		if (n.Operator == "+" || n.Operator == "*") && right < left {
+			left, right = right, left
+		}
+		return "(" + left + " " + n.Operator + " " + right + ")"
+	default:
+		return node.String()
+	}
+}
+
+// OperatorNegate — оператор смены знака в графе операций, на который
+// раскладывается унарный минус над подвыражением: -(2+1)
+const OperatorNegate = "neg"
+
+// Operators — бинарные операторы, для которых задается время выполнения
+var Operators = []string{"+", "-", "*", "/"}
+
+// Приоритеты бинарных операторов: чем больше значение, тем сильнее связывание
+var precedence = map[string]int{
+	"+": 1,
+	"-": 1,
+	"*": 2,
+	"/": 2,
+}
+
 func TokenizeExpression(expression string) ([]Token, error) {
 	var tokens []Token
 	var buffer strings.Builder
 
+	flush := func() {
+		if buffer.Len() > 0 {
+			tokens = append(tokens, Token{Type: "number", Value: buffer.String()})
+			buffer.Reset()
+		}
+	}
+
+	// expectOperand — на текущей позиции ожидается операнд: в начале
+	// выражения, после оператора или открывающей скобки
+	expectOperand := func() bool {
+		if buffer.Len() > 0 {
+			return false
+		}
+		if len(tokens) == 0 {
+			return true
+		}
+		last := tokens[len(tokens)-1]
+		return last.Type == "operator" || last.Type == "unary" || last.Value == "("
+	}
+
 	for _, char := range expression {
 		switch {
+		case (char == '+' || char == '-') && expectOperand():
+			// Знак перед операндом — унарный оператор: -5, 2 * -3, -(2+1)
+			tokens = append(tokens, Token{Type: "unary", Value: string(char)})
 		case char == '+' || char == '-' || char == '*' || char == '/':
-			if buffer.Len() > 0 {
-				tokens = append(tokens, Token{Type: "number", Value: buffer.String()})
-				buffer.Reset()
-			}
+			flush()
 			tokens = append(tokens, Token{Type: "operator", Value: string(char)})
+		case char == '(' || char == ')':
+			flush()
+			tokens = append(tokens, Token{Type: "paren", Value: string(char)})
 		case char >= '0' && char <= '9' || char == '.':
 			buffer.WriteRune(char)
 		case char == ' ':
-			continue
+			// Пробел разделяет числа: "2 3" — это два операнда, а не 23
+			flush()
 		default:
 			return nil, fmt.Errorf("invalid character in expression: %c", char)
 		}
 	}
 
-	if buffer.Len() > 0 {
-		tokens = append(tokens, Token{Type: "number", Value: buffer.String()})
-	}
+	flush()
 
 	return tokens, nil
 }
 
-func ParseExpression(expression string, durationMap map[string]int) (float64, error) {
+// MaxDepth — наибольшая глубина выражения: вложенность скобок и унарных
+// знаков, а также длина цепочки операций в дереве разбора. Без ограничения
+// выражение из сотен тысяч скобок переполнило бы стек горутины при разборе
+// или вычислении, а переполнение стека завершает весь процесс.
+const MaxDepth = 1000
+
+var errTooDeep = fmt.Errorf("expression is nested deeper than %d levels", MaxDepth)
+
+// parser — нисходящий парсер с приоритетами операторов (Pratt)
+type parser struct {
+	tokens []Token
+	pos    int
+	// nesting — текущая глубина рекурсии разбора
+	nesting int
+}
+
+// Parse строит дерево разбора выражения
+func Parse(expression string) (Node, error) {
 	tokens, err := TokenizeExpression(expression)
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("empty expression")
 	}
 
-	for _, op := range []string{"*/", "+-"} {
-		for {
-			found := false
-			for i, token := range tokens {
-				if token.Type == "operator" && strings.Contains(op, token.Value) {
-					op1, err := strconv.ParseFloat(tokens[i-1].Value, 64)
-					if err != nil {
-						return 0, fmt.Errorf("invalid operand 1: %s", tokens[i-1].Value)
-					}
-					op2, err := strconv.ParseFloat(tokens[i+1].Value, 64)
-					if err != nil {
-						return 0, fmt.Errorf("invalid operand 2: %s", tokens[i+1].Value)
-					}
-
-					duration := durationMap[token.Value] // Получаем время задержки для текущего оператора
-
-					result, err := EvaluateExpression(op1, op2, token.Value, duration)
-					if err != nil {
-						return 0, err
-					}
-
-					// Заменяем оператор и его операнды результатом вычисления
-					tokens = append(tokens[:i-1], append([]Token{{Type: "number", Value: fmt.Sprintf("%f", result)}}, tokens[i+2:]...)...)
-					found = true
-					break
-				}
-			}
-			if !found {
-				break
+	p := &parser{tokens: tokens}
+	node, _, err := p.parseExpression(0)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos < len(p.tokens) {
+		token := p.tokens[p.pos]
+		if token.Value == ")" {
+			return nil, errors.New("mismatched parentheses: unexpected )")
+		}
+		return nil, fmt.Errorf("unexpected token: %s", token.Value)
+	}
+
+	return node, nil
+}
+
+func (p *parser) peek() (Token, bool) {
+	if p.pos >= len(p.tokens) {
+		return Token{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *parser) next() (Token, bool) {
+	token, ok := p.peek()
+	if ok {
+		p.pos++
+	}
+	return token, ok
+}
+
+// enter учитывает вход в операнд: скобки и унарные знаки — единственное,
+// что углубляет рекурсию разбора без ограничения. leave вызывается при выходе.
+func (p *parser) enter() error {
+	p.nesting++
+	if p.nesting > MaxDepth {
+		return errTooDeep
+	}
+	return nil
+}
+
+func (p *parser) leave() {
+	p.nesting--
+}
+
+// parseExpression разбирает выражение, в котором все бинарные операторы
+// связывают сильнее minPrecedence, и возвращает его вместе с глубиной
+// дерева. Операторы одного приоритета левоассоциативны: 10 - 4 - 3 = (10 - 4) - 3.
+func (p *parser) parseExpression(minPrecedence int) (Node, int, error) {
+	left, depth, err := p.parseOperand()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for {
+		token, ok := p.peek()
+		if !ok || token.Type != "operator" {
+			break
+		}
+		prec := precedence[token.Value]
+		if prec <= minPrecedence {
+			break
+		}
+		p.pos++
+
+		right, rightDepth, err := p.parseExpression(prec)
+		if err != nil {
+			return nil, 0, err
+		}
+		left = &BinaryNode{Operator: token.Value, Left: left, Right: right}
+		if rightDepth > depth {
+			depth = rightDepth
+		}
+		if depth++; depth > MaxDepth {
+			return nil, 0, errTooDeep
+		}
+	}
+
+	return left, depth, nil
+}
+
+// parseOperand разбирает число, выражение в скобках или операнд
+// с унарным знаком. Унарный знак связывает сильнее любого бинарного
+// оператора: -2 * 3 = (-2) * 3. Возвращает операнд вместе с глубиной его дерева.
+func (p *parser) parseOperand() (Node, int, error) {
+	if err := p.enter(); err != nil {
+		return nil, 0, err
+	}
+	defer p.leave()
+
+	token, ok := p.next()
+	if !ok {
+		return nil, 0, errors.New("unexpected end of expression")
+	}
+
+	switch {
+	case token.Type == "unary":
+		operand, depth, err := p.parseOperand()
+		if err != nil {
+			return nil, 0, err
+		}
+		// Знак перед литералом сворачиваем в число со знаком
+		if number, ok := operand.(*NumberNode); ok {
+			value, err := EvaluateUnary(number.Value, token.Value)
+			if err != nil {
+				return nil, 0, err
 			}
+			return &NumberNode{Value: value}, depth, nil
+		}
+		return &UnaryNode{Operator: token.Value, Operand: operand}, depth + 1, nil
+	case token.Type == "number":
+		value, err := strconv.ParseFloat(token.Value, 64)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid operand: %s", token.Value)
 		}
+		return &NumberNode{Value: value}, 1, nil
+	case token.Value == "(":
+		node, depth, err := p.parseExpression(0)
+		if err != nil {
+			return nil, 0, err
+		}
+		closing, ok := p.next()
+		if !ok || closing.Value != ")" {
+			return nil, 0, errors.New("mismatched parentheses: missing )")
+		}
+		return node, depth, nil
+	default:
+		return nil, 0, fmt.Errorf("unexpected token: %s", token.Value)
+	}
+}
+
+func ParseExpression(ctx context.Context, expression string, durationMap map[string]int) (float64, error) {
+	node, err := Parse(expression)
+	if err != nil {
+		return 0, err
+	}
+
+	return Evaluate(ctx, node, durationMap)
+}
+
+// Evaluate вычисляет дерево разбора, выдерживая для каждого бинарного
+// оператора задержку из durationMap (в миллисекундах). Вычисление
+// прерывается при отмене ctx.
+func Evaluate(ctx context.Context, node Node, durationMap map[string]int) (float64, error) {
+	switch n := node.(type) {
+	case *NumberNode:
+		return n.Value, nil
+	case *UnaryNode:
+		operand, err := Evaluate(ctx, n.Operand, durationMap)
+		if err != nil {
+			return 0, err
+		}
+		return EvaluateUnary(operand, n.Operator)
+	case *BinaryNode:
+		op1, err := Evaluate(ctx, n.Left, durationMap)
+		if err != nil {
+			return 0, err
+		}
+		op2, err := Evaluate(ctx, n.Right, durationMap)
+		if err != nil {
+			return 0, err
+		}
+
+		duration := time.Duration(durationMap[n.Operator]) * time.Millisecond // Получаем время задержки для текущего оператора
+
+		return EvaluateExpression(ctx, op1, op2, n.Operator, duration)
+	default:
+		return 0, fmt.Errorf("unsupported node: %T", node)
 	}
+}
 
-	// Возвращаем результат
-	return strconv.ParseFloat(tokens[0].Value, 64)
+// EvaluateUnary применяет унарный оператор. Смена знака выполняется
+// мгновенно и не требует задержки.
+func EvaluateUnary(operand float64, operator string) (float64, error) {
+	switch operator {
+	case "+":
+		return operand, nil
+	case "-":
+		return -operand, nil
+	default:
+		return 0, fmt.Errorf("unsupported unary operator: %s", operator)
+	}
 }
 
-func EvaluateExpression(op1, op2 float64, operator string, duration int) (float64, error) {
-	time.Sleep(time.Duration(duration) * time.Second)
+// EvaluateExpression применяет бинарный оператор после задержки duration.
+// Если ctx отменен во время ожидания, возвращает ctx.Err().
+func EvaluateExpression(ctx context.Context, op1, op2 float64, operator string, duration time.Duration) (float64, error) {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case <-timer.C:
+	}
 
 	var result float64
 	switch operator {
@@ -1,6 +1,9 @@
 package expression
 
 import (
+	"context"
+	"errors"
+	"strings"
 	"testing"
 	"time"
 )
@@ -29,7 +32,7 @@ func TestParseExpression(t *testing.T) {
 
 	for _, test := range tests {
 		start := time.Now() // Measure start time
-		result, err := ParseExpression(test.expression, durationMap)
+		result, err := ParseExpression(context.Background(), test.expression, durationMap)
 		elapsed := time.Since(start) // Calculate elapsed time
 		if err != nil {
 			if test.expected != 0 {
@@ -60,9 +63,247 @@ func TestParseExpression_InvalidCharacters(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		_, err := ParseExpression(test.expression, durationMap)
+		_, err := ParseExpression(context.Background(), test.expression, durationMap)
 		if err == nil {
 			t.Errorf("Expected error for expression '%s' with invalid characters, but got none", test.expression)
 		}
 	}
 }
+
+func TestParseExpression_Parentheses(t *testing.T) {
+	durationMap := map[string]int{}
+
+	tests := []struct {
+		expression string
+		expected   float64
+	}{
+		{"(2+3)*4", 20},
+		{"2 * (3 + 4) * 5", 70},
+		{"((7))", 7},
+		{"10 - 4 - 3", 3},   // Левая ассоциативность вычитания
+		{"100 / 10 / 5", 2}, // Левая ассоциативность деления
+		{"10 - (4 - 3)", 9},
+		{"2 + 3 * 4 - 6 / 2", 11},
+		{"1.5 * (2 + 2)", 6},
+	}
+
+	for _, test := range tests {
+		result, err := ParseExpression(context.Background(), test.expression, durationMap)
+		if err != nil {
+			t.Errorf("Unexpected error while parsing expression '%s': %v", test.expression, err)
+			continue
+		}
+		if result != test.expected {
+			t.Errorf("Incorrect result for expression '%s'. Expected: %f, Got: %f", test.expression, test.expected, result)
+		}
+	}
+}
+
+func TestParse_Tree(t *testing.T) {
+	tests := []struct {
+		expression string
+		expected   string
+	}{
+		{"1 + 2 * 3", "(1 + (2 * 3))"},
+		{"(1 + 2) * 3", "((1 + 2) * 3)"},
+		{"8 / 4 / 2", "((8 / 4) / 2)"},
+		{"42", "42"},
+	}
+
+	for _, test := range tests {
+		node, err := Parse(test.expression)
+		if err != nil {
+			t.Errorf("Unexpected error while parsing expression '%s': %v", test.expression, err)
+			continue
+		}
+		if node.String() != test.expected {
+			t.Errorf("Incorrect tree for expression '%s'. Expected: %s, Got: %s", test.expression, test.expected, node.String())
+		}
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	tests := []string{
+		"",
+		"(1 + 2",
+		"1 + 2)",
+		"()",
+		"1 +",
+		"* 2",
+		"2 3",
+		"1..2 + 3",
+	}
+
+	for _, expression := range tests {
+		if _, err := Parse(expression); err == nil {
+			t.Errorf("Expected error for expression '%s', but got none", expression)
+		}
+	}
+}
+
+func TestParseExpression_Unary(t *testing.T) {
+	durationMap := map[string]int{}
+
+	tests := []struct {
+		expression string
+		expected   float64
+	}{
+		{"-5 + 3", -2},
+		{"2 * -3", -6},
+		{"--3", 3},
+		{"-(2+1)", -3},
+		{"+4", 4},
+		{"-2 * -2", 4},
+		{"3 - -2", 5},
+		{"3 + +2", 5},
+		{"-(-(1 + 1))", 2},
+		{"(-3)", -3},
+		{"-2 * 3 + 1", -5},
+		{"- 7", -7},
+	}
+
+	for _, test := range tests {
+		result, err := ParseExpression(context.Background(), test.expression, durationMap)
+		if err != nil {
+			t.Errorf("Unexpected error while parsing expression '%s': %v", test.expression, err)
+			continue
+		}
+		if result != test.expected {
+			t.Errorf("Incorrect result for expression '%s'. Expected: %f, Got: %f", test.expression, test.expected, result)
+		}
+	}
+}
+
+func TestTokenizeExpression_Unary(t *testing.T) {
+	tokens, err := TokenizeExpression("-1 - -(2)")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []Token{
+		{Type: "unary", Value: "-"},
+		{Type: "number", Value: "1"},
+		{Type: "operator", Value: "-"},
+		{Type: "unary", Value: "-"},
+		{Type: "paren", Value: "("},
+		{Type: "number", Value: "2"},
+		{Type: "paren", Value: ")"},
+	}
+
+	if len(tokens) != len(expected) {
+		t.Fatalf("Expected %d tokens, got %d: %v", len(expected), len(tokens), tokens)
+	}
+	for i := range expected {
+		if tokens[i] != expected[i] {
+			t.Errorf("Token %d: expected %v, got %v", i, expected[i], tokens[i])
+		}
+	}
+}
+
+func TestParse_UnaryTree(t *testing.T) {
+	tests := []struct {
+		expression string
+		expected   string
+	}{
+		{"-5 + 3", "(-5 + 3)"},
+		{"-(2 + 1)", "(-(2 + 1))"},
+		{"--(1 * 2)", "(-(-(1 * 2)))"},
+	}
+
+	for _, test := range tests {
+		node, err := Parse(test.expression)
+		if err != nil {
+			t.Errorf("Unexpected error while parsing expression '%s': %v", test.expression, err)
+			continue
+		}
+		if node.String() != test.expected {
+			t.Errorf("Incorrect tree for expression '%s'. Expected: %s, Got: %s", test.expression, test.expected, node.String())
+		}
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	tests := []struct {
+		expressions []string
+		expected    string
+	}{
+		{[]string{"2 + 3", "3+2", "2.0 + 3", "+2 + 3"}, "(2 + 3)"},
+		{[]string{"4 * (1 + 2)", "(2 + 1) * 4"}, "((1 + 2) * 4)"},
+		{[]string{"-(2 + 1)", "-(1 + 2)"}, "(-(1 + 2))"},
+		{[]string{"+(1 + 2)"}, "(1 + 2)"},
+		{[]string{"5 - 3"}, "(5 - 3)"},
+		{[]string{"3 - 5"}, "(3 - 5)"},
+		{[]string{"(1 + 2) + 3"}, "((1 + 2) + 3)"},
+		{[]string{"1 + (2 + 3)", "(3 + 2) + 1"}, "((2 + 3) + 1)"},
+		{[]string{"-7"}, "-7"},
+	}
+
+	for _, test := range tests {
+		for _, expression := range test.expressions {
+			node, err := Parse(expression)
+			if err != nil {
+				t.Errorf("Unexpected error while parsing expression '%s': %v", expression, err)
+				continue
+			}
+			if got := Canonical(node); got != test.expected {
+				t.Errorf("Incorrect canonical form for expression '%s'. Expected: %s, Got: %s", expression, test.expected, got)
+			}
+		}
+	}
+}
+
+func TestParse_InvalidUnary(t *testing.T) {
+	tests := []string{
+		"-",
+		"3 -",
+		"2 * -",
+		"(-)",
+		"--",
+		"* -2",
+	}
+
+	for _, expression := range tests {
+		if _, err := Parse(expression); err == nil {
+			t.Errorf("Expected error for expression '%s', but got none", expression)
+		}
+	}
+}
+
+func TestEvaluateExpression_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := EvaluateExpression(ctx, 1, 2, "+", 10*time.Second)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Evaluation should stop right after cancellation, took %s", elapsed)
+	}
+}
+
+func TestParse_TooDeep(t *testing.T) {
+	tests := []string{
+		strings.Repeat("(", 1000000) + "1" + strings.Repeat(")", 1000000),
+		strings.Repeat("-", 1000000) + "(1)",
+		strings.Repeat("-(", 2000) + "1" + strings.Repeat(")", 2000),
+		"1" + strings.Repeat(" + 1", MaxDepth+1),
+	}
+
+	for _, expression := range tests {
+		if _, err := Parse(expression); err == nil {
+			t.Errorf("Expected error for expression of %d characters, but got none", len(expression))
+		}
+	}
+
+	// Глубина в пределах MaxDepth допустима
+	nested := strings.Repeat("(", MaxDepth-1) + "1" + strings.Repeat(")", MaxDepth-1)
+	if _, err := Parse(nested); err != nil {
+		t.Errorf("Expected %d nested parentheses to parse, got %v", MaxDepth-1, err)
+	}
+	chain := "1" + strings.Repeat(" + 1", MaxDepth-1)
+	if _, err := Parse(chain); err != nil {
+		t.Errorf("Expected chain of %d additions to parse, got %v", MaxDepth-1, err)
+	}
+}
@@ -0,0 +1,228 @@
+// Package grpcqueue реализует очередь агента поверх gRPC-сервиса
+// оркестратора (см. agentpb): агенту не нужен доступ к базе, а подписка
+// Subscribe будит его воркеров сразу при появлении готовых операций.
+package grpcqueue
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Dadil/project/internal/agent/agent"
+	"github.com/Dadil/project/internal/agentpb"
+	"github.com/Dadil/project/internal/orchestra/domain"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// DefaultTimeout — предел времени одного вызова оркестратора
+	DefaultTimeout = 10 * time.Second
+	// resubscribeDelay — пауза перед повторной подпиской после разрыва потока
+	resubscribeDelay = time.Second
+)
+
+var (
+	_ agent.Queue          = (*Client)(nil)
+	_ agent.EventPublisher = (*Client)(nil)
+)
+
+// Client — очередь операций на стороне оркестратора
+type Client struct {
+	conn *grpc.ClientConn
+	rpc  agentpb.OrchestratorClient
+}
+
+// Dial подключается к gRPC-серверу оркестратора по адресу target
+// (например, orchestra:9090) с токеном агентов token. Соединение
+// устанавливается лениво, при первом вызове.
+func Dial(target, token string, opts ...grpc.DialOption) (*Client, error) {
+	opts = append([]grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithPerRPCCredentials(agentToken(token)),
+	}, opts...)
+	conn, err := grpc.Dial(target, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn, rpc: agentpb.NewOrchestratorClient(conn)}, nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client) ClaimOperation(ctx context.Context, owner string, lease time.Duration) (*domain.Operation, error) {
+	ctx, cancel := context.WithTimeout(ctx, DefaultTimeout)
+	defer cancel()
+
+	resp, err := c.rpc.ClaimTask(ctx, &agentpb.ClaimTaskRequest{Owner: owner, LeaseMs: lease.Milliseconds()})
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	if resp.Operation == nil {
+		return nil, nil
+	}
+	return resp.Operation.Domain(), nil
+}
+
+func (c *Client) RenewLease(ctx context.Context, operationID, owner string, lease time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, DefaultTimeout)
+	defer cancel()
+
+	_, err := c.rpc.Heartbeat(ctx, &agentpb.HeartbeatRequest{Owner: owner, OperationId: operationID, LeaseMs: lease.Milliseconds()})
+	return rpcError(err)
+}
+
+func (c *Client) CompleteOperation(ctx context.Context, operationID, owner string, result float64) error {
+	return c.report(ctx, &agentpb.ReportResultRequest{Owner: owner, OperationId: operationID,
+		Outcome: &agentpb.ReportResultRequest_Result{Result: result}})
+}
+
+func (c *Client) FailOperation(ctx context.Context, operationID, owner, message string) error {
+	return c.report(ctx, &agentpb.ReportResultRequest{Owner: owner, OperationId: operationID,
+		Outcome: &agentpb.ReportResultRequest_Error{Error: message}})
+}
+
+func (c *Client) ReleaseOperation(ctx context.Context, operationID, owner string) error {
+	return c.report(ctx, &agentpb.ReportResultRequest{Owner: owner, OperationId: operationID,
+		Outcome: &agentpb.ReportResultRequest_Release{Release: &agentpb.Release{}}})
+}
+
+func (c *Client) RetryOperation(ctx context.Context, operationID, owner, message string, nextAttemptAt time.Time) error {
+	retry := &agentpb.Retry{Error: message, NextAttemptAt: timestamppb.New(nextAttemptAt)}
+	return c.report(ctx, &agentpb.ReportResultRequest{Owner: owner, OperationId: operationID,
+		Outcome: &agentpb.ReportResultRequest_Retry{Retry: retry}})
+}
+
+func (c *Client) DeadLetterOperation(ctx context.Context, operationID, owner, message string) error {
+	return c.report(ctx, &agentpb.ReportResultRequest{Owner: owner, OperationId: operationID,
+		Outcome: &agentpb.ReportResultRequest_DeadLetter{DeadLetter: message}})
+}
+
+func (c *Client) GetDurations(ctx context.Context) (map[string]int, error) {
+	ctx, cancel := context.WithTimeout(ctx, DefaultTimeout)
+	defer cancel()
+
+	resp, err := c.rpc.GetDurations(ctx, &agentpb.GetDurationsRequest{})
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	durations := make(map[string]int, len(resp.Durations))
+	for operator, duration := range resp.Durations {
+		durations[operator] = int(duration)
+	}
+	return durations, nil
+}
+
+func (c *Client) RegisterAgent(ctx context.Context, info domain.AgentInfo) error {
+	ctx, cancel := context.WithTimeout(ctx, DefaultTimeout)
+	defer cancel()
+
+	_, err := c.rpc.RegisterAgent(ctx, &agentpb.RegisterAgentRequest{
+		Id:        info.ID,
+		Hostname:  info.Hostname,
+		Workers:   int32(info.Workers),
+		Operators: info.Operators,
+		Version:   info.Version,
+	})
+	return rpcError(err)
+}
+
+func (c *Client) HeartbeatAgent(ctx context.Context, agentID string) error {
+	ctx, cancel := context.WithTimeout(ctx, DefaultTimeout)
+	defer cancel()
+
+	_, err := c.rpc.AgentHeartbeat(ctx, &agentpb.AgentHeartbeatRequest{AgentId: agentID})
+	return rpcError(err)
+}
+
+// PublishTaskEvent передает смену статуса задачи в шину событий оркестратора
+func (c *Client) PublishTaskEvent(ctx context.Context, event domain.TaskEvent) error {
+	ctx, cancel := context.WithTimeout(ctx, DefaultTimeout)
+	defer cancel()
+
+	_, err := c.rpc.PublishTaskEvent(ctx, &agentpb.PublishTaskEventRequest{
+		TaskId: event.TaskID,
+		Status: event.Status,
+		At:     timestamppb.New(event.At),
+	})
+	return err
+}
+
+func (c *Client) report(ctx context.Context, req *agentpb.ReportResultRequest) error {
+	ctx, cancel := context.WithTimeout(ctx, DefaultTimeout)
+	defer cancel()
+
+	_, err := c.rpc.ReportResult(ctx, req)
+	return rpcError(err)
+}
+
+// Subscribe подписывается на уведомления о готовых операциях. Канал
+// получает значение на каждое событие, в том числе на первое событие
+// после (пере)подключения, и закрывается после отмены ctx. Разорванная
+// подписка восстанавливается автоматически.
+func (c *Client) Subscribe(ctx context.Context, owner string) <-chan struct{} {
+	notifications := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case notifications <- struct{}{}:
+		default:
+		}
+	}
+
+	go func() {
+		defer close(notifications)
+		for ctx.Err() == nil {
+			stream, err := c.rpc.Subscribe(ctx, &agentpb.SubscribeRequest{Owner: owner})
+			if err == nil {
+				for {
+					if _, err = stream.Recv(); err != nil {
+						break
+					}
+					notify()
+				}
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			log.Println("Subscription to orchestrator lost:", err)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(resubscribeDelay):
+			}
+		}
+	}()
+
+	return notifications
+}
+
+// rpcError возвращает domain.ErrLeaseLost для статуса Aborted и
+// domain.ErrAgentNotFound для NotFound
+func rpcError(err error) error {
+	switch status.Code(err) {
+	case codes.Aborted:
+		return domain.ErrLeaseLost
+	case codes.NotFound:
+		return domain.ErrAgentNotFound
+	}
+	return err
+}
+
+// agentToken передает токен агентов в метаданных каждого вызова
+type agentToken string
+
+func (t agentToken) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+// RequireTransportSecurity разрешает передавать токен без TLS:
+// сервер оркестратора слушает внутреннюю сеть
+func (t agentToken) RequireTransportSecurity() bool {
+	return false
+}
@@ -0,0 +1,182 @@
+package grpcqueue_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Dadil/project/internal/agent/agent"
+	"github.com/Dadil/project/internal/agent/grpcqueue"
+	"github.com/Dadil/project/internal/agent/queuetest"
+	"github.com/Dadil/project/internal/notify"
+	"github.com/Dadil/project/internal/orchestra/domain"
+	"github.com/Dadil/project/internal/orchestra/grpcapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const agentToken = "agent-secret"
+
+// newServer запускает gRPC-сервис оркестратора поверх bufconn и возвращает
+// функцию подключения клиента. Оркестратор переключается на хранилища
+// сервиса, как в orchestramain, чтобы новые задачи будили подписчиков.
+func newServer(t *testing.T, orchestrator *domain.Orchestrator, token string) func(token string) *grpcqueue.Client {
+	t.Helper()
+
+	service := grpcapi.New(orchestrator.Tasks, orchestrator.Registry, token)
+	service.Events = orchestrator.Events
+	service.Results = orchestrator
+	orchestrator.Tasks, orchestrator.Registry = service.Tasks(), service.Agents()
+
+	server := service.GRPC()
+	listener := bufconn.Listen(1 << 20)
+	go server.Serve(listener)
+	t.Cleanup(func() {
+		service.Close()
+		server.GracefulStop()
+	})
+
+	return func(token string) *grpcqueue.Client {
+		client, err := grpcqueue.Dial("bufnet", token, grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}))
+		require.NoError(t, err)
+		t.Cleanup(func() { client.Close() })
+		return client
+	}
+}
+
+func TestClient(t *testing.T) {
+	queuetest.Run(t, func(t *testing.T, orchestrator *domain.Orchestrator) queuetest.Queue {
+		return newServer(t, orchestrator, agentToken)(agentToken)
+	})
+}
+
+func TestAgentOverGRPC(t *testing.T) {
+	orchestrator := queuetest.NewOrchestrator(t)
+	client := newServer(t, orchestrator, agentToken)(agentToken)
+
+	// Опрос почти отключен: воркеров будит подписка
+	ctx, cancel := context.WithCancel(context.Background())
+	testAgent := agent.NewAgent(1, client, 2, nil)
+	testAgent.PollInterval = time.Hour
+	testAgent.SettingsInterval = 0
+	testAgent.Wakeup = notify.NewSignal()
+	notifications := client.Subscribe(ctx, "agent")
+	<-notifications // первое событие: подписка установлена
+	go testAgent.Wakeup.Forward(notifications)
+
+	stopped := make(chan struct{})
+	go func() {
+		testAgent.Start(ctx)
+		close(stopped)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	// Задачи появляются после того, как воркеры уснули
+	time.Sleep(50 * time.Millisecond)
+	queuetest.AddTask(t, orchestrator.Tasks, "sum", "(1 + 2) * (3 + 4)")
+	queuetest.AddTask(t, orchestrator.Tasks, "div", "1 / (2 - 2)")
+
+	assert.Eventually(t, func() bool {
+		return queuetest.GetTask(t, orchestrator.Tasks, "sum").Status == "completed" &&
+			queuetest.GetTask(t, orchestrator.Tasks, "div").Status == "error"
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 21.0, queuetest.GetTask(t, orchestrator.Tasks, "sum").Result)
+	assert.Equal(t, "division by zero", queuetest.GetTask(t, orchestrator.Tasks, "div").ErrorMessage)
+}
+
+func TestClient_Subscribe(t *testing.T) {
+	orchestrator := queuetest.NewOrchestrator(t)
+	client := newServer(t, orchestrator, agentToken)(agentToken)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	notifications := client.Subscribe(ctx, "agent")
+
+	// Первое событие приходит сразу после подписки
+	select {
+	case <-notifications:
+	case <-time.After(time.Second):
+		t.Fatal("No notification after subscription")
+	}
+
+	// Новая задача будит подписчиков
+	queuetest.AddTask(t, orchestrator.Tasks, "task", "2 + 3")
+	select {
+	case <-notifications:
+	case <-time.After(time.Second):
+		t.Fatal("No notification after task creation")
+	}
+
+	// После отмены контекста канал закрывается
+	cancel()
+	require.Eventually(t, func() bool {
+		select {
+		case _, ok := <-notifications:
+			return !ok
+		default:
+			return false
+		}
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestClient_SubscribeAgentExpiry(t *testing.T) {
+	orchestrator := queuetest.NewOrchestrator(t)
+	client := newServer(t, orchestrator, agentToken)(agentToken)
+	info := domain.AgentInfo{ID: "host/1/1", Hostname: "host", Workers: 1}
+	require.NoError(t, client.RegisterAgent(context.Background(), info))
+
+	queuetest.AddTask(t, orchestrator.Tasks, "task", "2 + 3")
+	_, err := client.ClaimOperation(context.Background(), "host/1/1", time.Minute)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	notifications := client.Subscribe(ctx, "agent")
+	<-notifications // первое событие: подписка установлена
+
+	// Операции offline-агента возвращаются в очередь, подписчики просыпаются
+	_, err = orchestrator.Registry.ExpireAgents(context.Background(), domain.Now().Add(time.Second))
+	require.NoError(t, err)
+	select {
+	case <-notifications:
+	case <-time.After(time.Second):
+		t.Fatal("No notification after agent expiry")
+	}
+	assert.Equal(t, "pending", queuetest.GetTask(t, orchestrator.Tasks, "task").Status)
+}
+
+func TestClient_Unauthorized(t *testing.T) {
+	dial := newServer(t, queuetest.NewOrchestrator(t), agentToken)
+
+	_, err := dial("wrong").ClaimOperation(context.Background(), "agent-1", time.Minute)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// Без токена на стороне оркестратора сервис отклоняет все вызовы
+	dial = newServer(t, queuetest.NewOrchestrator(t), "")
+	_, err = dial("").ClaimOperation(context.Background(), "agent-1", time.Minute)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestClient_StatusCodes(t *testing.T) {
+	orchestrator := queuetest.NewOrchestrator(t)
+	client := newServer(t, orchestrator, agentToken)(agentToken)
+	queuetest.AddTask(t, orchestrator.Tasks, "task", "2 + 3")
+	op, err := client.ClaimOperation(context.Background(), "agent-1", time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, op)
+
+	// ABORTED и NOT_FOUND превращаются в ошибки домена, остальные статусы
+	// возвращаются как есть
+	assert.ErrorIs(t, client.RenewLease(context.Background(), op.ID, "agent-2", time.Minute), domain.ErrLeaseLost)
+	assert.ErrorIs(t, client.HeartbeatAgent(context.Background(), "host/1/1"), domain.ErrAgentNotFound)
+	err = client.PublishTaskEvent(context.Background(), domain.TaskEvent{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
@@ -0,0 +1,208 @@
+// Package httpqueue реализует очередь агента поверх внутреннего HTTP API
+// оркестратора (/internal/...), чтобы агенту не нужен был доступ к базе.
+package httpqueue
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Dadil/project/internal/agent/agent"
+	"github.com/Dadil/project/internal/orchestra/domain"
+)
+
+// DefaultTimeout — предел времени одного запроса к оркестратору
+const DefaultTimeout = 10 * time.Second
+
+var (
+	_ agent.Queue          = (*Client)(nil)
+	_ agent.EventPublisher = (*Client)(nil)
+)
+
+// Client — очередь операций на стороне оркестратора
+type Client struct {
+	BaseURL string
+	Token   string
+	HTTP    *http.Client
+}
+
+// New создает клиент оркестратора по адресу baseURL (например, http://orchestra:8080)
+// с токеном агентов token
+func New(baseURL, token string) *Client {
+	return &Client{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		Token:   token,
+		HTTP:    &http.Client{Timeout: DefaultTimeout},
+	}
+}
+
+// report — тело запросов к /internal/task/{id}/...
+type report struct {
+	Owner         string     `json:"owner"`
+	Result        *float64   `json:"result,omitempty"`
+	Error         string     `json:"error,omitempty"`
+	LeaseMs       int64      `json:"lease_ms,omitempty"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+}
+
+func (c *Client) ClaimOperation(ctx context.Context, owner string, lease time.Duration) (*domain.Operation, error) {
+	query := url.Values{"owner": {owner}, "lease_ms": {strconv.FormatInt(lease.Milliseconds(), 10)}}
+	resp, err := c.do(ctx, http.MethodGet, "/internal/task?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+
+	var op domain.Operation
+	if err := json.NewDecoder(resp.Body).Decode(&op); err != nil {
+		return nil, fmt.Errorf("failed to decode operation: %w", err)
+	}
+	return &op, nil
+}
+
+func (c *Client) RenewLease(ctx context.Context, operationID, owner string, lease time.Duration) error {
+	return c.report(ctx, operationID, "lease", report{Owner: owner, LeaseMs: lease.Milliseconds()})
+}
+
+func (c *Client) CompleteOperation(ctx context.Context, operationID, owner string, result float64) error {
+	return c.report(ctx, operationID, "result", report{Owner: owner, Result: &result})
+}
+
+func (c *Client) FailOperation(ctx context.Context, operationID, owner, message string) error {
+	return c.report(ctx, operationID, "result", report{Owner: owner, Error: message})
+}
+
+func (c *Client) ReleaseOperation(ctx context.Context, operationID, owner string) error {
+	return c.report(ctx, operationID, "release", report{Owner: owner})
+}
+
+func (c *Client) RetryOperation(ctx context.Context, operationID, owner, message string, nextAttemptAt time.Time) error {
+	return c.report(ctx, operationID, "retry", report{Owner: owner, Error: message, NextAttemptAt: &nextAttemptAt})
+}
+
+func (c *Client) DeadLetterOperation(ctx context.Context, operationID, owner, message string) error {
+	return c.report(ctx, operationID, "dead", report{Owner: owner, Error: message})
+}
+
+func (c *Client) GetDurations(ctx context.Context) (map[string]int, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/internal/settings/durations", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var durations map[string]int
+	if err := json.NewDecoder(resp.Body).Decode(&durations); err != nil {
+		return nil, fmt.Errorf("failed to decode durations: %w", err)
+	}
+	return durations, nil
+}
+
+func (c *Client) RegisterAgent(ctx context.Context, info domain.AgentInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, http.MethodPost, "/internal/agents", data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// HeartbeatAgent возвращает domain.ErrAgentNotFound, если оркестратор не знает агента
+func (c *Client) HeartbeatAgent(ctx context.Context, agentID string) error {
+	data, err := json.Marshal(map[string]string{"id": agentID})
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, http.MethodPost, "/internal/agents/heartbeat", data)
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.Code == http.StatusNotFound {
+		return domain.ErrAgentNotFound
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// PublishTaskEvent передает смену статуса задачи в шину событий оркестратора
+func (c *Client) PublishTaskEvent(ctx context.Context, event domain.TaskEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, http.MethodPost, "/internal/events", data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *Client) report(ctx context.Context, operationID, action string, body report) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, http.MethodPost, "/internal/task/"+url.PathEscape(operationID)+"/"+action, data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// StatusError — неуспешный ответ оркестратора
+type StatusError struct {
+	Code    int
+	Status  string
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("orchestrator returned %s: %s", e.Status, e.Message)
+}
+
+// do выполняет запрос к оркестратору. Ответ 409 означает, что агент
+// потерял аренду, и превращается в domain.ErrLeaseLost; остальные
+// неуспешные ответы возвращаются ошибкой *StatusError с текстом ответа.
+func (c *Client) do(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusConflict {
+		return nil, domain.ErrLeaseLost
+	}
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return nil, &StatusError{Code: resp.StatusCode, Status: resp.Status, Message: strings.TrimSpace(string(message))}
+}
@@ -0,0 +1,59 @@
+package httpqueue_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Dadil/project/internal/agent/httpqueue"
+	"github.com/Dadil/project/internal/agent/queuetest"
+	"github.com/Dadil/project/internal/orchestra/api"
+	"github.com/Dadil/project/internal/orchestra/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const agentToken = "agent-secret"
+
+// newServer запускает HTTP API оркестратора и возвращает его адрес
+func newServer(t *testing.T, orchestrator *domain.Orchestrator, token string) string {
+	t.Helper()
+
+	orchestratorAPI := api.NewOrchestratorAPI(orchestrator, "secret")
+	orchestratorAPI.AgentToken = []byte(token)
+	server := httptest.NewServer(orchestratorAPI.Router)
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func TestClient(t *testing.T) {
+	queuetest.Run(t, func(t *testing.T, orchestrator *domain.Orchestrator) queuetest.Queue {
+		// Завершающий слэш в адресе оркестратора не мешает клиенту
+		return httpqueue.New(newServer(t, orchestrator, agentToken)+"/", agentToken)
+	})
+}
+
+func TestClient_Unauthorized(t *testing.T) {
+	url := newServer(t, queuetest.NewOrchestrator(t), agentToken)
+
+	_, err := httpqueue.New(url, "wrong").ClaimOperation(context.Background(), "agent-1", time.Minute)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "401")
+
+	// Без токена на стороне оркестратора внутренние эндпоинты отключены
+	url = newServer(t, queuetest.NewOrchestrator(t), "")
+	_, err = httpqueue.New(url, "").ClaimOperation(context.Background(), "agent-1", time.Minute)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "403")
+}
+
+func TestClient_StatusError(t *testing.T) {
+	client := httpqueue.New(newServer(t, queuetest.NewOrchestrator(t), agentToken), agentToken)
+
+	// Ответ без соответствующей ошибки домена возвращается с кодом статуса
+	err := client.PublishTaskEvent(context.Background(), domain.TaskEvent{Status: "processing"})
+	var statusErr *httpqueue.StatusError
+	require.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, 400, statusErr.Code)
+}
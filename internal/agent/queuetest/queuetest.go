@@ -0,0 +1,249 @@
+// Package queuetest — общий набор тестов, который должен проходить каждый
+// клиент очереди агента поверх транспорта оркестратора. Клиенты подключают
+// его в своих тестах:
+//
+//	queuetest.Run(t, func(t *testing.T, orchestrator *domain.Orchestrator) queuetest.Queue {
+//		return httpqueue.New(serve(t, orchestrator), token)
+//	})
+package queuetest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Dadil/project/internal/agent/agent"
+	"github.com/Dadil/project/internal/orchestra/domain"
+	"github.com/Dadil/project/internal/storage/memstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Login — владелец задач, которые создают проверки
+const Login = "user"
+
+const lease = time.Minute
+
+// Queue — клиент очереди, через который агент работает с оркестратором
+type Queue interface {
+	agent.Queue
+	agent.EventPublisher
+}
+
+// Factory запускает сервер транспорта поверх оркестратора и возвращает
+// клиент с действующим токеном агента. Сервер может заменить хранилища
+// оркестратора своими обертками, как это делает orchestramain.
+type Factory func(t *testing.T, orchestrator *domain.Orchestrator) Queue
+
+// Run запускает все проверки набора на клиентах из newQueue
+func Run(t *testing.T, newQueue Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, orchestrator *domain.Orchestrator, queue Queue)
+	}{
+		{"Agent", testAgent},
+		{"Claim", testClaim},
+		{"CompleteCachesResult", testCompleteCachesResult},
+		{"LeaseLost", testLeaseLost},
+		{"RetryAndDeadLetter", testRetryAndDeadLetter},
+		{"GetDurations", testGetDurations},
+		{"AgentRegistry", testAgentRegistry},
+		{"PublishTaskEvent", testPublishTaskEvent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orchestrator := NewOrchestrator(t)
+			queue := newQueue(t, orchestrator)
+			tt.test(t, orchestrator, queue)
+		})
+	}
+}
+
+// NewOrchestrator создает оркестратор на хранилище в памяти с пользователем
+// Login и нулевым временем выполнения операторов
+func NewOrchestrator(t *testing.T) *domain.Orchestrator {
+	t.Helper()
+
+	store := memstore.New()
+	require.NoError(t, store.CreateUser(context.Background(), Login, "hash"))
+	require.NoError(t, store.InitDurations(context.Background(), map[string]int{"+": 0, "-": 0, "*": 0, "/": 0}))
+	return domain.NewOrchestrator(store, store, store)
+}
+
+// AddTask создает задачу пользователя Login с графом операций выражения
+func AddTask(t *testing.T, tasks domain.TaskStore, taskID, expr string) {
+	t.Helper()
+
+	operations, _, err := domain.DecomposeExpression(taskID, expr)
+	require.NoError(t, err)
+	task := domain.Task{ID: taskID, Expression: expr, Status: "pending", CreatedAt: domain.Now()}
+	require.NoError(t, tasks.CreateTask(context.Background(), Login, task, operations))
+}
+
+// GetTask возвращает задачу пользователя Login
+func GetTask(t *testing.T, tasks domain.TaskStore, taskID string) *domain.Task {
+	t.Helper()
+
+	task, err := tasks.GetTaskForUser(context.Background(), Login, taskID)
+	require.NoError(t, err)
+	return task
+}
+
+func testAgent(t *testing.T, orchestrator *domain.Orchestrator, queue Queue) {
+	AddTask(t, orchestrator.Tasks, "sum", "(1 + 2) * (3 + 4)")
+	AddTask(t, orchestrator.Tasks, "div", "1 / (2 - 2)")
+
+	// Агент работает с оркестратором только через клиент
+	testAgent := agent.NewAgent(1, queue, 2, nil)
+	testAgent.PollInterval = 10 * time.Millisecond
+	testAgent.SettingsInterval = 0
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		testAgent.Start(ctx)
+		close(stopped)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	assert.Eventually(t, func() bool {
+		return GetTask(t, orchestrator.Tasks, "sum").Status == "completed" && GetTask(t, orchestrator.Tasks, "div").Status == "error"
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 21.0, GetTask(t, orchestrator.Tasks, "sum").Result)
+	assert.Equal(t, "division by zero", GetTask(t, orchestrator.Tasks, "div").ErrorMessage)
+}
+
+func testClaim(t *testing.T, orchestrator *domain.Orchestrator, queue Queue) {
+	ctx := context.Background()
+
+	// Готовых операций нет
+	op, err := queue.ClaimOperation(ctx, "agent-1", lease)
+	require.NoError(t, err)
+	assert.Nil(t, op)
+
+	AddTask(t, orchestrator.Tasks, "task", "2 + 3")
+	op, err = queue.ClaimOperation(ctx, "agent-1", lease)
+	require.NoError(t, err)
+	require.NotNil(t, op)
+	assert.Equal(t, "+", op.Operator)
+	require.NotNil(t, op.LeftValue)
+	assert.Equal(t, 2.0, *op.LeftValue)
+	assert.Equal(t, "agent-1", op.OwnerAgent)
+	assert.NotNil(t, op.LeaseExpiresAt)
+	assert.Equal(t, 1, op.Attempts)
+
+	require.NoError(t, queue.RenewLease(ctx, op.ID, "agent-1", lease))
+	require.NoError(t, queue.CompleteOperation(ctx, op.ID, "agent-1", 5))
+	task := GetTask(t, orchestrator.Tasks, "task")
+	assert.Equal(t, "completed", task.Status)
+	assert.Equal(t, 5.0, task.Result)
+}
+
+func testCompleteCachesResult(t *testing.T, orchestrator *domain.Orchestrator, queue Queue) {
+	ctx := context.Background()
+	orchestrator.Cache = domain.NewResultCache(10, time.Hour)
+
+	_, _, err := orchestrator.SubmitTaskForUser(ctx, Login, "2 + 3", domain.AddTaskOptions{})
+	require.NoError(t, err)
+	op, err := queue.ClaimOperation(ctx, "agent-1", lease)
+	require.NoError(t, err)
+	require.NotNil(t, op)
+	require.NoError(t, queue.CompleteOperation(ctx, op.ID, "agent-1", 5))
+
+	// Результат, присланный агентом, оркестратор находит при добавлении задачи
+	taskID, _, err := orchestrator.SubmitTaskForUser(ctx, Login, "3 + 2", domain.AddTaskOptions{})
+	require.NoError(t, err)
+	task := GetTask(t, orchestrator.Tasks, taskID)
+	assert.Equal(t, "completed", task.Status)
+	assert.Equal(t, 5.0, task.Result)
+	assert.Equal(t, uint64(1), orchestrator.CacheStats().Hits)
+}
+
+func testLeaseLost(t *testing.T, orchestrator *domain.Orchestrator, queue Queue) {
+	ctx := context.Background()
+	AddTask(t, orchestrator.Tasks, "task", "2 + 3")
+
+	op, err := queue.ClaimOperation(ctx, "agent-1", lease)
+	require.NoError(t, err)
+	require.NotNil(t, op)
+
+	// Чужой агент получает ErrLeaseLost, как и при работе с хранилищем напрямую
+	assert.ErrorIs(t, queue.RenewLease(ctx, op.ID, "agent-2", lease), domain.ErrLeaseLost)
+	assert.ErrorIs(t, queue.CompleteOperation(ctx, op.ID, "agent-2", 5), domain.ErrLeaseLost)
+	assert.ErrorIs(t, queue.ReleaseOperation(ctx, op.ID, "agent-2"), domain.ErrLeaseLost)
+}
+
+func testRetryAndDeadLetter(t *testing.T, orchestrator *domain.Orchestrator, queue Queue) {
+	ctx := context.Background()
+	AddTask(t, orchestrator.Tasks, "task", "2 + 3")
+
+	op, err := queue.ClaimOperation(ctx, "agent-1", lease)
+	require.NoError(t, err)
+	require.NotNil(t, op)
+
+	// Повтор откладывает операцию до nextAttemptAt
+	require.NoError(t, queue.RetryOperation(ctx, op.ID, "agent-1", "store unavailable", domain.Now().Add(50*time.Millisecond)))
+	op, err = queue.ClaimOperation(ctx, "agent-1", lease)
+	require.NoError(t, err)
+	assert.Nil(t, op)
+
+	require.Eventually(t, func() bool {
+		op, err = queue.ClaimOperation(ctx, "agent-1", lease)
+		require.NoError(t, err)
+		return op != nil
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 2, op.Attempts)
+	assert.Equal(t, "store unavailable", op.LastError)
+
+	require.NoError(t, queue.DeadLetterOperation(ctx, op.ID, "agent-1", "gave up"))
+	assert.Equal(t, "dead", GetTask(t, orchestrator.Tasks, "task").Status)
+}
+
+func testGetDurations(t *testing.T, orchestrator *domain.Orchestrator, queue Queue) {
+	require.NoError(t, orchestrator.Tasks.SetDurations(context.Background(), map[string]int{"*": 1500}))
+
+	durations, err := queue.GetDurations(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1500, durations["*"])
+	assert.Equal(t, 0, durations["+"])
+}
+
+func testAgentRegistry(t *testing.T, orchestrator *domain.Orchestrator, queue Queue) {
+	ctx := context.Background()
+
+	// Незарегистрированный агент получает ErrAgentNotFound и должен зарегистрироваться
+	assert.ErrorIs(t, queue.HeartbeatAgent(ctx, "host/1/1"), domain.ErrAgentNotFound)
+
+	info := domain.AgentInfo{ID: "host/1/1", Hostname: "host", Workers: 3, Operators: []string{"+", "-"}, Version: "1.0"}
+	require.NoError(t, queue.RegisterAgent(ctx, info))
+	require.NoError(t, queue.HeartbeatAgent(ctx, "host/1/1"))
+
+	agents, err := orchestrator.Registry.ListAgents(ctx)
+	require.NoError(t, err)
+	require.Len(t, agents, 1)
+	assert.Equal(t, "host/1/1", agents[0].ID)
+	assert.Equal(t, "host", agents[0].Hostname)
+	assert.Equal(t, 3, agents[0].Workers)
+	assert.Equal(t, []string{"+", "-"}, agents[0].Operators)
+	assert.Equal(t, domain.AgentOnline, agents[0].Status)
+}
+
+func testPublishTaskEvent(t *testing.T, orchestrator *domain.Orchestrator, queue Queue) {
+	events, unsubscribe := orchestrator.Events.Subscribe("")
+	defer unsubscribe()
+
+	// Событие агента попадает в шину оркестратора вместе со временем
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, queue.PublishTaskEvent(context.Background(), domain.TaskEvent{TaskID: "task", Status: "processing", At: at}))
+	event := <-events
+	assert.Equal(t, "task", event.TaskID)
+	assert.Equal(t, "processing", event.Status)
+	assert.True(t, at.Equal(event.At))
+
+	// Событие без ID задачи отклоняется
+	assert.Error(t, queue.PublishTaskEvent(context.Background(), domain.TaskEvent{Status: "processing"}))
+}
@@ -0,0 +1,420 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: agent.proto
+
+package agentpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Orchestrator_ClaimTask_FullMethodName        = "/calc.agent.v1.Orchestrator/ClaimTask"
+	Orchestrator_ReportResult_FullMethodName     = "/calc.agent.v1.Orchestrator/ReportResult"
+	Orchestrator_Heartbeat_FullMethodName        = "/calc.agent.v1.Orchestrator/Heartbeat"
+	Orchestrator_GetDurations_FullMethodName     = "/calc.agent.v1.Orchestrator/GetDurations"
+	Orchestrator_Subscribe_FullMethodName        = "/calc.agent.v1.Orchestrator/Subscribe"
+	Orchestrator_RegisterAgent_FullMethodName    = "/calc.agent.v1.Orchestrator/RegisterAgent"
+	Orchestrator_AgentHeartbeat_FullMethodName   = "/calc.agent.v1.Orchestrator/AgentHeartbeat"
+	Orchestrator_PublishTaskEvent_FullMethodName = "/calc.agent.v1.Orchestrator/PublishTaskEvent"
+)
+
+// OrchestratorClient is the client API for Orchestrator service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type OrchestratorClient interface {
+	// ClaimTask захватывает готовую операцию на lease_ms миллисекунд;
+	// если готовых операций нет, operation не заполнен
+	ClaimTask(ctx context.Context, in *ClaimTaskRequest, opts ...grpc.CallOption) (*ClaimTaskResponse, error)
+	// ReportResult записывает исход вычисления операции
+	ReportResult(ctx context.Context, in *ReportResultRequest, opts ...grpc.CallOption) (*ReportResultResponse, error)
+	// Heartbeat продлевает аренду операции, пока агент её вычисляет
+	Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*HeartbeatResponse, error)
+	// GetDurations возвращает время выполнения операторов в миллисекундах
+	GetDurations(ctx context.Context, in *GetDurationsRequest, opts ...grpc.CallOption) (*GetDurationsResponse, error)
+	// Subscribe присылает событие сразу после подписки и затем каждый раз,
+	// когда могли появиться готовые операции
+	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (Orchestrator_SubscribeClient, error)
+	// RegisterAgent записывает агента в реестр агентов
+	RegisterAgent(ctx context.Context, in *RegisterAgentRequest, opts ...grpc.CallOption) (*RegisterAgentResponse, error)
+	// AgentHeartbeat отмечает сигнал жизни агента; для незарегистрированного
+	// агента возвращает NOT_FOUND, и агент регистрируется заново
+	AgentHeartbeat(ctx context.Context, in *AgentHeartbeatRequest, opts ...grpc.CallOption) (*AgentHeartbeatResponse, error)
+	// PublishTaskEvent передает смену статуса задачи в шину событий
+	// оркестратора, откуда она уходит клиентам /expressions/stream
+	PublishTaskEvent(ctx context.Context, in *PublishTaskEventRequest, opts ...grpc.CallOption) (*PublishTaskEventResponse, error)
+}
+
+type orchestratorClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewOrchestratorClient(cc grpc.ClientConnInterface) OrchestratorClient {
+	return &orchestratorClient{cc}
+}
+
+func (c *orchestratorClient) ClaimTask(ctx context.Context, in *ClaimTaskRequest, opts ...grpc.CallOption) (*ClaimTaskResponse, error) {
+	out := new(ClaimTaskResponse)
+	err := c.cc.Invoke(ctx, Orchestrator_ClaimTask_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orchestratorClient) ReportResult(ctx context.Context, in *ReportResultRequest, opts ...grpc.CallOption) (*ReportResultResponse, error) {
+	out := new(ReportResultResponse)
+	err := c.cc.Invoke(ctx, Orchestrator_ReportResult_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orchestratorClient) Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*HeartbeatResponse, error) {
+	out := new(HeartbeatResponse)
+	err := c.cc.Invoke(ctx, Orchestrator_Heartbeat_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orchestratorClient) GetDurations(ctx context.Context, in *GetDurationsRequest, opts ...grpc.CallOption) (*GetDurationsResponse, error) {
+	out := new(GetDurationsResponse)
+	err := c.cc.Invoke(ctx, Orchestrator_GetDurations_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orchestratorClient) Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (Orchestrator_SubscribeClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Orchestrator_ServiceDesc.Streams[0], Orchestrator_Subscribe_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &orchestratorSubscribeClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Orchestrator_SubscribeClient interface {
+	Recv() (*OperationsReady, error)
+	grpc.ClientStream
+}
+
+type orchestratorSubscribeClient struct {
+	grpc.ClientStream
+}
+
+func (x *orchestratorSubscribeClient) Recv() (*OperationsReady, error) {
+	m := new(OperationsReady)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *orchestratorClient) RegisterAgent(ctx context.Context, in *RegisterAgentRequest, opts ...grpc.CallOption) (*RegisterAgentResponse, error) {
+	out := new(RegisterAgentResponse)
+	err := c.cc.Invoke(ctx, Orchestrator_RegisterAgent_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orchestratorClient) AgentHeartbeat(ctx context.Context, in *AgentHeartbeatRequest, opts ...grpc.CallOption) (*AgentHeartbeatResponse, error) {
+	out := new(AgentHeartbeatResponse)
+	err := c.cc.Invoke(ctx, Orchestrator_AgentHeartbeat_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orchestratorClient) PublishTaskEvent(ctx context.Context, in *PublishTaskEventRequest, opts ...grpc.CallOption) (*PublishTaskEventResponse, error) {
+	out := new(PublishTaskEventResponse)
+	err := c.cc.Invoke(ctx, Orchestrator_PublishTaskEvent_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// OrchestratorServer is the server API for Orchestrator service.
+// All implementations must embed UnimplementedOrchestratorServer
+// for forward compatibility
+type OrchestratorServer interface {
+	// ClaimTask захватывает готовую операцию на lease_ms миллисекунд;
+	// если готовых операций нет, operation не заполнен
+	ClaimTask(context.Context, *ClaimTaskRequest) (*ClaimTaskResponse, error)
+	// ReportResult записывает исход вычисления операции
+	ReportResult(context.Context, *ReportResultRequest) (*ReportResultResponse, error)
+	// Heartbeat продлевает аренду операции, пока агент её вычисляет
+	Heartbeat(context.Context, *HeartbeatRequest) (*HeartbeatResponse, error)
+	// GetDurations возвращает время выполнения операторов в миллисекундах
+	GetDurations(context.Context, *GetDurationsRequest) (*GetDurationsResponse, error)
+	// Subscribe присылает событие сразу после подписки и затем каждый раз,
+	// когда могли появиться готовые операции
+	Subscribe(*SubscribeRequest, Orchestrator_SubscribeServer) error
+	// RegisterAgent записывает агента в реестр агентов
+	RegisterAgent(context.Context, *RegisterAgentRequest) (*RegisterAgentResponse, error)
+	// AgentHeartbeat отмечает сигнал жизни агента; для незарегистрированного
+	// агента возвращает NOT_FOUND, и агент регистрируется заново
+	AgentHeartbeat(context.Context, *AgentHeartbeatRequest) (*AgentHeartbeatResponse, error)
+	// PublishTaskEvent передает смену статуса задачи в шину событий
+	// оркестратора, откуда она уходит клиентам /expressions/stream
+	PublishTaskEvent(context.Context, *PublishTaskEventRequest) (*PublishTaskEventResponse, error)
+	mustEmbedUnimplementedOrchestratorServer()
+}
+
+// UnimplementedOrchestratorServer must be embedded to have forward compatible implementations.
+type UnimplementedOrchestratorServer struct {
+}
+
+func (UnimplementedOrchestratorServer) ClaimTask(context.Context, *ClaimTaskRequest) (*ClaimTaskResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ClaimTask not implemented")
+}
+func (UnimplementedOrchestratorServer) ReportResult(context.Context, *ReportResultRequest) (*ReportResultResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReportResult not implemented")
+}
+func (UnimplementedOrchestratorServer) Heartbeat(context.Context, *HeartbeatRequest) (*HeartbeatResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Heartbeat not implemented")
+}
+func (UnimplementedOrchestratorServer) GetDurations(context.Context, *GetDurationsRequest) (*GetDurationsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetDurations not implemented")
+}
+func (UnimplementedOrchestratorServer) Subscribe(*SubscribeRequest, Orchestrator_SubscribeServer) error {
+	return status.Errorf(codes.Unimplemented, "method Subscribe not implemented")
+}
+func (UnimplementedOrchestratorServer) RegisterAgent(context.Context, *RegisterAgentRequest) (*RegisterAgentResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RegisterAgent not implemented")
+}
+func (UnimplementedOrchestratorServer) AgentHeartbeat(context.Context, *AgentHeartbeatRequest) (*AgentHeartbeatResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AgentHeartbeat not implemented")
+}
+func (UnimplementedOrchestratorServer) PublishTaskEvent(context.Context, *PublishTaskEventRequest) (*PublishTaskEventResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PublishTaskEvent not implemented")
+}
+func (UnimplementedOrchestratorServer) mustEmbedUnimplementedOrchestratorServer() {}
+
+// UnsafeOrchestratorServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to OrchestratorServer will
+// result in compilation errors.
+type UnsafeOrchestratorServer interface {
+	mustEmbedUnimplementedOrchestratorServer()
+}
+
+func RegisterOrchestratorServer(s grpc.ServiceRegistrar, srv OrchestratorServer) {
+	s.RegisterService(&Orchestrator_ServiceDesc, srv)
+}
+
+func _Orchestrator_ClaimTask_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ClaimTaskRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrchestratorServer).ClaimTask(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Orchestrator_ClaimTask_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrchestratorServer).ClaimTask(ctx, req.(*ClaimTaskRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Orchestrator_ReportResult_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReportResultRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrchestratorServer).ReportResult(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Orchestrator_ReportResult_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrchestratorServer).ReportResult(ctx, req.(*ReportResultRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Orchestrator_Heartbeat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HeartbeatRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrchestratorServer).Heartbeat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Orchestrator_Heartbeat_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrchestratorServer).Heartbeat(ctx, req.(*HeartbeatRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Orchestrator_GetDurations_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetDurationsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrchestratorServer).GetDurations(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Orchestrator_GetDurations_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrchestratorServer).GetDurations(ctx, req.(*GetDurationsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Orchestrator_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(OrchestratorServer).Subscribe(m, &orchestratorSubscribeServer{stream})
+}
+
+type Orchestrator_SubscribeServer interface {
+	Send(*OperationsReady) error
+	grpc.ServerStream
+}
+
+type orchestratorSubscribeServer struct {
+	grpc.ServerStream
+}
+
+func (x *orchestratorSubscribeServer) Send(m *OperationsReady) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Orchestrator_RegisterAgent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegisterAgentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrchestratorServer).RegisterAgent(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Orchestrator_RegisterAgent_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrchestratorServer).RegisterAgent(ctx, req.(*RegisterAgentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Orchestrator_AgentHeartbeat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AgentHeartbeatRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrchestratorServer).AgentHeartbeat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Orchestrator_AgentHeartbeat_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrchestratorServer).AgentHeartbeat(ctx, req.(*AgentHeartbeatRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Orchestrator_PublishTaskEvent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PublishTaskEventRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrchestratorServer).PublishTaskEvent(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Orchestrator_PublishTaskEvent_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrchestratorServer).PublishTaskEvent(ctx, req.(*PublishTaskEventRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Orchestrator_ServiceDesc is the grpc.ServiceDesc for Orchestrator service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Orchestrator_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "calc.agent.v1.Orchestrator",
+	HandlerType: (*OrchestratorServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ClaimTask",
+			Handler:    _Orchestrator_ClaimTask_Handler,
+		},
+		{
+			MethodName: "ReportResult",
+			Handler:    _Orchestrator_ReportResult_Handler,
+		},
+		{
+			MethodName: "Heartbeat",
+			Handler:    _Orchestrator_Heartbeat_Handler,
+		},
+		{
+			MethodName: "GetDurations",
+			Handler:    _Orchestrator_GetDurations_Handler,
+		},
+		{
+			MethodName: "RegisterAgent",
+			Handler:    _Orchestrator_RegisterAgent_Handler,
+		},
+		{
+			MethodName: "AgentHeartbeat",
+			Handler:    _Orchestrator_AgentHeartbeat_Handler,
+		},
+		{
+			MethodName: "PublishTaskEvent",
+			Handler:    _Orchestrator_PublishTaskEvent_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Subscribe",
+			Handler:       _Orchestrator_Subscribe_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "agent.proto",
+}
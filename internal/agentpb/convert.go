@@ -0,0 +1,48 @@
+package agentpb
+
+import (
+	"github.com/Dadil/project/internal/orchestra/domain"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// FromOperation переводит операцию в сообщение протокола
+func FromOperation(op *domain.Operation) *Operation {
+	msg := &Operation{
+		Id:         op.ID,
+		TaskId:     op.TaskID,
+		ParentId:   op.ParentID,
+		Operator:   op.Operator,
+		LeftValue:  op.LeftValue,
+		RightValue: op.RightValue,
+		OwnerAgent: op.OwnerAgent,
+		Attempts:   int32(op.Attempts),
+		LastError:  op.LastError,
+		NoCache:    op.NoCache,
+	}
+	if op.LeaseExpiresAt != nil {
+		msg.LeaseExpiresAt = timestamppb.New(*op.LeaseExpiresAt)
+	}
+	return msg
+}
+
+// Domain переводит сообщение протокола в операцию
+func (msg *Operation) Domain() *domain.Operation {
+	op := &domain.Operation{
+		ID:         msg.Id,
+		TaskID:     msg.TaskId,
+		ParentID:   msg.ParentId,
+		Operator:   msg.Operator,
+		LeftValue:  msg.LeftValue,
+		RightValue: msg.RightValue,
+		Status:     domain.OperationProcessing,
+		OwnerAgent: msg.OwnerAgent,
+		Attempts:   int(msg.Attempts),
+		LastError:  msg.LastError,
+		NoCache:    msg.NoCache,
+	}
+	if msg.LeaseExpiresAt != nil {
+		leaseExpiresAt := msg.LeaseExpiresAt.AsTime()
+		op.LeaseExpiresAt = &leaseExpiresAt
+	}
+	return op
+}
@@ -0,0 +1,5 @@
+// Package agentpb содержит gRPC-протокол между оркестратором и агентами.
+// agent.pb.go и agent_grpc.pb.go сгенерированы из agent.proto.
+package agentpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative agent.proto
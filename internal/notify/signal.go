@@ -0,0 +1,42 @@
+// Package notify содержит сигнал пробуждения, общий для агентов и
+// оркестратора: агенты ждут его, а хранилище и gRPC-сервис оркестратора
+// сообщают через него о готовых операциях.
+package notify
+
+import "sync"
+
+// Signal будит всех ожидающих воркеров одного или нескольких агентов,
+// когда хранилище сообщает о готовых операциях
+type Signal struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+func NewSignal() *Signal {
+	return &Signal{ch: make(chan struct{})}
+}
+
+// Wait возвращает канал, который закроется при следующем Broadcast. Канал
+// нужно получить до проверки очереди, чтобы не пропустить уведомление,
+// пришедшее между проверкой и ожиданием.
+func (s *Signal) Wait() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ch
+}
+
+// Broadcast будит всех, кто ждет канал из Wait
+func (s *Signal) Broadcast() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	close(s.ch)
+	s.ch = make(chan struct{})
+}
+
+// Forward вызывает Broadcast на каждое значение из notifications,
+// пока канал не закроется
+func (s *Signal) Forward(notifications <-chan struct{}) {
+	for range notifications {
+		s.Broadcast()
+	}
+}
@@ -0,0 +1,34 @@
+package notify_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Dadil/project/internal/notify"
+)
+
+func TestSignal(t *testing.T) {
+	signal := notify.NewSignal()
+	first, second := signal.Wait(), signal.Wait()
+
+	notifications := make(chan struct{})
+	go signal.Forward(notifications)
+	notifications <- struct{}{}
+
+	// Одно уведомление будит всех ожидающих
+	for _, wait := range []<-chan struct{}{first, second} {
+		select {
+		case <-wait:
+		case <-time.After(time.Second):
+			t.Fatal("Waiter was not woken up")
+		}
+	}
+
+	// Канал, полученный после уведомления, ждет следующего
+	select {
+	case <-signal.Wait():
+		t.Fatal("Waiter should not be woken up without a new notification")
+	default:
+	}
+	close(notifications)
+}
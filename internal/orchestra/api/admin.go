@@ -0,0 +1,90 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/Dadil/project/internal/orchestra/domain"
+	"github.com/gorilla/mux"
+)
+
+// setupAdminRoutes регистрирует эндпоинты администратора на подмаршрутизаторе
+// с проверкой роли (см. withRole)
+func (api *OrchestratorAPI) setupAdminRoutes(admin *mux.Router) {
+	admin.HandleFunc("/admin/tasks", api.GetAllTasks).Methods("GET")
+	admin.HandleFunc("/admin/users", api.GetUsers).Methods("GET")
+	admin.HandleFunc("/admin/users/{login}/disable", api.DisableUser).Methods("POST")
+	admin.HandleFunc("/admin/users/{login}/enable", api.EnableUser).Methods("POST")
+	admin.HandleFunc("/admin/cache", api.GetCacheStats).Methods("GET")
+}
+
+// GetAllTasks возвращает задачи всех пользователей
+func (api *OrchestratorAPI) GetAllTasks(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received request to get all tasks")
+
+	tasks := api.Orchestrator.GetTasks(r.Context())
+	if tasks == nil {
+		tasks = []domain.Task{}
+	}
+	jsonResponse(w, tasks)
+}
+
+// GetUsers возвращает пользователей с их ролями
+func (api *OrchestratorAPI) GetUsers(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received request to get users")
+
+	users, err := api.Orchestrator.ListUsers(r.Context())
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if users == nil {
+		users = []domain.User{}
+	}
+	jsonResponse(w, users)
+}
+
+// DisableUser отключает пользователя и отзывает его сессии. Отключить
+// самого себя нельзя, чтобы не остаться без администратора.
+func (api *OrchestratorAPI) DisableUser(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received request to disable user")
+
+	login := mux.Vars(r)["login"]
+	if login == CurrentPrincipal(r.Context()).Login {
+		http.Error(w, "Cannot disable yourself", http.StatusBadRequest)
+		return
+	}
+	api.setUserDisabled(w, r, login, true)
+}
+
+// EnableUser снова разрешает пользователю входить
+func (api *OrchestratorAPI) EnableUser(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received request to enable user")
+
+	api.setUserDisabled(w, r, mux.Vars(r)["login"], false)
+}
+
+func (api *OrchestratorAPI) setUserDisabled(w http.ResponseWriter, r *http.Request, login string, disabled bool) {
+	err := api.Orchestrator.SetUserDisabled(r.Context(), login, disabled, api.AccessTokenTTL)
+	if errors.Is(err, domain.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := api.Orchestrator.GetUserByLogin(r.Context(), login)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	jsonResponse(w, user)
+}
+
+// GetCacheStats возвращает метрики кеша результатов оркестратора
+func (api *OrchestratorAPI) GetCacheStats(w http.ResponseWriter, r *http.Request) {
+	jsonResponse(w, api.Orchestrator.CacheStats())
+}
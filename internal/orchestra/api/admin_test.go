@@ -0,0 +1,102 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Dadil/project/internal/orchestra/api"
+	"github.com/Dadil/project/internal/orchestra/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// loginAdmin создает администратора root, как при запуске оркестратора, и входит от его имени
+func loginAdmin(t *testing.T, orchestratorAPI *api.OrchestratorAPI) tokens {
+	t.Helper()
+	require.NoError(t, orchestratorAPI.Orchestrator.EnsureAdmin(context.Background(), "root", "password"))
+
+	rec := do(orchestratorAPI, "POST", "/login", "", `{"login": "root", "password": "password"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var result tokens
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&result))
+	return result
+}
+
+func TestAdminRoutes(t *testing.T) {
+	orchestratorAPI := newAPI(t)
+	alice := login(t, orchestratorAPI, "alice")
+	root := loginAdmin(t, orchestratorAPI)
+
+	// Обычный пользователь получает 403, без токена — 401
+	for _, path := range []string{"/admin/tasks", "/admin/users", "/admin/cache"} {
+		assert.Equal(t, http.StatusForbidden, do(orchestratorAPI, "GET", path, alice.Token, "").Code, path)
+		assert.Equal(t, http.StatusUnauthorized, do(orchestratorAPI, "GET", path, "", "").Code, path)
+	}
+	assert.Equal(t, http.StatusForbidden, do(orchestratorAPI, "PUT", "/settings/durations", alice.Token, `{"+": 1}`).Code)
+	assert.Equal(t, http.StatusOK, do(orchestratorAPI, "GET", "/settings/durations", alice.Token, "").Code)
+
+	// Администратор видит задачи всех пользователей
+	require.Equal(t, http.StatusOK, do(orchestratorAPI, "POST", "/add", alice.Token, `{"expression": "2 + 2"}`).Code)
+	rec := do(orchestratorAPI, "GET", "/admin/tasks", root.Token, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var tasks []domain.Task
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&tasks))
+	assert.Len(t, tasks, 1)
+
+	rec = do(orchestratorAPI, "GET", "/admin/users", root.Token, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var users []map[string]interface{}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&users))
+	require.Len(t, users, 2)
+	assert.Equal(t, map[string]interface{}{"login": "alice", "role": "user", "disabled": false}, users[0])
+	assert.Equal(t, "admin", users[1]["role"])
+
+	assert.Equal(t, http.StatusOK, do(orchestratorAPI, "PUT", "/settings/durations", root.Token, `{"+": 1}`).Code)
+}
+
+func TestDisableUser(t *testing.T) {
+	orchestratorAPI := newAPI(t)
+	alice := login(t, orchestratorAPI, "alice")
+	root := loginAdmin(t, orchestratorAPI)
+
+	assert.Equal(t, http.StatusNotFound, do(orchestratorAPI, "POST", "/admin/users/bob/disable", root.Token, "").Code)
+	assert.Equal(t, http.StatusBadRequest, do(orchestratorAPI, "POST", "/admin/users/root/disable", root.Token, "").Code)
+	require.Equal(t, http.StatusOK, do(orchestratorAPI, "POST", "/admin/users/alice/disable", root.Token, "").Code)
+
+	// Отключение действует сразу: выданный access-токен больше не принимается
+	assert.Equal(t, http.StatusUnauthorized, do(orchestratorAPI, "POST", "/add", alice.Token, `{"expression": "2 + 2"}`).Code)
+	assert.Equal(t, http.StatusUnauthorized, do(orchestratorAPI, "GET", "/expressions/stream", alice.Token, "").Code)
+
+	// Отключенный пользователь не может войти или обновить токен
+	credentials := `{"login": "alice", "password": "password"}`
+	assert.Equal(t, http.StatusForbidden, do(orchestratorAPI, "POST", "/login", "", credentials).Code)
+	rec := do(orchestratorAPI, "POST", "/token/refresh", "", `{"refresh_token": "`+alice.RefreshToken+`"}`)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	require.Equal(t, http.StatusOK, do(orchestratorAPI, "POST", "/admin/users/alice/enable", root.Token, "").Code)
+	assert.Equal(t, http.StatusOK, do(orchestratorAPI, "POST", "/login", "", credentials).Code)
+}
+
+func TestGetCacheStats(t *testing.T) {
+	orchestratorAPI := newAPI(t)
+	alice := login(t, orchestratorAPI, "alice")
+	root := loginAdmin(t, orchestratorAPI)
+
+	getStats := func() domain.CacheStats {
+		rec := do(orchestratorAPI, "GET", "/admin/cache", root.Token, "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		var stats domain.CacheStats
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&stats))
+		return stats
+	}
+	assert.False(t, getStats().Enabled)
+
+	// Задача с no_cache не обращается к кешу
+	orchestratorAPI.Orchestrator.Cache = domain.NewResultCache(10, time.Hour)
+	require.Equal(t, http.StatusOK, do(orchestratorAPI, "POST", "/add", alice.Token, `{"expression": "2 + 2"}`).Code)
+	require.Equal(t, http.StatusOK, do(orchestratorAPI, "POST", "/add", alice.Token, `{"expression": "3 + 3", "no_cache": true}`).Code)
+	assert.Equal(t, domain.CacheStats{Enabled: true, Misses: 1, MaxEntries: 10}, getStats())
+}
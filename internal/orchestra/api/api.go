@@ -2,23 +2,35 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/Dadil/project/internal/agent/expression"
 	"github.com/Dadil/project/internal/orchestra/domain"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type expressionRequest struct {
 	Expression string `json:"expression"`
+	// Deduplicate отклоняет выражение, если у пользователя уже есть задача с таким же текстом
+	Deduplicate bool `json:"deduplicate"`
+	// NoCache вычисляет выражение заново, без результатов из кеша
+	NoCache bool `json:"no_cache"`
 }
 
+// maxIdempotencyKeyLength — наибольшая длина заголовка Idempotency-Key
+const maxIdempotencyKeyLength = 255
+
 type User struct {
 	Login    string `json:"login"`
 	Password string `json:"password"`
@@ -27,12 +39,41 @@ type User struct {
 type OrchestratorAPI struct {
 	Router       *mux.Router
 	Orchestrator *domain.Orchestrator
+	JWTSecret    []byte
+	// AgentToken — общий секрет агентов для /internal/...; пустой отключает эти эндпоинты
+	AgentToken []byte
+	// Срок действия access-токена (JWT) и refresh-токена сессии
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	// MaxBatchSize — наибольшее число выражений в POST /add/batch
+	MaxBatchSize int
+	// MaxBodyBytes — наибольший размер тела запроса
+	MaxBodyBytes int64
+
+	// streamsDone закрывается при остановке сервера (см. CloseStreams)
+	streamsDone chan struct{}
+	closeOnce   sync.Once
 }
 
-func NewOrchestratorAPI(orchestrator *domain.Orchestrator) *OrchestratorAPI {
+const (
+	DefaultAccessTokenTTL  = 15 * time.Minute
+	DefaultRefreshTokenTTL = 30 * 24 * time.Hour
+	DefaultMaxBatchSize    = 1000
+	DefaultMaxBodyBytes    = 1 << 20
+)
+
+func NewOrchestratorAPI(orchestrator *domain.Orchestrator, jwtSecret string) *OrchestratorAPI {
 	api := &OrchestratorAPI{
 		Router:       mux.NewRouter(),
 		Orchestrator: orchestrator,
+		JWTSecret:    []byte(jwtSecret),
+
+		AccessTokenTTL:  DefaultAccessTokenTTL,
+		RefreshTokenTTL: DefaultRefreshTokenTTL,
+		MaxBatchSize:    DefaultMaxBatchSize,
+		MaxBodyBytes:    DefaultMaxBodyBytes,
+
+		streamsDone: make(chan struct{}),
 	}
 
 	api.setupRoutes()
@@ -40,30 +81,111 @@ func NewOrchestratorAPI(orchestrator *domain.Orchestrator) *OrchestratorAPI {
 }
 
 func (api *OrchestratorAPI) setupRoutes() {
+	api.Router.Use(api.limitBody)
+
+	// Маршруты без токена
 	api.Router.HandleFunc("/register", api.RegisterUser).Methods("POST")
 	api.Router.HandleFunc("/login", api.LoginUser).Methods("POST")
-	api.Router.HandleFunc("/add", api.AddExpression).Methods("POST")
-	api.Router.HandleFunc("/expressions", api.GetExpressions).Methods("GET")
-	api.Router.HandleFunc("/delete-tasks", api.DeleteAllTasksForUser).Methods("DELETE")
+	api.Router.HandleFunc("/token/refresh", api.RefreshToken).Methods("POST")
+
+	// Потоки статусов регистрируются раньше /expressions/{id}, иначе "stream" и "ws" примутся за ID
+	api.setupStreamRoutes()
+
+	// Маршруты вошедшего пользователя: обработчики получают его из контекста (см. CurrentPrincipal)
+	user := api.authenticated()
+	user.HandleFunc("/logout", api.Logout).Methods("POST")
+	user.HandleFunc("/add", api.AddExpression).Methods("POST")
+	user.HandleFunc("/add/batch", api.AddExpressions).Methods("POST")
+	user.HandleFunc("/expressions", api.GetExpressions).Methods("GET")
+	// /expressions/dead регистрируется раньше /expressions/{id}, иначе "dead" примется за ID
+	user.HandleFunc("/expressions/dead", api.GetDeadExpressions).Methods("GET")
+	user.HandleFunc("/expressions/{id}", api.GetExpression).Methods("GET")
+	user.HandleFunc("/expressions/{id}/cancel", api.CancelExpression).Methods("POST")
+	user.HandleFunc("/expressions/{id}/retry", api.RetryExpression).Methods("POST")
+	user.HandleFunc("/delete-tasks", api.DeleteAllTasksForUser).Methods("DELETE")
+	user.HandleFunc("/settings/durations", api.GetDurations).Methods("GET")
+	user.HandleFunc("/agents", api.GetAgents).Methods("GET")
+
+	// Маршруты администратора
+	admin := api.withRole(domain.RoleAdmin)
+	admin.HandleFunc("/settings/durations", api.UpdateDurations).Methods("PUT")
+	api.setupAdminRoutes(admin)
+
+	api.setupInternalRoutes()
 }
 
-func (api *OrchestratorAPI) DeleteAllTasksForUser(w http.ResponseWriter, r *http.Request) {
-	log.Println("Received request to delete all tasks for user")
+func (api *OrchestratorAPI) GetDurations(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received request to get operator durations")
 
-	authHeader := r.Header.Get("Authorization")
-	if _, err := ValidateJWTTokenFromHeader(authHeader); err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	durations, err := api.Orchestrator.GetDurations(r.Context())
+	if err != nil {
+		log.Println("Error getting operator durations:", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	login, err := ValidateJWTTokenFromHeader(authHeader)
+	jsonResponse(w, durations)
+}
+
+func (api *OrchestratorAPI) UpdateDurations(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received request to update operator durations")
+
+	// Время выполнения операторов в миллисекундах: {"+": 1000, "*": 5000}
+	var durations map[string]int
+	err := json.NewDecoder(r.Body).Decode(&durations)
 	if err != nil {
-		log.Println("Error validating JWT token:", err)
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		log.Println("Error decoding JSON:", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
 
-	err = api.Orchestrator.DeleteAllTasksForUser(login)
+	updated, err := api.Orchestrator.SetDurations(r.Context(), durations)
+	if errors.Is(err, domain.ErrInvalidDurations) {
+		log.Println("Invalid operator durations:", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Println("Error updating operator durations:", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse(w, updated)
+}
+
+// GetAgents возвращает реестр агентов: состояние, задачи, которые они
+// вычисляют сейчас, и пропускную способность
+func (api *OrchestratorAPI) GetAgents(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received request to get agents")
+
+	agents, err := api.Orchestrator.ListAgents(r.Context())
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Пустые списки отдаются как [], а не null
+	if agents == nil {
+		agents = []domain.AgentInfo{}
+	}
+	for i := range agents {
+		if agents[i].Operators == nil {
+			agents[i].Operators = []string{}
+		}
+		if agents[i].CurrentTasks == nil {
+			agents[i].CurrentTasks = []string{}
+		}
+	}
+	jsonResponse(w, agents)
+}
+
+func (api *OrchestratorAPI) DeleteAllTasksForUser(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received request to delete all tasks for user")
+
+	login := CurrentPrincipal(r.Context()).Login
+
+	err := api.Orchestrator.DeleteAllTasksForUser(r.Context(), login)
 	if err != nil {
 		log.Println("Error deleting tasks for user:", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -87,7 +209,15 @@ func (api *OrchestratorAPI) RegisterUser(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = api.Orchestrator.CreateUser(registerRequest.Login, registerRequest.Password)
+	err = api.Orchestrator.CreateUser(r.Context(), registerRequest.Login, registerRequest.Password)
+	if errors.Is(err, domain.ErrInvalidCredentials) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, domain.ErrUserExists) {
+		http.Error(w, "User already exists", http.StatusConflict)
+		return
+	}
 	if err != nil {
 		log.Println("Failed to register user:", err)
 		http.Error(w, "Failed to register user", http.StatusInternalServerError)
@@ -111,58 +241,70 @@ func (api *OrchestratorAPI) LoginUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	hashedPassword, err := api.GetUserHashByLogin(loginRequest.Login)
+	// Неизвестный логин, неверный пароль и заблокированный вход неотличимы
+	// ни по ответу, ни по времени
+	_, err = api.Orchestrator.Authenticate(r.Context(), loginRequest.Login, loginRequest.Password)
+	if errors.Is(err, domain.ErrAuthFailed) {
+		http.Error(w, "Invalid login or password", http.StatusUnauthorized)
+		return
+	}
 	if err != nil {
-		log.Println("Error retrieving user hash:", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	err = ComparePassword(loginRequest.Password, hashedPassword)
+	// Вход начинает сессию: короткий access-токен и refresh-токен для его обновления
+	session, err := api.Orchestrator.StartSession(r.Context(), loginRequest.Login, api.RefreshTokenTTL)
+	if errors.Is(err, domain.ErrUserDisabled) {
+		http.Error(w, "User is disabled", http.StatusForbidden)
+		return
+	}
 	if err != nil {
-		log.Println("Incorrect password:", err)
-		http.Error(w, "Incorrect password", http.StatusUnauthorized)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
 	log.Println("User logged in successfully:", loginRequest.Login)
+	api.writeTokens(w, "User logged in successfully", session)
+}
 
-	// Генерируем JWT токен для залогинившегося пользователя
-	tokenString, err := GenerateJWTToken(loginRequest.Login)
+// writeTokens выдает новый access-токен сессии вместе с её refresh-токеном
+func (api *OrchestratorAPI) writeTokens(w http.ResponseWriter, message string, session *domain.Session) {
+	tokenString, err := api.GenerateJWTToken(session)
 	if err != nil {
 		log.Println("Error generating JWT token:", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	// Включаем токен в ответ
-	response := map[string]string{
-		"message": "User logged in successfully",
-		"token":   tokenString,
+	response := map[string]interface{}{
+		"message":       message,
+		"token":         tokenString,
+		"refresh_token": session.RefreshToken,
+		"expires_in":    int(api.AccessTokenTTL.Seconds()),
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
-func Compare(hash string, s string) error {
-	existing := []byte(hash)
-	incoming := []byte(s)
-	log.Printf("Comparing password hash: %s with: %s\n", existing, incoming) // Добавленный лог
-	return bcrypt.CompareHashAndPassword(existing, incoming)
-}
-
-func GenerateJWTToken(login string) (string, error) {
-	// Устанавливаем время истечения срока действия токена на 24 часа от текущего момента
-	expirationTime := time.Now().Add(24 * time.Hour)
+// GenerateJWTToken создает access-токен сессии с логином и ролью
+// пользователя. Уникальный jti позволяет отозвать токен при выходе,
+// не дожидаясь истечения его срока.
+func (api *OrchestratorAPI) GenerateJWTToken(session *domain.Session) (string, error) {
+	now := time.Now()
+	expirationTime := now.Add(api.AccessTokenTTL)
 
-	// Создаем JWT токен с указанием времени истечения срока действия и логина пользователя в качестве утверждения
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"login": login,
+		"login": session.User.Login,
+		"role":  session.User.Role,
+		"sid":   session.ID,
+		"jti":   uuid.New().String(),
+		"iat":   now.Unix(),
 		"exp":   expirationTime.Unix(), // Установка времени истечения срока действия
 	})
 
 	// Подписываем токен
-	tokenString, err := token.SignedString([]byte("DbJ8nHvYX5QhragK8T5G4vsGxOgkorBtqBPMbPhPAw4="))
+	tokenString, err := token.SignedString(api.JWTSecret)
 	if err != nil {
 		return "", err
 	}
@@ -170,90 +312,166 @@ func GenerateJWTToken(login string) (string, error) {
 	return tokenString, nil
 }
 
-func ComparePassword(password string, hashedPassword string) error {
-	existing := []byte(hashedPassword)
-	incoming := []byte(password)
-	log.Printf("Comparing password hash: %s with: %s\n", existing, incoming) // Добавленный лог
-	return bcrypt.CompareHashAndPassword(existing, incoming)
-}
+// GetExpressions возвращает страницу задач пользователя. Параметры запроса:
+// status, created_from и created_to (RFC 3339), expression (подстрока),
+// sort (created, finished, result), order (asc, desc), limit и cursor —
+// next_cursor предыдущей страницы.
+func (api *OrchestratorAPI) GetExpressions(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received request to list expressions")
+
+	login := CurrentPrincipal(r.Context()).Login
 
-func (api *OrchestratorAPI) GetUserHashByLogin(login string) (string, error) {
-	user, err := api.Orchestrator.GetUserByLogin(login)
+	options, err := parseTaskListOptions(r.URL.Query())
 	if err != nil {
-		return "", err
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	if user == nil {
-		return "", fmt.Errorf("user not found")
+	page, err := api.Orchestrator.ListTasksForUser(r.Context(), login, options)
+	if errors.Is(err, domain.ErrInvalidTaskQuery) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-
-	return user.Password, nil
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	jsonResponse(w, page)
 }
 
-func ValidateJWTTokenFromHeader(header string) (string, error) {
-	tokenString := extractTokenFromHeader(header)
-	if tokenString == "" {
-		return "", fmt.Errorf("no token found in Authorization header")
+func parseTaskListOptions(values url.Values) (domain.TaskListOptions, error) {
+	options := domain.TaskListOptions{
+		TaskFilter: domain.TaskFilter{
+			Status:     values.Get("status"),
+			Expression: values.Get("expression"),
+		},
+		Sort:   values.Get("sort"),
+		Cursor: values.Get("cursor"),
 	}
 
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method")
+	switch values.Get("order") {
+	case "", "asc":
+	case "desc":
+		options.Desc = true
+	default:
+		return options, errors.New("order must be asc or desc")
+	}
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return options, errors.New("limit must be a positive number")
 		}
-		return []byte("DbJ8nHvYX5QhragK8T5G4vsGxOgkorBtqBPMbPhPAw4="), nil
-	})
-	if err != nil {
-		return "", fmt.Errorf("failed to parse JWT token: %v", err)
+		options.Limit = n
 	}
-
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok || !token.Valid {
-		return "", fmt.Errorf("invalid token")
+	for name, target := range map[string]**time.Time{
+		"created_from": &options.CreatedFrom,
+		"created_to":   &options.CreatedTo,
+	} {
+		if value := values.Get(name); value != "" {
+			moment, err := time.Parse(time.RFC3339Nano, value)
+			if err != nil {
+				return options, fmt.Errorf("%s must be an RFC 3339 time", name)
+			}
+			*target = &moment
+		}
 	}
+	return options, nil
+}
+
+func (api *OrchestratorAPI) GetExpression(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received request to get expression")
 
-	login, ok := claims["login"].(string)
-	if !ok {
-		return "", fmt.Errorf("invalid token claims")
+	login := CurrentPrincipal(r.Context()).Login
+
+	taskID := mux.Vars(r)["id"]
+	task, err := api.Orchestrator.GetTaskForUser(r.Context(), login, taskID)
+	if errors.Is(err, domain.ErrTaskNotFound) {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("Error getting task:", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
 	}
 
-	return login, nil
+	jsonResponse(w, task)
 }
 
-func (api *OrchestratorAPI) GetExpressions(w http.ResponseWriter, r *http.Request) {
-	log.Println("Received request to add expression")
+// CancelExpression отменяет задачу пользователя и возвращает её.
+// Для уже завершенной задачи возвращается 409.
+func (api *OrchestratorAPI) CancelExpression(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received request to cancel expression")
 
-	authHeader := r.Header.Get("Authorization")
-	if _, err := ValidateJWTTokenFromHeader(authHeader); err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	login := CurrentPrincipal(r.Context()).Login
+
+	taskID := mux.Vars(r)["id"]
+	task, err := api.Orchestrator.CancelTaskForUser(r.Context(), login, taskID)
+	if errors.Is(err, domain.ErrTaskNotFound) {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, domain.ErrTaskFinished) {
+		http.Error(w, "Task already finished", http.StatusConflict)
 		return
 	}
-
-	login, err := ValidateJWTTokenFromHeader(authHeader)
 	if err != nil {
-		log.Println("Error validating JWT token:", err)
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	// Продолжаем выполнение запроса
-	expressions := api.Orchestrator.GetTasksForUser(login)
-	var tasks []*domain.Task
-	for _, expr := range expressions {
-		task := expr
-		tasks = append(tasks, &task)
+	jsonResponse(w, task)
+}
+
+// GetDeadExpressions возвращает задачи пользователя, у которых исчерпаны
+// попытки вычисления
+func (api *OrchestratorAPI) GetDeadExpressions(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received request to get dead expressions")
+
+	login := CurrentPrincipal(r.Context()).Login
+
+	tasks, err := api.Orchestrator.GetDeadTasksForUser(r.Context(), login)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if tasks == nil {
+		tasks = []domain.Task{}
 	}
 	jsonResponse(w, tasks)
 }
 
-func (api *OrchestratorAPI) AddExpression(w http.ResponseWriter, r *http.Request) {
-	log.Println("Received request to add expression")
+// RetryExpression возвращает задачу в статусе dead в очередь.
+// Для задачи в другом статусе возвращается 409.
+func (api *OrchestratorAPI) RetryExpression(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received request to retry expression")
 
-	authHeader := r.Header.Get("Authorization")
-	if _, err := ValidateJWTTokenFromHeader(authHeader); err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	login := CurrentPrincipal(r.Context()).Login
+
+	taskID := mux.Vars(r)["id"]
+	task, err := api.Orchestrator.RetryTaskForUser(r.Context(), login, taskID)
+	if errors.Is(err, domain.ErrTaskNotFound) {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, domain.ErrTaskNotDead) {
+		http.Error(w, "Task is not dead", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
+	jsonResponse(w, task)
+}
+
+// AddExpression добавляет задачу пользователя. Заголовок Idempotency-Key
+// делает запрос идемпотентным: повтор с тем же ключом возвращает ID уже
+// созданной задачи с заголовком Idempotent-Replayed.
+func (api *OrchestratorAPI) AddExpression(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received request to add expression")
+
 	var expressionRequest expressionRequest
 	err := json.NewDecoder(r.Body).Decode(&expressionRequest)
 	if err != nil {
@@ -268,37 +486,68 @@ func (api *OrchestratorAPI) AddExpression(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	login, err := ValidateJWTTokenFromHeader(authHeader)
-	if err != nil {
-		log.Println("Error validating JWT token:", err)
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+	key := r.Header.Get("Idempotency-Key")
+	if len(key) > maxIdempotencyKeyLength {
+		http.Error(w, fmt.Sprintf("Idempotency-Key must be at most %d bytes long", maxIdempotencyKeyLength), http.StatusBadRequest)
 		return
 	}
 
-	existingTasks := api.Orchestrator.GetTasksForUser(login)
-	for _, task := range existingTasks {
-		if task.Expression == expressionRequest.Expression {
-			log.Println("Task with the same expression already exists")
-			http.Error(w, "Task with the same expression already exists", http.StatusBadRequest)
-			return
-		}
-	}
+	login := CurrentPrincipal(r.Context()).Login
 
-	id, err := api.Orchestrator.AddTaskForUser(expressionRequest.Expression, login)
+	options := domain.AddTaskOptions{
+		IdempotencyKey: key,
+		Deduplicate:    expressionRequest.Deduplicate,
+		NoCache:        expressionRequest.NoCache,
+	}
+	id, replayed, err := api.Orchestrator.SubmitTaskForUser(r.Context(), login, expressionRequest.Expression, options)
+	if errors.Is(err, domain.ErrDuplicateExpression) {
+		log.Println("Task with the same expression already exists")
+		http.Error(w, "Task with the same expression already exists", http.StatusConflict)
+		return
+	}
+	if errors.Is(err, domain.ErrIdempotencyKeyReused) {
+		http.Error(w, "Idempotency-Key was already used with another expression", http.StatusUnprocessableEntity)
+		return
+	}
 	if err != nil {
 		log.Println("Error adding task:", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
+	if replayed {
+		log.Println("Idempotent request replayed for task:", id)
+		w.Header().Set("Idempotent-Replayed", "true")
+	}
 	response := map[string]string{"id": id}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
+var validExpression = regexp.MustCompile(`^[\d+\-*/()\s]+$`)
+
 func IsValidExpression(expr string) bool {
-	validExpression := regexp.MustCompile(`^[\d+\-*/\s]+$`)
-	return validExpression.MatchString(expr)
+	if !validExpression.MatchString(expr) {
+		return false
+	}
+
+	// Проверяем структуру выражения: парность скобок и расстановку операторов
+	_, err := expression.Parse(expr)
+	return err == nil
+}
+
+// limitBody ограничивает тело запроса размером MaxBodyBytes: запрос с
+// заведомо большим телом отклоняется с 413, а чтение сверх предела
+// возвращает обработчику ошибку разбора
+func (api *OrchestratorAPI) limitBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > api.MaxBodyBytes {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, api.MaxBodyBytes)
+		next.ServeHTTP(w, r)
+	})
 }
 
 func jsonResponse(w http.ResponseWriter, data interface{}) {
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+)
+
+// Principal — пользователь, от имени которого выполняется запрос, и его
+// access-токен
+type Principal struct {
+	Login string
+	// Role — роль пользователя на момент выдачи токена
+	Role      string
+	SessionID string
+	// TokenID — jti access-токена, по нему токен отзывается при выходе
+	TokenID   string
+	ExpiresAt time.Time
+}
+
+type principalKey struct{}
+
+// CurrentPrincipal возвращает пользователя запроса, которого проверил
+// requireAuth. Для маршрутов без аутентификации возвращает nil.
+func CurrentPrincipal(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalKey{}).(*Principal)
+	return principal
+}
+
+// authenticated возвращает подмаршрутизатор, маршруты которого доступны
+// только с действительным access-токеном
+func (api *OrchestratorAPI) authenticated() *mux.Router {
+	router := api.Router.NewRoute().Subrouter()
+	router.Use(api.requireAuth)
+	return router
+}
+
+// withRole возвращает подмаршрутизатор, маршруты которого доступны только
+// пользователям с ролью role
+func (api *OrchestratorAPI) withRole(role string) *mux.Router {
+	router := api.authenticated()
+	router.Use(requireRole(role))
+	return router
+}
+
+// requireRole пропускает только пользователей с ролью role; остальные
+// получают 403. Подключается после requireAuth.
+func requireRole(role string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if principal := CurrentPrincipal(r.Context()); principal == nil || principal.Role != role {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// tokenFromQuery передает access-токен из параметра access_token, если
+// запрос пришел без заголовка Authorization
+func tokenFromQuery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := r.URL.Query().Get("access_token"); token != "" && r.Header.Get("Authorization") == "" {
+			r = r.Clone(r.Context())
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireAuth проверяет access-токен один раз на запрос и передает
+// пользователя обработчику через контекст
+func (api *OrchestratorAPI) requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := api.ValidateJWTTokenFromHeader(r.Context(), r.Header.Get("Authorization"))
+		if err != nil {
+			log.Println("Error validating JWT token:", err)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		ctx := context.WithValue(r.Context(), principalKey{}, principal)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// ValidateJWTTokenFromHeader проверяет access-токен из заголовка
+// Authorization и возвращает его владельца. Токены, отозванные при выходе
+// сами или вместе с сессией, отклоняются.
+func (api *OrchestratorAPI) ValidateJWTTokenFromHeader(ctx context.Context, header string) (*Principal, error) {
+	tokenString := extractTokenFromHeader(header)
+	if tokenString == "" {
+		return nil, fmt.Errorf("no token found in Authorization header")
+	}
+
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method")
+		}
+		return api.JWTSecret, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JWT token: %v", err)
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	login, _ := claims["login"].(string)
+	role, _ := claims["role"].(string)
+	sessionID, _ := claims["sid"].(string)
+	jti, _ := claims["jti"].(string)
+	expiresAt, err := claims.GetExpirationTime()
+	if login == "" || role == "" || sessionID == "" || jti == "" || err != nil || expiresAt == nil {
+		return nil, fmt.Errorf("invalid token claims")
+	}
+
+	denied, err := api.Orchestrator.IsAccessTokenDenied(ctx, jti, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if denied {
+		return nil, fmt.Errorf("token revoked")
+	}
+
+	return &Principal{Login: login, Role: role, SessionID: sessionID, TokenID: jti, ExpiresAt: expiresAt.Time}, nil
+}
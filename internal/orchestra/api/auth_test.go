@@ -0,0 +1,174 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Dadil/project/internal/orchestra/api"
+	"github.com/Dadil/project/internal/orchestra/domain"
+	"github.com/Dadil/project/internal/storage/memstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type tokens struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// newAPI создает API оркестратора на хранилище в памяти
+func newAPI(t *testing.T) *api.OrchestratorAPI {
+	t.Helper()
+	store := memstore.New()
+	return api.NewOrchestratorAPI(domain.NewOrchestrator(store, store, store), "secret")
+}
+
+// do выполняет запрос к API с access-токеном token, если он задан
+func do(orchestratorAPI *api.OrchestratorAPI, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	orchestratorAPI.Router.ServeHTTP(rec, req)
+	return rec
+}
+
+// login регистрирует пользователя и входит от его имени
+func login(t *testing.T, orchestratorAPI *api.OrchestratorAPI, user string) tokens {
+	t.Helper()
+	credentials := `{"login": "` + user + `", "password": "password"}`
+	require.Equal(t, http.StatusOK, do(orchestratorAPI, "POST", "/register", "", credentials).Code)
+
+	rec := do(orchestratorAPI, "POST", "/login", "", credentials)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var result tokens
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&result))
+	return result
+}
+
+func TestRequireAuth(t *testing.T) {
+	orchestratorAPI := newAPI(t)
+	alice := login(t, orchestratorAPI, "alice")
+	bob := login(t, orchestratorAPI, "bob")
+
+	// Без токена и с чужой подписью маршруты пользователя недоступны
+	assert.Equal(t, http.StatusUnauthorized, do(orchestratorAPI, "GET", "/expressions", "", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, do(orchestratorAPI, "GET", "/expressions", "not-a-jwt", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, do(orchestratorAPI, "POST", "/add", "", `{"expression": "2 + 2"}`).Code)
+
+	// Обработчик получает пользователя из контекста: задача видна только её владельцу
+	require.Equal(t, http.StatusOK, do(orchestratorAPI, "POST", "/add", alice.Token, `{"expression": "2 + 2"}`).Code)
+	var page domain.TaskPage
+	require.NoError(t, json.NewDecoder(do(orchestratorAPI, "GET", "/expressions", alice.Token, "").Body).Decode(&page))
+	assert.Len(t, page.Tasks, 1)
+	page = domain.TaskPage{}
+	require.NoError(t, json.NewDecoder(do(orchestratorAPI, "GET", "/expressions", bob.Token, "").Body).Decode(&page))
+	assert.Empty(t, page.Tasks)
+}
+
+func TestRefreshToken(t *testing.T) {
+	orchestratorAPI := newAPI(t)
+	first := login(t, orchestratorAPI, "alice")
+	assert.Equal(t, int(api.DefaultAccessTokenTTL.Seconds()), first.ExpiresIn)
+
+	rec := do(orchestratorAPI, "POST", "/token/refresh", "", `{"refresh_token": "`+first.RefreshToken+`"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var second tokens
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&second))
+	assert.NotEqual(t, first.RefreshToken, second.RefreshToken)
+	assert.Equal(t, http.StatusOK, do(orchestratorAPI, "GET", "/expressions", second.Token, "").Code)
+
+	// Повторное предъявление обмененного токена отзывает сессию вместе с новым токеном
+	rec = do(orchestratorAPI, "POST", "/token/refresh", "", `{"refresh_token": "`+first.RefreshToken+`"}`)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	rec = do(orchestratorAPI, "POST", "/token/refresh", "", `{"refresh_token": "`+second.RefreshToken+`"}`)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestLogout(t *testing.T) {
+	orchestratorAPI := newAPI(t)
+	session := login(t, orchestratorAPI, "alice")
+
+	assert.Equal(t, http.StatusUnauthorized, do(orchestratorAPI, "POST", "/logout", "", "").Code)
+	require.Equal(t, http.StatusOK, do(orchestratorAPI, "POST", "/logout", session.Token, "").Code)
+
+	// После выхода не действуют ни access-токен, ни refresh-токен сессии
+	assert.Equal(t, http.StatusUnauthorized, do(orchestratorAPI, "GET", "/expressions", session.Token, "").Code)
+	rec := do(orchestratorAPI, "POST", "/token/refresh", "", `{"refresh_token": "`+session.RefreshToken+`"}`)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestLogout_RevokesSession(t *testing.T) {
+	orchestratorAPI := newAPI(t)
+	first := login(t, orchestratorAPI, "alice")
+	rec := do(orchestratorAPI, "POST", "/login", "", `{"login": "alice", "password": "password"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var other tokens
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&other))
+
+	rec = do(orchestratorAPI, "POST", "/token/refresh", "", `{"refresh_token": "`+first.RefreshToken+`"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var refreshed tokens
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&refreshed))
+
+	// Выход с новым токеном отзывает и токен, выданный сессии раньше
+	require.Equal(t, http.StatusOK, do(orchestratorAPI, "POST", "/logout", refreshed.Token, "").Code)
+	assert.Equal(t, http.StatusUnauthorized, do(orchestratorAPI, "GET", "/expressions", first.Token, "").Code)
+	assert.Equal(t, http.StatusUnauthorized, do(orchestratorAPI, "GET", "/expressions", refreshed.Token, "").Code)
+
+	// Другие сессии пользователя продолжают работать
+	assert.Equal(t, http.StatusOK, do(orchestratorAPI, "GET", "/expressions", other.Token, "").Code)
+}
+
+func TestRegisterUser_Validation(t *testing.T) {
+	orchestratorAPI := newAPI(t)
+
+	for _, credentials := range []string{
+		`{"login": "al", "password": "password"}`,
+		`{"login": "alice smith", "password": "password"}`,
+		`{"login": "alice", "password": "short"}`,
+		`{"login": "password", "password": "password"}`,
+	} {
+		assert.Equal(t, http.StatusBadRequest, do(orchestratorAPI, "POST", "/register", "", credentials).Code, credentials)
+	}
+
+	credentials := `{"login": "alice", "password": "password"}`
+	require.Equal(t, http.StatusOK, do(orchestratorAPI, "POST", "/register", "", credentials).Code)
+	assert.Equal(t, http.StatusConflict, do(orchestratorAPI, "POST", "/register", "", credentials).Code)
+}
+
+func TestLoginUser_Failures(t *testing.T) {
+	orchestratorAPI := newAPI(t)
+	login(t, orchestratorAPI, "alice")
+
+	// Неизвестный логин и неверный пароль дают одинаковый ответ
+	unknown := do(orchestratorAPI, "POST", "/login", "", `{"login": "bob", "password": "password"}`)
+	wrong := do(orchestratorAPI, "POST", "/login", "", `{"login": "alice", "password": "wrong-password"}`)
+	assert.Equal(t, http.StatusUnauthorized, unknown.Code)
+	assert.Equal(t, http.StatusUnauthorized, wrong.Code)
+	assert.Equal(t, unknown.Body.String(), wrong.Body.String())
+
+	// После MaxLoginAttempts неудач подряд вход блокируется даже с верным
+	// паролем, но ответ тот же, что и для неизвестного логина
+	for i := 1; i < domain.DefaultMaxLoginAttempts; i++ {
+		rec := do(orchestratorAPI, "POST", "/login", "", `{"login": "alice", "password": "wrong-password"}`)
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	}
+	locked := do(orchestratorAPI, "POST", "/login", "", `{"login": "alice", "password": "password"}`)
+	assert.Equal(t, unknown.Code, locked.Code)
+	assert.Equal(t, unknown.Header(), locked.Header())
+	assert.Equal(t, unknown.Body.String(), locked.Body.String())
+
+	// Неизвестный логин после стольких же попыток отвечает так же
+	for i := 0; i < domain.DefaultMaxLoginAttempts; i++ {
+		unknown = do(orchestratorAPI, "POST", "/login", "", `{"login": "bob", "password": "password"}`)
+	}
+	assert.Equal(t, locked.Code, unknown.Code)
+	assert.Equal(t, locked.Body.String(), unknown.Body.String())
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/Dadil/project/internal/orchestra/domain"
+)
+
+type batchRequest struct {
+	Expressions []string `json:"expressions"`
+	// Deduplicate отклоняет выражения, которые у пользователя уже есть
+	Deduplicate bool `json:"deduplicate"`
+	// NoCache вычисляет выражения заново, без результатов из кеша
+	NoCache bool `json:"no_cache"`
+}
+
+// batchItem — итог одного выражения: id созданной задачи или error
+type batchItem struct {
+	Expression string `json:"expression"`
+	ID         string `json:"id,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+type batchResponse struct {
+	Results []batchItem `json:"results"`
+	// Число созданных и отклоненных выражений
+	Created  int `json:"created"`
+	Rejected int `json:"rejected"`
+}
+
+// AddExpressions создает задачи для пакета выражений одним запросом.
+// Выражения проверяются по отдельности: неверное выражение или, если
+// запрошено deduplicate, повтор отклоняются с ошибкой в своем элементе
+// ответа, не мешая остальным.
+// Принятые задачи сохраняются одной транзакцией.
+func (api *OrchestratorAPI) AddExpressions(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received request to add expression batch")
+
+	var request batchRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Println("Error decoding JSON:", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if len(request.Expressions) == 0 || len(request.Expressions) > api.MaxBatchSize {
+		http.Error(w, fmt.Sprintf("Batch must contain 1 to %d expressions", api.MaxBatchSize), http.StatusBadRequest)
+		return
+	}
+
+	login := CurrentPrincipal(r.Context()).Login
+
+	// В оркестратор уходят только выражения, прошедшие проверку синтаксиса;
+	// positions связывает их с элементами ответа
+	response := batchResponse{Results: make([]batchItem, len(request.Expressions))}
+	var valid []string
+	var positions []int
+	for i, expression := range request.Expressions {
+		response.Results[i].Expression = expression
+		if !IsValidExpression(expression) {
+			response.Results[i].Error = "Expression is invalid"
+			continue
+		}
+		valid = append(valid, expression)
+		positions = append(positions, i)
+	}
+
+	options := domain.AddTaskOptions{Deduplicate: request.Deduplicate, NoCache: request.NoCache}
+	results, err := api.Orchestrator.AddTasksForUser(r.Context(), login, valid, options)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	for j, result := range results {
+		item := &response.Results[positions[j]]
+		if result.Err != nil {
+			item.Error = result.Err.Error()
+		} else {
+			item.ID = result.ID
+		}
+	}
+
+	for _, item := range response.Results {
+		if item.Error != "" {
+			response.Rejected++
+		} else {
+			response.Created++
+		}
+	}
+	log.Printf("Batch of %d expressions added: %d created, %d rejected", len(request.Expressions), response.Created, response.Rejected)
+	jsonResponse(w, response)
+}
@@ -0,0 +1,67 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/Dadil/project/internal/orchestra/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type batchResponse struct {
+	Results []struct {
+		Expression string `json:"expression"`
+		ID         string `json:"id"`
+		Error      string `json:"error"`
+	} `json:"results"`
+	Created  int `json:"created"`
+	Rejected int `json:"rejected"`
+}
+
+func TestAddExpressions(t *testing.T) {
+	orchestratorAPI := newAPI(t)
+	alice := login(t, orchestratorAPI, "alice")
+	require.Equal(t, http.StatusOK, do(orchestratorAPI, "POST", "/add", alice.Token, `{"expression": "1 + 1"}`).Code)
+
+	rec := do(orchestratorAPI, "POST", "/add/batch", alice.Token, `{"expressions": ["2 + 2", "2 + x", "1 + 1", "(3 * 4"], "deduplicate": true}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var response batchResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+
+	require.Len(t, response.Results, 4)
+	assert.Equal(t, 1, response.Created)
+	assert.Equal(t, 3, response.Rejected)
+	assert.Equal(t, "2 + 2", response.Results[0].Expression)
+	assert.NotEmpty(t, response.Results[0].ID)
+	assert.Empty(t, response.Results[0].Error)
+	assert.Equal(t, "Expression is invalid", response.Results[1].Error)
+	assert.Equal(t, domain.ErrDuplicateExpression.Error(), response.Results[2].Error)
+	assert.Equal(t, "Expression is invalid", response.Results[3].Error)
+
+	rec = do(orchestratorAPI, "GET", "/expressions/"+response.Results[0].ID, alice.Token, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Без deduplicate повтор выражения принимается
+	rec = do(orchestratorAPI, "POST", "/add/batch", alice.Token, `{"expressions": ["1 + 1"]}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	response = batchResponse{}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+	assert.Equal(t, 1, response.Created)
+}
+
+func TestAddExpressions_Limits(t *testing.T) {
+	orchestratorAPI := newAPI(t)
+	orchestratorAPI.MaxBatchSize = 2
+	alice := login(t, orchestratorAPI, "alice")
+
+	for _, body := range []string{
+		`{"expressions": []}`,
+		`{"expressions": ["1 + 1", "2 + 2", "3 + 3"]}`,
+		`not json`,
+	} {
+		assert.Equal(t, http.StatusBadRequest, do(orchestratorAPI, "POST", "/add/batch", alice.Token, body).Code, body)
+	}
+	assert.Equal(t, http.StatusUnauthorized, do(orchestratorAPI, "POST", "/add/batch", "", `{"expressions": ["1 + 1"]}`).Code)
+}
@@ -0,0 +1,174 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Dadil/project/internal/orchestra/api"
+	"github.com/Dadil/project/internal/orchestra/domain"
+	"github.com/Dadil/project/internal/storage/memstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetExpressions_Pagination(t *testing.T) {
+	orchestratorAPI := newAPI(t)
+	alice := login(t, orchestratorAPI, "alice")
+	for _, expression := range []string{"2 + 2", "3 * 3", "2 - 1"} {
+		require.Equal(t, http.StatusOK, do(orchestratorAPI, "POST", "/add", alice.Token, `{"expression": "`+expression+`"}`).Code)
+	}
+
+	list := func(query url.Values) domain.TaskPage {
+		t.Helper()
+		rec := do(orchestratorAPI, "GET", "/expressions?"+query.Encode(), alice.Token, "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var page domain.TaskPage
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&page))
+		return page
+	}
+
+	first := list(url.Values{"limit": {"2"}, "order": {"desc"}})
+	require.Len(t, first.Tasks, 2)
+	assert.Equal(t, 3, first.Total)
+	require.NotEmpty(t, first.NextCursor)
+
+	second := list(url.Values{"limit": {"2"}, "order": {"desc"}, "cursor": {first.NextCursor}})
+	require.Len(t, second.Tasks, 1)
+	assert.Empty(t, second.NextCursor)
+	var expressions []string
+	for _, task := range append(first.Tasks, second.Tasks...) {
+		expressions = append(expressions, task.Expression)
+	}
+	assert.ElementsMatch(t, []string{"2 + 2", "3 * 3", "2 - 1"}, expressions)
+
+	filtered := list(url.Values{"expression": {"2 "}, "status": {"pending"}})
+	assert.Equal(t, 2, filtered.Total)
+	assert.Len(t, filtered.Tasks, 2)
+
+	// Курсор действует только с тем порядком, для которого выдан
+	for _, query := range []url.Values{
+		{"sort": {"name"}},
+		{"order": {"up"}},
+		{"limit": {"0"}},
+		{"limit": {"1000"}},
+		{"status": {"done"}},
+		{"created_from": {"yesterday"}},
+		{"cursor": {"garbage"}},
+		{"cursor": {first.NextCursor}},
+	} {
+		rec := do(orchestratorAPI, "GET", "/expressions?"+query.Encode(), alice.Token, "")
+		assert.Equal(t, http.StatusBadRequest, rec.Code, query.Encode())
+	}
+}
+
+// addWithKey добавляет выражение с заголовком Idempotency-Key
+func addWithKey(orchestratorAPI *api.OrchestratorAPI, token, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/add", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Idempotency-Key", key)
+	rec := httptest.NewRecorder()
+	orchestratorAPI.Router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAddExpression_IdempotencyKey(t *testing.T) {
+	orchestratorAPI := newAPI(t)
+	alice := login(t, orchestratorAPI, "alice")
+	bob := login(t, orchestratorAPI, "bob")
+
+	rec := addWithKey(orchestratorAPI, alice.Token, "key-1", `{"expression": "2 + 2"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("Idempotent-Replayed"))
+	var first map[string]string
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&first))
+
+	// Повтор возвращает ту же задачу с пометкой в заголовке
+	rec = addWithKey(orchestratorAPI, alice.Token, "key-1", `{"expression": "2 + 2"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "true", rec.Header().Get("Idempotent-Replayed"))
+	var second map[string]string
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&second))
+	assert.Equal(t, first["id"], second["id"])
+
+	// Ключ с другим выражением — 422; ключи разных пользователей независимы
+	assert.Equal(t, http.StatusUnprocessableEntity, addWithKey(orchestratorAPI, alice.Token, "key-1", `{"expression": "3 + 3"}`).Code)
+	rec = addWithKey(orchestratorAPI, bob.Token, "key-1", `{"expression": "3 + 3"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("Idempotent-Replayed"))
+
+	rec = do(orchestratorAPI, "GET", "/expressions", alice.Token, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var page domain.TaskPage
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&page))
+	assert.Equal(t, 1, page.Total)
+
+	assert.Equal(t, http.StatusBadRequest, addWithKey(orchestratorAPI, alice.Token, strings.Repeat("k", 256), `{"expression": "2 + 2"}`).Code)
+}
+
+func TestAddExpression_Deduplicate(t *testing.T) {
+	orchestratorAPI := newAPI(t)
+	alice := login(t, orchestratorAPI, "alice")
+
+	// По умолчанию повтор выражения создает новую задачу
+	require.Equal(t, http.StatusOK, do(orchestratorAPI, "POST", "/add", alice.Token, `{"expression": "2 + 2"}`).Code)
+	require.Equal(t, http.StatusOK, do(orchestratorAPI, "POST", "/add", alice.Token, `{"expression": "2 + 2"}`).Code)
+
+	rec := do(orchestratorAPI, "POST", "/add", alice.Token, `{"expression": "2 + 2", "deduplicate": true}`)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	rec = do(orchestratorAPI, "POST", "/add", alice.Token, `{"expression": "3 + 3", "deduplicate": true}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestAddExpression_Limits(t *testing.T) {
+	orchestratorAPI := newAPI(t)
+	orchestratorAPI.MaxBodyBytes = 1024
+	alice := login(t, orchestratorAPI, "alice")
+
+	// Тело больше предела отклоняется, даже если размер не объявлен заранее
+	huge := `{"expression": "` + strings.Repeat("1 + ", 1000) + `1"}`
+	assert.Equal(t, http.StatusRequestEntityTooLarge, do(orchestratorAPI, "POST", "/add", alice.Token, huge).Code)
+	req := httptest.NewRequest("POST", "/add", strings.NewReader(huge))
+	req.ContentLength = -1
+	req.Header.Set("Authorization", "Bearer "+alice.Token)
+	rec := httptest.NewRecorder()
+	orchestratorAPI.Router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, do(orchestratorAPI, "POST", "/add/batch", alice.Token, `{"expressions": ["`+strings.Repeat("1", 2000)+`"]}`).Code)
+
+	// Слишком глубокое выражение — ошибка разбора, а не переполнение стека
+	orchestratorAPI.MaxBodyBytes = api.DefaultMaxBodyBytes
+	deep := strings.Repeat("(", 100000) + "1" + strings.Repeat(")", 100000)
+	assert.Equal(t, http.StatusBadRequest, do(orchestratorAPI, "POST", "/add", alice.Token, `{"expression": "`+deep+`"}`).Code)
+}
+
+// brokenListStore — хранилище, у которого не работает выборка задач
+type brokenListStore struct {
+	*memstore.Store
+}
+
+func (s brokenListStore) QueryTasksForUser(ctx context.Context, login string, query domain.TaskQuery) ([]domain.Task, int, error) {
+	return nil, 0, errors.New("database is unavailable")
+}
+
+func TestGetDeadExpressions(t *testing.T) {
+	store := memstore.New()
+	orchestratorAPI := api.NewOrchestratorAPI(domain.NewOrchestrator(store, store, store), "secret")
+	alice := login(t, orchestratorAPI, "alice")
+	addExpression(t, orchestratorAPI, alice.Token, "2 + 2")
+
+	rec := do(orchestratorAPI, "GET", "/expressions/dead", alice.Token, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `[]`, rec.Body.String())
+
+	// Ошибка хранилища не выдается за пустой список
+	orchestratorAPI.Orchestrator.Tasks = brokenListStore{store}
+	rec = do(orchestratorAPI, "GET", "/expressions/dead", alice.Token, "")
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+}
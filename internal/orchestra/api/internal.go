@@ -0,0 +1,255 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Dadil/project/internal/orchestra/domain"
+	"github.com/gorilla/mux"
+)
+
+// operationReport — тело запросов агента к /internal/task/{id}/...
+// Для /result заполняется ровно одно из полей Result и Error.
+type operationReport struct {
+	Owner         string     `json:"owner"`
+	Result        *float64   `json:"result,omitempty"`
+	Error         string     `json:"error,omitempty"`
+	LeaseMs       int64      `json:"lease_ms,omitempty"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+}
+
+// setupInternalRoutes регистрирует эндпоинты, через которые агенты
+// без доступа к базе забирают операции и сообщают результаты
+func (api *OrchestratorAPI) setupInternalRoutes() {
+	internal := api.Router.PathPrefix("/internal").Subrouter()
+	internal.Use(api.requireAgentToken)
+	internal.HandleFunc("/task", api.ClaimTask).Methods("GET")
+	internal.HandleFunc("/task/{id}/result", api.ReportResult).Methods("POST")
+	internal.HandleFunc("/task/{id}/lease", api.RenewTaskLease).Methods("POST")
+	internal.HandleFunc("/task/{id}/release", api.ReleaseTask).Methods("POST")
+	internal.HandleFunc("/task/{id}/retry", api.RetryTask).Methods("POST")
+	internal.HandleFunc("/task/{id}/dead", api.DeadLetterTask).Methods("POST")
+	internal.HandleFunc("/settings/durations", api.GetAgentDurations).Methods("GET")
+	internal.HandleFunc("/agents", api.RegisterAgent).Methods("POST")
+	internal.HandleFunc("/agents/heartbeat", api.HeartbeatAgent).Methods("POST")
+	internal.HandleFunc("/events", api.PublishTaskEvent).Methods("POST")
+}
+
+// requireAgentToken пропускает только запросы с токеном агентов.
+// Пока токен не задан, внутренние эндпоинты отключены.
+func (api *OrchestratorAPI) requireAgentToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(api.AgentToken) == 0 {
+			http.Error(w, "Agent access is disabled", http.StatusForbidden)
+			return
+		}
+		token := extractTokenFromHeader(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare([]byte(token), api.AgentToken) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ClaimTask захватывает готовую операцию для агента owner на lease_ms
+// миллисекунд. Если готовых операций нет, возвращает 204.
+func (api *OrchestratorAPI) ClaimTask(w http.ResponseWriter, r *http.Request) {
+	owner := r.URL.Query().Get("owner")
+	leaseMs, err := strconv.ParseInt(r.URL.Query().Get("lease_ms"), 10, 64)
+	if owner == "" || err != nil || leaseMs <= 0 {
+		http.Error(w, "owner and positive lease_ms are required", http.StatusBadRequest)
+		return
+	}
+
+	op, err := api.Orchestrator.Tasks.ClaimOperation(r.Context(), owner, time.Duration(leaseMs)*time.Millisecond)
+	if err != nil {
+		log.Println("Error claiming operation:", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if op == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	jsonResponse(w, op)
+}
+
+// ReportResult записывает результат операции или ошибку вычисления.
+// Результат запоминается в кеше оркестратора.
+func (api *OrchestratorAPI) ReportResult(w http.ResponseWriter, r *http.Request) {
+	report, ok := decodeReport(w, r)
+	if !ok {
+		return
+	}
+	if (report.Result == nil) == (report.Error == "") {
+		http.Error(w, "Exactly one of result and error is required", http.StatusBadRequest)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	var err error
+	if report.Result != nil {
+		err = api.Orchestrator.Tasks.CompleteOperation(r.Context(), id, report.Owner, *report.Result)
+		if err == nil {
+			api.Orchestrator.CacheOperationResult(r.Context(), id, *report.Result)
+		}
+	} else {
+		err = api.Orchestrator.Tasks.FailOperation(r.Context(), id, report.Owner, report.Error)
+	}
+	writeReportError(w, err)
+}
+
+// RenewTaskLease продлевает аренду операции на lease_ms миллисекунд
+func (api *OrchestratorAPI) RenewTaskLease(w http.ResponseWriter, r *http.Request) {
+	report, ok := decodeReport(w, r)
+	if !ok {
+		return
+	}
+	if report.LeaseMs <= 0 {
+		http.Error(w, "Positive lease_ms is required", http.StatusBadRequest)
+		return
+	}
+
+	lease := time.Duration(report.LeaseMs) * time.Millisecond
+	writeReportError(w, api.Orchestrator.Tasks.RenewLease(r.Context(), mux.Vars(r)["id"], report.Owner, lease))
+}
+
+// ReleaseTask возвращает операцию в очередь, не засчитывая попытку
+func (api *OrchestratorAPI) ReleaseTask(w http.ResponseWriter, r *http.Request) {
+	report, ok := decodeReport(w, r)
+	if !ok {
+		return
+	}
+
+	writeReportError(w, api.Orchestrator.Tasks.ReleaseOperation(r.Context(), mux.Vars(r)["id"], report.Owner))
+}
+
+// RetryTask возвращает операцию в очередь после временной ошибки
+func (api *OrchestratorAPI) RetryTask(w http.ResponseWriter, r *http.Request) {
+	report, ok := decodeReport(w, r)
+	if !ok {
+		return
+	}
+	if report.NextAttemptAt == nil {
+		http.Error(w, "next_attempt_at is required", http.StatusBadRequest)
+		return
+	}
+
+	err := api.Orchestrator.Tasks.RetryOperation(r.Context(), mux.Vars(r)["id"], report.Owner, report.Error, *report.NextAttemptAt)
+	writeReportError(w, err)
+}
+
+// DeadLetterTask переводит задачу операции в dead
+func (api *OrchestratorAPI) DeadLetterTask(w http.ResponseWriter, r *http.Request) {
+	report, ok := decodeReport(w, r)
+	if !ok {
+		return
+	}
+
+	writeReportError(w, api.Orchestrator.Tasks.DeadLetterOperation(r.Context(), mux.Vars(r)["id"], report.Owner, report.Error))
+}
+
+// GetAgentDurations возвращает время выполнения операторов агентам
+func (api *OrchestratorAPI) GetAgentDurations(w http.ResponseWriter, r *http.Request) {
+	durations, err := api.Orchestrator.GetDurations(r.Context())
+	if err != nil {
+		log.Println("Error getting operator durations:", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse(w, durations)
+}
+
+// RegisterAgent записывает агента в реестр агентов
+func (api *OrchestratorAPI) RegisterAgent(w http.ResponseWriter, r *http.Request) {
+	var agent domain.AgentInfo
+	if err := json.NewDecoder(r.Body).Decode(&agent); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if agent.ID == "" {
+		http.Error(w, "id is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := api.Orchestrator.Registry.RegisterAgent(r.Context(), agent); err != nil {
+		log.Println("Error registering agent:", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HeartbeatAgent отмечает сигнал жизни агента. ID агента передается в теле:
+// в нем есть символы "/". Для незарегистрированного агента возвращает 404,
+// и агент регистрируется заново.
+func (api *OrchestratorAPI) HeartbeatAgent(w http.ResponseWriter, r *http.Request) {
+	var heartbeat struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&heartbeat); err != nil || heartbeat.ID == "" {
+		http.Error(w, "id is required", http.StatusBadRequest)
+		return
+	}
+
+	err := api.Orchestrator.Registry.HeartbeatAgent(r.Context(), heartbeat.ID)
+	if errors.Is(err, domain.ErrAgentNotFound) {
+		http.Error(w, "Agent not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("Error updating agent heartbeat:", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PublishTaskEvent передает в шину событий оркестратора смену статуса
+// задачи, о которой сообщил агент
+func (api *OrchestratorAPI) PublishTaskEvent(w http.ResponseWriter, r *http.Request) {
+	var event domain.TaskEvent
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil || event.TaskID == "" || event.Status == "" {
+		http.Error(w, "task_id and status are required", http.StatusBadRequest)
+		return
+	}
+
+	api.Orchestrator.Events.PublishTaskEvent(r.Context(), event)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func decodeReport(w http.ResponseWriter, r *http.Request) (operationReport, bool) {
+	var report operationReport
+	if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return report, false
+	}
+	if report.Owner == "" {
+		http.Error(w, "owner is required", http.StatusBadRequest)
+		return report, false
+	}
+	return report, true
+}
+
+// writeReportError отвечает 204 на успешный отчет и 409, если агент
+// больше не владеет операцией
+func writeReportError(w http.ResponseWriter, err error) {
+	if errors.Is(err, domain.ErrLeaseLost) {
+		http.Error(w, "Lease lost", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Println("Error updating operation:", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
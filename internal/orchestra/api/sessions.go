@@ -0,0 +1,54 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/Dadil/project/internal/orchestra/domain"
+)
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// RefreshToken обменивает refresh-токен на новую пару токенов той же сессии.
+// Предъявленный токен становится недействительным; его повторное
+// предъявление отзывает всю сессию.
+func (api *OrchestratorAPI) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received token refresh request")
+
+	var request refreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.RefreshToken == "" {
+		http.Error(w, "refresh_token is required", http.StatusBadRequest)
+		return
+	}
+
+	session, err := api.Orchestrator.RefreshSession(r.Context(), request.RefreshToken, api.RefreshTokenTTL)
+	if errors.Is(err, domain.ErrTokenNotFound) || errors.Is(err, domain.ErrTokenReused) || errors.Is(err, domain.ErrUserDisabled) {
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	api.writeTokens(w, "Token refreshed successfully", session)
+}
+
+// Logout завершает сессию access-токена: отзывает ее refresh-токены и
+// все ее access-токены, в том числе выданные ранее при обновлении
+func (api *OrchestratorAPI) Logout(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received logout request")
+
+	principal := CurrentPrincipal(r.Context())
+	if err := api.Orchestrator.EndSession(r.Context(), principal.SessionID, principal.TokenID, principal.ExpiresAt, api.AccessTokenTTL); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	log.Println("User logged out successfully:", principal.Login)
+	jsonResponse(w, map[string]string{"message": "User logged out successfully"})
+}
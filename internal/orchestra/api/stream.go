@@ -0,0 +1,205 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Dadil/project/internal/orchestra/domain"
+	"golang.org/x/net/websocket"
+)
+
+// streamPingInterval — как часто поток SSE присылает комментарий, чтобы
+// прокси не закрыли соединение без событий
+const streamPingInterval = 15 * time.Second
+
+var (
+	// errStreamLagged — поток не успевал забирать события, и шина его отключила
+	errStreamLagged = errors.New("task stream fell behind the event bus")
+	// errStreamExpired — истек access-токен, с которым открыт поток
+	errStreamExpired = errors.New("access token of the task stream expired")
+)
+
+// finishedStatuses — статусы, после которых задача меняется только по
+// запросу пользователя (повтор задачи в статусе dead)
+var finishedStatuses = map[string]bool{"completed": true, "error": true, "cancelled": true, "dead": true}
+
+// setupStreamRoutes регистрирует потоки статусов задач. Браузер открывает
+// их через EventSource и WebSocket, которые не умеют передавать заголовок
+// Authorization, поэтому access-токен можно передать параметром access_token.
+func (api *OrchestratorAPI) setupStreamRoutes() {
+	streams := api.Router.NewRoute().Subrouter()
+	streams.Use(tokenFromQuery, api.requireAuth)
+	streams.HandleFunc("/expressions/stream", api.StreamExpressions).Methods("GET")
+	streams.HandleFunc("/expressions/ws", api.ExpressionsWebSocket).Methods("GET")
+}
+
+// CloseStreams завершает открытые потоки статусов задач, иначе остановка
+// сервера ждала бы их до истечения таймаута
+func (api *OrchestratorAPI) CloseStreams() {
+	api.closeOnce.Do(func() { close(api.streamsDone) })
+}
+
+// StreamExpressions присылает по Server-Sent Events задачи пользователя
+// каждый раз, когда меняется их статус: событие task с задачей в формате
+// GET /expressions/{id}
+func (api *OrchestratorAPI) StreamExpressions(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received request to stream expressions")
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	principal := CurrentPrincipal(r.Context())
+	// Подписка до ответа: клиент, получивший заголовки, не пропустит события
+	events, unsubscribe := api.Orchestrator.Events.Subscribe(principal.Login)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Иначе nginx копит события в буфере
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	send := func(task *domain.Task) error {
+		data, err := json.Marshal(task)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: task\ndata: %s\n\n", data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+	ping := func() error {
+		if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+	if err := api.watchTasks(r.Context(), events, principal, send, ping); err != nil {
+		log.Println("Expression stream closed:", err)
+	}
+}
+
+// ExpressionsWebSocket присылает по WebSocket задачи пользователя каждый
+// раз, когда меняется их статус: одно JSON-сообщение с задачей на каждое
+// изменение. Сообщения клиента игнорируются. Соединение со страницы
+// другого сайта отклоняется (см. checkOrigin).
+func (api *OrchestratorAPI) ExpressionsWebSocket(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received request to open expressions WebSocket")
+
+	principal := CurrentPrincipal(r.Context())
+	events, unsubscribe := api.Orchestrator.Events.Subscribe(principal.Login)
+	defer unsubscribe()
+
+	server := websocket.Server{
+		Handshake: checkOrigin,
+		Handler: func(conn *websocket.Conn) {
+			ctx, cancel := context.WithCancel(r.Context())
+			defer cancel()
+			// Чтение нужно, чтобы заметить закрытие соединения клиентом
+			go func() {
+				io.Copy(io.Discard, conn)
+				cancel()
+			}()
+
+			send := func(task *domain.Task) error {
+				return websocket.JSON.Send(conn, task)
+			}
+			if err := api.watchTasks(ctx, events, principal, send, nil); err != nil {
+				log.Println("Expression WebSocket closed:", err)
+			}
+		},
+	}
+	server.ServeHTTP(w, r)
+}
+
+// checkOrigin принимает WebSocket только со страниц самого сервера.
+// Access-токен в параметре access_token попадает в адрес, и без проверки
+// чужая страница, узнавшая адрес с токеном, открыла бы поток от имени
+// пользователя. Клиенты вне браузера Origin не передают и проходят.
+func checkOrigin(config *websocket.Config, r *http.Request) error {
+	origin, err := websocket.Origin(config, r)
+	if err != nil {
+		return err
+	}
+	if origin != nil && origin.Host != r.Host {
+		return fmt.Errorf("origin %s is not allowed", origin)
+	}
+	config.Origin = origin
+	return nil
+}
+
+// watchTasks вызывает send с текущим состоянием задачи пользователя
+// principal каждый раз, когда её статус меняется по событиям events, пока
+// ctx не отменен, сервер не остановлен, не истек access-токен principal
+// или send не вернул ошибку. ping (если не nil) вызывается раз в
+// streamPingInterval. Шина присылает только события задач пользователя, а
+// состояние задачи берется из хранилища.
+func (api *OrchestratorAPI) watchTasks(ctx context.Context, events <-chan domain.TaskEvent, principal *Principal, send func(*domain.Task) error, ping func() error) error {
+	var pings <-chan time.Time
+	if ping != nil {
+		ticker := time.NewTicker(streamPingInterval)
+		defer ticker.Stop()
+		pings = ticker.C
+	}
+	// Поток живет не дольше токена, с которым открыт: отозванный или
+	// отключенный пользователь иначе получал бы события бесконечно
+	expiry := time.NewTimer(time.Until(principal.ExpiresAt))
+	defer expiry.Stop()
+
+	// Последний отправленный статус незавершенных задач: агенты сообщают о
+	// захвате каждой операции, а клиенту нужна только смена статуса
+	sent := make(map[string]string)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-api.streamsDone:
+			return nil
+		case <-expiry.C:
+			return errStreamExpired
+		case <-pings:
+			if err := ping(); err != nil {
+				return err
+			}
+		case event, ok := <-events:
+			if !ok {
+				return errStreamLagged
+			}
+			if sent[event.TaskID] == event.Status {
+				continue
+			}
+			task, err := api.Orchestrator.GetTaskForUser(ctx, principal.Login, event.TaskID)
+			if errors.Is(err, domain.ErrTaskNotFound) {
+				// Задача другого пользователя или уже удалена
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if sent[task.ID] == task.Status {
+				continue
+			}
+			if finishedStatuses[task.Status] {
+				delete(sent, task.ID)
+			} else {
+				sent[task.ID] = task.Status
+			}
+			if err := send(task); err != nil {
+				return err
+			}
+		}
+	}
+}
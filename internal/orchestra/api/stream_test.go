@@ -0,0 +1,161 @@
+package api_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Dadil/project/internal/orchestra/api"
+	"github.com/Dadil/project/internal/orchestra/domain"
+	"github.com/Dadil/project/internal/storage/memstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+// newStreamServer запускает API на хранилище в памяти и возвращает API,
+// хранилище (чтобы захватывать операции, как агент) и адрес сервера
+func newStreamServer(t *testing.T) (*api.OrchestratorAPI, *memstore.Store, string) {
+	t.Helper()
+	store := memstore.New()
+	orchestratorAPI := api.NewOrchestratorAPI(domain.NewOrchestrator(store, store, store), "secret")
+	server := httptest.NewServer(orchestratorAPI.Router)
+	t.Cleanup(func() {
+		orchestratorAPI.CloseStreams()
+		server.Close()
+	})
+	return orchestratorAPI, store, server.URL
+}
+
+// openStream открывает поток SSE и возвращает чтение его событий
+func openStream(t *testing.T, url, token string) *bufio.Reader {
+	t.Helper()
+	req, err := http.NewRequest("GET", url+"/expressions/stream", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+token)
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	return bufio.NewReader(resp.Body)
+}
+
+// nextTask читает из потока SSE следующее событие task
+func nextTask(t *testing.T, stream *bufio.Reader) domain.Task {
+	t.Helper()
+	event := ""
+	for {
+		line, err := stream.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			require.Equal(t, "task", event)
+			var task domain.Task
+			require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &task))
+			return task
+		}
+	}
+}
+
+func addExpression(t *testing.T, orchestratorAPI *api.OrchestratorAPI, token, expression string) string {
+	t.Helper()
+	rec := do(orchestratorAPI, "POST", "/add", token, `{"expression": "`+expression+`"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var response map[string]string
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+	return response["id"]
+}
+
+func TestStreamExpressions(t *testing.T) {
+	orchestratorAPI, store, url := newStreamServer(t)
+	alice := login(t, orchestratorAPI, "alice")
+	bob := login(t, orchestratorAPI, "bob")
+	stream := openStream(t, url, alice.Token)
+
+	// Задачи других пользователей в поток не попадают
+	addExpression(t, orchestratorAPI, bob.Token, "1 + 1")
+	taskID := addExpression(t, orchestratorAPI, alice.Token, "2 + 2")
+	task := nextTask(t, stream)
+	assert.Equal(t, taskID, task.ID)
+	assert.Equal(t, "pending", task.Status)
+
+	// Агент сообщает о захвате операций обоих пользователей; повтор того же
+	// статуса не присылается
+	for i := 0; i < 2; i++ {
+		op, err := store.ClaimOperation(context.Background(), "agent", time.Minute)
+		require.NoError(t, err)
+		require.NotNil(t, op)
+		orchestratorAPI.Orchestrator.Events.PublishTaskEvent(context.Background(), domain.TaskEvent{TaskID: op.TaskID, Status: "processing"})
+	}
+	orchestratorAPI.Orchestrator.Events.PublishTaskEvent(context.Background(), domain.TaskEvent{TaskID: taskID, Status: "processing"})
+	task = nextTask(t, stream)
+	assert.Equal(t, taskID, task.ID)
+	assert.Equal(t, "processing", task.Status)
+
+	require.Equal(t, http.StatusOK, do(orchestratorAPI, "POST", "/expressions/"+taskID+"/cancel", alice.Token, "").Code)
+	task = nextTask(t, stream)
+	assert.Equal(t, taskID, task.ID)
+	assert.Equal(t, "cancelled", task.Status)
+}
+
+func TestStreamExpressions_Unauthorized(t *testing.T) {
+	orchestratorAPI, _, url := newStreamServer(t)
+	alice := login(t, orchestratorAPI, "alice")
+
+	for _, path := range []string{"/expressions/stream", "/expressions/ws", "/expressions/stream?access_token=not-a-jwt"} {
+		resp, err := http.Get(url + path)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, path)
+	}
+
+	// /expressions/stream не перехватывается маршрутом /expressions/{id}
+	assert.Equal(t, http.StatusUnauthorized, do(orchestratorAPI, "GET", "/expressions/stream", "", "").Code)
+	assert.Equal(t, http.StatusNotFound, do(orchestratorAPI, "GET", "/expressions/missing", alice.Token, "").Code)
+}
+
+func TestExpressionsWebSocket(t *testing.T) {
+	orchestratorAPI, _, url := newStreamServer(t)
+	alice := login(t, orchestratorAPI, "alice")
+
+	// Браузер не может передать заголовок, поэтому токен передается параметром
+	wsURL := "ws" + strings.TrimPrefix(url, "http") + "/expressions/ws?access_token=" + alice.Token
+	conn, err := websocket.Dial(wsURL, "", url)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	taskID := addExpression(t, orchestratorAPI, alice.Token, "2 + 2")
+	var task domain.Task
+	require.NoError(t, websocket.JSON.Receive(conn, &task))
+	assert.Equal(t, taskID, task.ID)
+	assert.Equal(t, "pending", task.Status)
+
+	// Страница другого сайта не откроет поток, даже зная адрес с токеном
+	_, err = websocket.Dial(wsURL, "", "http://evil.example")
+	assert.Error(t, err)
+}
+
+func TestStreamExpressions_TokenExpiry(t *testing.T) {
+	orchestratorAPI, _, url := newStreamServer(t)
+	orchestratorAPI.AccessTokenTTL = time.Second
+	alice := login(t, orchestratorAPI, "alice")
+
+	// Поток закрывается, когда истекает токен, с которым он открыт
+	stream := openStream(t, url, alice.Token)
+	start := time.Now()
+	_, err := io.ReadAll(stream)
+	require.NoError(t, err)
+	assert.Less(t, time.Since(start), 3*time.Second)
+}
@@ -0,0 +1,92 @@
+package domain
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Состояния агента в реестре
+const (
+	AgentOnline  = "online"
+	AgentOffline = "offline" // агент перестал присылать сигнал жизни
+)
+
+// AgentInfo — запись реестра агентов
+type AgentInfo struct {
+	// ID совпадает с владельцем аренды операций, которые вычисляет агент
+	ID        string   `json:"id"`
+	Hostname  string   `json:"hostname"`
+	Workers   int      `json:"workers"`
+	Operators []string `json:"operators"`
+	Version   string   `json:"version"`
+
+	Status        string    `json:"status"`
+	RegisteredAt  time.Time `json:"registered_at"`
+	LastHeartbeat time.Time `json:"last_heartbeat"`
+	// Задачи, операции которых агент вычисляет сейчас
+	CurrentTasks []string `json:"current_tasks"`
+	// Сколько операций агент вычислил с момента регистрации
+	CompletedOperations int `json:"completed_operations"`
+	// Throughput — вычисленных операций в минуту (см. Orchestrator.ListAgents)
+	Throughput float64 `json:"throughput"`
+}
+
+// throughput считает вычисленные операции в минуту за время работы агента:
+// для offline-агента оно заканчивается последним сигналом. Первую минуту
+// после регистрации время считается равным минуте, чтобы одна операция
+// не давала сотни операций в минуту.
+func (a AgentInfo) throughput(now time.Time) float64 {
+	end := now
+	if a.Status == AgentOffline {
+		end = a.LastHeartbeat
+	}
+	elapsed := end.Sub(a.RegisteredAt)
+	if elapsed < time.Minute {
+		elapsed = time.Minute
+	}
+	return float64(a.CompletedOperations) / elapsed.Minutes()
+}
+
+// ListAgents возвращает агентов реестра с их пропускной способностью
+func (o *Orchestrator) ListAgents(ctx context.Context) ([]AgentInfo, error) {
+	agents, err := o.Registry.ListAgents(ctx)
+	if err != nil {
+		log.Println("Error getting agents:", err)
+		return nil, err
+	}
+	now := Now()
+	for i := range agents {
+		agents[i].Throughput = agents[i].throughput(now)
+	}
+	return agents, nil
+}
+
+// ExpireAgents переводит в offline агентов, не присылавших сигнал дольше
+// timeout, и возвращает их операции в очередь
+func (o *Orchestrator) ExpireAgents(ctx context.Context, timeout time.Duration) error {
+	expired, err := o.Registry.ExpireAgents(ctx, Now().Add(-timeout))
+	if err != nil {
+		log.Println("Error expiring agents:", err)
+		return err
+	}
+	for _, id := range expired {
+		log.Printf("Agent %s is offline: no heartbeat for %s, its operations are returned to the queue", id, timeout)
+	}
+	return nil
+}
+
+// WatchAgents проверяет сигналы агентов несколько раз за timeout, пока ctx
+// не отменен
+func (o *Orchestrator) WatchAgents(ctx context.Context, timeout time.Duration) {
+	ticker := time.NewTicker(timeout / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			o.ExpireAgents(ctx, timeout)
+		}
+	}
+}
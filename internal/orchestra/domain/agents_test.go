@@ -0,0 +1,84 @@
+package domain_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Dadil/project/internal/orchestra/domain"
+)
+
+func TestListAgents(t *testing.T) {
+	ctx := context.Background()
+	orchestrator, store := newOrchestrator(t)
+
+	if err := store.RegisterAgent(ctx, domain.AgentInfo{ID: "agent-1", Workers: 2}); err != nil {
+		t.Fatalf("Error registering agent: %v", err)
+	}
+	taskID, err := orchestrator.AddTask(ctx, "2 + 2")
+	if err != nil {
+		t.Fatalf("Error adding task: %v", err)
+	}
+	op, err := store.ClaimOperation(ctx, "agent-1", time.Minute)
+	if err != nil || op == nil {
+		t.Fatalf("Expected a ready operation, got %v, %v", op, err)
+	}
+
+	agents, err := orchestrator.ListAgents(ctx)
+	if err != nil {
+		t.Fatalf("Error listing agents: %v", err)
+	}
+	if len(agents) != 1 || len(agents[0].CurrentTasks) != 1 || agents[0].CurrentTasks[0] != taskID {
+		t.Fatalf("Expected agent-1 computing task %s, got %+v", taskID, agents)
+	}
+
+	if err := store.CompleteOperation(ctx, op.ID, "agent-1", 4); err != nil {
+		t.Fatalf("Error completing operation: %v", err)
+	}
+	agents, err = orchestrator.ListAgents(ctx)
+	if err != nil {
+		t.Fatalf("Error listing agents: %v", err)
+	}
+	// В первую минуту после регистрации время работы считается равным минуте
+	if agents[0].CompletedOperations != 1 || agents[0].Throughput != 1 {
+		t.Errorf("Expected 1 completed operation and throughput 1/min, got %d and %v", agents[0].CompletedOperations, agents[0].Throughput)
+	}
+	if len(agents[0].CurrentTasks) != 0 {
+		t.Errorf("Expected no current tasks, got %v", agents[0].CurrentTasks)
+	}
+}
+
+func TestExpireAgents(t *testing.T) {
+	ctx := context.Background()
+	orchestrator, store := newOrchestrator(t)
+
+	if err := store.RegisterAgent(ctx, domain.AgentInfo{ID: "agent-1"}); err != nil {
+		t.Fatalf("Error registering agent: %v", err)
+	}
+	if _, err := orchestrator.AddTask(ctx, "2 + 2"); err != nil {
+		t.Fatalf("Error adding task: %v", err)
+	}
+	op, err := store.ClaimOperation(ctx, "agent-1", time.Minute)
+	if err != nil || op == nil {
+		t.Fatalf("Expected a ready operation, got %v, %v", op, err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if err := orchestrator.ExpireAgents(ctx, 10*time.Millisecond); err != nil {
+		t.Fatalf("Error expiring agents: %v", err)
+	}
+
+	agents, err := orchestrator.ListAgents(ctx)
+	if err != nil {
+		t.Fatalf("Error listing agents: %v", err)
+	}
+	if agents[0].Status != domain.AgentOffline {
+		t.Errorf("Expected agent to be offline, got %s", agents[0].Status)
+	}
+
+	// Операция offline-агента снова в очереди, хотя аренда ещё действует
+	reclaimed, err := store.ClaimOperation(ctx, "agent-2", time.Minute)
+	if err != nil || reclaimed == nil || reclaimed.ID != op.ID {
+		t.Errorf("Expected operation %s to be requeued, got %v, %v", op.ID, reclaimed, err)
+	}
+}
@@ -0,0 +1,77 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"log"
+)
+
+// ErrDuplicateExpression — у пользователя уже есть задача с таким выражением
+var ErrDuplicateExpression = errors.New("task with the same expression already exists")
+
+// NewTask — задача вместе с графом операций, готовая к сохранению
+type NewTask struct {
+	Task       Task
+	Operations []Operation
+}
+
+// BatchResult — итог одного выражения пакета: ID созданной задачи или
+// причина, по которой выражение отклонено
+type BatchResult struct {
+	ID  string
+	Err error
+}
+
+// AddTasksForUser создает задачи пользователя для пакета выражений.
+// Каждое выражение проверяется отдельно: ошибка разбора, а при
+// options.Deduplicate и повтор уже существующего выражения (в том числе в
+// этом же пакете) отклоняет только его. Принятые задачи сохраняются одной
+// транзакцией. Результаты идут в порядке выражений; ошибка возвращается,
+// только если сохранить пакет не удалось. Ключ идемпотентности для пакета
+// не используется.
+func (o *Orchestrator) AddTasksForUser(ctx context.Context, login string, expressions []string, options AddTaskOptions) ([]BatchResult, error) {
+	seen := make(map[string]bool)
+	if options.Deduplicate {
+		existing, err := o.Tasks.ListTasksForUser(ctx, login)
+		if err != nil {
+			log.Println("Error getting tasks for user:", err)
+			return nil, err
+		}
+		for _, task := range existing {
+			seen[task.Expression] = true
+		}
+	}
+
+	cached := o.cachedResults(ctx, options.NoCache)
+	results := make([]BatchResult, len(expressions))
+	var tasks []NewTask
+	for i, expression := range expressions {
+		if seen[expression] {
+			results[i].Err = ErrDuplicateExpression
+			continue
+		}
+		task, err := newTask(generateTaskID(), expression, cached, options.NoCache)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		if options.Deduplicate {
+			seen[expression] = true
+		}
+		tasks = append(tasks, task)
+		results[i].ID = task.Task.ID
+	}
+
+	if len(tasks) > 0 {
+		if err := o.Tasks.CreateTasks(ctx, login, tasks); err != nil {
+			if !errors.Is(err, ErrUserNotFound) {
+				log.Println("Error saving tasks:", err)
+			}
+			return nil, err
+		}
+	}
+	for _, task := range tasks {
+		o.publish(ctx, login, task.Task.ID, task.Task.Status)
+	}
+	return results, nil
+}
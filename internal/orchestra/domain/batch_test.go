@@ -0,0 +1,63 @@
+package domain_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Dadil/project/internal/orchestra/domain"
+)
+
+func TestAddTasksForUser(t *testing.T) {
+	ctx := context.Background()
+	orchestrator, _ := newOrchestrator(t)
+	if _, err := orchestrator.AddTaskForUser(ctx, "1 + 1", "testuser"); err != nil {
+		t.Fatalf("Error adding task: %v", err)
+	}
+
+	// Повтор существующего выражения, повтор внутри пакета и ошибка разбора
+	// отклоняют только свои выражения
+	results, err := orchestrator.AddTasksForUser(ctx, "testuser", []string{"2 + 2", "1 + 1", "2 + 2", "3 *", "-5"}, domain.AddTaskOptions{Deduplicate: true})
+	if err != nil {
+		t.Fatalf("Error adding tasks: %v", err)
+	}
+	if len(results) != 5 {
+		t.Fatalf("Expected 5 results, got %d", len(results))
+	}
+	if results[0].Err != nil || results[0].ID == "" || results[4].Err != nil || results[4].ID == "" {
+		t.Errorf("Expected tasks to be created, got %+v", results)
+	}
+	if results[1].Err != domain.ErrDuplicateExpression || results[2].Err != domain.ErrDuplicateExpression {
+		t.Errorf("Expected ErrDuplicateExpression for repeated expressions, got %+v", results)
+	}
+	if results[3].Err == nil || results[3].ID != "" {
+		t.Errorf("Expected invalid expression to be rejected, got %+v", results[3])
+	}
+
+	tasks := orchestrator.GetTasksForUser(ctx, "testuser")
+	if len(tasks) != 3 {
+		t.Errorf("Expected 3 tasks, got %d", len(tasks))
+	}
+	task, err := orchestrator.GetTaskForUser(ctx, "testuser", results[4].ID)
+	if err != nil || task.Status != "completed" || task.Result != -5 {
+		t.Errorf("Expected literal task to be completed, got %+v, %v", task, err)
+	}
+
+	// Без deduplicate повторы принимаются
+	results, err = orchestrator.AddTasksForUser(ctx, "testuser", []string{"1 + 1", "1 + 1"}, domain.AddTaskOptions{})
+	if err != nil {
+		t.Fatalf("Error adding tasks: %v", err)
+	}
+	for _, result := range results {
+		if result.Err != nil || result.ID == "" {
+			t.Errorf("Expected repeated expression to be accepted, got %+v", result)
+		}
+	}
+}
+
+func TestAddTasksForUser_UnknownUser(t *testing.T) {
+	orchestrator, _ := newOrchestrator(t)
+
+	if _, err := orchestrator.AddTasksForUser(context.Background(), "nobody", []string{"2 + 2"}, domain.AddTaskOptions{}); err != domain.ErrUserNotFound {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+}
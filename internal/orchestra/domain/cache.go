@@ -0,0 +1,264 @@
+package domain
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Dadil/project/internal/agent/expression"
+)
+
+// Кеш результатов по умолчанию
+const (
+	DefaultCacheMaxEntries = 10000
+	DefaultCacheTTL        = time.Hour
+)
+
+// CacheStats — метрики кеша результатов
+type CacheStats struct {
+	Enabled bool   `json:"enabled"`
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	// Evictions — записи, вытесненные из-за предела размера
+	Evictions  uint64 `json:"evictions"`
+	Entries    int    `json:"entries"`
+	MaxEntries int    `json:"max_entries"`
+}
+
+// SharedResults — хранилище записей кеша, общее для процессов (см.
+// TaskStore.GetCachedResult)
+type SharedResults interface {
+	GetCachedResult(ctx context.Context, key string) (float64, bool, error)
+	PutCachedResult(ctx context.Context, key string, result float64, expiresAt time.Time) error
+}
+
+// ResultCache — кеш результатов вычисленных операций в памяти процесса.
+// Ключ — каноническая запись выражения вместе с версией настроек времени
+// операторов (см. ResultCacheKey). Запись живет ttl; при превышении
+// maxEntries вытесняется запись, к которой дольше всего не обращались.
+// Методы nil-кеша ничего не находят и ничего не сохраняют.
+type ResultCache struct {
+	maxEntries int
+	ttl        time.Duration
+
+	// Shared — общее хранилище записей: туда попадает каждый сохраненный
+	// результат, а промах в памяти ищется там. Так оркестратор видит
+	// результаты агентов, которые работают с базой напрямую. nil — кеш
+	// только в памяти.
+	Shared SharedResults
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // от недавно использованных к давно использованным
+	stats   CacheStats
+}
+
+type cacheEntry struct {
+	key       string
+	result    float64
+	expiresAt time.Time
+}
+
+// NewResultCache создает кеш на maxEntries записей, каждая из которых
+// хранится ttl
+func NewResultCache(maxEntries int, ttl time.Duration) *ResultCache {
+	return &ResultCache{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// Get возвращает сохраненный результат, если запись есть и не истекла.
+// Ошибка общего хранилища считается промахом.
+func (c *ResultCache) Get(ctx context.Context, key string) (float64, bool) {
+	if c == nil {
+		return 0, false
+	}
+	if result, ok := c.get(key); ok {
+		return result, true
+	}
+
+	if c.Shared != nil {
+		result, ok, err := c.Shared.GetCachedResult(ctx, key)
+		if err != nil {
+			log.Println("Error getting cached result:", err)
+		}
+		if ok {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.put(key, result, Now().Add(c.ttl))
+			c.stats.Hits++
+			return result, true
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.Misses++
+	return 0, false
+}
+
+// get ищет запись в памяти; промах не засчитывается
+func (c *ResultCache) get(key string) (float64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if ok && element.Value.(*cacheEntry).expiresAt.After(Now()) {
+		c.stats.Hits++
+		c.order.MoveToFront(element)
+		return element.Value.(*cacheEntry).result, true
+	}
+	if ok {
+		c.remove(element)
+	}
+	return 0, false
+}
+
+// Put сохраняет результат на TTL в памяти и в общем хранилище, при
+// необходимости вытесняя давно использованные записи
+func (c *ResultCache) Put(ctx context.Context, key string, result float64) {
+	if c == nil {
+		return
+	}
+	expiresAt := Now().Add(c.ttl)
+	if c.Shared != nil {
+		if err := c.Shared.PutCachedResult(ctx, key, result, expiresAt); err != nil {
+			log.Println("Error saving cached result:", err)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.put(key, result, expiresAt)
+}
+
+func (c *ResultCache) put(key string, result float64, expiresAt time.Time) {
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*cacheEntry)
+		entry.result, entry.expiresAt = result, expiresAt
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, result: result, expiresAt: expiresAt})
+	for c.order.Len() > c.maxEntries {
+		c.remove(c.order.Back())
+		c.stats.Evictions++
+	}
+}
+
+func (c *ResultCache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*cacheEntry).key)
+}
+
+// Stats возвращает метрики кеша; у nil-кеша Enabled = false
+func (c *ResultCache) Stats() CacheStats {
+	if c == nil {
+		return CacheStats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Enabled = true
+	stats.Entries = c.order.Len()
+	stats.MaxEntries = c.maxEntries
+	return stats
+}
+
+// DurationsVersion возвращает версию настроек времени операторов. Версия
+// зависит только от значений, поэтому оркестратор и агенты получают одну и
+// ту же версию, прочитав одни и те же настройки, а их изменение через
+// PUT /settings/durations делает прежние записи кеша недоступными.
+func DurationsVersion(durations map[string]int) string {
+	operators := make([]string, 0, len(durations))
+	for operator := range durations {
+		operators = append(operators, operator)
+	}
+	sort.Strings(operators)
+
+	hash := sha256.New()
+	for _, operator := range operators {
+		fmt.Fprintf(hash, "%s=%d;", operator, durations[operator])
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+// ResultCacheKey возвращает ключ кеша для дерева разбора node
+func ResultCacheKey(version string, node expression.Node) string {
+	return version + ":" + expression.Canonical(node)
+}
+
+// OperationCacheKey возвращает ключ кеша для бинарной операции с
+// известными операндами. Для остальных операций ключа нет: смена знака
+// вычисляется мгновенно.
+func OperationCacheKey(version string, op Operation) (string, bool) {
+	if op.Operator == expression.OperatorNegate || op.LeftValue == nil || op.RightValue == nil {
+		return "", false
+	}
+	node := &expression.BinaryNode{
+		Operator: op.Operator,
+		Left:     &expression.NumberNode{Value: *op.LeftValue},
+		Right:    &expression.NumberNode{Value: *op.RightValue},
+	}
+	return ResultCacheKey(version, node), true
+}
+
+// CacheStats возвращает метрики кеша результатов оркестратора
+func (o *Orchestrator) CacheStats() CacheStats {
+	return o.Cache.Stats()
+}
+
+// resultLookup ищет в кеше результат дерева разбора
+type resultLookup func(expression.Node) (float64, bool)
+
+// cachedResults возвращает поиск в кеше для раскладки выражения при
+// добавлении задачи или nil, если кеш отключен или задача от него
+// отказалась. Если настройки прочитать не удалось, задача добавляется без кеша.
+func (o *Orchestrator) cachedResults(ctx context.Context, noCache bool) resultLookup {
+	if o.Cache == nil || noCache {
+		return nil
+	}
+	durations, err := o.Tasks.GetDurations(ctx)
+	if err != nil {
+		log.Println("Error getting operator durations:", err)
+		return nil
+	}
+	version := DurationsVersion(durations)
+	return func(node expression.Node) (float64, bool) {
+		return o.Cache.Get(ctx, ResultCacheKey(version, node))
+	}
+}
+
+// CacheOperationResult сохраняет в кеш результат операции, которую агент
+// вычислил и прислал оркестратору. Агенты не передают ключ кеша, поэтому
+// операнды берутся из хранилища; ошибки только записываются в журнал —
+// результат операции уже сохранен.
+func (o *Orchestrator) CacheOperationResult(ctx context.Context, operationID string, result float64) {
+	if o.Cache == nil {
+		return
+	}
+	op, err := o.Tasks.GetOperation(ctx, operationID)
+	if err != nil {
+		log.Println("Error getting operation:", err)
+		return
+	}
+	durations, err := o.Tasks.GetDurations(ctx)
+	if err != nil {
+		log.Println("Error getting operator durations:", err)
+		return
+	}
+	if key, ok := OperationCacheKey(DurationsVersion(durations), *op); ok {
+		o.Cache.Put(ctx, key, result)
+	}
+}
@@ -0,0 +1,168 @@
+package domain_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Dadil/project/internal/agent/expression"
+	"github.com/Dadil/project/internal/orchestra/domain"
+	"github.com/Dadil/project/internal/storage/memstore"
+)
+
+func TestResultCache(t *testing.T) {
+	ctx := context.Background()
+	cache := domain.NewResultCache(2, time.Hour)
+
+	if _, ok := cache.Get(ctx, "a"); ok {
+		t.Error("Expected miss in empty cache")
+	}
+	cache.Put(ctx, "a", 1)
+	cache.Put(ctx, "b", 2)
+	if result, ok := cache.Get(ctx, "a"); !ok || result != 1 {
+		t.Errorf("Expected cached 1, got %v, %v", result, ok)
+	}
+
+	// Вытесняется запись, к которой дольше всего не обращались
+	cache.Put(ctx, "c", 3)
+	if _, ok := cache.Get(ctx, "b"); ok {
+		t.Error("Expected least recently used entry to be evicted")
+	}
+	if _, ok := cache.Get(ctx, "a"); !ok {
+		t.Error("Expected recently used entry to stay")
+	}
+
+	stats := cache.Stats()
+	expected := domain.CacheStats{Enabled: true, Hits: 2, Misses: 2, Evictions: 1, Entries: 2, MaxEntries: 2}
+	if stats != expected {
+		t.Errorf("Expected stats %+v, got %+v", expected, stats)
+	}
+}
+
+func TestResultCache_Expiry(t *testing.T) {
+	ctx := context.Background()
+	cache := domain.NewResultCache(10, -time.Second)
+
+	cache.Put(ctx, "a", 1)
+	if _, ok := cache.Get(ctx, "a"); ok {
+		t.Error("Expected expired entry to be missed")
+	}
+	if stats := cache.Stats(); stats.Entries != 0 {
+		t.Errorf("Expected expired entry to be removed, got %+v", stats)
+	}
+
+	// nil-кеш отключен
+	var disabled *domain.ResultCache
+	disabled.Put(ctx, "a", 1)
+	if _, ok := disabled.Get(ctx, "a"); ok || disabled.Stats().Enabled {
+		t.Error("Expected nil cache to be disabled")
+	}
+}
+
+func TestResultCache_Shared(t *testing.T) {
+	ctx := context.Background()
+	shared := memstore.New()
+	orchestratorCache := domain.NewResultCache(10, time.Hour)
+	orchestratorCache.Shared = shared
+	agentCache := domain.NewResultCache(10, time.Hour)
+	agentCache.Shared = shared
+
+	// Результат, сохраненный агентом другого процесса, находится через общее хранилище
+	agentCache.Put(ctx, "a", 1)
+	if result, ok := orchestratorCache.Get(ctx, "a"); !ok || result != 1 {
+		t.Errorf("Expected shared 1, got %v, %v", result, ok)
+	}
+	if _, ok := orchestratorCache.Get(ctx, "b"); ok {
+		t.Error("Expected miss for unknown key")
+	}
+
+	// Найденная запись остается в памяти
+	stats := orchestratorCache.Stats()
+	expected := domain.CacheStats{Enabled: true, Hits: 1, Misses: 1, Entries: 1, MaxEntries: 10}
+	if stats != expected {
+		t.Errorf("Expected stats %+v, got %+v", expected, stats)
+	}
+}
+
+func TestDurationsVersion(t *testing.T) {
+	version := domain.DurationsVersion(map[string]int{"+": 1, "*": 2})
+	if other := domain.DurationsVersion(map[string]int{"*": 2, "+": 1}); other != version {
+		t.Errorf("Expected the same version for equal settings, got %s and %s", version, other)
+	}
+	if other := domain.DurationsVersion(map[string]int{"+": 1, "*": 3}); other == version {
+		t.Error("Expected another version after durations change")
+	}
+}
+
+// cacheResult сохраняет в кеш оркестратора результат выражения при
+// текущих настройках хранилища
+func cacheResult(t *testing.T, orchestrator *domain.Orchestrator, expr string, result float64) {
+	t.Helper()
+
+	durations, err := orchestrator.GetDurations(context.Background())
+	if err != nil {
+		t.Fatalf("Error getting durations: %v", err)
+	}
+	node, err := expression.Parse(expr)
+	if err != nil {
+		t.Fatalf("Error parsing expression: %v", err)
+	}
+	orchestrator.Cache.Put(context.Background(), domain.ResultCacheKey(domain.DurationsVersion(durations), node), result)
+}
+
+func TestSubmitTaskForUser_Cache(t *testing.T) {
+	ctx := context.Background()
+	orchestrator, store := newOrchestrator(t)
+	orchestrator.Cache = domain.NewResultCache(10, time.Hour)
+	if err := orchestrator.InitDurations(ctx, map[string]int{"+": 1000, "*": 1000}); err != nil {
+		t.Fatalf("Error initializing durations: %v", err)
+	}
+	cacheResult(t, orchestrator, "2 + 3", 5)
+
+	// Выражение, найденное в кеше, вычислено сразу
+	taskID, _, err := orchestrator.SubmitTaskForUser(ctx, "testuser", "3 + 2", domain.AddTaskOptions{})
+	if err != nil {
+		t.Fatalf("Error adding task: %v", err)
+	}
+	task, err := orchestrator.GetTaskForUser(ctx, "testuser", taskID)
+	if err != nil || task.Status != "completed" || task.Result != 5 {
+		t.Errorf("Expected task completed from cache, got %+v, %v", task, err)
+	}
+
+	// Найденная в кеше операция заменяется своим результатом
+	if _, _, err := orchestrator.SubmitTaskForUser(ctx, "testuser", "(2 + 3) * 4", domain.AddTaskOptions{}); err != nil {
+		t.Fatalf("Error adding task: %v", err)
+	}
+	op, err := store.ClaimOperation(ctx, "agent", time.Minute)
+	if err != nil || op == nil {
+		t.Fatalf("Expected a ready operation, got %v, %v", op, err)
+	}
+	if op.Operator != "*" || *op.LeftValue != 5 || *op.RightValue != 4 || op.NoCache {
+		t.Errorf("Expected multiplication of the cached result, got %+v", op)
+	}
+
+	// Задача, отказавшаяся от кеша, вычисляется заново
+	if _, _, err := orchestrator.SubmitTaskForUser(ctx, "testuser", "2 + 3", domain.AddTaskOptions{NoCache: true}); err != nil {
+		t.Fatalf("Error adding task: %v", err)
+	}
+	op, err = store.ClaimOperation(ctx, "agent", time.Minute)
+	if err != nil || op == nil || op.Operator != "+" || !op.NoCache {
+		t.Errorf("Expected addition marked NoCache, got %+v, %v", op, err)
+	}
+
+	// После изменения времени операторов прежние записи не используются
+	if _, err := orchestrator.SetDurations(ctx, map[string]int{"+": 2000}); err != nil {
+		t.Fatalf("Error setting durations: %v", err)
+	}
+	taskID, _, err = orchestrator.SubmitTaskForUser(ctx, "testuser", "2 + 3", domain.AddTaskOptions{})
+	if err != nil {
+		t.Fatalf("Error adding task: %v", err)
+	}
+	if task, _ := orchestrator.GetTaskForUser(ctx, "testuser", taskID); task == nil || task.Status != "pending" {
+		t.Errorf("Expected pending task after durations change, got %+v", task)
+	}
+
+	if stats := orchestrator.CacheStats(); stats.Hits != 2 || stats.Misses != 2 {
+		t.Errorf("Expected 2 hits and 2 misses, got %+v", stats)
+	}
+}
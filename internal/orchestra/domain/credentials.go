@@ -0,0 +1,111 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrInvalidCredentials — логин или пароль не соответствуют требованиям
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrAuthFailed — неизвестный логин или неверный пароль; причина
+	// намеренно не уточняется
+	ErrAuthFailed = errors.New("invalid login or password")
+	// ErrUserLocked — вход временно заблокирован после неудачных попыток.
+	// Это частный случай ErrAuthFailed: снаружи блокировка неотличима от
+	// неверного пароля, иначе по ней можно было бы узнать, что логин есть.
+	ErrUserLocked = fmt.Errorf("%w: too many failed login attempts", ErrAuthFailed)
+)
+
+// Требования к учетным данным. bcrypt учитывает только первые 72 байта
+// пароля, поэтому более длинные пароли отклоняются, а не обрезаются молча.
+const (
+	MinLoginLength    = 3
+	MaxLoginLength    = 32
+	MinPasswordLength = 8
+	MaxPasswordLength = 72
+)
+
+// Блокировка входа по умолчанию (см. Orchestrator.MaxLoginAttempts)
+const (
+	DefaultMaxLoginAttempts = 5
+	DefaultLockoutDuration  = 15 * time.Minute
+)
+
+var loginPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// ValidateCredentials проверяет логин и пароль нового пользователя
+func ValidateCredentials(login, password string) error {
+	if len(login) < MinLoginLength || len(login) > MaxLoginLength {
+		return fmt.Errorf("%w: login must be %d to %d characters long", ErrInvalidCredentials, MinLoginLength, MaxLoginLength)
+	}
+	if !loginPattern.MatchString(login) {
+		return fmt.Errorf("%w: login may contain only latin letters, digits, '_', '-' and '.'", ErrInvalidCredentials)
+	}
+	if len(password) < MinPasswordLength || len(password) > MaxPasswordLength {
+		return fmt.Errorf("%w: password must be %d to %d bytes long", ErrInvalidCredentials, MinPasswordLength, MaxPasswordLength)
+	}
+	if password == login {
+		return fmt.Errorf("%w: password must differ from login", ErrInvalidCredentials)
+	}
+	return nil
+}
+
+// dummyHash строится при инициализации пакета: если генерировать его при
+// первом входе с неизвестным логином, этот вход занимал бы вдвое больше
+// времени и выдавал бы, что логина нет
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+// compareDummyHash тратит на неизвестный логин столько же времени, сколько
+// на проверку пароля существующего пользователя, чтобы по времени ответа
+// нельзя было узнать, есть ли логин
+func compareDummyHash(password string) {
+	bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+}
+
+// Authenticate проверяет пароль пользователя. Для неизвестного логина и
+// неверного пароля возвращается одна и та же ошибка ErrAuthFailed. После
+// MaxLoginAttempts неудачных попыток подряд вход блокируется на
+// LockoutDuration и возвращается ErrUserLocked (он же ErrAuthFailed).
+// Пароль сравнивается с хешем в любом случае, поэтому время ответа не
+// зависит ни от существования логина, ни от блокировки.
+func (o *Orchestrator) Authenticate(ctx context.Context, login, password string) (*User, error) {
+	user, err := o.Users.GetUserByLogin(ctx, login)
+	if errors.Is(err, ErrUserNotFound) {
+		compareDummyHash(password)
+		return nil, ErrAuthFailed
+	}
+	if err != nil {
+		log.Println("Error getting user:", err)
+		return nil, err
+	}
+
+	mismatch := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil
+	if user.LockedUntil != nil && user.LockedUntil.After(Now()) {
+		log.Println("Login attempt for locked user:", login)
+		return nil, ErrUserLocked
+	}
+
+	if mismatch {
+		log.Println("Failed login attempt for user:", login)
+		if o.MaxLoginAttempts > 0 {
+			if err := o.Users.RecordLoginFailure(ctx, login, o.MaxLoginAttempts, o.LockoutDuration); err != nil {
+				log.Println("Error recording login failure:", err)
+			}
+		}
+		return nil, ErrAuthFailed
+	}
+
+	if user.FailedLogins > 0 || user.LockedUntil != nil {
+		if err := o.Users.ResetLoginFailures(ctx, login); err != nil {
+			log.Println("Error resetting login failures:", err)
+		}
+	}
+	return user, nil
+}
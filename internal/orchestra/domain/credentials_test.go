@@ -0,0 +1,105 @@
+package domain_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Dadil/project/internal/orchestra/domain"
+)
+
+func TestValidateCredentials(t *testing.T) {
+	tests := []struct {
+		login, password string
+		valid           bool
+	}{
+		{"alice", "password1", true},
+		{"a.b_c-1", strings.Repeat("p", 72), true},
+		{"", "password1", false},
+		{"al", "password1", false},
+		{strings.Repeat("a", 33), "password1", false},
+		{"alice smith", "password1", false},
+		{"алиса", "password1", false},
+		{"alice", "short", false},
+		{"alice", strings.Repeat("p", 73), false},
+		{"password", "password", false},
+	}
+
+	for _, test := range tests {
+		err := domain.ValidateCredentials(test.login, test.password)
+		if test.valid && err != nil {
+			t.Errorf("Expected %q/%q to be valid, got %v", test.login, test.password, err)
+		}
+		if !test.valid && !errors.Is(err, domain.ErrInvalidCredentials) {
+			t.Errorf("Expected ErrInvalidCredentials for %q/%q, got %v", test.login, test.password, err)
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	ctx := context.Background()
+	orchestrator, _ := newOrchestrator(t)
+	if err := orchestrator.CreateUser(ctx, "alice", "password1"); err != nil {
+		t.Fatalf("Error creating user: %v", err)
+	}
+
+	user, err := orchestrator.Authenticate(ctx, "alice", "password1")
+	if err != nil || user.Login != "alice" {
+		t.Fatalf("Expected alice to authenticate, got %v, %v", user, err)
+	}
+
+	// Неизвестный логин и неверный пароль дают одну и ту же ошибку
+	if _, err := orchestrator.Authenticate(ctx, "nobody", "password1"); err != domain.ErrAuthFailed {
+		t.Errorf("Expected ErrAuthFailed for unknown user, got %v", err)
+	}
+	if _, err := orchestrator.Authenticate(ctx, "alice", "wrong-password"); err != domain.ErrAuthFailed {
+		t.Errorf("Expected ErrAuthFailed for wrong password, got %v", err)
+	}
+}
+
+func TestAuthenticate_Lockout(t *testing.T) {
+	ctx := context.Background()
+	orchestrator, store := newOrchestrator(t)
+	orchestrator.MaxLoginAttempts = 3
+	orchestrator.LockoutDuration = time.Hour
+	if err := orchestrator.CreateUser(ctx, "alice", "password1"); err != nil {
+		t.Fatalf("Error creating user: %v", err)
+	}
+
+	// Успешный вход сбрасывает счетчик неудачных попыток
+	for i := 0; i < 2; i++ {
+		orchestrator.Authenticate(ctx, "alice", "wrong-password")
+	}
+	if _, err := orchestrator.Authenticate(ctx, "alice", "password1"); err != nil {
+		t.Fatalf("Expected alice to authenticate, got %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := orchestrator.Authenticate(ctx, "alice", "wrong-password"); err != domain.ErrAuthFailed {
+			t.Fatalf("Expected ErrAuthFailed on attempt %d, got %v", i+1, err)
+		}
+	}
+	// Заблокированный пользователь не может войти даже с верным паролем;
+	// снаружи блокировка выглядит как неудачный вход
+	_, err := orchestrator.Authenticate(ctx, "alice", "password1")
+	if err != domain.ErrUserLocked || !errors.Is(err, domain.ErrAuthFailed) {
+		t.Errorf("Expected ErrUserLocked wrapping ErrAuthFailed, got %v", err)
+	}
+
+	// После окончания блокировки вход снова возможен
+	if err := store.RecordLoginFailure(ctx, "alice", 1, -time.Second); err != nil {
+		t.Fatalf("Error recording login failure: %v", err)
+	}
+	if _, err := orchestrator.Authenticate(ctx, "alice", "password1"); err != nil {
+		t.Errorf("Expected alice to authenticate after lockout, got %v", err)
+	}
+	user, err := store.GetUserByLogin(ctx, "alice")
+	if err != nil {
+		t.Fatalf("Error getting user: %v", err)
+	}
+	if user.FailedLogins != 0 || user.LockedUntil != nil {
+		t.Errorf("Expected lockout to be reset, got %d failures, locked until %v", user.FailedLogins, user.LockedUntil)
+	}
+}
@@ -1,30 +1,81 @@
 package domain
 
 import (
-	"database/sql"
-	"fmt"
+	"context"
+	"errors"
 	"log"
+	"time"
 
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type Task struct {
-	ID         string  `json:"id"`
-	Expression string  `json:"expression"`
-	Status     string  `json:"status"`
-	Result     float64 `json:"result"`
+	ID           string     `json:"id"`
+	Expression   string     `json:"expression"`
+	Status       string     `json:"status"`
+	Result       float64    `json:"result"`
+	CreatedAt    time.Time  `json:"created_at"`
+	StartedAt    *time.Time `json:"started_at,omitempty"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
+	ErrorMessage string     `json:"error_message,omitempty"`
+	AgentID      string     `json:"agent_id,omitempty"` // агент, последним вычислявший операцию задачи
 }
 
+var (
+	// ErrTaskNotFound — задачи нет или она принадлежит другому пользователю
+	ErrTaskNotFound = errors.New("task not found")
+	// ErrTaskFinished — задача уже вычислена, завершилась ошибкой или отменена
+	ErrTaskFinished = errors.New("task already finished")
+	// ErrTaskNotDead — повторить вручную можно только задачу в статусе dead
+	ErrTaskNotDead = errors.New("task is not dead")
+)
+
+// Роли пользователей
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin" // видит задачи всех пользователей и управляет системой
+)
+
 type User struct {
-	Login    string
-	Password string
+	Login    string `json:"login"`
+	Password string `json:"-"`
+	Role     string `json:"role"`
+	// Отключенный пользователь не может войти, его сессии отозваны
+	Disabled bool `json:"disabled"`
+	// Неудачные попытки входа подряд и время, до которого вход заблокирован
+	FailedLogins int        `json:"-"`
+	LockedUntil  *time.Time `json:"locked_until,omitempty"`
+}
+
+// RefreshToken — refresh-токен сессии пользователя. Сам токен хранится
+// только у клиента, хранилище знает лишь его хеш (см. HashToken).
+type RefreshToken struct {
+	Hash      string
+	Login     string
+	SessionID string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	RevokedAt *time.Time
 }
 
 type Orchestrator struct {
-	DB             *sql.DB
-	Agents         []*Agent
-	processedTasks map[string]bool
+	Tasks    TaskStore
+	Users    UserStore
+	Registry AgentStore
+	// Блокировка входа: после MaxLoginAttempts неудачных попыток подряд
+	// вход блокируется на LockoutDuration; 0 отключает блокировку
+	MaxLoginAttempts int
+	LockoutDuration  time.Duration
+	// Cache — кеш результатов, из которого при добавлении задачи
+	// подставляются уже вычисленные операции; nil отключает кеш
+	Cache *ResultCache
+	// Events — шина событий о смене статусов задач
+	Events *EventBus
+	// IdempotencyKeyTTL — сколько хранится ключ идемпотентности (см. SubmitTaskForUser)
+	IdempotencyKeyTTL time.Duration
+	Agents            []*Agent
+	processedTasks    map[string]bool
 }
 
 type Agent struct {
@@ -32,16 +83,27 @@ type Agent struct {
 	TaskChannel chan Task
 }
 
-func NewOrchestrator(db *sql.DB) *Orchestrator {
+// NewOrchestrator создает оркестратор поверх хранилищ задач, пользователей
+// и реестра агентов. Обычно все интерфейсы реализует одно хранилище (см. Store).
+func NewOrchestrator(tasks TaskStore, users UserStore, agents AgentStore) *Orchestrator {
+	events := NewEventBus()
+	events.Owners = tasks
 	return &Orchestrator{
-		DB:             db,
+		Tasks:          tasks,
+		Users:          users,
+		Registry:       agents,
+		Events:         events,
 		processedTasks: make(map[string]bool),
+
+		MaxLoginAttempts: DefaultMaxLoginAttempts,
+		LockoutDuration:  DefaultLockoutDuration,
+
+		IdempotencyKeyTTL: DefaultIdempotencyKeyTTL,
 	}
 }
 
-func (o *Orchestrator) AddTask(expression string) (string, error) {
+func (o *Orchestrator) AddTask(ctx context.Context, expression string) (string, error) {
 	taskID := generateTaskID()
-	task := Task{ID: taskID, Expression: expression, Status: "pending"}
 
 	// Проверяем, была ли уже обработана задача с таким ID
 	if _, exists := o.processedTasks[taskID]; exists {
@@ -49,10 +111,7 @@ func (o *Orchestrator) AddTask(expression string) (string, error) {
 		return taskID, nil
 	}
 
-	_, err := o.DB.Exec("INSERT INTO tasks (id, expression, status, result) VALUES ($1, $2, $3, $4)",
-		taskID, task.Expression, task.Status, task.Result)
-	if err != nil {
-		log.Println("Error saving task to PostgreSQL:", err)
+	if err := o.createTask(ctx, "", taskID, expression); err != nil {
 		return "", err
 	}
 
@@ -62,87 +121,143 @@ func (o *Orchestrator) AddTask(expression string) (string, error) {
 	return taskID, nil
 }
 
-func (o *Orchestrator) GetTasks() []Task {
-	rows, err := o.DB.Query("SELECT id, expression, status, result FROM tasks")
+func (o *Orchestrator) GetTasks(ctx context.Context) []Task {
+	tasks, err := o.Tasks.ListTasks(ctx)
 	if err != nil {
-		log.Println("Error getting tasks from PostgreSQL:", err)
+		log.Println("Error getting tasks:", err)
 		return nil
 	}
-	defer rows.Close()
-
-	var tasks []Task
-	for rows.Next() {
-		var task Task
-		err := rows.Scan(&task.ID, &task.Expression, &task.Status, &task.Result)
-		if err != nil {
-			log.Println("Error scanning task:", err)
-			continue
-		}
-		tasks = append(tasks, task)
-	}
 
 	return tasks
 }
 
-func (o *Orchestrator) GetTasksForUser(login string) []Task {
-	rows, err := o.DB.Query(`
-        SELECT t.id, t.expression, t.status, t.result
-        FROM tasks t
-        JOIN user_tasks ut ON t.id = ut.task_id
-        JOIN users u ON ut.user_id = u.id
-        WHERE u.login = $1
-    `, login)
+func (o *Orchestrator) GetTasksForUser(ctx context.Context, login string) []Task {
+	tasks, err := o.Tasks.ListTasksForUser(ctx, login)
 	if err != nil {
-		log.Println("Error getting tasks for user from PostgreSQL:", err)
+		log.Println("Error getting tasks for user:", err)
 		return nil
 	}
-	defer rows.Close()
 
-	var tasks []Task
-	for rows.Next() {
-		var task Task
-		err := rows.Scan(&task.ID, &task.Expression, &task.Status, &task.Result)
+	return tasks
+}
+
+// GetTaskForUser возвращает одну задачу пользователя. Чужие задачи
+// неотличимы от несуществующих: в обоих случаях возвращается ErrTaskNotFound.
+func (o *Orchestrator) GetTaskForUser(ctx context.Context, login, taskID string) (*Task, error) {
+	task, err := o.Tasks.GetTaskForUser(ctx, login, taskID)
+	if err != nil && !errors.Is(err, ErrTaskNotFound) {
+		log.Println("Error getting task:", err)
+	}
+	return task, err
+}
+
+// CancelTaskForUser отменяет задачу пользователя. Агенты, вычисляющие её
+// операции, теряют аренду и прерывают вычисление. Возвращает ErrTaskNotFound
+// или ErrTaskFinished, если задача уже завершена.
+func (o *Orchestrator) CancelTaskForUser(ctx context.Context, login, taskID string) (*Task, error) {
+	task, err := o.Tasks.CancelTaskForUser(ctx, login, taskID)
+	if err != nil && !errors.Is(err, ErrTaskNotFound) && !errors.Is(err, ErrTaskFinished) {
+		log.Println("Error cancelling task:", err)
+	}
+	if err == nil {
+		o.publish(ctx, login, taskID, task.Status)
+	}
+	return task, err
+}
+
+// GetDeadTasksForUser возвращает задачи пользователя, у которых исчерпаны
+// попытки вычисления, в порядке создания. Хранилище отбирает их по статусу
+// страницами по MaxTaskPageSize задач.
+func (o *Orchestrator) GetDeadTasksForUser(ctx context.Context, login string) ([]Task, error) {
+	query := TaskQuery{TaskFilter: TaskFilter{Status: "dead"}, Sort: SortByCreated, Limit: MaxTaskPageSize}
+	var dead []Task
+	for {
+		tasks, _, err := o.Tasks.QueryTasksForUser(ctx, login, query)
 		if err != nil {
-			log.Println("Error scanning task:", err)
-			continue
+			log.Println("Error getting dead tasks for user:", err)
+			return nil, err
 		}
-		tasks = append(tasks, task)
+		dead = append(dead, tasks...)
+		if len(tasks) < query.Limit {
+			return dead, nil
+		}
+		last := tasks[len(tasks)-1]
+		query.After = &TaskCursor{Value: TaskSortKey(last, query.Sort), ID: last.ID}
 	}
+}
 
-	return tasks
+// RetryTaskForUser возвращает задачу в статусе dead в очередь с обнуленным
+// счетчиком попыток. Для задачи в другом статусе возвращается ErrTaskNotDead.
+func (o *Orchestrator) RetryTaskForUser(ctx context.Context, login, taskID string) (*Task, error) {
+	task, err := o.Tasks.RetryTaskForUser(ctx, login, taskID)
+	if err != nil && !errors.Is(err, ErrTaskNotFound) && !errors.Is(err, ErrTaskNotDead) {
+		log.Println("Error retrying task:", err)
+	}
+	if err == nil {
+		o.publish(ctx, login, taskID, task.Status)
+	}
+	return task, err
 }
 
-func (o *Orchestrator) AddTaskForUser(expression string, userName string) (string, error) {
+func (o *Orchestrator) AddTaskForUser(ctx context.Context, expression string, userName string) (string, error) {
 	taskID := generateTaskID()
-	task := Task{ID: taskID, Expression: expression, Status: "pending"}
 
-	// Проверяем существование пользователя по его имени
-	var userID string
-	err := o.DB.QueryRow("SELECT id FROM users WHERE login = $1", userName).Scan(&userID)
-	if err != nil {
-		if err == sql.ErrNoRows {
+	if err := o.createTask(ctx, userName, taskID, expression); err != nil {
+		if errors.Is(err, ErrUserNotFound) {
 			log.Println("User not found:", userName)
-			return "", fmt.Errorf("user not found: %s", userName)
 		}
-		log.Println("Error getting user from PostgreSQL:", err)
 		return "", err
 	}
 
-	_, err = o.DB.Exec("INSERT INTO tasks (id, expression, status, result) VALUES ($1, $2, $3, $4)",
-		taskID, task.Expression, task.Status, task.Result)
+	return taskID, nil
+}
+
+// createTask раскладывает выражение на граф операций и сохраняет задачу
+func (o *Orchestrator) createTask(ctx context.Context, login, taskID, expression string) error {
+	task, err := newTask(taskID, expression, o.cachedResults(ctx, false), false)
 	if err != nil {
-		log.Println("Error saving task to PostgreSQL:", err)
-		return "", err
+		log.Println("Error decomposing expression:", err)
+		return err
+	}
+
+	if err := o.Tasks.CreateTask(ctx, login, task.Task, task.Operations); err != nil {
+		log.Println("Error saving task:", err)
+		return err
 	}
 
-	// Связываем задачу с пользователем
-	_, err = o.DB.Exec("INSERT INTO user_tasks (user_id, task_id) VALUES ($1, $2)", userID, taskID)
+	o.publish(ctx, login, taskID, task.Task.Status)
+	return nil
+}
+
+// newTask раскладывает выражение на граф операций, подставляя результаты
+// операций из кеша cached (nil — без кеша). Выражение без операторов
+// (например, "-5") или целиком найденное в кеше считается сразу
+// вычисленным. noCache помечает операции задачи, отказавшейся от кеша.
+func newTask(taskID, expression string, cached resultLookup, noCache bool) (NewTask, error) {
+	operations, value, err := decomposeExpression(taskID, expression, cached)
 	if err != nil {
-		log.Println("Error associating task with user:", err)
-		return "", err
+		return NewTask{}, err
+	}
+	for i := range operations {
+		operations[i].NoCache = noCache
 	}
 
-	return taskID, nil
+	now := Now()
+	task := Task{ID: taskID, Expression: expression, Status: "pending", CreatedAt: now}
+	if len(operations) == 0 {
+		task.Status = "completed"
+		task.Result = value
+		task.StartedAt = &now
+		task.FinishedAt = &now
+	}
+	return NewTask{Task: task, Operations: operations}, nil
+}
+
+// Now возвращает текущее время в UTC с точностью до микросекунд — с такой
+// точностью время хранят все хранилища, поэтому значения совпадают после
+// сохранения и чтения.
+func Now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
 }
 
 func generateTaskID() string {
@@ -150,14 +265,20 @@ func generateTaskID() string {
 	return taskID.String()
 }
 
-func (o *Orchestrator) CreateUser(login, password string) error {
+// CreateUser создает пользователя, если логин и пароль соответствуют
+// требованиям (см. ValidateCredentials)
+func (o *Orchestrator) CreateUser(ctx context.Context, login, password string) error {
+	if err := ValidateCredentials(login, password); err != nil {
+		return err
+	}
+
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		log.Println("Error hashing password:", err)
 		return err
 	}
 
-	_, err = o.DB.Exec("INSERT INTO users (login, password) VALUES ($1, $2)", login, string(hashedPassword))
+	err = o.Users.CreateUser(ctx, login, string(hashedPassword))
 	if err != nil {
 		log.Println("Error creating user:", err)
 		return err
@@ -166,36 +287,71 @@ func (o *Orchestrator) CreateUser(login, password string) error {
 	return nil
 }
 
-func (o *Orchestrator) GetUserByLogin(login string) (*User, error) {
-	var user User
-	err := o.DB.QueryRow("SELECT login, password FROM users WHERE login = $1", login).
-		Scan(&user.Login, &user.Password)
+// EnsureAdmin создает администратора login с паролем password или, если
+// пользователь уже есть, назначает ему роль admin, не меняя пароль
+func (o *Orchestrator) EnsureAdmin(ctx context.Context, login, password string) error {
+	err := o.CreateUser(ctx, login, password)
+	if err != nil && !errors.Is(err, ErrUserExists) {
+		return err
+	}
+
+	if err := o.Users.SetUserRole(ctx, login, RoleAdmin); err != nil {
+		log.Println("Error setting user role:", err)
+		return err
+	}
+	return nil
+}
+
+func (o *Orchestrator) ListUsers(ctx context.Context) ([]User, error) {
+	users, err := o.Users.ListUsers(ctx)
+	if err != nil {
+		log.Println("Error getting users:", err)
+		return nil, err
+	}
+	return users, nil
+}
+
+// SetUserDisabled отключает или включает пользователя. Отключение отзывает
+// его сессии и сразу запрещает их access-токены — на accessTTL, пока не
+// истекут все выданные до отключения.
+func (o *Orchestrator) SetUserDisabled(ctx context.Context, login string, disabled bool, accessTTL time.Duration) error {
+	err := o.Users.SetUserDisabled(ctx, login, disabled)
+	if err != nil {
+		if !errors.Is(err, ErrUserNotFound) {
+			log.Println("Error updating user:", err)
+		}
+		return err
+	}
+	if disabled {
+		if err := o.Users.DenyUserSessions(ctx, login, Now().Add(accessTTL)); err != nil {
+			log.Println("Error denying user sessions:", err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *Orchestrator) GetUserByLogin(ctx context.Context, login string) (*User, error) {
+	user, err := o.Users.GetUserByLogin(ctx, login)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if errors.Is(err, ErrUserNotFound) {
 			log.Println("User not found:", login)
 			return nil, err
 		}
-		log.Println("Error getting user from PostgreSQL:", err)
+		log.Println("Error getting user:", err)
 		return nil, err
 	}
 
-	return &user, nil
+	return user, nil
 }
 
-func (o *Orchestrator) DeleteAllTasksForUser(login string) error {
-	// Удаляем все задачи пользователя из таблицы tasks
-	_, err := o.DB.Exec("DELETE FROM tasks WHERE id IN (SELECT task_id FROM user_tasks WHERE user_id = (SELECT id FROM users WHERE login = $1))", login)
+func (o *Orchestrator) DeleteAllTasksForUser(ctx context.Context, login string) error {
+	// Удаляем задачи пользователя вместе с их операциями и связями с пользователем
+	err := o.Tasks.DeleteTasksForUser(ctx, login)
 	if err != nil {
 		log.Println("Error deleting tasks for user:", err)
 		return err
 	}
 
-	// Удаляем записи о связях пользователей с задачами из таблицы user_tasks
-	_, err = o.DB.Exec("DELETE FROM user_tasks WHERE user_id = (SELECT id FROM users WHERE login = $1)", login)
-	if err != nil {
-		log.Println("Error deleting user-task associations:", err)
-		return err
-	}
-
 	return nil
 }
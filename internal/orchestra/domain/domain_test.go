@@ -1,30 +1,32 @@
 package domain_test
 
 import (
+	"context"
+	"errors"
 	"testing"
+	"time"
 
-	"github.com/DATA-DOG/go-sqlmock"
-	"github.com/Dadil/project/internal/orchestra/domain" // Update with your project's import path
+	"github.com/Dadil/project/internal/orchestra/domain"
+	"github.com/Dadil/project/internal/storage/memstore"
 )
 
-func TestAddTask(t *testing.T) {
-	// Create a new mock database connection
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("Error creating mock database: %v", err)
-	}
-	defer db.Close()
+// newOrchestrator создает оркестратор на хранилище в памяти с пользователем testuser
+func newOrchestrator(t *testing.T) (*domain.Orchestrator, *memstore.Store) {
+	t.Helper()
 
-	// Create a new orchestrator with the mock database
-	orchestrator := domain.NewOrchestrator(db)
+	store := memstore.New()
+	if err := store.CreateUser(context.Background(), "testuser", "hashed_password"); err != nil {
+		t.Fatalf("Error creating user: %v", err)
+	}
+	return domain.NewOrchestrator(store, store, store), store
+}
 
-	// Define the expected SQL query and mock behavior
-	mock.ExpectExec("INSERT INTO tasks").
-		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
-		WillReturnResult(sqlmock.NewResult(1, 1))
+func TestAddTask(t *testing.T) {
+	ctx := context.Background()
+	orchestrator, store := newOrchestrator(t)
 
 	// Call the function under test
-	taskID, err := orchestrator.AddTask("2 + 2")
+	taskID, err := orchestrator.AddTask(ctx, "2 + 2")
 	if err != nil {
 		t.Fatalf("Error adding task: %v", err)
 	}
@@ -34,67 +36,42 @@ func TestAddTask(t *testing.T) {
 		t.Error("Expected non-empty task ID, got empty")
 	}
 
-	// Ensure all expectations were met
-	if err := mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("Unfulfilled expectations: %s", err)
+	// The only operation of the task is ready for agents
+	op, err := store.ClaimOperation(ctx, "agent", 0)
+	if err != nil || op == nil {
+		t.Fatalf("Expected a ready operation, got %v, %v", op, err)
+	}
+	if op.TaskID != taskID || op.Operator != "+" || *op.LeftValue != 2 || *op.RightValue != 2 {
+		t.Errorf("Unexpected operation: %+v", op)
 	}
 }
 
 func TestGetTasks(t *testing.T) {
-	// Create a new mock database connection
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("Error creating mock database: %v", err)
-	}
-	defer db.Close()
-
-	// Create a new orchestrator with the mock database
-	orchestrator := domain.NewOrchestrator(db)
+	ctx := context.Background()
+	orchestrator, _ := newOrchestrator(t)
 
-	// Define the expected SQL query and mock behavior
-	rows := sqlmock.NewRows([]string{"id", "expression", "status", "result"}).
-		AddRow("1", "2 + 2", "pending", 0.0).
-		AddRow("2", "3 * 3", "completed", 9.0)
-	mock.ExpectQuery("SELECT id, expression, status, result FROM tasks").WillReturnRows(rows)
+	if _, err := orchestrator.AddTask(ctx, "2 + 2"); err != nil {
+		t.Fatalf("Error adding task: %v", err)
+	}
+	if _, err := orchestrator.AddTaskForUser(ctx, "3 * 3", "testuser"); err != nil {
+		t.Fatalf("Error adding task: %v", err)
+	}
 
 	// Call the function under test
-	tasks := orchestrator.GetTasks()
+	tasks := orchestrator.GetTasks(ctx)
 
 	// Check if tasks are returned
 	if len(tasks) != 2 {
 		t.Errorf("Expected 2 tasks, got %d", len(tasks))
 	}
-
-	// Ensure all expectations were met
-	if err := mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("Unfulfilled expectations: %s", err)
-	}
 }
 
 func TestAddTaskForUser(t *testing.T) {
-	// Create a new mock database connection
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("Error creating mock database: %v", err)
-	}
-	defer db.Close()
-
-	// Create a new orchestrator with the mock database
-	orchestrator := domain.NewOrchestrator(db)
-
-	// Define the expected SQL queries and mock behavior
-	mock.ExpectQuery("SELECT id FROM users WHERE login = ?").
-		WithArgs("testuser").
-		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("1"))
-	mock.ExpectExec("INSERT INTO tasks").
-		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
-		WillReturnResult(sqlmock.NewResult(1, 1))
-	mock.ExpectExec("INSERT INTO user_tasks").
-		WithArgs("1", sqlmock.AnyArg()).
-		WillReturnResult(sqlmock.NewResult(1, 1))
+	ctx := context.Background()
+	orchestrator, _ := newOrchestrator(t)
 
 	// Call the function under test
-	taskID, err := orchestrator.AddTaskForUser("2 + 2", "testuser")
+	taskID, err := orchestrator.AddTaskForUser(ctx, "2 + 2", "testuser")
 	if err != nil {
 		t.Fatalf("Error adding task for user: %v", err)
 	}
@@ -104,103 +81,333 @@ func TestAddTaskForUser(t *testing.T) {
 		t.Error("Expected non-empty task ID, got empty")
 	}
 
-	// Ensure all expectations were met
-	if err := mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("Unfulfilled expectations: %s", err)
+	task, err := orchestrator.GetTaskForUser(ctx, "testuser", taskID)
+	if err != nil {
+		t.Fatalf("Error getting task: %v", err)
+	}
+	if task.Status != "pending" || task.CreatedAt.IsZero() {
+		t.Errorf("Unexpected task: %+v", task)
 	}
 }
-func TestGetUserByLogin(t *testing.T) {
-	// Create a new mock database connection
-	db, mock, err := sqlmock.New()
+
+func TestAddTaskForUser_UnknownUser(t *testing.T) {
+	orchestrator, _ := newOrchestrator(t)
+
+	_, err := orchestrator.AddTaskForUser(context.Background(), "2 + 2", "nobody")
+	if !errors.Is(err, domain.ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+}
+
+func TestCreateUser(t *testing.T) {
+	ctx := context.Background()
+	orchestrator, store := newOrchestrator(t)
+
+	if err := orchestrator.CreateUser(ctx, "newuser", "secret-password"); err != nil {
+		t.Fatalf("Error creating user: %v", err)
+	}
+
+	// The password is stored as a bcrypt hash
+	user, err := store.GetUserByLogin(ctx, "newuser")
+	if err != nil {
+		t.Fatalf("Error getting user: %v", err)
+	}
+	if user.Password == "secret-password" || user.Password == "" {
+		t.Errorf("Expected hashed password, got %q", user.Password)
+	}
+
+	if err := orchestrator.CreateUser(ctx, "newuser", "secret-password"); !errors.Is(err, domain.ErrUserExists) {
+		t.Errorf("Expected ErrUserExists, got %v", err)
+	}
+}
+
+func TestEnsureAdmin(t *testing.T) {
+	ctx := context.Background()
+	orchestrator, store := newOrchestrator(t)
+
+	// Новый администратор создается с паролем из конфигурации
+	if err := orchestrator.EnsureAdmin(ctx, "admin", "secret-password"); err != nil {
+		t.Fatalf("Error ensuring admin: %v", err)
+	}
+	user, err := store.GetUserByLogin(ctx, "admin")
 	if err != nil {
-		t.Fatalf("Error creating mock database: %v", err)
+		t.Fatalf("Error getting user: %v", err)
+	}
+	if user.Role != domain.RoleAdmin {
+		t.Errorf("Expected role admin, got %q", user.Role)
 	}
-	defer db.Close()
 
-	// Create a new orchestrator with the mock database
-	orchestrator := domain.NewOrchestrator(db)
+	// Существующий пользователь получает роль, пароль не меняется
+	if err := orchestrator.EnsureAdmin(ctx, "testuser", "secret-password"); err != nil {
+		t.Fatalf("Error ensuring admin: %v", err)
+	}
+	user, err = store.GetUserByLogin(ctx, "testuser")
+	if err != nil {
+		t.Fatalf("Error getting user: %v", err)
+	}
+	if user.Role != domain.RoleAdmin || user.Password != "hashed_password" {
+		t.Errorf("Expected admin with the old password, got %+v", user)
+	}
+}
 
-	// Define the expected SQL query and mock behavior
-	rows := sqlmock.NewRows([]string{"login", "password"}).
-		AddRow("testuser", "hashed_password")
-	mock.ExpectQuery("SELECT login, password FROM users WHERE login = ?").
-		WithArgs("testuser").
-		WillReturnRows(rows)
+func TestGetUserByLogin(t *testing.T) {
+	orchestrator, _ := newOrchestrator(t)
 
 	// Call the function under test
-	user, err := orchestrator.GetUserByLogin("testuser")
+	user, err := orchestrator.GetUserByLogin(context.Background(), "testuser")
 	if err != nil {
 		t.Fatalf("Error getting user by login: %v", err)
 	}
 
 	// Check if user is returned
-	if user == nil {
-		t.Error("Expected user, got nil")
+	if user == nil || user.Password != "hashed_password" {
+		t.Errorf("Unexpected user: %+v", user)
 	}
 
-	// Ensure all expectations were met
-	if err := mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("Unfulfilled expectations: %s", err)
+	if _, err := orchestrator.GetUserByLogin(context.Background(), "nobody"); !errors.Is(err, domain.ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
 	}
 }
 
 func TestDeleteAllTasksForUser(t *testing.T) {
-	// Create a new mock database connection
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("Error creating mock database: %v", err)
-	}
-	defer db.Close()
-
-	// Create a new orchestrator with the mock database
-	orchestrator := domain.NewOrchestrator(db)
+	ctx := context.Background()
+	orchestrator, _ := newOrchestrator(t)
 
-	// Define the expected SQL queries and mock behavior
-	mock.ExpectExec("DELETE FROM tasks WHERE id IN").
-		WillReturnResult(sqlmock.NewResult(0, 0))
-	mock.ExpectExec("DELETE FROM user_tasks WHERE user_id =").
-		WillReturnResult(sqlmock.NewResult(0, 0))
+	if _, err := orchestrator.AddTaskForUser(ctx, "2 + 2", "testuser"); err != nil {
+		t.Fatalf("Error adding task: %v", err)
+	}
 
 	// Call the function under test
-	err = orchestrator.DeleteAllTasksForUser("testuser")
+	err := orchestrator.DeleteAllTasksForUser(ctx, "testuser")
 	if err != nil {
 		t.Fatalf("Error deleting tasks for user: %v", err)
 	}
 
-	// Ensure all expectations were met
-	if err := mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("Unfulfilled expectations: %s", err)
+	if tasks := orchestrator.GetTasksForUser(ctx, "testuser"); len(tasks) != 0 {
+		t.Errorf("Expected no tasks, got %d", len(tasks))
 	}
 }
 
 func TestGetTasksForUser(t *testing.T) {
-	// Create a new mock database connection
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("Error creating mock database: %v", err)
-	}
-	defer db.Close()
+	ctx := context.Background()
+	orchestrator, _ := newOrchestrator(t)
 
-	// Create a new orchestrator with the mock database
-	orchestrator := domain.NewOrchestrator(db)
-
-	// Define the expected SQL query and mock behavior
-	rows := sqlmock.NewRows([]string{"id", "expression", "status", "result"}).
-		AddRow("1", "2 + 2", "pending", 0.0).
-		AddRow("2", "3 * 3", "completed", 9.0)
-	mock.ExpectQuery("SELECT t.id, t.expression, t.status, t.result FROM tasks t").
-		WillReturnRows(rows)
+	for _, expr := range []string{"2 + 2", "3 * 3"} {
+		if _, err := orchestrator.AddTaskForUser(ctx, expr, "testuser"); err != nil {
+			t.Fatalf("Error adding task: %v", err)
+		}
+	}
+	// Tasks without an owner are not returned
+	if _, err := orchestrator.AddTask(ctx, "4 - 4"); err != nil {
+		t.Fatalf("Error adding task: %v", err)
+	}
 
 	// Call the function under test
-	tasks := orchestrator.GetTasksForUser("testuser")
+	tasks := orchestrator.GetTasksForUser(ctx, "testuser")
 
 	// Check if tasks are returned
 	if len(tasks) != 2 {
 		t.Errorf("Expected 2 tasks, got %d", len(tasks))
 	}
+}
+
+func TestAddTask_Literal(t *testing.T) {
+	ctx := context.Background()
+	orchestrator, _ := newOrchestrator(t)
+
+	// Expression without operators is completed right away
+	taskID, err := orchestrator.AddTaskForUser(ctx, "-5", "testuser")
+	if err != nil {
+		t.Fatalf("Error adding task: %v", err)
+	}
+
+	task, err := orchestrator.GetTaskForUser(ctx, "testuser", taskID)
+	if err != nil {
+		t.Fatalf("Error getting task: %v", err)
+	}
+	if task.Status != "completed" || task.Result != -5 || task.StartedAt == nil || task.FinishedAt == nil {
+		t.Errorf("Unexpected task: %+v", task)
+	}
+}
+
+func TestDecomposeExpression(t *testing.T) {
+	operations, _, err := domain.DecomposeExpression("task", "(1 + 2) * (3 + 4)")
+	if err != nil {
+		t.Fatalf("Error decomposing expression: %v", err)
+	}
+
+	if len(operations) != 3 {
+		t.Fatalf("Expected 3 operations, got %d", len(operations))
+	}
+
+	// Both additions are independent and ready, multiplication waits for them
+	var ready, waiting int
+	var root domain.Operation
+	for _, op := range operations {
+		switch op.Status {
+		case domain.OperationPending:
+			ready++
+		case domain.OperationWaiting:
+			waiting++
+		}
+		if op.ParentID == "" {
+			root = op
+		}
+	}
+	if ready != 2 || waiting != 1 {
+		t.Errorf("Expected 2 ready and 1 waiting operations, got %d and %d", ready, waiting)
+	}
+	if root.Operator != "*" || root.LeftID == "" || root.RightID == "" {
+		t.Errorf("Unexpected root operation: %+v", root)
+	}
+	for _, op := range operations {
+		if op.ID != root.ID && op.ParentID != root.ID {
+			t.Errorf("Operation %s should reference root %s as parent", op.ID, root.ID)
+		}
+	}
+}
+
+func TestDecomposeExpression_Negate(t *testing.T) {
+	operations, _, err := domain.DecomposeExpression("task", "-(2 + 1)")
+	if err != nil {
+		t.Fatalf("Error decomposing expression: %v", err)
+	}
+
+	if len(operations) != 2 {
+		t.Fatalf("Expected 2 operations, got %d", len(operations))
+	}
+	if root := operations[1]; root.Operator != "neg" || root.Status != domain.OperationWaiting {
+		t.Errorf("Unexpected root operation: %+v", root)
+	}
+}
+
+func TestDecomposeExpression_Literal(t *testing.T) {
+	operations, value, err := domain.DecomposeExpression("task", "(-7)")
+	if err != nil {
+		t.Fatalf("Error decomposing expression: %v", err)
+	}
+
+	if len(operations) != 0 || value != -7 {
+		t.Errorf("Expected literal -7 without operations, got %v and %d operations", value, len(operations))
+	}
+}
+
+func TestGetTaskForUser(t *testing.T) {
+	ctx := context.Background()
+	orchestrator, store := newOrchestrator(t)
+
+	taskID, err := orchestrator.AddTaskForUser(ctx, "1 / 0", "testuser")
+	if err != nil {
+		t.Fatalf("Error adding task: %v", err)
+	}
+	op, err := store.ClaimOperation(ctx, "agent", 0)
+	if err != nil || op == nil {
+		t.Fatalf("Expected a ready operation, got %v, %v", op, err)
+	}
+	if err := store.FailOperation(ctx, op.ID, "agent", "division by zero"); err != nil {
+		t.Fatalf("Error failing operation: %v", err)
+	}
+
+	task, err := orchestrator.GetTaskForUser(ctx, "testuser", taskID)
+	if err != nil {
+		t.Fatalf("Error getting task: %v", err)
+	}
+
+	if task.ErrorMessage != "division by zero" || task.AgentID != "agent" {
+		t.Errorf("Unexpected task details: %+v", task)
+	}
+	if task.StartedAt == nil || task.FinishedAt == nil || task.FinishedAt.Before(*task.StartedAt) {
+		t.Errorf("Unexpected task timestamps: %+v", task)
+	}
+}
+
+func TestGetTaskForUser_NotFound(t *testing.T) {
+	ctx := context.Background()
+	orchestrator, store := newOrchestrator(t)
+
+	if err := store.CreateUser(ctx, "other", "hash"); err != nil {
+		t.Fatalf("Error creating user: %v", err)
+	}
+	foreign, err := orchestrator.AddTaskForUser(ctx, "2 + 2", "other")
+	if err != nil {
+		t.Fatalf("Error adding task: %v", err)
+	}
+
+	// Another user's task is indistinguishable from a missing one
+	for _, taskID := range []string{foreign, "missing"} {
+		_, err = orchestrator.GetTaskForUser(ctx, "testuser", taskID)
+		if !errors.Is(err, domain.ErrTaskNotFound) {
+			t.Errorf("Expected ErrTaskNotFound for %s, got %v", taskID, err)
+		}
+	}
+}
+
+func TestCancelTaskForUser(t *testing.T) {
+	ctx := context.Background()
+	orchestrator, _ := newOrchestrator(t)
+
+	taskID, err := orchestrator.AddTaskForUser(ctx, "(1 + 2) * 3", "testuser")
+	if err != nil {
+		t.Fatalf("Error adding task: %v", err)
+	}
+
+	task, err := orchestrator.CancelTaskForUser(ctx, "testuser", taskID)
+	if err != nil {
+		t.Fatalf("Error cancelling task: %v", err)
+	}
+	if task.Status != "cancelled" || task.FinishedAt == nil {
+		t.Errorf("Expected cancelled task with finish time, got %+v", task)
+	}
+
+	// A finished task cannot be cancelled again
+	if _, err := orchestrator.CancelTaskForUser(ctx, "testuser", taskID); !errors.Is(err, domain.ErrTaskFinished) {
+		t.Errorf("Expected ErrTaskFinished, got %v", err)
+	}
+	if _, err := orchestrator.CancelTaskForUser(ctx, "testuser", "missing"); !errors.Is(err, domain.ErrTaskNotFound) {
+		t.Errorf("Expected ErrTaskNotFound, got %v", err)
+	}
+}
+
+func TestRetryTaskForUser(t *testing.T) {
+	ctx := context.Background()
+	orchestrator, store := newOrchestrator(t)
+
+	deadID, err := orchestrator.AddTaskForUser(ctx, "2 + 2", "testuser")
+	if err != nil {
+		t.Fatalf("Error adding task: %v", err)
+	}
+	liveID, err := orchestrator.AddTaskForUser(ctx, "3 * 3", "testuser")
+	if err != nil {
+		t.Fatalf("Error adding task: %v", err)
+	}
+
+	// The agent gives up on the first task
+	op, err := store.ClaimOperation(ctx, "agent", time.Minute)
+	if err != nil || op == nil || op.TaskID != deadID {
+		t.Fatalf("Expected an operation of task %s, got %v, %v", deadID, op, err)
+	}
+	if err := store.DeadLetterOperation(ctx, op.ID, "agent", "operation failed after 5 attempts"); err != nil {
+		t.Fatalf("Error moving task to dead letters: %v", err)
+	}
+
+	dead, err := orchestrator.GetDeadTasksForUser(ctx, "testuser")
+	if err != nil || len(dead) != 1 || dead[0].ID != deadID {
+		t.Fatalf("Expected only task %s to be dead, got %+v, %v", deadID, dead, err)
+	}
+
+	task, err := orchestrator.RetryTaskForUser(ctx, "testuser", deadID)
+	if err != nil {
+		t.Fatalf("Error retrying task: %v", err)
+	}
+	if task.Status != "pending" || task.ErrorMessage != "" {
+		t.Errorf("Expected pending task without error, got %+v", task)
+	}
+	if dead, err := orchestrator.GetDeadTasksForUser(ctx, "testuser"); err != nil || len(dead) != 0 {
+		t.Errorf("Expected no dead tasks after retry, got %+v, %v", dead, err)
+	}
 
-	// Ensure all expectations were met
-	if err := mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("Unfulfilled expectations: %s", err)
+	// Only dead tasks can be retried
+	if _, err := orchestrator.RetryTaskForUser(ctx, "testuser", liveID); !errors.Is(err, domain.ErrTaskNotDead) {
+		t.Errorf("Expected ErrTaskNotDead, got %v", err)
 	}
 }
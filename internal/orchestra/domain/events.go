@@ -0,0 +1,129 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// eventBufferSize — сколько событий может накопиться у подписчика, который
+// не успевает их забирать
+const eventBufferSize = 256
+
+// TaskEvent — смена статуса задачи. Событие лишь сообщает, что задача
+// изменилась: её текущее состояние подписчик берет из хранилища, поэтому
+// Status — только подсказка, а агенты передают события без данных пользователя.
+type TaskEvent struct {
+	TaskID string    `json:"task_id"`
+	Status string    `json:"status"`
+	At     time.Time `json:"at"`
+	// Login — владелец задачи. Агенты его не знают и не передают: если
+	// логин пуст, шина один раз узнает его у хранилища (см. EventBus.Owners).
+	Login string `json:"-"`
+}
+
+// TaskOwners возвращает логин владельца задачи или ErrTaskNotFound
+// (реализует TaskStore)
+type TaskOwners interface {
+	GetTaskOwner(ctx context.Context, taskID string) (string, error)
+}
+
+// EventBus рассылает события задач подписчикам внутри процесса
+// оркестратора. Публикуют в неё сам оркестратор (создание, отмена, повтор
+// задачи) и агенты: встроенные напрямую, остальные через внутренние
+// эндпоинты, gRPC или уведомления PostgreSQL (см. Forward). Публикация не
+// блокируется: подписчик, переполнивший буфер, отключается — его канал
+// закрывается, и он должен подписаться заново и перечитать задачи.
+type EventBus struct {
+	// Owners определяет владельца задачи для событий без логина; nil —
+	// такие события получают все подписчики
+	Owners TaskOwners
+
+	mu          sync.Mutex
+	subscribers map[chan TaskEvent]string // канал -> логин подписчика
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{subscribers: make(map[chan TaskEvent]string)}
+}
+
+// PublishTaskEvent рассылает событие подписчикам: подписчик с логином
+// получает только события задач этого пользователя. Если владельца узнать
+// не удалось, событие получают все, а задачи без владельца или удаленные —
+// только подписчики без логина. У nil-шины ничего не делает. Ошибки не
+// бывает: сигнатура совпадает с транспортами агентов.
+func (b *EventBus) PublishTaskEvent(ctx context.Context, event TaskEvent) error {
+	if b == nil {
+		return nil
+	}
+
+	// Владелец запрашивается один раз на событие, а не каждым подписчиком
+	ownerKnown := event.Login != ""
+	if !ownerKnown && b.Owners != nil && b.hasSubscribers() {
+		login, err := b.Owners.GetTaskOwner(ctx, event.TaskID)
+		switch {
+		case err == nil:
+			event.Login, ownerKnown = login, true
+		case errors.Is(err, ErrTaskNotFound):
+			ownerKnown = true
+		default:
+			log.Println("Error getting task owner:", err)
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for subscriber, login := range b.subscribers {
+		if login != "" && ownerKnown && login != event.Login {
+			continue
+		}
+		select {
+		case subscriber <- event:
+		default:
+			close(subscriber)
+			delete(b.subscribers, subscriber)
+		}
+	}
+	return nil
+}
+
+func (b *EventBus) hasSubscribers() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subscribers) > 0
+}
+
+// Subscribe возвращает канал событий задач пользователя login (пустой
+// логин — событий всех задач) и функцию отписки, которую нужно вызвать,
+// когда события больше не нужны
+func (b *EventBus) Subscribe(login string) (<-chan TaskEvent, func()) {
+	subscriber := make(chan TaskEvent, eventBufferSize)
+	b.mu.Lock()
+	b.subscribers[subscriber] = login
+	b.mu.Unlock()
+
+	return subscriber, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subscribers[subscriber]; ok {
+			close(subscriber)
+			delete(b.subscribers, subscriber)
+		}
+	}
+}
+
+// Forward публикует события из events (см. storage.ListenEvents), пока
+// канал не закроется
+func (b *EventBus) Forward(events <-chan TaskEvent) {
+	for event := range events {
+		b.PublishTaskEvent(context.Background(), event)
+	}
+}
+
+// publish сообщает подписчикам о смене статуса задачи пользователя login оркестратором
+func (o *Orchestrator) publish(ctx context.Context, login, taskID, status string) {
+	o.Events.PublishTaskEvent(ctx, TaskEvent{TaskID: taskID, Status: status, At: Now(), Login: login})
+}
@@ -0,0 +1,156 @@
+package domain_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Dadil/project/internal/orchestra/domain"
+)
+
+func TestEventBus(t *testing.T) {
+	ctx := context.Background()
+	bus := domain.NewEventBus()
+
+	first, unsubscribeFirst := bus.Subscribe("")
+	second, unsubscribeSecond := bus.Subscribe("")
+	defer unsubscribeSecond()
+
+	bus.PublishTaskEvent(ctx, domain.TaskEvent{TaskID: "task", Status: "pending"})
+	for _, events := range []<-chan domain.TaskEvent{first, second} {
+		if event := <-events; event.TaskID != "task" || event.Status != "pending" {
+			t.Errorf("Expected pending event for task, got %+v", event)
+		}
+	}
+
+	// После отписки канал закрыт и новых событий не получает
+	unsubscribeFirst()
+	unsubscribeFirst()
+	bus.PublishTaskEvent(ctx, domain.TaskEvent{TaskID: "task", Status: "processing"})
+	if event, ok := <-first; ok {
+		t.Errorf("Expected closed channel after unsubscribe, got %+v", event)
+	}
+	if event := <-second; event.Status != "processing" {
+		t.Errorf("Expected processing event, got %+v", event)
+	}
+
+	// У nil-шины публикация ничего не делает
+	var disabled *domain.EventBus
+	if err := disabled.PublishTaskEvent(ctx, domain.TaskEvent{TaskID: "task"}); err != nil {
+		t.Errorf("Expected no error from nil bus, got %v", err)
+	}
+}
+
+// taskOwners — владельцы задач для шины событий, считающие запросы
+type taskOwners struct {
+	owners  map[string]string
+	lookups int
+}
+
+func (o *taskOwners) GetTaskOwner(ctx context.Context, taskID string) (string, error) {
+	o.lookups++
+	login, ok := o.owners[taskID]
+	if !ok {
+		return "", domain.ErrTaskNotFound
+	}
+	return login, nil
+}
+
+func TestEventBus_Owners(t *testing.T) {
+	ctx := context.Background()
+	owners := &taskOwners{owners: map[string]string{"task": "alice"}}
+	bus := domain.NewEventBus()
+	bus.Owners = owners
+
+	alice, unsubscribeAlice := bus.Subscribe("alice")
+	defer unsubscribeAlice()
+	bob, unsubscribeBob := bus.Subscribe("bob")
+	defer unsubscribeBob()
+	all, unsubscribeAll := bus.Subscribe("")
+	defer unsubscribeAll()
+
+	// Владелец события без логина запрашивается один раз на всех подписчиков
+	bus.PublishTaskEvent(ctx, domain.TaskEvent{TaskID: "task", Status: "pending"})
+	if owners.lookups != 1 {
+		t.Errorf("Expected one owner lookup, got %d", owners.lookups)
+	}
+	for _, events := range []<-chan domain.TaskEvent{alice, all} {
+		if event := <-events; event.TaskID != "task" || event.Login != "alice" {
+			t.Errorf("Expected alice's event, got %+v", event)
+		}
+	}
+
+	// Событие с логином не требует запроса; задача без владельца достается
+	// только подписчику без логина
+	bus.PublishTaskEvent(ctx, domain.TaskEvent{TaskID: "task", Status: "processing", Login: "alice"})
+	bus.PublishTaskEvent(ctx, domain.TaskEvent{TaskID: "orphan", Status: "pending"})
+	if owners.lookups != 2 {
+		t.Errorf("Expected two owner lookups, got %d", owners.lookups)
+	}
+	if event := <-alice; event.Status != "processing" {
+		t.Errorf("Expected processing event, got %+v", event)
+	}
+	if event := <-all; event.Status != "processing" {
+		t.Errorf("Expected processing event, got %+v", event)
+	}
+	if event := <-all; event.TaskID != "orphan" {
+		t.Errorf("Expected orphan event, got %+v", event)
+	}
+	select {
+	case event := <-bob:
+		t.Errorf("Expected no events for bob, got %+v", event)
+	case event := <-alice:
+		t.Errorf("Expected no more events for alice, got %+v", event)
+	default:
+	}
+}
+
+func TestEventBus_SlowSubscriber(t *testing.T) {
+	ctx := context.Background()
+	bus := domain.NewEventBus()
+	events, unsubscribe := bus.Subscribe("")
+	defer unsubscribe()
+
+	// Подписчик, не забиравший события, отключается, а публикация не блокируется
+	for i := 0; i < 1000; i++ {
+		bus.PublishTaskEvent(ctx, domain.TaskEvent{TaskID: "task", Status: "processing"})
+	}
+	received := 0
+	for range events {
+		received++
+	}
+	if received == 0 || received >= 1000 {
+		t.Errorf("Expected buffered events before disconnect, got %d", received)
+	}
+}
+
+func TestOrchestrator_Events(t *testing.T) {
+	ctx := context.Background()
+	orchestrator, _ := newOrchestrator(t)
+	events, unsubscribe := orchestrator.Events.Subscribe("")
+	defer unsubscribe()
+
+	taskID, _, err := orchestrator.SubmitTaskForUser(ctx, "testuser", "2 + 2", domain.AddTaskOptions{})
+	if err != nil {
+		t.Fatalf("Error adding task: %v", err)
+	}
+	if event := <-events; event.TaskID != taskID || event.Status != "pending" {
+		t.Errorf("Expected pending event for %s, got %+v", taskID, event)
+	}
+
+	if _, err := orchestrator.CancelTaskForUser(ctx, "testuser", taskID); err != nil {
+		t.Fatalf("Error cancelling task: %v", err)
+	}
+	if event := <-events; event.TaskID != taskID || event.Status != "cancelled" {
+		t.Errorf("Expected cancelled event for %s, got %+v", taskID, event)
+	}
+
+	// Неудачная отмена событий не порождает
+	if _, err := orchestrator.CancelTaskForUser(ctx, "testuser", taskID); err == nil {
+		t.Error("Expected error cancelling finished task")
+	}
+	select {
+	case event := <-events:
+		t.Errorf("Expected no event, got %+v", event)
+	default:
+	}
+}
@@ -0,0 +1,121 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+var (
+	// ErrIdempotencyKeyNotFound — у пользователя нет действующего ключа
+	ErrIdempotencyKeyNotFound = errors.New("idempotency key not found")
+	// ErrIdempotencyKeyExists — ключ уже занят другим запросом
+	ErrIdempotencyKeyExists = errors.New("idempotency key already exists")
+	// ErrIdempotencyKeyReused — ключ повторно предъявлен с другим выражением
+	ErrIdempotencyKeyReused = errors.New("idempotency key was used with another expression")
+)
+
+// DefaultIdempotencyKeyTTL — срок хранения ключа идемпотентности по умолчанию
+const DefaultIdempotencyKeyTTL = 24 * time.Hour
+
+// IdempotencyKey — ключ идемпотентности запроса на добавление задачи.
+// Ключ принадлежит пользователю и запоминает выражение и созданную задачу.
+type IdempotencyKey struct {
+	Key        string
+	Login      string
+	Expression string
+	TaskID     string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+}
+
+// AddTaskOptions — необязательные параметры добавления задачи
+type AddTaskOptions struct {
+	// IdempotencyKey — ключ клиента: повтор запроса с тем же ключом
+	// возвращает уже созданную задачу вместо новой
+	IdempotencyKey string
+	// Deduplicate отклоняет выражение, если у пользователя уже есть задача
+	// с таким же текстом выражения
+	Deduplicate bool
+	// NoCache — задача вычисляется заново, без результатов из кеша
+	// (см. ResultCache); её результаты всё равно попадают в кеш
+	NoCache bool
+}
+
+// SubmitTaskForUser добавляет задачу пользователя с учетом параметров
+// options. Повтор запроса с тем же ключом идемпотентности возвращает ID
+// задачи, созданной первым запросом, и replayed = true; ключ с другим
+// выражением возвращает ErrIdempotencyKeyReused. При Deduplicate повтор
+// существующего выражения возвращает ErrDuplicateExpression.
+func (o *Orchestrator) SubmitTaskForUser(ctx context.Context, login, expression string, options AddTaskOptions) (taskID string, replayed bool, err error) {
+	if options.IdempotencyKey != "" {
+		taskID, err := o.replayIdempotencyKey(ctx, login, options.IdempotencyKey, expression)
+		if err == nil {
+			return taskID, true, nil
+		}
+		if !errors.Is(err, ErrIdempotencyKeyNotFound) {
+			return "", false, err
+		}
+	}
+
+	if options.Deduplicate {
+		tasks, err := o.Tasks.ListTasksForUser(ctx, login)
+		if err != nil {
+			log.Println("Error getting tasks for user:", err)
+			return "", false, err
+		}
+		for _, task := range tasks {
+			if task.Expression == expression {
+				return "", false, ErrDuplicateExpression
+			}
+		}
+	}
+
+	task, err := newTask(generateTaskID(), expression, o.cachedResults(ctx, options.NoCache), options.NoCache)
+	if err != nil {
+		log.Println("Error decomposing expression:", err)
+		return "", false, err
+	}
+	if options.IdempotencyKey == "" {
+		err = o.Tasks.CreateTask(ctx, login, task.Task, task.Operations)
+	} else {
+		key := IdempotencyKey{
+			Key:        options.IdempotencyKey,
+			Login:      login,
+			Expression: expression,
+			TaskID:     task.Task.ID,
+			CreatedAt:  task.Task.CreatedAt,
+			ExpiresAt:  task.Task.CreatedAt.Add(o.IdempotencyKeyTTL),
+		}
+		err = o.Tasks.CreateTaskWithKey(ctx, login, key, task.Task, task.Operations)
+		if errors.Is(err, ErrIdempotencyKeyExists) {
+			// Одновременный запрос с тем же ключом успел создать задачу первым
+			taskID, err := o.replayIdempotencyKey(ctx, login, options.IdempotencyKey, expression)
+			return taskID, err == nil, err
+		}
+	}
+	if err != nil {
+		if !errors.Is(err, ErrUserNotFound) {
+			log.Println("Error saving task:", err)
+		}
+		return "", false, err
+	}
+	o.publish(ctx, login, task.Task.ID, task.Task.Status)
+	return task.Task.ID, false, nil
+}
+
+// replayIdempotencyKey возвращает задачу, созданную запросом с ключом key
+func (o *Orchestrator) replayIdempotencyKey(ctx context.Context, login, key, expression string) (string, error) {
+	stored, err := o.Tasks.GetIdempotencyKey(ctx, login, key)
+	if err != nil {
+		if !errors.Is(err, ErrIdempotencyKeyNotFound) {
+			log.Println("Error getting idempotency key:", err)
+		}
+		return "", err
+	}
+	if stored.Expression != expression {
+		return "", ErrIdempotencyKeyReused
+	}
+	return stored.TaskID, nil
+}
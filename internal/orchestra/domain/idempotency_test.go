@@ -0,0 +1,81 @@
+package domain_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Dadil/project/internal/orchestra/domain"
+)
+
+func TestSubmitTaskForUser_IdempotencyKey(t *testing.T) {
+	ctx := context.Background()
+	orchestrator, _ := newOrchestrator(t)
+	options := domain.AddTaskOptions{IdempotencyKey: "key-1"}
+
+	taskID, replayed, err := orchestrator.SubmitTaskForUser(ctx, "testuser", "2 + 2", options)
+	if err != nil || replayed {
+		t.Fatalf("Expected new task, got replayed=%v, err=%v", replayed, err)
+	}
+
+	// Повтор с тем же ключом возвращает ту же задачу и не создает новую
+	again, replayed, err := orchestrator.SubmitTaskForUser(ctx, "testuser", "2 + 2", options)
+	if err != nil || !replayed || again != taskID {
+		t.Errorf("Expected replay of %s, got %s, replayed=%v, err=%v", taskID, again, replayed, err)
+	}
+	if tasks := orchestrator.GetTasksForUser(ctx, "testuser"); len(tasks) != 1 {
+		t.Errorf("Expected 1 task, got %d", len(tasks))
+	}
+
+	// Тот же ключ с другим выражением отклоняется
+	if _, _, err := orchestrator.SubmitTaskForUser(ctx, "testuser", "3 + 3", options); err != domain.ErrIdempotencyKeyReused {
+		t.Errorf("Expected ErrIdempotencyKeyReused, got %v", err)
+	}
+
+	// Без ключа повтор выражения создает новую задачу
+	other, replayed, err := orchestrator.SubmitTaskForUser(ctx, "testuser", "2 + 2", domain.AddTaskOptions{})
+	if err != nil || replayed || other == taskID {
+		t.Errorf("Expected another task, got %s, replayed=%v, err=%v", other, replayed, err)
+	}
+}
+
+func TestSubmitTaskForUser_ExpiredKey(t *testing.T) {
+	ctx := context.Background()
+	orchestrator, _ := newOrchestrator(t)
+	orchestrator.IdempotencyKeyTTL = -time.Second
+	options := domain.AddTaskOptions{IdempotencyKey: "key-1"}
+
+	first, _, err := orchestrator.SubmitTaskForUser(ctx, "testuser", "2 + 2", options)
+	if err != nil {
+		t.Fatalf("Error adding task: %v", err)
+	}
+	// Истекший ключ занимается заново, даже с другим выражением
+	second, replayed, err := orchestrator.SubmitTaskForUser(ctx, "testuser", "3 + 3", options)
+	if err != nil || replayed || second == first {
+		t.Errorf("Expected new task for expired key, got %s, replayed=%v, err=%v", second, replayed, err)
+	}
+}
+
+func TestSubmitTaskForUser_Deduplicate(t *testing.T) {
+	ctx := context.Background()
+	orchestrator, _ := newOrchestrator(t)
+	options := domain.AddTaskOptions{Deduplicate: true}
+
+	if _, _, err := orchestrator.SubmitTaskForUser(ctx, "testuser", "2 + 2", options); err != nil {
+		t.Fatalf("Error adding task: %v", err)
+	}
+	if _, _, err := orchestrator.SubmitTaskForUser(ctx, "testuser", "2 + 2", options); err != domain.ErrDuplicateExpression {
+		t.Errorf("Expected ErrDuplicateExpression, got %v", err)
+	}
+
+	// Повтор запроса с ключом не считается дубликатом
+	options.IdempotencyKey = "key-1"
+	taskID, _, err := orchestrator.SubmitTaskForUser(ctx, "testuser", "5 * 5", options)
+	if err != nil {
+		t.Fatalf("Error adding task: %v", err)
+	}
+	again, replayed, err := orchestrator.SubmitTaskForUser(ctx, "testuser", "5 * 5", options)
+	if err != nil || !replayed || again != taskID {
+		t.Errorf("Expected replay of %s, got %s, replayed=%v, err=%v", taskID, again, replayed, err)
+	}
+}
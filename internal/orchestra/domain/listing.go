@@ -0,0 +1,221 @@
+package domain
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Поля сортировки списка задач
+const (
+	SortByCreated  = "created"
+	SortByFinished = "finished"
+	SortByResult   = "result"
+)
+
+// Размер страницы списка задач
+const (
+	DefaultTaskPageSize = 50
+	MaxTaskPageSize     = 500
+)
+
+// ErrInvalidTaskQuery — неверные параметры списка задач
+var ErrInvalidTaskQuery = errors.New("invalid task query")
+
+var taskStatuses = map[string]bool{
+	"pending": true, "processing": true, "completed": true,
+	"error": true, "cancelled": true, "dead": true,
+}
+
+// TaskFilter отбирает задачи списка; пустые поля выборку не ограничивают
+type TaskFilter struct {
+	Status string
+	// Время создания: CreatedFrom включительно, CreatedTo — нет
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	// Expression — подстрока выражения
+	Expression string
+}
+
+// Matches сообщает, подходит ли задача под фильтр
+func (f TaskFilter) Matches(task Task) bool {
+	if f.Status != "" && task.Status != f.Status {
+		return false
+	}
+	if f.CreatedFrom != nil && task.CreatedAt.Before(*f.CreatedFrom) {
+		return false
+	}
+	if f.CreatedTo != nil && !task.CreatedAt.Before(*f.CreatedTo) {
+		return false
+	}
+	return strings.Contains(task.Expression, f.Expression)
+}
+
+// TaskSortKey возвращает значение поля сортировки задачи: time.Time или
+// float64. Для незавершенной задачи по времени завершения и для не
+// вычисленной по результату возвращается nil — такие задачи идут в конце
+// списка при любом направлении сортировки.
+func TaskSortKey(task Task, sort string) interface{} {
+	switch sort {
+	case SortByFinished:
+		if task.FinishedAt == nil {
+			return nil
+		}
+		return *task.FinishedAt
+	case SortByResult:
+		if task.Status != "completed" {
+			return nil
+		}
+		return task.Result
+	default:
+		return task.CreatedAt
+	}
+}
+
+// TaskCursor — позиция в списке задач: ключ сортировки (см. TaskSortKey)
+// и ID последней задачи предыдущей страницы
+type TaskCursor struct {
+	Value interface{}
+	ID    string
+}
+
+// TaskQuery — запрос страницы задач к хранилищу
+type TaskQuery struct {
+	TaskFilter
+	Sort string
+	Desc bool
+	// After — задачи возвращаются начиная со следующей за курсором
+	After *TaskCursor
+	Limit int
+}
+
+// TaskListOptions — параметры списка задач пользователя
+type TaskListOptions struct {
+	TaskFilter
+	Sort  string // по умолчанию SortByCreated
+	Desc  bool
+	Limit int // по умолчанию DefaultTaskPageSize
+	// Cursor — NextCursor предыдущей страницы
+	Cursor string
+}
+
+// TaskPage — страница списка задач
+type TaskPage struct {
+	Tasks []Task `json:"tasks"`
+	// Total — число задач, подходящих под фильтр, на всех страницах
+	Total int `json:"total"`
+	// NextCursor запрашивает следующую страницу; пуст на последней
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// cursorData — содержимое курсора. Курсор привязан к полю и направлению
+// сортировки, с которыми выдан.
+type cursorData struct {
+	Sort   string     `json:"s"`
+	Desc   bool       `json:"d,omitempty"`
+	Time   *time.Time `json:"t,omitempty"`
+	Result *float64   `json:"r,omitempty"`
+	ID     string     `json:"id"`
+}
+
+func encodeCursor(query TaskQuery, task Task) string {
+	data := cursorData{Sort: query.Sort, Desc: query.Desc, ID: task.ID}
+	switch value := TaskSortKey(task, query.Sort).(type) {
+	case time.Time:
+		data.Time = &value
+	case float64:
+		data.Result = &value
+	}
+	raw, _ := json.Marshal(data)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(cursor string, query TaskQuery) (*TaskCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	var data cursorData
+	if err == nil {
+		err = json.Unmarshal(raw, &data)
+	}
+	if err != nil || data.ID == "" {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidTaskQuery)
+	}
+	if data.Sort != query.Sort || data.Desc != query.Desc {
+		return nil, fmt.Errorf("%w: cursor was issued for another sort order", ErrInvalidTaskQuery)
+	}
+
+	after := &TaskCursor{ID: data.ID}
+	switch {
+	case data.Time != nil:
+		after.Value = data.Time.UTC()
+	case data.Result != nil:
+		after.Value = *data.Result
+	}
+	return after, nil
+}
+
+// newTaskQuery проверяет параметры списка и превращает их в запрос к хранилищу
+func newTaskQuery(options TaskListOptions) (TaskQuery, error) {
+	query := TaskQuery{TaskFilter: options.TaskFilter, Sort: options.Sort, Desc: options.Desc, Limit: options.Limit}
+	if query.Sort == "" {
+		query.Sort = SortByCreated
+	}
+	if query.Sort != SortByCreated && query.Sort != SortByFinished && query.Sort != SortByResult {
+		return query, fmt.Errorf("%w: unknown sort field %q", ErrInvalidTaskQuery, query.Sort)
+	}
+	if query.Limit == 0 {
+		query.Limit = DefaultTaskPageSize
+	}
+	if query.Limit < 0 || query.Limit > MaxTaskPageSize {
+		return query, fmt.Errorf("%w: limit must be 1 to %d", ErrInvalidTaskQuery, MaxTaskPageSize)
+	}
+	if query.Status != "" && !taskStatuses[query.Status] {
+		return query, fmt.Errorf("%w: unknown status %q", ErrInvalidTaskQuery, query.Status)
+	}
+	if query.CreatedFrom != nil && query.CreatedTo != nil && !query.CreatedFrom.Before(*query.CreatedTo) {
+		return query, fmt.Errorf("%w: created_from must be before created_to", ErrInvalidTaskQuery)
+	}
+	if options.Cursor != "" {
+		after, err := decodeCursor(options.Cursor, query)
+		if err != nil {
+			return query, err
+		}
+		query.After = after
+	}
+	return query, nil
+}
+
+// ListTasksForUser возвращает страницу задач пользователя с фильтрами и
+// сортировкой. Следующая страница запрашивается курсором NextCursor с теми
+// же параметрами; курсор указывает на последнюю выданную задачу, поэтому
+// новые задачи не сдвигают страницы. Неверные параметры возвращают
+// ErrInvalidTaskQuery.
+func (o *Orchestrator) ListTasksForUser(ctx context.Context, login string, options TaskListOptions) (*TaskPage, error) {
+	query, err := newTaskQuery(options)
+	if err != nil {
+		return nil, err
+	}
+
+	// Лишняя задача показывает, есть ли следующая страница
+	limit := query.Limit
+	query.Limit++
+	tasks, total, err := o.Tasks.QueryTasksForUser(ctx, login, query)
+	if err != nil {
+		log.Println("Error listing tasks for user:", err)
+		return nil, err
+	}
+
+	page := &TaskPage{Tasks: tasks, Total: total}
+	if len(tasks) > limit {
+		page.Tasks = tasks[:limit]
+		page.NextCursor = encodeCursor(query, page.Tasks[limit-1])
+	}
+	if page.Tasks == nil {
+		page.Tasks = []Task{}
+	}
+	return page, nil
+}
@@ -0,0 +1,83 @@
+package domain_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Dadil/project/internal/orchestra/domain"
+)
+
+func TestListTasksForUser(t *testing.T) {
+	ctx := context.Background()
+	orchestrator, store := newOrchestrator(t)
+
+	base := domain.Now().Add(-time.Hour)
+	for i, expression := range []string{"1 + 1", "2 + 2", "3 + 3", "4 * 4"} {
+		task := domain.Task{ID: string(rune('a' + i)), Expression: expression, Status: "pending", CreatedAt: base.Add(time.Duration(i) * time.Second)}
+		if err := store.CreateTask(ctx, "testuser", task, nil); err != nil {
+			t.Fatalf("Error creating task: %v", err)
+		}
+	}
+
+	options := domain.TaskListOptions{TaskFilter: domain.TaskFilter{Expression: "+"}, Desc: true, Limit: 2}
+	page, err := orchestrator.ListTasksForUser(ctx, "testuser", options)
+	if err != nil {
+		t.Fatalf("Error listing tasks: %v", err)
+	}
+	if page.Total != 3 || len(page.Tasks) != 2 || page.Tasks[0].Expression != "3 + 3" || page.NextCursor == "" {
+		t.Fatalf("Unexpected first page: %+v", page)
+	}
+
+	// Новая задача не сдвигает следующую страницу
+	if _, err := orchestrator.AddTaskForUser(ctx, "5 + 5", "testuser"); err != nil {
+		t.Fatalf("Error adding task: %v", err)
+	}
+	options.Cursor = page.NextCursor
+	page, err = orchestrator.ListTasksForUser(ctx, "testuser", options)
+	if err != nil {
+		t.Fatalf("Error listing tasks: %v", err)
+	}
+	if page.Total != 4 || len(page.Tasks) != 1 || page.Tasks[0].Expression != "1 + 1" || page.NextCursor != "" {
+		t.Errorf("Unexpected last page: %+v", page)
+	}
+
+	// Пустой список кодируется как [], а не null
+	page, err = orchestrator.ListTasksForUser(ctx, "testuser", domain.TaskListOptions{TaskFilter: domain.TaskFilter{Status: "dead"}})
+	if err != nil || page.Tasks == nil || page.Total != 0 {
+		t.Errorf("Expected empty page, got %+v, %v", page, err)
+	}
+}
+
+func TestListTasksForUser_Invalid(t *testing.T) {
+	ctx := context.Background()
+	orchestrator, store := newOrchestrator(t)
+	for _, id := range []string{"a", "b"} {
+		if err := store.CreateTask(ctx, "testuser", domain.Task{ID: id, Expression: "1 + 1", Status: "pending", CreatedAt: domain.Now()}, nil); err != nil {
+			t.Fatalf("Error creating task: %v", err)
+		}
+	}
+	page, err := orchestrator.ListTasksForUser(ctx, "testuser", domain.TaskListOptions{Limit: 1})
+	if err != nil {
+		t.Fatalf("Error listing tasks: %v", err)
+	}
+
+	now := domain.Now()
+	tests := []domain.TaskListOptions{
+		{Sort: "name"},
+		{Limit: -1},
+		{Limit: domain.MaxTaskPageSize + 1},
+		{TaskFilter: domain.TaskFilter{Status: "done"}},
+		{TaskFilter: domain.TaskFilter{CreatedFrom: &now, CreatedTo: &now}},
+		{Cursor: "garbage"},
+		// Курсор привязан к полю и направлению сортировки
+		{Cursor: page.NextCursor, Desc: true},
+		{Cursor: page.NextCursor, Sort: domain.SortByResult},
+	}
+	for _, options := range tests {
+		if _, err := orchestrator.ListTasksForUser(ctx, "testuser", options); !errors.Is(err, domain.ErrInvalidTaskQuery) {
+			t.Errorf("Expected ErrInvalidTaskQuery for %+v, got %v", options, err)
+		}
+	}
+}
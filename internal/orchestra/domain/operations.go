@@ -0,0 +1,150 @@
+package domain
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Dadil/project/internal/agent/expression"
+)
+
+// Статусы операций в графе вычисления выражения
+const (
+	OperationWaiting    = "waiting"    // ждёт результатов дочерних операций
+	OperationPending    = "pending"    // все операнды известны, операция готова к вычислению
+	OperationProcessing = "processing" // операцию вычисляет агент
+	OperationCompleted  = "completed"
+	OperationError      = "error"
+	OperationCancelled  = "cancelled" // задачу отменил пользователь
+	OperationDead       = "dead"      // попытки вычисления исчерпаны, ждёт ручного повтора
+)
+
+// Operation — одна арифметическая операция выражения. Операнд либо известен
+// сразу (LeftValue/RightValue), либо является результатом дочерней операции
+// (LeftID/RightID) и заполняется, когда она завершится.
+type Operation struct {
+	ID         string   `json:"id"`
+	TaskID     string   `json:"task_id"`
+	ParentID   string   `json:"parent_id,omitempty"`
+	Operator   string   `json:"operator"`
+	LeftID     string   `json:"left_id,omitempty"`
+	RightID    string   `json:"right_id,omitempty"`
+	LeftValue  *float64 `json:"left_value,omitempty"`
+	RightValue *float64 `json:"right_value,omitempty"`
+	Status     string   `json:"status"`
+	Result     float64  `json:"result"`
+	// Аренда: агент, вычисляющий операцию, и срок, до которого он должен её продлить
+	OwnerAgent     string     `json:"owner_agent,omitempty"`
+	LeaseExpiresAt *time.Time `json:"lease_expires_at,omitempty"`
+	// Повторные попытки: сколько раз операцию захватывали, раньше какого
+	// времени её нельзя захватить снова и последняя временная ошибка
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	// NoCache — задача отказалась от кеша результатов: агент вычисляет
+	// операцию, не заглядывая в кеш (см. ResultCache)
+	NoCache bool `json:"no_cache,omitempty"`
+}
+
+// DecomposeExpression разбивает выражение на граф независимых операций.
+// Операции, у которых оба операнда — числа, сразу готовы к вычислению,
+// поэтому (1+2)*(3+4) даёт две параллельные операции сложения и умножение,
+// ожидающее их результатов. Если выражение — просто число, операций нет,
+// а значение возвращается вторым результатом.
+func DecomposeExpression(taskID string, expr string) ([]Operation, float64, error) {
+	return decomposeExpression(taskID, expr, nil)
+}
+
+// decomposeExpression раскладывает выражение, как DecomposeExpression, но
+// бинарную операцию над известными операндами, результат которой находит
+// cached, сразу заменяет этим результатом. Подстановка идет снизу вверх,
+// поэтому выражение, все операции которого есть в кеше, сворачивается
+// в число.
+func decomposeExpression(taskID string, expr string, cached resultLookup) ([]Operation, float64, error) {
+	node, err := expression.Parse(expr)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var operations []Operation
+	var visit func(node expression.Node, parentID string) (string, float64, error)
+	visit = func(node expression.Node, parentID string) (string, float64, error) {
+		switch n := node.(type) {
+		case *expression.NumberNode:
+			return "", n.Value, nil
+		case *expression.UnaryNode:
+			if n.Operator == "+" {
+				return visit(n.Operand, parentID)
+			}
+			op := Operation{ID: generateTaskID(), TaskID: taskID, ParentID: parentID, Operator: expression.OperatorNegate}
+			// Правый операнд у унарной операции не используется
+			zero := 0.0
+			op.RightValue = &zero
+			childID, value, err := visit(n.Operand, op.ID)
+			if err != nil {
+				return "", 0, err
+			}
+			// Знак перед литералом сворачивает парсер, так что число здесь —
+			// результат из кеша; смена знака мгновенна
+			if childID == "" && cached != nil {
+				return "", -value, nil
+			}
+			if childID == "" {
+				op.LeftValue = &value
+			} else {
+				op.LeftID = childID
+			}
+			operations = append(operations, withStatus(op))
+			return op.ID, 0, nil
+		case *expression.BinaryNode:
+			op := Operation{ID: generateTaskID(), TaskID: taskID, ParentID: parentID, Operator: n.Operator}
+			leftID, leftValue, err := visit(n.Left, op.ID)
+			if err != nil {
+				return "", 0, err
+			}
+			rightID, rightValue, err := visit(n.Right, op.ID)
+			if err != nil {
+				return "", 0, err
+			}
+			if leftID == "" && rightID == "" && cached != nil {
+				folded := &expression.BinaryNode{
+					Operator: n.Operator,
+					Left:     &expression.NumberNode{Value: leftValue},
+					Right:    &expression.NumberNode{Value: rightValue},
+				}
+				if result, ok := cached(folded); ok {
+					return "", result, nil
+				}
+			}
+			if leftID == "" {
+				op.LeftValue = &leftValue
+			} else {
+				op.LeftID = leftID
+			}
+			if rightID == "" {
+				op.RightValue = &rightValue
+			} else {
+				op.RightID = rightID
+			}
+			operations = append(operations, withStatus(op))
+			return op.ID, 0, nil
+		default:
+			return "", 0, fmt.Errorf("unsupported node: %T", node)
+		}
+	}
+
+	_, value, err := visit(node, "")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return operations, value, nil
+}
+
+func withStatus(op Operation) Operation {
+	if op.LeftValue != nil && op.RightValue != nil {
+		op.Status = OperationPending
+	} else {
+		op.Status = OperationWaiting
+	}
+	return op
+}
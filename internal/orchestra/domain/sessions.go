@@ -0,0 +1,166 @@
+package domain
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// refreshTokenBytes — длина случайной части refresh-токена
+const refreshTokenBytes = 32
+
+// ErrUserDisabled — учетная запись отключена администратором
+var ErrUserDisabled = errors.New("user is disabled")
+
+// HashToken возвращает хеш refresh-токена, под которым он хранится:
+// утечка таблицы не дает предъявить токены
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateRefreshToken() (string, error) {
+	buf := make([]byte, refreshTokenBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// Session — сессия вошедшего пользователя
+type Session struct {
+	ID   string
+	User User
+	// RefreshToken — действующий refresh-токен сессии
+	RefreshToken string
+}
+
+// StartSession начинает сессию пользователя после входа и выдает первый
+// refresh-токен, действующий ttl. Отключенный пользователь получает
+// ErrUserDisabled.
+func (o *Orchestrator) StartSession(ctx context.Context, login string, ttl time.Duration) (*Session, error) {
+	user, err := o.GetUserByLogin(ctx, login)
+	if err != nil {
+		return nil, err
+	}
+	if user.Disabled {
+		return nil, ErrUserDisabled
+	}
+
+	refresh, err := generateRefreshToken()
+	if err != nil {
+		log.Println("Error generating refresh token:", err)
+		return nil, err
+	}
+
+	now := Now()
+	token := RefreshToken{
+		Hash:      HashToken(refresh),
+		Login:     login,
+		SessionID: uuid.New().String(),
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	if err := o.Users.CreateRefreshToken(ctx, token); err != nil {
+		log.Println("Error creating refresh token:", err)
+		return nil, err
+	}
+	return &Session{ID: token.SessionID, User: *user, RefreshToken: refresh}, nil
+}
+
+// RefreshSession обменивает refresh-токен на новый (ротация). Повторное
+// предъявление уже обмененного токена отзывает всю сессию и возвращает
+// ErrTokenReused. Сессия возвращается с текущими данными пользователя,
+// поэтому новая роль попадает в access-токен при следующем обновлении.
+func (o *Orchestrator) RefreshSession(ctx context.Context, refresh string, ttl time.Duration) (*Session, error) {
+	next, err := generateRefreshToken()
+	if err != nil {
+		log.Println("Error generating refresh token:", err)
+		return nil, err
+	}
+
+	token, err := o.Users.RotateRefreshToken(ctx, HashToken(refresh), HashToken(next), Now().Add(ttl))
+	if errors.Is(err, ErrTokenReused) {
+		log.Println("Refresh token reuse detected, session revoked")
+		return nil, err
+	}
+	if err != nil {
+		if !errors.Is(err, ErrTokenNotFound) {
+			log.Println("Error rotating refresh token:", err)
+		}
+		return nil, err
+	}
+
+	user, err := o.GetUserByLogin(ctx, token.Login)
+	if err != nil {
+		return nil, err
+	}
+	if user.Disabled {
+		// Сессии отключенного пользователя отзываются при отключении;
+		// сюда попадает только токен, обновленный одновременно с ним
+		o.Users.RevokeSession(ctx, token.SessionID)
+		return nil, ErrUserDisabled
+	}
+	return &Session{ID: token.SessionID, User: *user, RefreshToken: next}, nil
+}
+
+// EndSession отзывает refresh-токены сессии и запрещает ее access-токены:
+// предъявленный jti — до истечения его срока expiresAt, остальные — на
+// accessTTL, пока не истекут все выданные до выхода
+func (o *Orchestrator) EndSession(ctx context.Context, sessionID, jti string, expiresAt time.Time, accessTTL time.Duration) error {
+	if err := o.Users.RevokeSession(ctx, sessionID); err != nil {
+		log.Println("Error revoking session:", err)
+		return err
+	}
+	if err := o.Users.DenyAccessToken(ctx, jti, expiresAt); err != nil {
+		log.Println("Error denying access token:", err)
+		return err
+	}
+	if err := o.Users.DenySession(ctx, sessionID, Now().Add(accessTTL)); err != nil {
+		log.Println("Error denying session:", err)
+		return err
+	}
+	return nil
+}
+
+// IsAccessTokenDenied сообщает, отозван ли access-токен jti сессии
+// sessionID при выходе
+func (o *Orchestrator) IsAccessTokenDenied(ctx context.Context, jti, sessionID string) (bool, error) {
+	denied, err := o.Users.IsAccessTokenDenied(ctx, jti, sessionID)
+	if err != nil {
+		log.Println("Error checking access token:", err)
+		return false, err
+	}
+	return denied, nil
+}
+
+// PurgeExpired удаляет истекшие refresh-токены, записи запрещенных
+// access-токенов, ключи идемпотентности и записи общего кеша результатов
+// раз в interval, пока ctx не отменен
+func (o *Orchestrator) PurgeExpired(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := o.Users.DeleteExpiredTokens(ctx, Now()); err != nil {
+				log.Println("Error deleting expired tokens:", err)
+			}
+			if err := o.Tasks.DeleteExpiredIdempotencyKeys(ctx, Now()); err != nil {
+				log.Println("Error deleting expired idempotency keys:", err)
+			}
+			if err := o.Tasks.DeleteExpiredResults(ctx, Now()); err != nil {
+				log.Println("Error deleting expired cached results:", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package domain_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Dadil/project/internal/orchestra/domain"
+)
+
+func TestRefreshSession(t *testing.T) {
+	ctx := context.Background()
+	orchestrator, _ := newOrchestrator(t)
+
+	session, err := orchestrator.StartSession(ctx, "testuser", time.Hour)
+	if err != nil {
+		t.Fatalf("Error starting session: %v", err)
+	}
+	if session.User.Login != "testuser" || session.User.Role != domain.RoleUser {
+		t.Errorf("Expected testuser with role user, got %+v", session.User)
+	}
+
+	refreshed, err := orchestrator.RefreshSession(ctx, session.RefreshToken, time.Hour)
+	if err != nil {
+		t.Fatalf("Error refreshing session: %v", err)
+	}
+	if refreshed.User.Login != "testuser" || refreshed.ID != session.ID {
+		t.Errorf("Expected testuser in session %s, got %s in session %s", session.ID, refreshed.User.Login, refreshed.ID)
+	}
+	if refreshed.RefreshToken == session.RefreshToken {
+		t.Error("Expected a new refresh token after rotation")
+	}
+
+	// Повторное предъявление обмененного токена отзывает сессию целиком
+	if _, err := orchestrator.RefreshSession(ctx, session.RefreshToken, time.Hour); !errors.Is(err, domain.ErrTokenReused) {
+		t.Errorf("Expected ErrTokenReused, got %v", err)
+	}
+	if _, err := orchestrator.RefreshSession(ctx, refreshed.RefreshToken, time.Hour); !errors.Is(err, domain.ErrTokenReused) {
+		t.Errorf("Expected the rotated token to be revoked, got %v", err)
+	}
+
+	if _, err := orchestrator.RefreshSession(ctx, "unknown", time.Hour); !errors.Is(err, domain.ErrTokenNotFound) {
+		t.Errorf("Expected ErrTokenNotFound, got %v", err)
+	}
+}
+
+func TestEndSession(t *testing.T) {
+	ctx := context.Background()
+	orchestrator, _ := newOrchestrator(t)
+
+	session, err := orchestrator.StartSession(ctx, "testuser", time.Hour)
+	if err != nil {
+		t.Fatalf("Error starting session: %v", err)
+	}
+	if err := orchestrator.EndSession(ctx, session.ID, "jti-1", domain.Now().Add(time.Minute), time.Minute); err != nil {
+		t.Fatalf("Error ending session: %v", err)
+	}
+
+	// Запрещены и предъявленный токен, и другие токены сессии
+	for _, jti := range []string{"jti-1", "jti-2"} {
+		denied, err := orchestrator.IsAccessTokenDenied(ctx, jti, session.ID)
+		if err != nil || !denied {
+			t.Errorf("Expected access token %s to be denied, got %v, %v", jti, denied, err)
+		}
+	}
+	if denied, err := orchestrator.IsAccessTokenDenied(ctx, "jti-3", "other-session"); err != nil || denied {
+		t.Errorf("Expected token of another session to be allowed, got %v, %v", denied, err)
+	}
+	if _, err := orchestrator.RefreshSession(ctx, session.RefreshToken, time.Hour); err == nil {
+		t.Error("Expected refresh token of an ended session to be rejected")
+	}
+}
+
+func TestSetUserDisabled(t *testing.T) {
+	ctx := context.Background()
+	orchestrator, _ := newOrchestrator(t)
+
+	session, err := orchestrator.StartSession(ctx, "testuser", time.Hour)
+	if err != nil {
+		t.Fatalf("Error starting session: %v", err)
+	}
+	if err := orchestrator.SetUserDisabled(ctx, "testuser", true, time.Minute); err != nil {
+		t.Fatalf("Error disabling user: %v", err)
+	}
+
+	// Отключение отзывает сессии, запрещает их access-токены и вход
+	if _, err := orchestrator.RefreshSession(ctx, session.RefreshToken, time.Hour); err == nil {
+		t.Error("Expected refresh token of a disabled user to be rejected")
+	}
+	if denied, err := orchestrator.IsAccessTokenDenied(ctx, "jti-1", session.ID); err != nil || !denied {
+		t.Errorf("Expected access token of a disabled user to be denied, got %v, %v", denied, err)
+	}
+	if _, err := orchestrator.StartSession(ctx, "testuser", time.Hour); !errors.Is(err, domain.ErrUserDisabled) {
+		t.Errorf("Expected ErrUserDisabled, got %v", err)
+	}
+
+	if err := orchestrator.SetUserDisabled(ctx, "testuser", false, time.Minute); err != nil {
+		t.Fatalf("Error enabling user: %v", err)
+	}
+	if _, err := orchestrator.StartSession(ctx, "testuser", time.Hour); err != nil {
+		t.Errorf("Expected enabled user to log in, got %v", err)
+	}
+	if err := orchestrator.SetUserDisabled(ctx, "unknown", true, time.Minute); !errors.Is(err, domain.ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+}
+
+func TestHashToken(t *testing.T) {
+	hash := domain.HashToken("token")
+	if hash == "token" || len(hash) != 64 {
+		t.Errorf("Expected a hex sha256 hash, got %q", hash)
+	}
+	if domain.HashToken("token") != hash {
+		t.Error("Expected the same hash for the same token")
+	}
+}
@@ -0,0 +1,78 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/Dadil/project/internal/agent/expression"
+)
+
+// ErrInvalidDurations — недопустимые настройки времени выполнения операторов
+var ErrInvalidDurations = errors.New("invalid operator durations")
+
+// DurationKeyPrefix — префикс ключей времени выполнения операторов
+// в настройках хранилища: duration.+, duration.- и т.д.
+const DurationKeyPrefix = "duration."
+
+// ValidateDurations проверяет, что заданы только известные операторы
+// и время выполнения неотрицательно
+func ValidateDurations(durations map[string]int) error {
+	for operator, duration := range durations {
+		if !isOperator(operator) {
+			return fmt.Errorf("%w: unknown operator %q", ErrInvalidDurations, operator)
+		}
+		if duration < 0 {
+			return fmt.Errorf("%w: duration for %s must not be negative", ErrInvalidDurations, operator)
+		}
+	}
+	return nil
+}
+
+func isOperator(operator string) bool {
+	for _, known := range expression.Operators {
+		if operator == known {
+			return true
+		}
+	}
+	return false
+}
+
+// InitDurations сохраняет время выполнения операторов из конфигурации,
+// не перезаписывая значения, уже измененные через API
+func (o *Orchestrator) InitDurations(ctx context.Context, defaults map[string]int) error {
+	if err := ValidateDurations(defaults); err != nil {
+		return err
+	}
+	if err := o.Tasks.InitDurations(ctx, defaults); err != nil {
+		log.Println("Error initializing operator durations:", err)
+		return err
+	}
+	return nil
+}
+
+// GetDurations возвращает время выполнения операторов в миллисекундах
+func (o *Orchestrator) GetDurations(ctx context.Context) (map[string]int, error) {
+	durations, err := o.Tasks.GetDurations(ctx)
+	if err != nil {
+		log.Println("Error getting operator durations:", err)
+		return nil, err
+	}
+	return durations, nil
+}
+
+// SetDurations обновляет время выполнения перечисленных операторов.
+// Агенты подхватывают новые значения при следующем опросе хранилища.
+func (o *Orchestrator) SetDurations(ctx context.Context, durations map[string]int) (map[string]int, error) {
+	if err := ValidateDurations(durations); err != nil {
+		return nil, err
+	}
+
+	if err := o.Tasks.SetDurations(ctx, durations); err != nil {
+		log.Println("Error saving operator durations:", err)
+		return nil, err
+	}
+
+	return o.GetDurations(ctx)
+}
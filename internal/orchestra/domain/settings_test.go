@@ -0,0 +1,76 @@
+package domain_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Dadil/project/internal/orchestra/domain"
+)
+
+func TestGetDurations(t *testing.T) {
+	ctx := context.Background()
+	orchestrator, _ := newOrchestrator(t)
+
+	if err := orchestrator.InitDurations(ctx, map[string]int{"+": 1000, "*": 2500}); err != nil {
+		t.Fatalf("Error initializing durations: %v", err)
+	}
+
+	durations, err := orchestrator.GetDurations(ctx)
+	if err != nil {
+		t.Fatalf("Error getting durations: %v", err)
+	}
+
+	if durations["+"] != 1000 || durations["*"] != 2500 || len(durations) != 2 {
+		t.Errorf("Unexpected durations: %v", durations)
+	}
+}
+
+func TestSetDurations(t *testing.T) {
+	ctx := context.Background()
+	orchestrator, _ := newOrchestrator(t)
+
+	if err := orchestrator.InitDurations(ctx, map[string]int{"+": 1000, "-": 2000}); err != nil {
+		t.Fatalf("Error initializing durations: %v", err)
+	}
+
+	// Only the listed operators change, the result holds all of them
+	durations, err := orchestrator.SetDurations(ctx, map[string]int{"/": 0, "+": 100})
+	if err != nil {
+		t.Fatalf("Error setting durations: %v", err)
+	}
+	if durations["+"] != 100 || durations["-"] != 2000 || durations["/"] != 0 || len(durations) != 3 {
+		t.Errorf("Unexpected durations: %v", durations)
+	}
+
+	// Configuration defaults do not override values changed through the API
+	if err := orchestrator.InitDurations(ctx, map[string]int{"+": 1000}); err != nil {
+		t.Fatalf("Error initializing durations: %v", err)
+	}
+	if durations, _ := orchestrator.GetDurations(ctx); durations["+"] != 100 {
+		t.Errorf("Unexpected durations after restart: %v", durations)
+	}
+}
+
+func TestSetDurations_Invalid(t *testing.T) {
+	ctx := context.Background()
+	orchestrator, _ := newOrchestrator(t)
+
+	tests := []map[string]int{
+		{"^": 100},
+		{"+": -1},
+	}
+
+	for _, durations := range tests {
+		_, err := orchestrator.SetDurations(ctx, durations)
+		if !errors.Is(err, domain.ErrInvalidDurations) {
+			t.Errorf("Expected ErrInvalidDurations for %v, got %v", durations, err)
+		}
+	}
+
+	// Invalid settings must not reach the store
+	durations, err := orchestrator.GetDurations(ctx)
+	if err != nil || len(durations) != 0 {
+		t.Errorf("Expected no durations, got %v, %v", durations, err)
+	}
+}
@@ -0,0 +1,183 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrUserNotFound — пользователя с таким логином нет
+	ErrUserNotFound = errors.New("user not found")
+	// ErrUserExists — логин уже занят
+	ErrUserExists = errors.New("user already exists")
+	// ErrLeaseLost — аренда операции истекла или операция принадлежит другому агенту
+	ErrLeaseLost = errors.New("operation lease lost")
+	// ErrAgentNotFound — агент не зарегистрирован в реестре
+	ErrAgentNotFound = errors.New("agent not found")
+	// ErrTokenNotFound — refresh-токена нет или срок его действия истек
+	ErrTokenNotFound = errors.New("refresh token not found")
+	// ErrTokenReused — предъявлен уже замененный или отозванный refresh-токен
+	ErrTokenReused = errors.New("refresh token reused")
+	// ErrOperationNotFound — операции с таким ID нет
+	ErrOperationNotFound = errors.New("operation not found")
+)
+
+// TaskStore хранит задачи, граф их операций и настройки вычисления.
+// Реализации: sqlstore (PostgreSQL и SQLite) и memstore (в памяти);
+// все они проходят общий набор тестов из пакета storetest.
+type TaskStore interface {
+	// CreateTask сохраняет задачу вместе с операциями. Если login не пуст,
+	// задача связывается с пользователем; для неизвестного логина
+	// возвращается ErrUserNotFound.
+	CreateTask(ctx context.Context, login string, task Task, operations []Operation) error
+	// CreateTasks сохраняет несколько задач одной транзакцией: сохраняются
+	// все задачи или ни одной
+	CreateTasks(ctx context.Context, login string, tasks []NewTask) error
+	// CreateTaskWithKey сохраняет задачу, как CreateTask, вместе с ключом
+	// идемпотентности одной транзакцией. Если у пользователя уже есть
+	// действующий ключ с тем же значением, задача не сохраняется и
+	// возвращается ErrIdempotencyKeyExists; истекший ключ заменяется.
+	CreateTaskWithKey(ctx context.Context, login string, key IdempotencyKey, task Task, operations []Operation) error
+	// GetIdempotencyKey возвращает действующий ключ пользователя или
+	// ErrIdempotencyKeyNotFound. Ключ удаляется вместе со своей задачей.
+	GetIdempotencyKey(ctx context.Context, login, key string) (*IdempotencyKey, error)
+	// DeleteExpiredIdempotencyKeys удаляет ключи, срок которых истек до before
+	DeleteExpiredIdempotencyKeys(ctx context.Context, before time.Time) error
+	ListTasks(ctx context.Context) ([]Task, error)
+	ListTasksForUser(ctx context.Context, login string) ([]Task, error)
+	// QueryTasksForUser возвращает до query.Limit задач пользователя,
+	// подходящих под фильтр, в порядке сортировки query, начиная со
+	// следующей за query.After, и общее число подходящих под фильтр задач.
+	// Задачи без значения поля сортировки (см. TaskSortKey) идут в конце.
+	QueryTasksForUser(ctx context.Context, login string, query TaskQuery) ([]Task, int, error)
+	// GetTaskForUser возвращает ErrTaskNotFound и для чужой, и для несуществующей задачи
+	GetTaskForUser(ctx context.Context, login, taskID string) (*Task, error)
+	// GetTaskOwner возвращает логин владельца задачи; для задачи без
+	// владельца или несуществующей возвращается ErrTaskNotFound
+	GetTaskOwner(ctx context.Context, taskID string) (string, error)
+	DeleteTasksForUser(ctx context.Context, login string) error
+	// CancelTaskForUser переводит задачу и все её незавершенные операции
+	// в cancelled и возвращает задачу. Агент, вычисляющий операцию задачи,
+	// теряет аренду. Для завершенной задачи возвращается ErrTaskFinished.
+	CancelTaskForUser(ctx context.Context, login, taskID string) (*Task, error)
+	// RetryTaskForUser возвращает задачу в статусе dead и её операции dead
+	// в очередь с обнуленным счетчиком попыток. Для задачи в другом статусе
+	// возвращается ErrTaskNotDead.
+	RetryTaskForUser(ctx context.Context, login, taskID string) (*Task, error)
+
+	// GetOperation возвращает операцию или ErrOperationNotFound
+	GetOperation(ctx context.Context, operationID string) (*Operation, error)
+	// ClaimOperation атомарно захватывает готовую операцию, время повтора
+	// которой наступило, или операцию с истекшей арендой и увеличивает её
+	// счетчик попыток. Возвращает nil, если таких нет.
+	ClaimOperation(ctx context.Context, owner string, lease time.Duration) (*Operation, error)
+	// Методы ниже возвращают ErrLeaseLost, если owner больше не владеет операцией
+	RenewLease(ctx context.Context, operationID, owner string, lease time.Duration) error
+	// CompleteOperation записывает результат, подставляет его в родительскую
+	// операцию, а для корневой операции завершает задачу. Счетчик вычисленных
+	// операций агента owner в реестре увеличивается.
+	CompleteOperation(ctx context.Context, operationID, owner string, result float64) error
+	// FailOperation переводит в ошибку задачу и все её незавершенные операции
+	FailOperation(ctx context.Context, operationID, owner, message string) error
+	// ReleaseOperation возвращает операцию в очередь без результата,
+	// не засчитывая попытку
+	ReleaseOperation(ctx context.Context, operationID, owner string) error
+	// RetryOperation возвращает операцию в очередь после временной ошибки:
+	// захватить её снова можно не раньше nextAttemptAt
+	RetryOperation(ctx context.Context, operationID, owner, message string, nextAttemptAt time.Time) error
+	// DeadLetterOperation переводит задачу и все её незавершенные операции
+	// в статус dead, когда попытки вычисления исчерпаны
+	DeadLetterOperation(ctx context.Context, operationID, owner, message string) error
+
+	// GetCachedResult возвращает результат из общего кеша результатов
+	// (см. ResultCache.Shared), если запись есть и не истекла
+	GetCachedResult(ctx context.Context, key string) (float64, bool, error)
+	// PutCachedResult сохраняет результат в общий кеш до expiresAt,
+	// заменяя прежнюю запись с тем же ключом
+	PutCachedResult(ctx context.Context, key string, result float64, expiresAt time.Time) error
+	// DeleteExpiredResults удаляет записи общего кеша, истекшие до before
+	DeleteExpiredResults(ctx context.Context, before time.Time) error
+
+	// InitDurations записывает время операторов, которых ещё нет в хранилище
+	InitDurations(ctx context.Context, defaults map[string]int) error
+	GetDurations(ctx context.Context) (map[string]int, error)
+	SetDurations(ctx context.Context, durations map[string]int) error
+}
+
+// UserStore хранит учетные записи пользователей и их сессии
+type UserStore interface {
+	// CreateUser возвращает ErrUserExists, если логин занят
+	CreateUser(ctx context.Context, login, passwordHash string) error
+	// GetUserByLogin возвращает ErrUserNotFound, если пользователя нет
+	GetUserByLogin(ctx context.Context, login string) (*User, error)
+	// ListUsers возвращает пользователей в порядке логинов
+	ListUsers(ctx context.Context) ([]User, error)
+	// SetUserRole возвращает ErrUserNotFound, если пользователя нет
+	SetUserRole(ctx context.Context, login, role string) error
+	// SetUserDisabled отключает или включает пользователя; при отключении
+	// отзываются все его сессии. Возвращает ErrUserNotFound, если
+	// пользователя нет.
+	SetUserDisabled(ctx context.Context, login string, disabled bool) error
+	// RecordLoginFailure атомарно засчитывает неудачную попытку входа; на
+	// maxAttempts-й попытке вход блокируется на lockout, а счетчик
+	// обнуляется. Возвращает ErrUserNotFound, если пользователя нет.
+	RecordLoginFailure(ctx context.Context, login string, maxAttempts int, lockout time.Duration) error
+	// ResetLoginFailures обнуляет счетчик неудачных попыток и снимает блокировку
+	ResetLoginFailures(ctx context.Context, login string) error
+
+	// CreateRefreshToken сохраняет первый refresh-токен новой сессии;
+	// для неизвестного логина возвращается ErrUserNotFound
+	CreateRefreshToken(ctx context.Context, token RefreshToken) error
+	// RotateRefreshToken атомарно отзывает действующий токен с хешем hash
+	// и сохраняет вместо него токен nextHash той же сессии, действующий до
+	// expiresAt. Возвращает новый токен. Для неизвестного или истекшего
+	// токена возвращается ErrTokenNotFound. Если токен уже отозван, его
+	// украли или предъявили повторно: все токены сессии отзываются и
+	// возвращается ErrTokenReused.
+	RotateRefreshToken(ctx context.Context, hash, nextHash string, expiresAt time.Time) (*RefreshToken, error)
+	// RevokeSession отзывает все refresh-токены сессии
+	RevokeSession(ctx context.Context, sessionID string) error
+	// DenyAccessToken запрещает access-токен с идентификатором jti до
+	// окончания срока его действия expiresAt
+	DenyAccessToken(ctx context.Context, jti string, expiresAt time.Time) error
+	// DenySession запрещает все access-токены сессии до expiresAt; повторный
+	// запрет может продлить срок, но не сократить его
+	DenySession(ctx context.Context, sessionID string, expiresAt time.Time) error
+	// DenyUserSessions запрещает до expiresAt access-токены всех сессий
+	// пользователя, у которых есть refresh-токены
+	DenyUserSessions(ctx context.Context, login string, expiresAt time.Time) error
+	// IsAccessTokenDenied сообщает, запрещен ли access-токен jti сам или
+	// вместе со своей сессией sessionID
+	IsAccessTokenDenied(ctx context.Context, jti, sessionID string) (bool, error)
+	// DeleteExpiredTokens удаляет refresh-токены и записи запрещенных
+	// access-токенов и сессий, срок действия которых истек до before
+	DeleteExpiredTokens(ctx context.Context, before time.Time) error
+}
+
+// AgentStore — реестр агентов. Агент регистрируется при запуске и
+// периодически присылает сигнал жизни; агентов без сигнала оркестратор
+// переводит в offline (см. Orchestrator.WatchAgents).
+type AgentStore interface {
+	// RegisterAgent добавляет агента или обновляет описание уже известного
+	// и переводит его в online. Время регистрации и счетчик вычисленных
+	// операций у известного агента сохраняются.
+	RegisterAgent(ctx context.Context, agent AgentInfo) error
+	// HeartbeatAgent отмечает сигнал жизни агента и переводит его в online.
+	// Для незарегистрированного агента возвращается ErrAgentNotFound.
+	HeartbeatAgent(ctx context.Context, agentID string) error
+	// ListAgents возвращает агентов в порядке ID вместе с операциями,
+	// которые они вычисляют сейчас
+	ListAgents(ctx context.Context) ([]AgentInfo, error)
+	// ExpireAgents переводит в offline агентов, последний сигнал которых
+	// был раньше before, и возвращает в очередь вычисляемые ими операции.
+	// Возвращает ID агентов, переведенных в offline.
+	ExpireAgents(ctx context.Context, before time.Time) ([]string, error)
+}
+
+// Store — полное хранилище оркестратора
+type Store interface {
+	TaskStore
+	UserStore
+	AgentStore
+}
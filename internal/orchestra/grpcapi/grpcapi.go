@@ -0,0 +1,342 @@
+// Package grpcapi — gRPC-сервис оркестратора для агентов (см. agentpb).
+// Он повторяет внутренние HTTP-эндпоинты /internal/... и дополнительно
+// рассылает подписанным агентам уведомления о готовых операциях.
+package grpcapi
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dadil/project/internal/agentpb"
+	"github.com/Dadil/project/internal/notify"
+	"github.com/Dadil/project/internal/orchestra/domain"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type Server struct {
+	agentpb.UnimplementedOrchestratorServer
+
+	tasks  domain.TaskStore
+	agents domain.AgentStore
+	token  []byte
+	ready  *notify.Signal
+	done   chan struct{}
+	once   sync.Once
+
+	// Events — шина событий оркестратора, в которую агенты публикуют смену
+	// статусов задач; nil — события отбрасываются
+	Events *domain.EventBus
+	// Results запоминает в кеше оркестратора результаты, которые присылают
+	// агенты; nil — результаты не запоминаются
+	Results ResultRecorder
+}
+
+// ResultRecorder запоминает результат вычисленной операции (реализует
+// domain.Orchestrator)
+type ResultRecorder interface {
+	CacheOperationResult(ctx context.Context, operationID string, result float64)
+}
+
+// New создает сервис поверх хранилища задач tasks и реестра агентов agents.
+// Пустой agentToken отклоняет все вызовы, как и внутренние HTTP-эндпоинты.
+func New(tasks domain.TaskStore, agents domain.AgentStore, agentToken string) *Server {
+	s := &Server{token: []byte(agentToken), ready: notify.NewSignal(), done: make(chan struct{})}
+	s.tasks = &notifyingStore{TaskStore: tasks, ready: s.ready}
+	s.agents = &notifyingRegistry{AgentStore: agents, ready: s.ready}
+	return s
+}
+
+// Tasks возвращает хранилище, которое будит подписчиков после изменений,
+// делающих операции готовыми. Оркестратор должен работать с задачами через него.
+func (s *Server) Tasks() domain.TaskStore {
+	return s.tasks
+}
+
+// Agents возвращает реестр агентов, который будит подписчиков, когда
+// операции offline-агентов возвращаются в очередь
+func (s *Server) Agents() domain.AgentStore {
+	return s.agents
+}
+
+// Notify будит подписчиков: могли появиться готовые операции
+func (s *Server) Notify() {
+	s.ready.Broadcast()
+}
+
+// Forward пересылает подписчикам уведомления хранилища (см. storage.Listen),
+// пока канал не закроется
+func (s *Server) Forward(notifications <-chan struct{}) {
+	s.ready.Forward(notifications)
+}
+
+// GRPC создает gRPC-сервер с этим сервисом и проверкой токена агентов
+func (s *Server) GRPC(opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts, grpc.UnaryInterceptor(s.authUnary), grpc.StreamInterceptor(s.authStream))
+	server := grpc.NewServer(opts...)
+	agentpb.RegisterOrchestratorServer(server, s)
+	return server
+}
+
+// Close завершает подписки агентов, иначе GracefulStop ждал бы их бесконечно
+func (s *Server) Close() {
+	s.once.Do(func() { close(s.done) })
+}
+
+func (s *Server) authorize(ctx context.Context) error {
+	if len(s.token) == 0 {
+		return status.Error(codes.PermissionDenied, "agent access is disabled")
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, header := range md.Get("authorization") {
+		token := strings.TrimPrefix(header, "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), s.token) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "invalid agent token")
+}
+
+func (s *Server) authUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *Server) authStream(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := s.authorize(stream.Context()); err != nil {
+		return err
+	}
+	return handler(srv, stream)
+}
+
+func (s *Server) ClaimTask(ctx context.Context, req *agentpb.ClaimTaskRequest) (*agentpb.ClaimTaskResponse, error) {
+	if req.Owner == "" || req.LeaseMs <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "owner and positive lease_ms are required")
+	}
+
+	op, err := s.tasks.ClaimOperation(ctx, req.Owner, time.Duration(req.LeaseMs)*time.Millisecond)
+	if err != nil {
+		return nil, storeError(err)
+	}
+	if op == nil {
+		return &agentpb.ClaimTaskResponse{}, nil
+	}
+	return &agentpb.ClaimTaskResponse{Operation: agentpb.FromOperation(op)}, nil
+}
+
+func (s *Server) ReportResult(ctx context.Context, req *agentpb.ReportResultRequest) (*agentpb.ReportResultResponse, error) {
+	if req.Owner == "" || req.OperationId == "" {
+		return nil, status.Error(codes.InvalidArgument, "owner and operation_id are required")
+	}
+
+	var err error
+	switch outcome := req.Outcome.(type) {
+	case *agentpb.ReportResultRequest_Result:
+		err = s.tasks.CompleteOperation(ctx, req.OperationId, req.Owner, outcome.Result)
+		if err == nil && s.Results != nil {
+			s.Results.CacheOperationResult(ctx, req.OperationId, outcome.Result)
+		}
+	case *agentpb.ReportResultRequest_Error:
+		err = s.tasks.FailOperation(ctx, req.OperationId, req.Owner, outcome.Error)
+	case *agentpb.ReportResultRequest_Retry:
+		if outcome.Retry.NextAttemptAt == nil {
+			return nil, status.Error(codes.InvalidArgument, "next_attempt_at is required")
+		}
+		err = s.tasks.RetryOperation(ctx, req.OperationId, req.Owner, outcome.Retry.Error, outcome.Retry.NextAttemptAt.AsTime())
+	case *agentpb.ReportResultRequest_DeadLetter:
+		err = s.tasks.DeadLetterOperation(ctx, req.OperationId, req.Owner, outcome.DeadLetter)
+	case *agentpb.ReportResultRequest_Release:
+		err = s.tasks.ReleaseOperation(ctx, req.OperationId, req.Owner)
+	default:
+		return nil, status.Error(codes.InvalidArgument, "outcome is required")
+	}
+	if err != nil {
+		return nil, storeError(err)
+	}
+	return &agentpb.ReportResultResponse{}, nil
+}
+
+func (s *Server) Heartbeat(ctx context.Context, req *agentpb.HeartbeatRequest) (*agentpb.HeartbeatResponse, error) {
+	if req.Owner == "" || req.OperationId == "" || req.LeaseMs <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "owner, operation_id and positive lease_ms are required")
+	}
+
+	if err := s.tasks.RenewLease(ctx, req.OperationId, req.Owner, time.Duration(req.LeaseMs)*time.Millisecond); err != nil {
+		return nil, storeError(err)
+	}
+	return &agentpb.HeartbeatResponse{}, nil
+}
+
+func (s *Server) GetDurations(ctx context.Context, req *agentpb.GetDurationsRequest) (*agentpb.GetDurationsResponse, error) {
+	durations, err := s.tasks.GetDurations(ctx)
+	if err != nil {
+		return nil, storeError(err)
+	}
+
+	resp := &agentpb.GetDurationsResponse{Durations: make(map[string]int32, len(durations))}
+	for operator, duration := range durations {
+		resp.Durations[operator] = int32(duration)
+	}
+	return resp, nil
+}
+
+// Subscribe отправляет событие сразу после подписки — пока агент не был
+// подписан, операции могли появиться — и затем при каждом уведомлении
+// о готовых операциях. Уведомления, пришедшие во время отправки,
+// сливаются в одно событие.
+func (s *Server) Subscribe(req *agentpb.SubscribeRequest, stream agentpb.Orchestrator_SubscribeServer) error {
+	ready := s.ready.Wait()
+	if err := stream.Send(&agentpb.OperationsReady{}); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-s.done:
+			return nil
+		case <-ready:
+			ready = s.ready.Wait()
+			if err := stream.Send(&agentpb.OperationsReady{}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *Server) RegisterAgent(ctx context.Context, req *agentpb.RegisterAgentRequest) (*agentpb.RegisterAgentResponse, error) {
+	if req.Id == "" {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+
+	info := domain.AgentInfo{
+		ID:        req.Id,
+		Hostname:  req.Hostname,
+		Workers:   int(req.Workers),
+		Operators: req.Operators,
+		Version:   req.Version,
+	}
+	if err := s.agents.RegisterAgent(ctx, info); err != nil {
+		return nil, storeError(err)
+	}
+	return &agentpb.RegisterAgentResponse{}, nil
+}
+
+func (s *Server) AgentHeartbeat(ctx context.Context, req *agentpb.AgentHeartbeatRequest) (*agentpb.AgentHeartbeatResponse, error) {
+	if err := s.agents.HeartbeatAgent(ctx, req.AgentId); err != nil {
+		return nil, storeError(err)
+	}
+	return &agentpb.AgentHeartbeatResponse{}, nil
+}
+
+func (s *Server) PublishTaskEvent(ctx context.Context, req *agentpb.PublishTaskEventRequest) (*agentpb.PublishTaskEventResponse, error) {
+	if req.TaskId == "" || req.Status == "" {
+		return nil, status.Error(codes.InvalidArgument, "task_id and status are required")
+	}
+
+	event := domain.TaskEvent{TaskID: req.TaskId, Status: req.Status, At: req.At.AsTime()}
+	s.Events.PublishTaskEvent(ctx, event)
+	return &agentpb.PublishTaskEventResponse{}, nil
+}
+
+// storeError превращает ошибку хранилища в статус gRPC: потерянная
+// аренда — Aborted, неизвестный агент — NotFound, остальное — Internal
+func storeError(err error) error {
+	if errors.Is(err, domain.ErrLeaseLost) {
+		return status.Error(codes.Aborted, err.Error())
+	}
+	if errors.Is(err, domain.ErrAgentNotFound) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	log.Println("Error updating operation:", err)
+	return status.Error(codes.Internal, "internal error")
+}
+
+// notifyingStore будит подписчиков после изменений, от которых операции
+// становятся готовыми: новая задача, ручной повтор, вычисленный операнд,
+// возвращенная в очередь операция
+type notifyingStore struct {
+	domain.TaskStore
+	ready *notify.Signal
+}
+
+func (n *notifyingStore) CreateTask(ctx context.Context, login string, task domain.Task, operations []domain.Operation) error {
+	if err := n.TaskStore.CreateTask(ctx, login, task, operations); err != nil {
+		return err
+	}
+	if len(operations) > 0 {
+		n.ready.Broadcast()
+	}
+	return nil
+}
+
+func (n *notifyingStore) CreateTasks(ctx context.Context, login string, tasks []domain.NewTask) error {
+	if err := n.TaskStore.CreateTasks(ctx, login, tasks); err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		if len(task.Operations) > 0 {
+			n.ready.Broadcast()
+			break
+		}
+	}
+	return nil
+}
+
+func (n *notifyingStore) CreateTaskWithKey(ctx context.Context, login string, key domain.IdempotencyKey, task domain.Task, operations []domain.Operation) error {
+	if err := n.TaskStore.CreateTaskWithKey(ctx, login, key, task, operations); err != nil {
+		return err
+	}
+	if len(operations) > 0 {
+		n.ready.Broadcast()
+	}
+	return nil
+}
+
+func (n *notifyingStore) RetryTaskForUser(ctx context.Context, login, taskID string) (*domain.Task, error) {
+	task, err := n.TaskStore.RetryTaskForUser(ctx, login, taskID)
+	if err == nil {
+		n.ready.Broadcast()
+	}
+	return task, err
+}
+
+func (n *notifyingStore) CompleteOperation(ctx context.Context, operationID, owner string, result float64) error {
+	if err := n.TaskStore.CompleteOperation(ctx, operationID, owner, result); err != nil {
+		return err
+	}
+	n.ready.Broadcast()
+	return nil
+}
+
+func (n *notifyingStore) ReleaseOperation(ctx context.Context, operationID, owner string) error {
+	if err := n.TaskStore.ReleaseOperation(ctx, operationID, owner); err != nil {
+		return err
+	}
+	n.ready.Broadcast()
+	return nil
+}
+
+// notifyingRegistry будит подписчиков, когда операции offline-агентов
+// возвращаются в очередь
+type notifyingRegistry struct {
+	domain.AgentStore
+	ready *notify.Signal
+}
+
+func (n *notifyingRegistry) ExpireAgents(ctx context.Context, before time.Time) ([]string, error) {
+	expired, err := n.AgentStore.ExpireAgents(ctx, before)
+	if len(expired) > 0 {
+		n.ready.Broadcast()
+	}
+	return expired, err
+}
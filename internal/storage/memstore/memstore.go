@@ -0,0 +1,949 @@
+// Package memstore — хранилище оркестратора в памяти процесса. Подходит
+// для локального запуска и тестов: данные теряются при перезапуске, а
+// агенты должны работать в том же процессе, что и оркестратор.
+package memstore
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dadil/project/internal/orchestra/domain"
+)
+
+type Store struct {
+	mu sync.Mutex
+
+	users      map[string]domain.User
+	tasks      map[string]*domain.Task
+	taskOwners map[string]string // задача -> логин пользователя
+	taskOrder  []string
+	operations map[string]*domain.Operation
+	opOrder    []string
+	settings   map[string]int
+	agents     map[string]*domain.AgentInfo
+	// Сессии: refresh-токены по хешу, запрещенные access-токены по jti и
+	// запрещенные сессии по ID
+	refreshTokens  map[string]*domain.RefreshToken
+	deniedTokens   map[string]time.Time
+	deniedSessions map[string]time.Time
+	// Ключи идемпотентности по логину и значению ключа
+	idempotencyKeys map[idempotencyKeyID]domain.IdempotencyKey
+	// Общий кеш результатов по ключу кеша
+	cachedResults map[string]cachedResult
+}
+
+type cachedResult struct {
+	result    float64
+	expiresAt time.Time
+}
+
+type idempotencyKeyID struct {
+	login, key string
+}
+
+var _ domain.Store = (*Store)(nil)
+
+func New() *Store {
+	return &Store{
+		users:      make(map[string]domain.User),
+		tasks:      make(map[string]*domain.Task),
+		taskOwners: make(map[string]string),
+		operations: make(map[string]*domain.Operation),
+		settings:   make(map[string]int),
+		agents:     make(map[string]*domain.AgentInfo),
+
+		refreshTokens:  make(map[string]*domain.RefreshToken),
+		deniedTokens:   make(map[string]time.Time),
+		deniedSessions: make(map[string]time.Time),
+
+		idempotencyKeys: make(map[idempotencyKeyID]domain.IdempotencyKey),
+		cachedResults:   make(map[string]cachedResult),
+	}
+}
+
+// Close нужен для единообразия с sqlstore и ничего не делает
+func (s *Store) Close() error {
+	return nil
+}
+
+func (s *Store) CreateUser(ctx context.Context, login, passwordHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.users[login]; exists {
+		return domain.ErrUserExists
+	}
+	s.users[login] = domain.User{Login: login, Password: passwordHash, Role: domain.RoleUser}
+	return nil
+}
+
+func (s *Store) GetUserByLogin(ctx context.Context, login string) (*domain.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[login]
+	if !ok {
+		return nil, domain.ErrUserNotFound
+	}
+	return &user, nil
+}
+
+func (s *Store) ListUsers(ctx context.Context) ([]domain.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := make([]domain.User, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Login < users[j].Login })
+	return users, nil
+}
+
+func (s *Store) SetUserRole(ctx context.Context, login, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[login]
+	if !ok {
+		return domain.ErrUserNotFound
+	}
+	user.Role = role
+	s.users[login] = user
+	return nil
+}
+
+func (s *Store) SetUserDisabled(ctx context.Context, login string, disabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[login]
+	if !ok {
+		return domain.ErrUserNotFound
+	}
+	user.Disabled = disabled
+	s.users[login] = user
+
+	if disabled {
+		now := domain.Now()
+		for _, token := range s.refreshTokens {
+			if token.Login == login && token.RevokedAt == nil {
+				token.RevokedAt = &now
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Store) RecordLoginFailure(ctx context.Context, login string, maxAttempts int, lockout time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[login]
+	if !ok {
+		return domain.ErrUserNotFound
+	}
+	user.FailedLogins++
+	if user.FailedLogins >= maxAttempts {
+		lockedUntil := domain.Now().Add(lockout)
+		user.LockedUntil = &lockedUntil
+		user.FailedLogins = 0
+	}
+	s.users[login] = user
+	return nil
+}
+
+func (s *Store) ResetLoginFailures(ctx context.Context, login string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[login]
+	if !ok {
+		return domain.ErrUserNotFound
+	}
+	user.FailedLogins = 0
+	user.LockedUntil = nil
+	s.users[login] = user
+	return nil
+}
+
+func (s *Store) CreateRefreshToken(ctx context.Context, token domain.RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[token.Login]; !ok {
+		return domain.ErrUserNotFound
+	}
+	token.RevokedAt = nil
+	s.refreshTokens[token.Hash] = &token
+	return nil
+}
+
+func (s *Store) RotateRefreshToken(ctx context.Context, hash, nextHash string, expiresAt time.Time) (*domain.RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := domain.Now()
+	token, ok := s.refreshTokens[hash]
+	if !ok {
+		return nil, domain.ErrTokenNotFound
+	}
+	if token.RevokedAt != nil {
+		// Отозванный токен предъявлен повторно: сессия скомпрометирована
+		s.revokeSession(token.SessionID, now)
+		return nil, domain.ErrTokenReused
+	}
+	if !token.ExpiresAt.After(now) {
+		return nil, domain.ErrTokenNotFound
+	}
+
+	token.RevokedAt = &now
+	next := domain.RefreshToken{Hash: nextHash, Login: token.Login, SessionID: token.SessionID, CreatedAt: now, ExpiresAt: expiresAt}
+	s.refreshTokens[nextHash] = &next
+	result := next
+	return &result, nil
+}
+
+func (s *Store) RevokeSession(ctx context.Context, sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.revokeSession(sessionID, domain.Now())
+	return nil
+}
+
+func (s *Store) revokeSession(sessionID string, now time.Time) {
+	for _, token := range s.refreshTokens {
+		if token.SessionID == sessionID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+		}
+	}
+}
+
+func (s *Store) DenyAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deniedTokens[jti] = expiresAt
+	return nil
+}
+
+func (s *Store) DenySession(ctx context.Context, sessionID string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.denySession(sessionID, expiresAt)
+	return nil
+}
+
+func (s *Store) DenyUserSessions(ctx context.Context, login string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, token := range s.refreshTokens {
+		if token.Login == login {
+			s.denySession(token.SessionID, expiresAt)
+		}
+	}
+	return nil
+}
+
+func (s *Store) denySession(sessionID string, expiresAt time.Time) {
+	if current, ok := s.deniedSessions[sessionID]; !ok || current.Before(expiresAt) {
+		s.deniedSessions[sessionID] = expiresAt
+	}
+}
+
+func (s *Store) IsAccessTokenDenied(ctx context.Context, jti, sessionID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, tokenDenied := s.deniedTokens[jti]
+	_, sessionDenied := s.deniedSessions[sessionID]
+	return tokenDenied || sessionDenied, nil
+}
+
+func (s *Store) DeleteExpiredTokens(ctx context.Context, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for hash, token := range s.refreshTokens {
+		if token.ExpiresAt.Before(before) {
+			delete(s.refreshTokens, hash)
+		}
+	}
+	for jti, expiresAt := range s.deniedTokens {
+		if expiresAt.Before(before) {
+			delete(s.deniedTokens, jti)
+		}
+	}
+	for sessionID, expiresAt := range s.deniedSessions {
+		if expiresAt.Before(before) {
+			delete(s.deniedSessions, sessionID)
+		}
+	}
+	return nil
+}
+
+func (s *Store) CreateTask(ctx context.Context, login string, task domain.Task, operations []domain.Operation) error {
+	return s.CreateTasks(ctx, login, []domain.NewTask{{Task: task, Operations: operations}})
+}
+
+func (s *Store) CreateTasks(ctx context.Context, login string, tasks []domain.NewTask) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if login != "" {
+		if _, ok := s.users[login]; !ok {
+			return domain.ErrUserNotFound
+		}
+	}
+	s.insertTasks(login, tasks)
+	return nil
+}
+
+func (s *Store) CreateTaskWithKey(ctx context.Context, login string, key domain.IdempotencyKey, task domain.Task, operations []domain.Operation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[login]; !ok {
+		return domain.ErrUserNotFound
+	}
+	id := idempotencyKeyID{login, key.Key}
+	if stored, ok := s.idempotencyKeys[id]; ok && stored.ExpiresAt.After(domain.Now()) {
+		return domain.ErrIdempotencyKeyExists
+	}
+	key.Login = login
+	s.idempotencyKeys[id] = key
+	s.insertTasks(login, []domain.NewTask{{Task: task, Operations: operations}})
+	return nil
+}
+
+func (s *Store) GetIdempotencyKey(ctx context.Context, login, key string) (*domain.IdempotencyKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.idempotencyKeys[idempotencyKeyID{login, key}]
+	if !ok || !stored.ExpiresAt.After(domain.Now()) {
+		return nil, domain.ErrIdempotencyKeyNotFound
+	}
+	return &stored, nil
+}
+
+func (s *Store) DeleteExpiredIdempotencyKeys(ctx context.Context, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, key := range s.idempotencyKeys {
+		if key.ExpiresAt.Before(before) {
+			delete(s.idempotencyKeys, id)
+		}
+	}
+	return nil
+}
+
+// insertTasks сохраняет задачи пользователя; вызывается под s.mu
+func (s *Store) insertTasks(login string, tasks []domain.NewTask) {
+	for _, newTask := range tasks {
+		task := newTask.Task
+		if login != "" {
+			s.taskOwners[task.ID] = login
+		}
+		s.tasks[task.ID] = &task
+		s.taskOrder = append(s.taskOrder, task.ID)
+		for _, op := range newTask.Operations {
+			op := op
+			s.operations[op.ID] = &op
+			s.opOrder = append(s.opOrder, op.ID)
+		}
+	}
+}
+
+func (s *Store) ListTasks(ctx context.Context) ([]domain.Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var tasks []domain.Task
+	for _, id := range s.taskOrder {
+		tasks = append(tasks, *s.tasks[id])
+	}
+	return tasks, nil
+}
+
+func (s *Store) ListTasksForUser(ctx context.Context, login string) ([]domain.Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var tasks []domain.Task
+	for _, id := range s.taskOrder {
+		if s.taskOwners[id] == login {
+			tasks = append(tasks, *s.tasks[id])
+		}
+	}
+	return tasks, nil
+}
+
+func (s *Store) QueryTasksForUser(ctx context.Context, login string, query domain.TaskQuery) ([]domain.Task, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var matched []domain.Task
+	for _, id := range s.taskOrder {
+		if s.taskOwners[id] == login && query.Matches(*s.tasks[id]) {
+			matched = append(matched, *s.tasks[id])
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+		return compareListed(domain.TaskSortKey(a, query.Sort), a.ID, domain.TaskSortKey(b, query.Sort), b.ID, query.Desc) < 0
+	})
+
+	tasks := matched
+	if query.After != nil {
+		tasks = nil
+		for _, task := range matched {
+			if compareListed(domain.TaskSortKey(task, query.Sort), task.ID, query.After.Value, query.After.ID, query.Desc) > 0 {
+				tasks = append(tasks, task)
+			}
+		}
+	}
+	if len(tasks) > query.Limit {
+		tasks = tasks[:query.Limit]
+	}
+	return tasks, len(matched), nil
+}
+
+// compareListed сравнивает позиции двух задач в списке по ключу сортировки
+// и ID. Задачи без ключа (nil) идут в конце при любом направлении.
+func compareListed(keyA interface{}, idA string, keyB interface{}, idB string, desc bool) int {
+	if (keyA == nil) != (keyB == nil) {
+		if keyA == nil {
+			return 1
+		}
+		return -1
+	}
+	result := 0
+	if keyA != nil {
+		result = compareKeys(keyA, keyB)
+	}
+	if result == 0 {
+		result = strings.Compare(idA, idB)
+	}
+	if desc {
+		return -result
+	}
+	return result
+}
+
+func compareKeys(a, b interface{}) int {
+	switch a := a.(type) {
+	case time.Time:
+		b := b.(time.Time)
+		if a.Before(b) {
+			return -1
+		}
+		if a.After(b) {
+			return 1
+		}
+	case float64:
+		b := b.(float64)
+		if a < b {
+			return -1
+		}
+		if a > b {
+			return 1
+		}
+	}
+	return 0
+}
+
+func (s *Store) GetTaskOwner(ctx context.Context, taskID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	login, ok := s.taskOwners[taskID]
+	if !ok {
+		return "", domain.ErrTaskNotFound
+	}
+	return login, nil
+}
+
+func (s *Store) GetTaskForUser(ctx context.Context, login, taskID string) (*domain.Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task, ok := s.tasks[taskID]
+	if !ok || s.taskOwners[taskID] != login {
+		return nil, domain.ErrTaskNotFound
+	}
+	result := *task
+	return &result, nil
+}
+
+func (s *Store) DeleteTasksForUser(ctx context.Context, login string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := make(map[string]bool)
+	for taskID, owner := range s.taskOwners {
+		if owner == login {
+			deleted[taskID] = true
+			delete(s.tasks, taskID)
+			delete(s.taskOwners, taskID)
+		}
+	}
+	s.taskOrder = filter(s.taskOrder, func(id string) bool { return !deleted[id] })
+	for id, key := range s.idempotencyKeys {
+		if deleted[key.TaskID] {
+			delete(s.idempotencyKeys, id)
+		}
+	}
+	s.opOrder = filter(s.opOrder, func(id string) bool {
+		if deleted[s.operations[id].TaskID] {
+			delete(s.operations, id)
+			return false
+		}
+		return true
+	})
+	return nil
+}
+
+func (s *Store) CancelTaskForUser(ctx context.Context, login, taskID string) (*domain.Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task, ok := s.tasks[taskID]
+	if !ok || s.taskOwners[taskID] != login {
+		return nil, domain.ErrTaskNotFound
+	}
+	if task.Status != "pending" && task.Status != "processing" {
+		return nil, domain.ErrTaskFinished
+	}
+
+	for _, op := range s.operations {
+		if op.TaskID == taskID && op.Status != domain.OperationCompleted {
+			op.Status = domain.OperationCancelled
+			op.LeaseExpiresAt = nil
+		}
+	}
+
+	now := domain.Now()
+	task.Status = "cancelled"
+	task.FinishedAt = &now
+	result := *task
+	return &result, nil
+}
+
+func (s *Store) RetryTaskForUser(ctx context.Context, login, taskID string) (*domain.Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task, ok := s.tasks[taskID]
+	if !ok || s.taskOwners[taskID] != login {
+		return nil, domain.ErrTaskNotFound
+	}
+	if task.Status != "dead" {
+		return nil, domain.ErrTaskNotDead
+	}
+
+	for _, op := range s.operations {
+		if op.TaskID != taskID || op.Status != domain.OperationDead {
+			continue
+		}
+		// Операция готова к вычислению, если оба операнда уже известны
+		op.Status = domain.OperationWaiting
+		if op.LeftValue != nil && op.RightValue != nil {
+			op.Status = domain.OperationPending
+		}
+		op.Attempts = 0
+		op.NextAttemptAt = nil
+		op.LastError = ""
+		op.OwnerAgent = ""
+		op.LeaseExpiresAt = nil
+	}
+
+	task.Status = "pending"
+	task.ErrorMessage = ""
+	task.FinishedAt = nil
+	result := *task
+	return &result, nil
+}
+
+func filter(ids []string, keep func(string) bool) []string {
+	result := ids[:0]
+	for _, id := range ids {
+		if keep(id) {
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+// ClaimOperation выбирает операцию так же, как sqlstore: сначала готовые
+// операции в порядке добавления, затем операции с самой давно истекшей арендой
+func (s *Store) ClaimOperation(ctx context.Context, owner string, lease time.Duration) (*domain.Operation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := domain.Now()
+	var claimed *domain.Operation
+	for _, id := range s.opOrder {
+		op := s.operations[id]
+		if op.Status == domain.OperationPending && (op.NextAttemptAt == nil || !op.NextAttemptAt.After(now)) {
+			claimed = op
+			break
+		}
+		expired := op.Status == domain.OperationProcessing && op.LeaseExpiresAt.Before(now)
+		if expired && (claimed == nil || op.LeaseExpiresAt.Before(*claimed.LeaseExpiresAt)) {
+			claimed = op
+		}
+	}
+	if claimed == nil {
+		return nil, nil
+	}
+
+	expiresAt := now.Add(lease)
+	claimed.Status = domain.OperationProcessing
+	claimed.OwnerAgent = owner
+	claimed.LeaseExpiresAt = &expiresAt
+	claimed.Attempts++
+
+	// Время начала фиксируется при захвате первой операции задачи
+	task := s.tasks[claimed.TaskID]
+	if task.Status == "pending" || task.Status == "processing" {
+		task.Status = "processing"
+		if task.StartedAt == nil {
+			task.StartedAt = &now
+		}
+		task.AgentID = owner
+	}
+
+	result := *claimed
+	return &result, nil
+}
+
+func (s *Store) GetOperation(ctx context.Context, operationID string) (*domain.Operation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	op, ok := s.operations[operationID]
+	if !ok {
+		return nil, domain.ErrOperationNotFound
+	}
+	result := *op
+	return &result, nil
+}
+
+// owned возвращает операцию, если её вычисляет owner
+func (s *Store) owned(operationID, owner string) (*domain.Operation, error) {
+	op, ok := s.operations[operationID]
+	if !ok || op.OwnerAgent != owner || op.Status != domain.OperationProcessing {
+		return nil, domain.ErrLeaseLost
+	}
+	return op, nil
+}
+
+func (s *Store) RenewLease(ctx context.Context, operationID, owner string, lease time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	op, err := s.owned(operationID, owner)
+	if err != nil {
+		return err
+	}
+	expiresAt := domain.Now().Add(lease)
+	op.LeaseExpiresAt = &expiresAt
+	return nil
+}
+
+func (s *Store) CompleteOperation(ctx context.Context, operationID, owner string, result float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	op, err := s.owned(operationID, owner)
+	if err != nil {
+		return err
+	}
+	op.Status = domain.OperationCompleted
+	op.Result = result
+	op.LeaseExpiresAt = nil
+	if agent, ok := s.agents[owner]; ok {
+		agent.CompletedOperations++
+	}
+
+	if op.ParentID != "" {
+		// Подставляем результат в родительскую операцию
+		parent := s.operations[op.ParentID]
+		value := result
+		if parent.LeftID == op.ID {
+			parent.LeftValue = &value
+		}
+		if parent.RightID == op.ID {
+			parent.RightValue = &value
+		}
+		// Родитель готов к вычислению, когда известны оба операнда
+		if parent.Status == domain.OperationWaiting && parent.LeftValue != nil && parent.RightValue != nil {
+			parent.Status = domain.OperationPending
+		}
+		return nil
+	}
+
+	// Корневая операция: результат всего выражения
+	now := domain.Now()
+	task := s.tasks[op.TaskID]
+	task.Result = result
+	task.Status = "completed"
+	task.FinishedAt = &now
+	task.AgentID = owner
+	return nil
+}
+
+func (s *Store) FailOperation(ctx context.Context, operationID, owner, message string) error {
+	return s.failTask(operationID, owner, domain.OperationError, message)
+}
+
+func (s *Store) DeadLetterOperation(ctx context.Context, operationID, owner, message string) error {
+	return s.failTask(operationID, owner, domain.OperationDead, message)
+}
+
+// failTask завершает операцию со статусом status (error или dead) и
+// переводит в него же задачу и все её невычисленные операции
+func (s *Store) failTask(operationID, owner, status, message string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	op, err := s.owned(operationID, owner)
+	if err != nil {
+		return err
+	}
+	op.Status = status
+	op.LeaseExpiresAt = nil
+
+	// Ошибка в любой операции делает бессмысленным вычисление остальных
+	for _, other := range s.operations {
+		if other.TaskID == op.TaskID && (other.Status == domain.OperationWaiting || other.Status == domain.OperationPending) {
+			other.Status = status
+		}
+	}
+
+	now := domain.Now()
+	task := s.tasks[op.TaskID]
+	task.Status = status
+	task.ErrorMessage = message
+	task.FinishedAt = &now
+	task.AgentID = owner
+	return nil
+}
+
+func (s *Store) ReleaseOperation(ctx context.Context, operationID, owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	op, err := s.requeue(operationID, owner)
+	if err != nil {
+		return err
+	}
+	// Попытка, прерванная остановкой агента, не засчитывается
+	op.Attempts--
+	return nil
+}
+
+func (s *Store) RetryOperation(ctx context.Context, operationID, owner, message string, nextAttemptAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	op, err := s.requeue(operationID, owner)
+	if err != nil {
+		return err
+	}
+	op.NextAttemptAt = &nextAttemptAt
+	op.LastError = message
+	return nil
+}
+
+// requeue возвращает операцию владельца owner в очередь. Задача возвращается
+// в pending, если других вычисляемых операций у неё нет.
+func (s *Store) requeue(operationID, owner string) (*domain.Operation, error) {
+	op, err := s.owned(operationID, owner)
+	if err != nil {
+		return nil, err
+	}
+	s.requeueOperation(op)
+	return op, nil
+}
+
+// requeueOperation возвращает вычисляемую операцию в очередь
+func (s *Store) requeueOperation(op *domain.Operation) {
+	op.Status = domain.OperationPending
+	op.OwnerAgent = ""
+	op.LeaseExpiresAt = nil
+
+	for _, other := range s.operations {
+		if other.TaskID == op.TaskID && other.Status == domain.OperationProcessing {
+			return
+		}
+	}
+	if task := s.tasks[op.TaskID]; task.Status == "processing" {
+		task.Status = "pending"
+	}
+}
+
+func (s *Store) GetCachedResult(ctx context.Context, key string) (float64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cached, ok := s.cachedResults[key]
+	if !ok || !cached.expiresAt.After(domain.Now()) {
+		return 0, false, nil
+	}
+	return cached.result, true, nil
+}
+
+func (s *Store) PutCachedResult(ctx context.Context, key string, result float64, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cachedResults[key] = cachedResult{result: result, expiresAt: expiresAt}
+	return nil
+}
+
+func (s *Store) DeleteExpiredResults(ctx context.Context, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, cached := range s.cachedResults {
+		if cached.expiresAt.Before(before) {
+			delete(s.cachedResults, key)
+		}
+	}
+	return nil
+}
+
+func (s *Store) InitDurations(ctx context.Context, defaults map[string]int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for operator, duration := range defaults {
+		key := domain.DurationKeyPrefix + operator
+		if _, exists := s.settings[key]; !exists {
+			s.settings[key] = duration
+		}
+	}
+	return nil
+}
+
+func (s *Store) GetDurations(ctx context.Context) (map[string]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	durations := make(map[string]int)
+	for key, value := range s.settings {
+		if strings.HasPrefix(key, domain.DurationKeyPrefix) {
+			durations[strings.TrimPrefix(key, domain.DurationKeyPrefix)] = value
+		}
+	}
+	return durations, nil
+}
+
+func (s *Store) SetDurations(ctx context.Context, durations map[string]int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for operator, duration := range durations {
+		s.settings[domain.DurationKeyPrefix+operator] = duration
+	}
+	return nil
+}
+
+func (s *Store) RegisterAgent(ctx context.Context, agent domain.AgentInfo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := domain.Now()
+	if known, ok := s.agents[agent.ID]; ok {
+		agent.RegisteredAt = known.RegisteredAt
+		agent.CompletedOperations = known.CompletedOperations
+	} else {
+		agent.RegisteredAt = now
+		agent.CompletedOperations = 0
+	}
+	agent.Operators = append([]string(nil), agent.Operators...)
+	agent.Status = domain.AgentOnline
+	agent.LastHeartbeat = now
+	agent.CurrentTasks = nil
+	agent.Throughput = 0
+	s.agents[agent.ID] = &agent
+	return nil
+}
+
+func (s *Store) HeartbeatAgent(ctx context.Context, agentID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	agent, ok := s.agents[agentID]
+	if !ok {
+		return domain.ErrAgentNotFound
+	}
+	agent.Status = domain.AgentOnline
+	agent.LastHeartbeat = domain.Now()
+	return nil
+}
+
+func (s *Store) ListAgents(ctx context.Context) ([]domain.AgentInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var agents []domain.AgentInfo
+	for _, agent := range s.agents {
+		info := *agent
+		info.Operators = append([]string(nil), agent.Operators...)
+		info.CurrentTasks = s.currentTasks(agent.ID)
+		agents = append(agents, info)
+	}
+	sort.Slice(agents, func(i, j int) bool { return agents[i].ID < agents[j].ID })
+	return agents, nil
+}
+
+// currentTasks возвращает задачи, операции которых вычисляет агент, в порядке ID
+func (s *Store) currentTasks(agentID string) []string {
+	seen := make(map[string]bool)
+	var tasks []string
+	for _, op := range s.operations {
+		if op.OwnerAgent == agentID && op.Status == domain.OperationProcessing && !seen[op.TaskID] {
+			seen[op.TaskID] = true
+			tasks = append(tasks, op.TaskID)
+		}
+	}
+	sort.Strings(tasks)
+	return tasks
+}
+
+func (s *Store) ExpireAgents(ctx context.Context, before time.Time) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var expired []string
+	for id, agent := range s.agents {
+		if agent.Status == domain.AgentOnline && agent.LastHeartbeat.Before(before) {
+			agent.Status = domain.AgentOffline
+			expired = append(expired, id)
+		}
+	}
+	sort.Strings(expired)
+
+	// Операции offline-агентов возвращаются в очередь; попытка засчитывается,
+	// как если бы истекла аренда
+	for _, id := range s.opOrder {
+		op := s.operations[id]
+		if op.Status != domain.OperationProcessing {
+			continue
+		}
+		if agent, ok := s.agents[op.OwnerAgent]; ok && agent.Status == domain.AgentOffline {
+			s.requeueOperation(op)
+		}
+	}
+	return expired, nil
+}
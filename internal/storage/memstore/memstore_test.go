@@ -0,0 +1,15 @@
+package memstore_test
+
+import (
+	"testing"
+
+	"github.com/Dadil/project/internal/orchestra/domain"
+	"github.com/Dadil/project/internal/storage/memstore"
+	"github.com/Dadil/project/internal/storage/storetest"
+)
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) domain.Store {
+		return memstore.New()
+	})
+}
@@ -0,0 +1,294 @@
+// Package migrate применяет версионированные миграции схемы хранилища.
+// Миграции лежат в каталогах postgres/ и sqlite/ в виде пар файлов
+// NNNN_name.up.sql и NNNN_name.down.sql и встраиваются в бинарник.
+// Примененные версии записываются в таблицу schema_migrations.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Dadil/project/internal/orchestra/domain"
+	"github.com/Dadil/project/internal/storage/sqlstore"
+	"github.com/jmoiron/sqlx"
+)
+
+//go:embed postgres/*.sql sqlite/*.sql
+var files embed.FS
+
+// lockID — ключ advisory lock в PostgreSQL, под которым оркестратор
+// и агенты применяют миграции по очереди
+const lockID = 7264031
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status — состояние одной миграции в базе
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+func (s Status) Applied() bool {
+	return s.AppliedAt != nil
+}
+
+type Migrator struct {
+	db         *sqlx.DB
+	migrations []Migration
+}
+
+// New создает мигратор со встроенными миграциями для диалекта db
+func New(db *sqlx.DB) (*Migrator, error) {
+	dir := "postgres"
+	if db.DriverName() == sqlstore.DriverSQLite {
+		dir = "sqlite"
+	}
+	sub, err := fs.Sub(files, dir)
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := Load(sub)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Load читает миграции из fsys и сортирует их по версии. У каждой версии
+// должен быть up-файл; down-файл необязателен, но без него миграцию нельзя откатить.
+func Load(fsys fs.FS) ([]Migration, error) {
+	names, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, name := range names {
+		base := strings.TrimSuffix(name, ".sql")
+		direction := path.Ext(base)
+		base = strings.TrimSuffix(base, direction)
+		if direction != ".up" && direction != ".down" {
+			return nil, fmt.Errorf("migration %s: expected .up.sql or .down.sql suffix", name)
+		}
+
+		prefix, title, ok := strings.Cut(base, "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: expected NNNN_name prefix", name)
+		}
+
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+
+		m, exists := byVersion[version]
+		if !exists {
+			m = &Migration{Version: version, Name: title}
+			byVersion[version] = m
+		}
+		if m.Name != title {
+			return nil, fmt.Errorf("migration %d has different names: %s and %s", version, m.Name, title)
+		}
+		if direction == ".up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %04d_%s has no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// Migrations возвращает известные мигратору миграции по возрастанию версии
+func (m *Migrator) Migrations() []Migration {
+	return m.migrations
+}
+
+// Up применяет все непримененные миграции по возрастанию версии
+// и возвращает примененные
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var applied []Migration
+	err := m.withLock(ctx, func(conn *sqlx.Conn) error {
+		for _, migration := range m.migrations {
+			done, err := m.apply(ctx, conn, migration, true)
+			if err != nil {
+				return err
+			}
+			if done {
+				log.Printf("Applied migration %04d_%s", migration.Version, migration.Name)
+				applied = append(applied, migration)
+			}
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down откатывает steps последних примененных миграций
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var rolledBack []Migration
+	err := m.withLock(ctx, func(conn *sqlx.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && len(rolledBack) < steps; i-- {
+			migration := m.migrations[i]
+			if _, ok := versions[migration.Version]; !ok {
+				continue
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("migration %04d_%s cannot be rolled back: no down file", migration.Version, migration.Name)
+			}
+			done, err := m.apply(ctx, conn, migration, false)
+			if err != nil {
+				return err
+			}
+			if done {
+				log.Printf("Rolled back migration %04d_%s", migration.Version, migration.Name)
+				rolledBack = append(rolledBack, migration)
+			}
+		}
+		return nil
+	})
+	return rolledBack, err
+}
+
+// Status возвращает все известные миграции с временем их применения
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+	err := m.withLock(ctx, func(conn *sqlx.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			status := Status{Migration: migration}
+			if appliedAt, ok := versions[migration.Version]; ok {
+				status.AppliedAt = &appliedAt
+			}
+			statuses = append(statuses, status)
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+// withLock выполняет fn на отдельном соединении, пока другие процессы ждут.
+// В PostgreSQL очередь держит advisory lock; SQLite сериализует транзакции
+// записи сама, поэтому там достаточно проверять версию внутри транзакции.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sqlx.Conn) error) error {
+	conn, err := m.db.Connx(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if m.db.DriverName() != sqlstore.DriverSQLite {
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", lockID); err != nil {
+			return fmt.Errorf("failed to acquire migration lock: %w", err)
+		}
+		defer func() {
+			if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", lockID); err != nil {
+				log.Println("Error releasing migration lock:", err)
+			}
+		}()
+	}
+
+	_, err = conn.ExecContext(ctx, `
+        CREATE TABLE IF NOT EXISTS schema_migrations (
+            version INTEGER PRIMARY KEY,
+            name TEXT NOT NULL,
+            applied_at TIMESTAMP NOT NULL
+        )
+    `)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	return fn(conn)
+}
+
+func appliedVersions(ctx context.Context, conn *sqlx.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		versions[version] = appliedAt
+	}
+	return versions, rows.Err()
+}
+
+// apply выполняет миграцию в одной транзакции со записью в schema_migrations.
+// Возвращает false, если миграция уже в нужном состоянии — например,
+// её только что применил другой процесс.
+func (m *Migrator) apply(ctx context.Context, conn *sqlx.Conn, migration Migration, up bool) (bool, error) {
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var exists int
+	err = tx.QueryRowContext(ctx, tx.Rebind("SELECT 1 FROM schema_migrations WHERE version = ?"), migration.Version).Scan(&exists)
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+	if (err == nil) == up {
+		return false, nil
+	}
+
+	script := migration.Down
+	if up {
+		script = migration.Up
+	}
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return false, fmt.Errorf("migration %04d_%s failed: %w", migration.Version, migration.Name, err)
+	}
+
+	if up {
+		_, err = tx.ExecContext(ctx, tx.Rebind("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)"),
+			migration.Version, migration.Name, domain.Now())
+	} else {
+		_, err = tx.ExecContext(ctx, tx.Rebind("DELETE FROM schema_migrations WHERE version = ?"), migration.Version)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
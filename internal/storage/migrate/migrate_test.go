@@ -0,0 +1,209 @@
+package migrate_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/Dadil/project/internal/storage/migrate"
+	"github.com/Dadil/project/internal/storage/sqlstore"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newMigrator(t *testing.T, db *sqlx.DB) *migrate.Migrator {
+	t.Helper()
+	migrator, err := migrate.New(db)
+	require.NoError(t, err)
+	return migrator
+}
+
+func openSQLite(t *testing.T, path string) *sqlx.DB {
+	t.Helper()
+	db, err := sqlstore.OpenSQLite(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// tableExists проверяет наличие таблицы в базе SQLite
+func tableExists(t *testing.T, db *sqlx.DB, table string) bool {
+	t.Helper()
+	var count int
+	require.NoError(t, db.Get(&count, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", table))
+	return count == 1
+}
+
+func TestUp(t *testing.T) {
+	db := openSQLite(t, filepath.Join(t.TempDir(), "calc.db"))
+	migrator := newMigrator(t, db)
+	all := migrator.Migrations()
+	require.NotEmpty(t, all)
+
+	applied, err := migrator.Up(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, all, applied)
+	for _, table := range []string{"users", "tasks", "user_tasks", "operations", "settings"} {
+		assert.True(t, tableExists(t, db, table), "Table %s should exist", table)
+	}
+
+	// Повторный запуск ничего не применяет
+	applied, err = migrator.Up(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, applied)
+}
+
+func TestStatus(t *testing.T) {
+	db := openSQLite(t, filepath.Join(t.TempDir(), "calc.db"))
+	migrator := newMigrator(t, db)
+
+	statuses, err := migrator.Status(context.Background())
+	require.NoError(t, err)
+	require.Len(t, statuses, len(migrator.Migrations()))
+	for _, status := range statuses {
+		assert.False(t, status.Applied(), "Migration %d should be pending", status.Version)
+	}
+
+	_, err = migrator.Up(context.Background())
+	require.NoError(t, err)
+
+	statuses, err = migrator.Status(context.Background())
+	require.NoError(t, err)
+	for _, status := range statuses {
+		assert.True(t, status.Applied(), "Migration %d should be applied", status.Version)
+		assert.NotNil(t, status.AppliedAt)
+	}
+}
+
+func TestDown(t *testing.T) {
+	db := openSQLite(t, filepath.Join(t.TempDir(), "calc.db"))
+	migrator := newMigrator(t, db)
+	all := migrator.Migrations()
+	_, err := migrator.Up(context.Background())
+	require.NoError(t, err)
+
+	// Откат одного шага убирает только последнюю миграцию
+	rolledBack, err := migrator.Down(context.Background(), 1)
+	require.NoError(t, err)
+	require.Len(t, rolledBack, 1)
+	assert.Equal(t, all[len(all)-1].Version, rolledBack[0].Version)
+	statuses, err := migrator.Status(context.Background())
+	require.NoError(t, err)
+	assert.False(t, statuses[len(all)-1].Applied())
+	assert.True(t, statuses[len(all)-2].Applied())
+	assert.True(t, tableExists(t, db, "operations"))
+
+	// Откат всех миграций и повторное применение
+	rolledBack, err = migrator.Down(context.Background(), len(all))
+	require.NoError(t, err)
+	assert.Len(t, rolledBack, len(all)-1)
+	assert.False(t, tableExists(t, db, "users"))
+
+	applied, err := migrator.Up(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, all, applied)
+}
+
+func TestUp_Concurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calc.db")
+
+	// Несколько процессов с отдельными подключениями запускают миграции одновременно
+	var wg sync.WaitGroup
+	results := make([][]migrate.Migration, 4)
+	errs := make([]error, len(results))
+	for i := range results {
+		migrator := newMigrator(t, openSQLite(t, path))
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = migrator.Up(context.Background())
+		}(i)
+	}
+	wg.Wait()
+
+	// Каждая миграция применена ровно один раз
+	applied := make(map[int]int)
+	for i := range results {
+		require.NoError(t, errs[i])
+		for _, migration := range results[i] {
+			applied[migration.Version]++
+		}
+	}
+	for _, migration := range newMigrator(t, openSQLite(t, path)).Migrations() {
+		assert.Equal(t, 1, applied[migration.Version], "Migration %d should be applied once", migration.Version)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	migrations, err := migrate.Load(fstest.MapFS{
+		"0002_second.up.sql":   {Data: []byte("CREATE TABLE b (id INTEGER)")},
+		"0001_first.up.sql":    {Data: []byte("CREATE TABLE a (id INTEGER)")},
+		"0001_first.down.sql":  {Data: []byte("DROP TABLE a")},
+		"0002_second.down.sql": {Data: []byte("DROP TABLE b")},
+	})
+	require.NoError(t, err)
+	require.Len(t, migrations, 2)
+	assert.Equal(t, migrate.Migration{Version: 1, Name: "first", Up: "CREATE TABLE a (id INTEGER)", Down: "DROP TABLE a"}, migrations[0])
+	assert.Equal(t, 2, migrations[1].Version)
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	tests := map[string]fstest.MapFS{
+		"missing up":      {"0001_first.down.sql": {}},
+		"bad version":     {"first.up.sql": {}},
+		"zero version":    {"0000_first.up.sql": {}},
+		"bad direction":   {"0001_first.sql": {}},
+		"different names": {"0001_first.up.sql": {}, "0001_second.down.sql": {}},
+	}
+	for name, fsys := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := migrate.Load(fsys)
+			assert.Error(t, err)
+		})
+	}
+}
+
+// TestPostgres запускается, только если задана TEST_POSTGRES_DSN.
+// Тест откатывает все миграции, поэтому не указывайте рабочую базу.
+func TestPostgres(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN is not set")
+	}
+
+	db, err := sqlx.Open(sqlstore.DriverPostgres, dsn)
+	require.NoError(t, err)
+	defer db.Close()
+	migrator := newMigrator(t, db)
+	all := migrator.Migrations()
+
+	_, err = migrator.Up(context.Background())
+	require.NoError(t, err)
+	_, err = migrator.Down(context.Background(), len(all))
+	require.NoError(t, err)
+
+	// Параллельные запуски ждут друг друга на advisory lock
+	var wg sync.WaitGroup
+	counts := make(chan int, 4)
+	for i := 0; i < cap(counts); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			applied, err := migrator.Up(context.Background())
+			assert.NoError(t, err)
+			counts <- len(applied)
+		}()
+	}
+	wg.Wait()
+	close(counts)
+
+	total := 0
+	for count := range counts {
+		total += count
+	}
+	assert.Equal(t, len(all), total)
+}
@@ -0,0 +1,29 @@
+-- IF NOT EXISTS: базы, созданные до появления миграций, принимают схему без ошибок
+CREATE TABLE IF NOT EXISTS users (
+    id SERIAL PRIMARY KEY,
+    login VARCHAR(255) UNIQUE NOT NULL,
+    password VARCHAR(255) NOT NULL
+);
+
+-- Создаем индекс для быстрого доступа к пользователю по логину
+CREATE INDEX IF NOT EXISTS idx_users_login ON users(login);
+
+CREATE TABLE IF NOT EXISTS tasks (
+    id TEXT PRIMARY KEY,
+    expression TEXT,
+    status TEXT,
+    result REAL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    started_at TIMESTAMPTZ,
+    finished_at TIMESTAMPTZ,
+    error_message TEXT,
+    agent_id TEXT
+);
+
+CREATE TABLE IF NOT EXISTS user_tasks (
+    user_id INTEGER REFERENCES users(id),
+    task_id TEXT REFERENCES tasks(id) ON DELETE CASCADE
+);
+
+-- Создаем индекс для быстрого доступа к задачам пользователя
+CREATE INDEX IF NOT EXISTS idx_user_tasks_user_id ON user_tasks(user_id);
@@ -0,0 +1,22 @@
+-- Граф операций выражения: каждая строка — одна арифметическая операция,
+-- которую агенты вычисляют независимо друг от друга
+CREATE TABLE IF NOT EXISTS operations (
+    id TEXT PRIMARY KEY,
+    task_id TEXT REFERENCES tasks(id) ON DELETE CASCADE,
+    parent_id TEXT,
+    operator TEXT NOT NULL,
+    left_id TEXT,
+    right_id TEXT,
+    left_value DOUBLE PRECISION,
+    right_value DOUBLE PRECISION,
+    status TEXT NOT NULL,
+    result DOUBLE PRECISION,
+    -- Аренда: агент, вычисляющий операцию, и срок, до которого он должен её продлить
+    owner_agent TEXT,
+    lease_expires_at TIMESTAMPTZ
+);
+
+-- Индексы для поиска готовых операций и операций задачи
+CREATE INDEX IF NOT EXISTS idx_operations_status ON operations(status);
+CREATE INDEX IF NOT EXISTS idx_operations_task_id ON operations(task_id);
+CREATE INDEX IF NOT EXISTS idx_operations_lease ON operations(lease_expires_at) WHERE status = 'processing';
@@ -0,0 +1,7 @@
+-- Настройки, изменяемые через API без перезапуска агентов.
+-- Время выполнения операторов хранится в миллисекундах под ключами duration.+, duration.- и т.д.
+CREATE TABLE IF NOT EXISTS settings (
+    key TEXT PRIMARY KEY,
+    value TEXT NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,3 @@
+ALTER TABLE operations DROP COLUMN IF EXISTS last_error;
+ALTER TABLE operations DROP COLUMN IF EXISTS next_attempt_at;
+ALTER TABLE operations DROP COLUMN IF EXISTS attempts;
@@ -0,0 +1,5 @@
+-- Повторные попытки операции после временных ошибок: число захватов,
+-- время, раньше которого операцию нельзя захватить, и последняя ошибка
+ALTER TABLE operations ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE operations ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMPTZ;
+ALTER TABLE operations ADD COLUMN IF NOT EXISTS last_error TEXT;
@@ -0,0 +1,16 @@
+-- Реестр агентов: агент регистрируется при запуске и периодически
+-- присылает сигнал жизни; без сигнала оркестратор переводит его в offline
+CREATE TABLE IF NOT EXISTS agents (
+    id TEXT PRIMARY KEY,
+    hostname TEXT NOT NULL,
+    workers INTEGER NOT NULL,
+    -- Поддерживаемые операторы через запятую
+    operators TEXT NOT NULL,
+    version TEXT NOT NULL,
+    status TEXT NOT NULL,
+    registered_at TIMESTAMPTZ NOT NULL,
+    last_heartbeat TIMESTAMPTZ NOT NULL,
+    completed_operations INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_agents_heartbeat ON agents(last_heartbeat) WHERE status = 'online';
@@ -0,0 +1,19 @@
+-- Сессии пользователей: refresh-токены хранятся только в виде хеша.
+-- При обновлении токен отзывается и заменяется новым той же сессии;
+-- повторно предъявленный отозванный токен отзывает всю сессию.
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    token_hash TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    session_id TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_session ON refresh_tokens(session_id);
+
+-- Отозванные access-токены (по claim jti) до окончания срока их действия
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    jti TEXT PRIMARY KEY,
+    expires_at TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,3 @@
+-- Неудачные попытки входа подряд и время, до которого вход заблокирован
+ALTER TABLE users ADD COLUMN IF NOT EXISTS failed_logins INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locked_until TIMESTAMPTZ;
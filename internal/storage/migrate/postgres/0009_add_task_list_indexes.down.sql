@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_tasks_status;
+DROP INDEX IF EXISTS idx_tasks_result;
+DROP INDEX IF EXISTS idx_tasks_finished;
+DROP INDEX IF EXISTS idx_tasks_created;
+DROP INDEX IF EXISTS idx_user_tasks_task_id;
+DROP INDEX IF EXISTS idx_user_tasks_user_task;
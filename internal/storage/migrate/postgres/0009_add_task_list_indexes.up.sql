@@ -0,0 +1,8 @@
+-- Индексы постраничного списка задач: задачи пользователя и порядок по
+-- каждому полю сортировки (ID задачи разрешает равные значения ключа)
+CREATE INDEX IF NOT EXISTS idx_user_tasks_user_task ON user_tasks(user_id, task_id);
+CREATE INDEX IF NOT EXISTS idx_user_tasks_task_id ON user_tasks(task_id);
+CREATE INDEX IF NOT EXISTS idx_tasks_created ON tasks(created_at, id);
+CREATE INDEX IF NOT EXISTS idx_tasks_finished ON tasks(finished_at, id);
+CREATE INDEX IF NOT EXISTS idx_tasks_result ON tasks((CASE WHEN status = 'completed' THEN result END), id);
+CREATE INDEX IF NOT EXISTS idx_tasks_status ON tasks(status);
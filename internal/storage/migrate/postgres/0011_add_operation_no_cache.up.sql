@@ -0,0 +1,2 @@
+-- Задача отказалась от кеша результатов: агенты вычисляют её операции заново
+ALTER TABLE operations ADD COLUMN IF NOT EXISTS no_cache BOOLEAN NOT NULL DEFAULT FALSE;
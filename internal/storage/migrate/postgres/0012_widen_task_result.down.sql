@@ -0,0 +1 @@
+ALTER TABLE tasks ALTER COLUMN result TYPE REAL;
@@ -0,0 +1,3 @@
+-- Результат задачи хранится с той же точностью, что и результаты операций:
+-- REAL отбрасывал знаки после седьмого значащего
+ALTER TABLE tasks ALTER COLUMN result TYPE DOUBLE PRECISION;
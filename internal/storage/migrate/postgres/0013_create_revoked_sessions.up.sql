@@ -0,0 +1,6 @@
+-- Завершенные сессии: все access-токены сессии отклоняются до expires_at,
+-- когда истекут выданные до завершения
+CREATE TABLE IF NOT EXISTS revoked_sessions (
+    session_id TEXT PRIMARY KEY,
+    expires_at TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,25 @@
+-- Время хранится в TIMESTAMP, чтобы драйвер разбирал его в time.Time
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    login TEXT UNIQUE NOT NULL,
+    password TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS tasks (
+    id TEXT PRIMARY KEY,
+    expression TEXT,
+    status TEXT,
+    result REAL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMP,
+    finished_at TIMESTAMP,
+    error_message TEXT,
+    agent_id TEXT
+);
+
+CREATE TABLE IF NOT EXISTS user_tasks (
+    user_id INTEGER REFERENCES users(id),
+    task_id TEXT REFERENCES tasks(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_tasks_user_id ON user_tasks(user_id);
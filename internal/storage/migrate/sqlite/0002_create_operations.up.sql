@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS operations (
+    id TEXT PRIMARY KEY,
+    task_id TEXT REFERENCES tasks(id) ON DELETE CASCADE,
+    parent_id TEXT,
+    operator TEXT NOT NULL,
+    left_id TEXT,
+    right_id TEXT,
+    left_value REAL,
+    right_value REAL,
+    status TEXT NOT NULL,
+    result REAL,
+    owner_agent TEXT,
+    lease_expires_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_operations_status ON operations(status);
+CREATE INDEX IF NOT EXISTS idx_operations_task_id ON operations(task_id);
+CREATE INDEX IF NOT EXISTS idx_operations_lease ON operations(lease_expires_at) WHERE status = 'processing';
@@ -0,0 +1,3 @@
+ALTER TABLE operations DROP COLUMN last_error;
+ALTER TABLE operations DROP COLUMN next_attempt_at;
+ALTER TABLE operations DROP COLUMN attempts;
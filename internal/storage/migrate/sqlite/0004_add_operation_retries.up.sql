@@ -0,0 +1,3 @@
+ALTER TABLE operations ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE operations ADD COLUMN next_attempt_at TIMESTAMP;
+ALTER TABLE operations ADD COLUMN last_error TEXT;
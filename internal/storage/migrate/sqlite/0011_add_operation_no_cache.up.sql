@@ -0,0 +1 @@
+ALTER TABLE operations ADD COLUMN no_cache BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1 @@
+-- Нечего откатывать (см. up-миграцию)
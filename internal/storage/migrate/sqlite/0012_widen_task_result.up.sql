@@ -0,0 +1,2 @@
+-- В SQLite REAL уже хранит 8-байтное число: миграция оставлена, чтобы
+-- версии схемы совпадали с PostgreSQL
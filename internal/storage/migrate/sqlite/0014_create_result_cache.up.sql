@@ -0,0 +1,7 @@
+-- Общий кеш результатов: оркестратор и агенты, работающие с базой напрямую,
+-- видят результаты друг друга до expires_at
+CREATE TABLE IF NOT EXISTS result_cache (
+    cache_key TEXT PRIMARY KEY,
+    result REAL NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
@@ -0,0 +1,104 @@
+package sqlstore
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/Dadil/project/internal/orchestra/domain"
+	"github.com/lib/pq"
+)
+
+// NotifyChannel — канал PostgreSQL, в который хранилище сообщает о готовых операциях
+const NotifyChannel = "operations_ready"
+
+// EventsChannel — канал PostgreSQL, в который агенты, работающие с базой
+// напрямую, публикуют смену статусов задач для шины событий оркестратора
+const EventsChannel = "task_events"
+
+// listenerPingInterval — как часто проверять соединение слушателя: без
+// запросов обрыв соединения может долго оставаться незамеченным
+const listenerPingInterval = time.Minute
+
+// Listen подписывается на уведомления о готовых операциях в PostgreSQL.
+// В возвращаемый канал приходит значение на каждое уведомление, а также
+// после переподключения к базе, когда уведомления могли быть пропущены.
+// Несколько уведомлений подряд сливаются в одно. Канал закрывается после отмены ctx.
+func Listen(ctx context.Context, dsn string) (<-chan struct{}, error) {
+	notifications := make(chan struct{}, 1)
+	err := listen(ctx, dsn, NotifyChannel, func(*pq.Notification) {
+		// nil приходит после переподключения — это тоже повод проверить очередь
+		select {
+		case notifications <- struct{}{}:
+		default:
+		}
+	}, func() { close(notifications) })
+	if err != nil {
+		return nil, err
+	}
+	return notifications, nil
+}
+
+// ListenEvents подписывается на события задач, опубликованные через
+// PublishTaskEvent. События, пришедшие, пока соединение было разорвано,
+// теряются. Канал закрывается после отмены ctx.
+func ListenEvents(ctx context.Context, dsn string) (<-chan domain.TaskEvent, error) {
+	events := make(chan domain.TaskEvent, 64)
+	err := listen(ctx, dsn, EventsChannel, func(notification *pq.Notification) {
+		if notification == nil {
+			return
+		}
+		var event domain.TaskEvent
+		if err := json.Unmarshal([]byte(notification.Extra), &event); err != nil {
+			log.Println("Malformed task event:", err)
+			return
+		}
+		select {
+		case events <- event:
+		case <-ctx.Done():
+		}
+	}, func() { close(events) })
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// listen подписывается на канал PostgreSQL channel и вызывает handle на
+// каждое уведомление (nil — после переподключения), пока ctx не отменен;
+// затем вызывается done
+func listen(ctx context.Context, dsn, channel string, handle func(*pq.Notification), done func()) error {
+	listener := pq.NewListener(dsn, 100*time.Millisecond, 10*time.Second, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Println("PostgreSQL listener error:", err)
+		}
+	})
+	if err := listener.Listen(channel); err != nil {
+		listener.Close()
+		return err
+	}
+
+	go func() {
+		defer done()
+		defer listener.Close()
+
+		ticker := time.NewTicker(listenerPingInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				go listener.Ping()
+			case notification, ok := <-listener.Notify:
+				if !ok {
+					return
+				}
+				handle(notification)
+			}
+		}
+	}()
+	return nil
+}
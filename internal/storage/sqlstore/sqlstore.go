@@ -0,0 +1,1312 @@
+// Package sqlstore — хранилище оркестратора в PostgreSQL или SQLite.
+// Запросы пишутся с плейсхолдерами "?" и переводятся в синтаксис драйвера
+// через sqlx.Rebind; время вычисляется в Go, а не функциями СУБД, чтобы
+// оба диалекта вели себя одинаково.
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Dadil/project/internal/orchestra/domain"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Имена драйверов database/sql
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite3"
+)
+
+type Store struct {
+	db *sqlx.DB
+}
+
+var _ domain.Store = (*Store)(nil)
+
+// New оборачивает открытое подключение. Диалект определяется по имени драйвера.
+// Схему базы создают миграции из пакета migrate.
+func New(db *sqlx.DB) *Store {
+	return &Store{db: db}
+}
+
+// OpenSQLite открывает базу SQLite в файле path. SQLite допускает только
+// одного писателя, поэтому все запросы идут через одно соединение.
+func OpenSQLite(path string) (*sqlx.DB, error) {
+	db, err := sqlx.Open(DriverSQLite, "file:"+path+"?_busy_timeout=5000&_foreign_keys=on&_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	return db, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Столбцы пользователя в порядке полей domain.User
+const userColumns = "login, password, role, disabled, failed_logins, locked_until"
+
+// Столбцы задачи в порядке, который ожидает scanTask
+const taskColumns = "t.id, t.expression, t.status, t.result, t.created_at, t.started_at, t.finished_at, t.error_message, t.agent_id"
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanTask(row rowScanner) (domain.Task, error) {
+	var task domain.Task
+	var errorMessage, agentID sql.NullString
+	err := row.Scan(&task.ID, &task.Expression, &task.Status, &task.Result,
+		&task.CreatedAt, &task.StartedAt, &task.FinishedAt, &errorMessage, &agentID)
+	task.ErrorMessage = errorMessage.String
+	task.AgentID = agentID.String
+	return task, err
+}
+
+// nullString превращает пустую строку в NULL
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// notifyReady сообщает агентам, что появились готовые операции. PostgreSQL
+// доставляет уведомление после фиксации транзакции; в SQLite уведомлений нет,
+// и агенты находят операции опросом.
+func (s *Store) notifyReady(ctx context.Context, tx *sqlx.Tx) error {
+	if s.db.DriverName() == DriverSQLite {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, "SELECT pg_notify($1, '')", NotifyChannel)
+	return err
+}
+
+// PublishTaskEvent передает смену статуса задачи оркестратору уведомлением
+// в канал EventsChannel (см. ListenEvents). В SQLite уведомлений нет, и
+// событие отбрасывается: клиенты узнают о статусе, перечитав задачу.
+func (s *Store) PublishTaskEvent(ctx context.Context, event domain.TaskEvent) error {
+	if s.db.DriverName() == DriverSQLite {
+		return nil
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, "SELECT pg_notify($1, $2)", EventsChannel, string(payload))
+	return err
+}
+
+func (s *Store) CreateUser(ctx context.Context, login, passwordHash string) error {
+	res, err := s.db.ExecContext(ctx, s.db.Rebind("INSERT INTO users (login, password) VALUES (?, ?) ON CONFLICT (login) DO NOTHING"),
+		login, passwordHash)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return domain.ErrUserExists
+	}
+	return nil
+}
+
+func (s *Store) GetUserByLogin(ctx context.Context, login string) (*domain.User, error) {
+	var user domain.User
+	err := s.db.QueryRowContext(ctx, s.db.Rebind("SELECT "+userColumns+" FROM users WHERE login = ?"), login).
+		Scan(&user.Login, &user.Password, &user.Role, &user.Disabled, &user.FailedLogins, &user.LockedUntil)
+	if err == sql.ErrNoRows {
+		return nil, domain.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *Store) ListUsers(ctx context.Context) ([]domain.User, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+userColumns+" FROM users ORDER BY login")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []domain.User
+	for rows.Next() {
+		var user domain.User
+		if err := rows.Scan(&user.Login, &user.Password, &user.Role, &user.Disabled, &user.FailedLogins, &user.LockedUntil); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func (s *Store) RecordLoginFailure(ctx context.Context, login string, maxAttempts int, lockout time.Duration) error {
+	// Правые части SET видят значения до обновления
+	res, err := s.db.ExecContext(ctx, s.db.Rebind(`
+        UPDATE users SET
+            failed_logins = CASE WHEN failed_logins + 1 >= ? THEN 0 ELSE failed_logins + 1 END,
+            locked_until = CASE WHEN failed_logins + 1 >= ? THEN ? ELSE locked_until END
+        WHERE login = ?
+    `), maxAttempts, maxAttempts, domain.Now().Add(lockout), login)
+	if err != nil {
+		return err
+	}
+	return userAffected(res)
+}
+
+func (s *Store) ResetLoginFailures(ctx context.Context, login string) error {
+	res, err := s.db.ExecContext(ctx, s.db.Rebind("UPDATE users SET failed_logins = 0, locked_until = NULL WHERE login = ?"), login)
+	if err != nil {
+		return err
+	}
+	return userAffected(res)
+}
+
+func (s *Store) SetUserRole(ctx context.Context, login, role string) error {
+	res, err := s.db.ExecContext(ctx, s.db.Rebind("UPDATE users SET role = ? WHERE login = ?"), role, login)
+	if err != nil {
+		return err
+	}
+	return userAffected(res)
+}
+
+func (s *Store) SetUserDisabled(ctx context.Context, login string, disabled bool) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, tx.Rebind("UPDATE users SET disabled = ? WHERE login = ?"), disabled, login)
+	if err != nil {
+		return err
+	}
+	if err := userAffected(res); err != nil {
+		return err
+	}
+
+	if disabled {
+		_, err = tx.ExecContext(ctx, tx.Rebind(`
+            UPDATE refresh_tokens SET revoked_at = ?
+            WHERE user_id = (SELECT id FROM users WHERE login = ?) AND revoked_at IS NULL
+        `), domain.Now(), login)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// userAffected возвращает ErrUserNotFound, если запрос не изменил ни одного пользователя
+func userAffected(res sql.Result) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return domain.ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *Store) CreateRefreshToken(ctx context.Context, token domain.RefreshToken) error {
+	res, err := s.db.ExecContext(ctx, s.db.Rebind(`
+        INSERT INTO refresh_tokens (token_hash, user_id, session_id, created_at, expires_at)
+        SELECT ?, id, ?, ?, ? FROM users WHERE login = ?
+    `), token.Hash, token.SessionID, token.CreatedAt, token.ExpiresAt, token.Login)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return domain.ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *Store) RotateRefreshToken(ctx context.Context, hash, nextHash string, expiresAt time.Time) (*domain.RefreshToken, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Токен отзывается тем же UPDATE, в котором проверяется: из двух
+	// одновременных обновлений одним токеном успешным будет только одно
+	now := domain.Now()
+	next := domain.RefreshToken{Hash: nextHash, CreatedAt: now, ExpiresAt: expiresAt}
+	var userID int64
+	err = tx.QueryRowContext(ctx, tx.Rebind(`
+        UPDATE refresh_tokens SET revoked_at = ?
+        WHERE token_hash = ? AND revoked_at IS NULL AND expires_at > ?
+        RETURNING user_id, session_id
+    `), now, hash, now).Scan(&userID, &next.SessionID)
+	if err == sql.ErrNoRows {
+		return nil, s.rejectRefreshToken(ctx, tx, hash, now)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, tx.Rebind(`
+        INSERT INTO refresh_tokens (token_hash, user_id, session_id, created_at, expires_at)
+        VALUES (?, ?, ?, ?, ?)
+    `), next.Hash, userID, next.SessionID, next.CreatedAt, next.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	err = tx.QueryRowContext(ctx, tx.Rebind("SELECT login FROM users WHERE id = ?"), userID).Scan(&next.Login)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &next, nil
+}
+
+// rejectRefreshToken объясняет, почему токен не удалось обновить. Если он
+// уже отозван, сессия отзывается целиком и транзакция фиксируется.
+func (s *Store) rejectRefreshToken(ctx context.Context, tx *sqlx.Tx, hash string, now time.Time) error {
+	var sessionID string
+	var revokedAt *time.Time
+	err := tx.QueryRowContext(ctx, tx.Rebind("SELECT session_id, revoked_at FROM refresh_tokens WHERE token_hash = ?"), hash).
+		Scan(&sessionID, &revokedAt)
+	if err == sql.ErrNoRows || (err == nil && revokedAt == nil) {
+		return domain.ErrTokenNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := revokeSession(ctx, tx, sessionID, now); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return domain.ErrTokenReused
+}
+
+func (s *Store) RevokeSession(ctx context.Context, sessionID string) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := revokeSession(ctx, tx, sessionID, domain.Now()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func revokeSession(ctx context.Context, tx *sqlx.Tx, sessionID string, now time.Time) error {
+	_, err := tx.ExecContext(ctx, tx.Rebind("UPDATE refresh_tokens SET revoked_at = ? WHERE session_id = ? AND revoked_at IS NULL"),
+		now, sessionID)
+	return err
+}
+
+func (s *Store) DenyAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	_, err := s.db.ExecContext(ctx, s.db.Rebind("INSERT INTO revoked_tokens (jti, expires_at) VALUES (?, ?) ON CONFLICT (jti) DO NOTHING"),
+		jti, expiresAt)
+	return err
+}
+
+func (s *Store) DenySession(ctx context.Context, sessionID string, expiresAt time.Time) error {
+	_, err := s.db.ExecContext(ctx, s.db.Rebind(denySessionQuery), sessionID, expiresAt)
+	return err
+}
+
+// denySessionQuery запрещает сессию, продлевая, но не сокращая прежний запрет
+const denySessionQuery = `
+    INSERT INTO revoked_sessions (session_id, expires_at) VALUES (?, ?)
+    ON CONFLICT (session_id) DO UPDATE SET expires_at = excluded.expires_at
+    WHERE revoked_sessions.expires_at < excluded.expires_at`
+
+// DenyUserSessions выбирает сессии подзапросом: с DISTINCT во внешнем
+// SELECT PostgreSQL определил бы тип параметра срока как text, а не как
+// тип столбца expires_at. WHERE во внешнем SELECT нужен SQLite, чтобы ON
+// CONFLICT не разбирался как условие соединения.
+func (s *Store) DenyUserSessions(ctx context.Context, login string, expiresAt time.Time) error {
+	_, err := s.db.ExecContext(ctx, s.db.Rebind(`
+        INSERT INTO revoked_sessions (session_id, expires_at)
+        SELECT s.session_id, ? FROM (
+            SELECT DISTINCT rt.session_id, u.login FROM refresh_tokens rt
+            JOIN users u ON u.id = rt.user_id
+        ) s
+        WHERE s.login = ?
+        ON CONFLICT (session_id) DO UPDATE SET expires_at = excluded.expires_at
+        WHERE revoked_sessions.expires_at < excluded.expires_at`), expiresAt, login)
+	return err
+}
+
+func (s *Store) IsAccessTokenDenied(ctx context.Context, jti, sessionID string) (bool, error) {
+	var exists int
+	err := s.db.QueryRowContext(ctx, s.db.Rebind(`
+        SELECT 1 FROM revoked_tokens WHERE jti = ?
+        UNION ALL
+        SELECT 1 FROM revoked_sessions WHERE session_id = ?`), jti, sessionID).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *Store) DeleteExpiredTokens(ctx context.Context, before time.Time) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, statement := range []string{
+		"DELETE FROM refresh_tokens WHERE expires_at < ?",
+		"DELETE FROM revoked_tokens WHERE expires_at < ?",
+		"DELETE FROM revoked_sessions WHERE expires_at < ?",
+	} {
+		if _, err := tx.ExecContext(ctx, tx.Rebind(statement), before); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *Store) CreateTask(ctx context.Context, login string, task domain.Task, operations []domain.Operation) error {
+	return s.CreateTasks(ctx, login, []domain.NewTask{{Task: task, Operations: operations}})
+}
+
+func (s *Store) CreateTasks(ctx context.Context, login string, tasks []domain.NewTask) error {
+	return s.createTasks(ctx, login, nil, tasks)
+}
+
+func (s *Store) CreateTaskWithKey(ctx context.Context, login string, key domain.IdempotencyKey, task domain.Task, operations []domain.Operation) error {
+	return s.createTasks(ctx, login, &key, []domain.NewTask{{Task: task, Operations: operations}})
+}
+
+// createTasks сохраняет задачи и, если key задан, ключ идемпотентности
+// пользователя одной транзакцией
+func (s *Store) createTasks(ctx context.Context, login string, key *domain.IdempotencyKey, tasks []domain.NewTask) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var userID int64
+	if login != "" {
+		err := tx.QueryRowContext(ctx, tx.Rebind("SELECT id FROM users WHERE login = ?"), login).Scan(&userID)
+		if err == sql.ErrNoRows {
+			return domain.ErrUserNotFound
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// Пакет сохраняется несколькими многострочными INSERT, а не запросом на
+	// каждую строку, чтобы транзакция не держалась тысячи обращений к базе
+	var taskRows, ownerRows, operationRows [][]interface{}
+	for _, newTask := range tasks {
+		task := newTask.Task
+		taskRows = append(taskRows, []interface{}{task.ID, task.Expression, task.Status, task.Result, task.CreatedAt, task.StartedAt, task.FinishedAt})
+		if login != "" {
+			// Связываем задачу с пользователем
+			ownerRows = append(ownerRows, []interface{}{userID, task.ID})
+		}
+		for _, op := range newTask.Operations {
+			operationRows = append(operationRows, []interface{}{op.ID, op.TaskID, nullString(op.ParentID), op.Operator,
+				nullString(op.LeftID), nullString(op.RightID), op.LeftValue, op.RightValue, op.Status, op.NoCache})
+		}
+	}
+	if err := insertRows(ctx, tx, "tasks", taskInsertColumns, taskRows); err != nil {
+		return err
+	}
+	if err := insertRows(ctx, tx, "user_tasks", []string{"user_id", "task_id"}, ownerRows); err != nil {
+		return err
+	}
+	if err := insertRows(ctx, tx, "operations", operationInsertColumns, operationRows); err != nil {
+		return err
+	}
+	ready := len(operationRows) > 0
+
+	if key != nil {
+		if err := insertIdempotencyKey(ctx, tx, userID, *key); err != nil {
+			return err
+		}
+	}
+
+	if ready {
+		if err := s.notifyReady(ctx, tx); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// insertIdempotencyKey сохраняет ключ после задачи, на которую он ссылается.
+// Истекший ключ с тем же значением заменяется, действующий — нет.
+func insertIdempotencyKey(ctx context.Context, tx *sqlx.Tx, userID int64, key domain.IdempotencyKey) error {
+	_, err := tx.ExecContext(ctx, tx.Rebind("DELETE FROM idempotency_keys WHERE user_id = ? AND key = ? AND expires_at <= ?"),
+		userID, key.Key, domain.Now())
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, tx.Rebind(`
+        INSERT INTO idempotency_keys (user_id, key, expression, task_id, created_at, expires_at)
+        VALUES (?, ?, ?, ?, ?, ?)
+        ON CONFLICT (user_id, key) DO NOTHING
+    `), userID, key.Key, key.Expression, key.TaskID, key.CreatedAt, key.ExpiresAt)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return domain.ErrIdempotencyKeyExists
+	}
+	return nil
+}
+
+func (s *Store) GetIdempotencyKey(ctx context.Context, login, key string) (*domain.IdempotencyKey, error) {
+	stored := domain.IdempotencyKey{Key: key, Login: login}
+	err := s.db.QueryRowContext(ctx, s.db.Rebind(`
+        SELECT k.expression, k.task_id, k.created_at, k.expires_at
+        FROM idempotency_keys k
+        JOIN users u ON k.user_id = u.id
+        WHERE u.login = ? AND k.key = ? AND k.expires_at > ?
+    `), login, key, domain.Now()).Scan(&stored.Expression, &stored.TaskID, &stored.CreatedAt, &stored.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, domain.ErrIdempotencyKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &stored, nil
+}
+
+func (s *Store) DeleteExpiredIdempotencyKeys(ctx context.Context, before time.Time) error {
+	_, err := s.db.ExecContext(ctx, s.db.Rebind("DELETE FROM idempotency_keys WHERE expires_at < ?"), before)
+	return err
+}
+
+// Столбцы, которые заполняются при создании задач и операций
+var (
+	taskInsertColumns      = []string{"id", "expression", "status", "result", "created_at", "started_at", "finished_at"}
+	operationInsertColumns = []string{"id", "task_id", "parent_id", "operator", "left_id", "right_id", "left_value", "right_value", "status", "no_cache"}
+)
+
+// maxInsertParams — наибольшее число параметров одного запроса: столько
+// допускает SQLite, собранный со значениями по умолчанию старше 3.32;
+// у PostgreSQL предел выше
+const maxInsertParams = 999
+
+// insertRows вставляет rows в столбцы columns таблицы table многострочными
+// INSERT, деля строки так, чтобы в запросе было не больше maxInsertParams параметров
+func insertRows(ctx context.Context, tx *sqlx.Tx, table string, columns []string, rows [][]interface{}) error {
+	placeholders := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ") + ")"
+	perQuery := maxInsertParams / len(columns)
+
+	for start := 0; start < len(rows); start += perQuery {
+		end := start + perQuery
+		if end > len(rows) {
+			end = len(rows)
+		}
+
+		var query strings.Builder
+		fmt.Fprintf(&query, "INSERT INTO %s (%s) VALUES ", table, strings.Join(columns, ", "))
+		args := make([]interface{}, 0, (end-start)*len(columns))
+		for i, row := range rows[start:end] {
+			if i > 0 {
+				query.WriteString(", ")
+			}
+			query.WriteString(placeholders)
+			args = append(args, row...)
+		}
+		if _, err := tx.ExecContext(ctx, tx.Rebind(query.String()), args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) queryTasks(ctx context.Context, query string, args ...interface{}) ([]domain.Task, error) {
+	rows, err := s.db.QueryContext(ctx, s.db.Rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tasks []domain.Task
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+
+	return tasks, rows.Err()
+}
+
+func (s *Store) ListTasks(ctx context.Context) ([]domain.Task, error) {
+	return s.queryTasks(ctx, "SELECT "+taskColumns+" FROM tasks t ORDER BY t.created_at, t.id")
+}
+
+func (s *Store) ListTasksForUser(ctx context.Context, login string) ([]domain.Task, error) {
+	return s.queryTasks(ctx, `
+        SELECT `+taskColumns+`
+        FROM tasks t
+        JOIN user_tasks ut ON t.id = ut.task_id
+        JOIN users u ON ut.user_id = u.id
+        WHERE u.login = ?
+        ORDER BY t.created_at, t.id
+    `, login)
+}
+
+// taskSortKeys — выражения полей сортировки списка задач. Время завершения
+// есть только у завершенной задачи, результат — у вычисленной.
+var taskSortKeys = map[string]string{
+	domain.SortByCreated:  "t.created_at",
+	domain.SortByFinished: "t.finished_at",
+	domain.SortByResult:   "CASE WHEN t.status = 'completed' THEN t.result END",
+}
+
+// likeEscaper экранирует спецсимволы LIKE, чтобы подстрока искалась буквально
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func (s *Store) QueryTasksForUser(ctx context.Context, login string, query domain.TaskQuery) ([]domain.Task, int, error) {
+	key, ok := taskSortKeys[query.Sort]
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown sort field %q", query.Sort)
+	}
+
+	conditions := []string{"u.login = ?"}
+	args := []interface{}{login}
+	if query.Status != "" {
+		conditions = append(conditions, "t.status = ?")
+		args = append(args, query.Status)
+	}
+	if query.CreatedFrom != nil {
+		conditions = append(conditions, "t.created_at >= ?")
+		args = append(args, query.CreatedFrom.UTC())
+	}
+	if query.CreatedTo != nil {
+		conditions = append(conditions, "t.created_at < ?")
+		args = append(args, query.CreatedTo.UTC())
+	}
+	if query.Expression != "" {
+		conditions = append(conditions, `t.expression LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+likeEscaper.Replace(query.Expression)+"%")
+	}
+	from := `
+        FROM tasks t
+        JOIN user_tasks ut ON t.id = ut.task_id
+        JOIN users u ON ut.user_id = u.id
+        WHERE ` + strings.Join(conditions, " AND ")
+
+	var total int
+	if err := s.db.QueryRowContext(ctx, s.db.Rebind("SELECT COUNT(*)"+from), args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	if query.After != nil {
+		condition, cursorArgs := afterCursor(key, query)
+		from += " AND " + condition
+		args = append(args, cursorArgs...)
+	}
+	direction := "ASC"
+	if query.Desc {
+		direction = "DESC"
+	}
+	// Задачи без значения ключа идут в конце при любом направлении
+	order := fmt.Sprintf(" ORDER BY (%[1]s) IS NULL, %[1]s %[2]s, t.id %[2]s LIMIT ?", key, direction)
+	tasks, err := s.queryTasks(ctx, "SELECT "+taskColumns+from+order, append(args, query.Limit)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	return tasks, total, nil
+}
+
+// afterCursor возвращает условие "задача идет в списке после курсора"
+func afterCursor(key string, query domain.TaskQuery) (string, []interface{}) {
+	cmp := ">"
+	if query.Desc {
+		cmp = "<"
+	}
+	if query.After.Value == nil {
+		return fmt.Sprintf("(%s) IS NULL AND t.id %s ?", key, cmp), []interface{}{query.After.ID}
+	}
+	condition := fmt.Sprintf("((%[1]s) IS NULL OR (%[1]s) %[2]s ? OR ((%[1]s) = ? AND t.id %[2]s ?))", key, cmp)
+	return condition, []interface{}{query.After.Value, query.After.Value, query.After.ID}
+}
+
+func (s *Store) GetTaskForUser(ctx context.Context, login, taskID string) (*domain.Task, error) {
+	row := s.db.QueryRowContext(ctx, s.db.Rebind(`
+        SELECT `+taskColumns+`
+        FROM tasks t
+        JOIN user_tasks ut ON t.id = ut.task_id
+        JOIN users u ON ut.user_id = u.id
+        WHERE u.login = ? AND t.id = ?
+    `), login, taskID)
+
+	task, err := scanTask(row)
+	if err == sql.ErrNoRows {
+		return nil, domain.ErrTaskNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+func (s *Store) GetTaskOwner(ctx context.Context, taskID string) (string, error) {
+	var login string
+	err := s.db.QueryRowContext(ctx, s.db.Rebind(`
+        SELECT u.login FROM user_tasks ut
+        JOIN users u ON ut.user_id = u.id
+        WHERE ut.task_id = ?
+    `), taskID).Scan(&login)
+	if err == sql.ErrNoRows {
+		return "", domain.ErrTaskNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return login, nil
+}
+
+func (s *Store) DeleteTasksForUser(ctx context.Context, login string) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Удаляем явно, не полагаясь на каскадное удаление: в SQLite оно
+	// работает, только если включены внешние ключи
+	userTasks := "SELECT task_id FROM user_tasks WHERE user_id = (SELECT id FROM users WHERE login = ?)"
+	statements := []string{
+		"DELETE FROM idempotency_keys WHERE task_id IN (" + userTasks + ")",
+		"DELETE FROM operations WHERE task_id IN (" + userTasks + ")",
+		"DELETE FROM tasks WHERE id IN (" + userTasks + ")",
+		"DELETE FROM user_tasks WHERE user_id = (SELECT id FROM users WHERE login = ?)",
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, tx.Rebind(statement), login); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// checkTaskOwner возвращает ErrTaskNotFound, если у пользователя нет такой задачи
+func checkTaskOwner(ctx context.Context, tx *sqlx.Tx, login, taskID string) error {
+	var exists int
+	err := tx.QueryRowContext(ctx, tx.Rebind(`
+        SELECT 1
+        FROM user_tasks ut
+        JOIN users u ON ut.user_id = u.id
+        WHERE u.login = ? AND ut.task_id = ?
+    `), login, taskID).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return domain.ErrTaskNotFound
+	}
+	return err
+}
+
+func (s *Store) CancelTaskForUser(ctx context.Context, login, taskID string) (*domain.Task, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := checkTaskOwner(ctx, tx, login, taskID); err != nil {
+		return nil, err
+	}
+
+	// Статус проверяется в том же UPDATE: задачу могут завершить параллельно
+	res, err := tx.ExecContext(ctx, tx.Rebind(`
+        UPDATE tasks SET status = 'cancelled', finished_at = ?
+        WHERE id = ? AND status IN ('pending', 'processing')
+    `), domain.Now(), taskID)
+	if err != nil {
+		return nil, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if affected == 0 {
+		return nil, domain.ErrTaskFinished
+	}
+
+	// Вычисляемые операции тоже отменяются: агент потеряет аренду
+	// при следующем продлении и прервет вычисление
+	_, err = tx.ExecContext(ctx, tx.Rebind(`
+        UPDATE operations SET status = 'cancelled', lease_expires_at = NULL
+        WHERE task_id = ? AND status IN ('waiting', 'pending', 'processing')
+    `), taskID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s.GetTaskForUser(ctx, login, taskID)
+}
+
+func (s *Store) RetryTaskForUser(ctx context.Context, login, taskID string) (*domain.Task, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := checkTaskOwner(ctx, tx, login, taskID); err != nil {
+		return nil, err
+	}
+
+	res, err := tx.ExecContext(ctx, tx.Rebind(`
+        UPDATE tasks SET status = 'pending', error_message = NULL, finished_at = NULL
+        WHERE id = ? AND status = 'dead'
+    `), taskID)
+	if err != nil {
+		return nil, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if affected == 0 {
+		return nil, domain.ErrTaskNotDead
+	}
+
+	// Операция готова к вычислению, если оба операнда уже известны
+	_, err = tx.ExecContext(ctx, tx.Rebind(`
+        UPDATE operations SET
+            status = CASE WHEN left_value IS NOT NULL AND right_value IS NOT NULL THEN 'pending' ELSE 'waiting' END,
+            attempts = 0, next_attempt_at = NULL, last_error = NULL, owner_agent = NULL, lease_expires_at = NULL
+        WHERE task_id = ? AND status = 'dead'
+    `), taskID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.notifyReady(ctx, tx); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s.GetTaskForUser(ctx, login, taskID)
+}
+
+// GetOperation возвращает операцию по ID или ErrOperationNotFound.
+func (s *Store) GetOperation(ctx context.Context, operationID string) (*domain.Operation, error) {
+	var op domain.Operation
+	var parentID, leftID, rightID, ownerAgent, lastError sql.NullString
+	var result sql.NullFloat64
+	err := s.db.QueryRowContext(ctx, s.db.Rebind(`
+        SELECT id, task_id, parent_id, operator, left_id, right_id, left_value, right_value, status, result,
+            owner_agent, lease_expires_at, attempts, next_attempt_at, last_error, no_cache
+        FROM operations WHERE id = ?
+    `), operationID).Scan(&op.ID, &op.TaskID, &parentID, &op.Operator, &leftID, &rightID, &op.LeftValue, &op.RightValue,
+		&op.Status, &result, &ownerAgent, &op.LeaseExpiresAt, &op.Attempts, &op.NextAttemptAt, &lastError, &op.NoCache)
+	if err == sql.ErrNoRows {
+		return nil, domain.ErrOperationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	op.ParentID, op.LeftID, op.RightID = parentID.String, leftID.String, rightID.String
+	op.Result, op.OwnerAgent, op.LastError = result.Float64, ownerAgent.String, lastError.String
+	return &op, nil
+}
+
+// ClaimOperation захватывает операцию одним UPDATE ... RETURNING. В PostgreSQL
+// строки, заблокированные другими агентами, пропускаются (SKIP LOCKED);
+// SQLite сериализует транзакции записи, поэтому блокировка строк не нужна.
+func (s *Store) ClaimOperation(ctx context.Context, owner string, lease time.Duration) (*domain.Operation, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lock := ""
+	if s.db.DriverName() != DriverSQLite {
+		lock = "FOR UPDATE SKIP LOCKED"
+	}
+
+	now := domain.Now()
+	expiresAt := now.Add(lease)
+	op := domain.Operation{Status: domain.OperationProcessing, OwnerAgent: owner, LeaseExpiresAt: &expiresAt}
+	var parentID, leftID, rightID, lastError sql.NullString
+	err = tx.QueryRowContext(ctx, tx.Rebind(`
+        UPDATE operations SET status = 'processing', owner_agent = ?, lease_expires_at = ?, attempts = attempts + 1
+        WHERE id = (
+            SELECT id FROM operations
+            WHERE (status = 'pending' AND (next_attempt_at IS NULL OR next_attempt_at <= ?))
+                OR (status = 'processing' AND lease_expires_at < ?)
+            ORDER BY lease_expires_at NULLS FIRST
+            LIMIT 1
+            `+lock+`
+        )
+        RETURNING id, task_id, parent_id, operator, left_id, right_id, left_value, right_value, attempts, next_attempt_at, last_error, no_cache
+    `), owner, expiresAt, now, now).Scan(&op.ID, &op.TaskID, &parentID, &op.Operator, &leftID, &rightID, &op.LeftValue, &op.RightValue,
+		&op.Attempts, &op.NextAttemptAt, &lastError, &op.NoCache)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	op.ParentID, op.LeftID, op.RightID, op.LastError = parentID.String, leftID.String, rightID.String, lastError.String
+
+	// Время начала фиксируется при захвате первой операции задачи
+	_, err = tx.ExecContext(ctx, tx.Rebind(`
+        UPDATE tasks SET status = 'processing', started_at = COALESCE(started_at, ?), agent_id = ?
+        WHERE id = ? AND status IN ('pending', 'processing')
+    `), now, owner, op.TaskID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &op, nil
+}
+
+func (s *Store) RenewLease(ctx context.Context, operationID, owner string, lease time.Duration) error {
+	res, err := s.db.ExecContext(ctx, s.db.Rebind(`
+        UPDATE operations SET lease_expires_at = ?
+        WHERE id = ? AND owner_agent = ? AND status = 'processing'
+    `), domain.Now().Add(lease), operationID, owner)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return domain.ErrLeaseLost
+	}
+	return nil
+}
+
+// finishOperation переводит операцию владельца owner из processing в status
+// и возвращает её задачу и родителя. Результат записывает только владелец аренды.
+func finishOperation(ctx context.Context, tx *sqlx.Tx, operationID, owner, status string, result sql.NullFloat64) (taskID string, parentID sql.NullString, err error) {
+	err = tx.QueryRowContext(ctx, tx.Rebind(`
+        UPDATE operations SET status = ?, result = ?, lease_expires_at = NULL
+        WHERE id = ? AND owner_agent = ? AND status = 'processing'
+        RETURNING task_id, parent_id
+    `), status, result, operationID, owner).Scan(&taskID, &parentID)
+	if err == sql.ErrNoRows {
+		return "", parentID, domain.ErrLeaseLost
+	}
+	return taskID, parentID, err
+}
+
+func (s *Store) CompleteOperation(ctx context.Context, operationID, owner string, result float64) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	taskID, parentID, err := finishOperation(ctx, tx, operationID, owner, domain.OperationCompleted,
+		sql.NullFloat64{Float64: result, Valid: true})
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, tx.Rebind("UPDATE agents SET completed_operations = completed_operations + 1 WHERE id = ?"), owner)
+	if err != nil {
+		return err
+	}
+
+	if parentID.Valid {
+		// Подставляем результат в родительскую операцию
+		_, err = tx.ExecContext(ctx, tx.Rebind(`
+            UPDATE operations SET
+                left_value = CASE WHEN left_id = ? THEN ? ELSE left_value END,
+                right_value = CASE WHEN right_id = ? THEN ? ELSE right_value END
+            WHERE id = ?
+        `), operationID, result, operationID, result, parentID.String)
+		if err != nil {
+			return err
+		}
+
+		// Родитель готов к вычислению, когда известны оба операнда
+		res, err := tx.ExecContext(ctx, tx.Rebind(`
+            UPDATE operations SET status = 'pending'
+            WHERE id = ? AND status = 'waiting' AND left_value IS NOT NULL AND right_value IS NOT NULL
+        `), parentID.String)
+		if err != nil {
+			return err
+		}
+		ready, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if ready > 0 {
+			if err := s.notifyReady(ctx, tx); err != nil {
+				return err
+			}
+		}
+	} else {
+		// Корневая операция: результат всего выражения
+		_, err = tx.ExecContext(ctx, tx.Rebind(`
+            UPDATE tasks SET result = ?, status = 'completed', finished_at = ?, agent_id = ?
+            WHERE id = ?
+        `), result, domain.Now(), owner, taskID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) FailOperation(ctx context.Context, operationID, owner, message string) error {
+	return s.failTask(ctx, operationID, owner, domain.OperationError, message)
+}
+
+func (s *Store) DeadLetterOperation(ctx context.Context, operationID, owner, message string) error {
+	return s.failTask(ctx, operationID, owner, domain.OperationDead, message)
+}
+
+// failTask завершает операцию со статусом status (error или dead) и
+// переводит в него же задачу и все её невычисленные операции
+func (s *Store) failTask(ctx context.Context, operationID, owner, status, message string) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	taskID, _, err := finishOperation(ctx, tx, operationID, owner, status, sql.NullFloat64{})
+	if err != nil {
+		return err
+	}
+
+	// Ошибка в любой операции делает бессмысленным вычисление остальных
+	_, err = tx.ExecContext(ctx, tx.Rebind("UPDATE operations SET status = ? WHERE task_id = ? AND status IN ('waiting', 'pending')"), status, taskID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, tx.Rebind(`
+        UPDATE tasks SET status = ?, error_message = ?, finished_at = ?, agent_id = ?
+        WHERE id = ?
+    `), status, message, domain.Now(), owner, taskID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) ReleaseOperation(ctx context.Context, operationID, owner string) error {
+	// Попытка, прерванная остановкой агента, не засчитывается
+	return s.requeueOperation(ctx, operationID, owner, true, "attempts = attempts - 1")
+}
+
+func (s *Store) RetryOperation(ctx context.Context, operationID, owner, message string, nextAttemptAt time.Time) error {
+	// Операция станет готовой только к nextAttemptAt, уведомлять агентов сейчас незачем
+	return s.requeueOperation(ctx, operationID, owner, false, "next_attempt_at = ?, last_error = ?", nextAttemptAt, message)
+}
+
+// requeueOperation возвращает операцию владельца owner в очередь, дополнительно
+// выполняя присваивания set с аргументами args. Задача возвращается в pending,
+// если других вычисляемых операций у неё нет. Если notify, агенты получают
+// уведомление о готовой операции.
+func (s *Store) requeueOperation(ctx context.Context, operationID, owner string, notify bool, set string, args ...interface{}) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var taskID string
+	err = tx.QueryRowContext(ctx, tx.Rebind(`
+        UPDATE operations SET status = 'pending', owner_agent = NULL, lease_expires_at = NULL, `+set+`
+        WHERE id = ? AND owner_agent = ? AND status = 'processing'
+        RETURNING task_id
+    `), append(args, operationID, owner)...).Scan(&taskID)
+	if err == sql.ErrNoRows {
+		return domain.ErrLeaseLost
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := requeueTask(ctx, tx, taskID); err != nil {
+		return err
+	}
+
+	if notify {
+		if err := s.notifyReady(ctx, tx); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// requeueTask возвращает задачу в pending, если у неё не осталось вычисляемых операций
+func requeueTask(ctx context.Context, tx *sqlx.Tx, taskID string) error {
+	_, err := tx.ExecContext(ctx, tx.Rebind(`
+        UPDATE tasks SET status = 'pending'
+        WHERE id = ? AND status = 'processing'
+            AND NOT EXISTS (SELECT 1 FROM operations WHERE task_id = ? AND status = 'processing')
+    `), taskID, taskID)
+	return err
+}
+
+func (s *Store) InitDurations(ctx context.Context, defaults map[string]int) error {
+	for operator, duration := range defaults {
+		_, err := s.db.ExecContext(ctx, s.db.Rebind("INSERT INTO settings (key, value, updated_at) VALUES (?, ?, ?) ON CONFLICT (key) DO NOTHING"),
+			domain.DurationKeyPrefix+operator, duration, domain.Now())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) GetCachedResult(ctx context.Context, key string) (float64, bool, error) {
+	var result float64
+	err := s.db.QueryRowContext(ctx, s.db.Rebind("SELECT result FROM result_cache WHERE cache_key = ? AND expires_at > ?"),
+		key, domain.Now()).Scan(&result)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return result, true, nil
+}
+
+func (s *Store) PutCachedResult(ctx context.Context, key string, result float64, expiresAt time.Time) error {
+	_, err := s.db.ExecContext(ctx, s.db.Rebind(`
+        INSERT INTO result_cache (cache_key, result, expires_at) VALUES (?, ?, ?)
+        ON CONFLICT (cache_key) DO UPDATE SET result = excluded.result, expires_at = excluded.expires_at
+    `), key, result, expiresAt)
+	return err
+}
+
+func (s *Store) DeleteExpiredResults(ctx context.Context, before time.Time) error {
+	_, err := s.db.ExecContext(ctx, s.db.Rebind("DELETE FROM result_cache WHERE expires_at < ?"), before)
+	return err
+}
+
+func (s *Store) GetDurations(ctx context.Context) (map[string]int, error) {
+	rows, err := s.db.QueryContext(ctx, s.db.Rebind("SELECT key, value FROM settings WHERE key LIKE ?"), domain.DurationKeyPrefix+"%")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	durations := make(map[string]int)
+	for rows.Next() {
+		var key string
+		var value int
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		durations[strings.TrimPrefix(key, domain.DurationKeyPrefix)] = value
+	}
+
+	return durations, rows.Err()
+}
+
+func (s *Store) SetDurations(ctx context.Context, durations map[string]int) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := domain.Now()
+	for operator, duration := range durations {
+		_, err := tx.ExecContext(ctx, tx.Rebind(`
+            INSERT INTO settings (key, value, updated_at) VALUES (?, ?, ?)
+            ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, updated_at = EXCLUDED.updated_at
+        `), domain.DurationKeyPrefix+operator, duration, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) RegisterAgent(ctx context.Context, agent domain.AgentInfo) error {
+	// Время регистрации и счетчик вычисленных операций известного агента сохраняются
+	now := domain.Now()
+	_, err := s.db.ExecContext(ctx, s.db.Rebind(`
+        INSERT INTO agents (id, hostname, workers, operators, version, status, registered_at, last_heartbeat)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT (id) DO UPDATE SET
+            hostname = EXCLUDED.hostname, workers = EXCLUDED.workers, operators = EXCLUDED.operators,
+            version = EXCLUDED.version, status = EXCLUDED.status, last_heartbeat = EXCLUDED.last_heartbeat
+    `), agent.ID, agent.Hostname, agent.Workers, strings.Join(agent.Operators, ","), agent.Version, domain.AgentOnline, now, now)
+	return err
+}
+
+func (s *Store) HeartbeatAgent(ctx context.Context, agentID string) error {
+	res, err := s.db.ExecContext(ctx, s.db.Rebind("UPDATE agents SET status = ?, last_heartbeat = ? WHERE id = ?"),
+		domain.AgentOnline, domain.Now(), agentID)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return domain.ErrAgentNotFound
+	}
+	return nil
+}
+
+func (s *Store) ListAgents(ctx context.Context) ([]domain.AgentInfo, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT id, hostname, workers, operators, version, status, registered_at, last_heartbeat, completed_operations
+        FROM agents
+        ORDER BY id
+    `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var agents []domain.AgentInfo
+	index := make(map[string]int)
+	for rows.Next() {
+		var agent domain.AgentInfo
+		var operators string
+		err := rows.Scan(&agent.ID, &agent.Hostname, &agent.Workers, &operators, &agent.Version, &agent.Status,
+			&agent.RegisteredAt, &agent.LastHeartbeat, &agent.CompletedOperations)
+		if err != nil {
+			return nil, err
+		}
+		if operators != "" {
+			agent.Operators = strings.Split(operators, ",")
+		}
+		index[agent.ID] = len(agents)
+		agents = append(agents, agent)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Задачи, операции которых агенты вычисляют сейчас
+	rows, err = s.db.QueryContext(ctx, `
+        SELECT DISTINCT owner_agent, task_id
+        FROM operations
+        WHERE status = 'processing' AND owner_agent IS NOT NULL
+        ORDER BY owner_agent, task_id
+    `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var owner, taskID string
+		if err := rows.Scan(&owner, &taskID); err != nil {
+			return nil, err
+		}
+		if i, ok := index[owner]; ok {
+			agents[i].CurrentTasks = append(agents[i].CurrentTasks, taskID)
+		}
+	}
+
+	return agents, rows.Err()
+}
+
+func (s *Store) ExpireAgents(ctx context.Context, before time.Time) ([]string, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var expired []string
+	err = tx.SelectContext(ctx, &expired, tx.Rebind(`
+        UPDATE agents SET status = ?
+        WHERE status = ? AND last_heartbeat < ?
+        RETURNING id
+    `), domain.AgentOffline, domain.AgentOnline, before)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(expired)
+
+	// Операции offline-агентов возвращаются в очередь; попытка засчитывается,
+	// как если бы истекла аренда
+	var taskIDs []string
+	err = tx.SelectContext(ctx, &taskIDs, tx.Rebind(`
+        UPDATE operations SET status = 'pending', owner_agent = NULL, lease_expires_at = NULL
+        WHERE status = 'processing' AND owner_agent IN (SELECT id FROM agents WHERE status = ?)
+        RETURNING task_id
+    `), domain.AgentOffline)
+	if err != nil {
+		return nil, err
+	}
+
+	requeued := make(map[string]bool)
+	for _, taskID := range taskIDs {
+		if requeued[taskID] {
+			continue
+		}
+		requeued[taskID] = true
+		if err := requeueTask(ctx, tx, taskID); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(taskIDs) > 0 {
+		if err := s.notifyReady(ctx, tx); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return expired, nil
+}
@@ -0,0 +1,126 @@
+package sqlstore_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Dadil/project/internal/orchestra/domain"
+	"github.com/Dadil/project/internal/storage/migrate"
+	"github.com/Dadil/project/internal/storage/sqlstore"
+	"github.com/Dadil/project/internal/storage/storetest"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+)
+
+// migrateUp создает схему базы миграциями
+func migrateUp(t *testing.T, db *sqlx.DB) {
+	t.Helper()
+	migrator, err := migrate.New(db)
+	require.NoError(t, err)
+	_, err = migrator.Up(context.Background())
+	require.NoError(t, err)
+}
+
+func TestSQLite(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) domain.Store {
+		db, err := sqlstore.OpenSQLite(filepath.Join(t.TempDir(), "calc.db"))
+		require.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+		migrateUp(t, db)
+		return sqlstore.New(db)
+	})
+}
+
+// TestPostgres запускается, только если задана TEST_POSTGRES_DSN, например
+// TEST_POSTGRES_DSN="host=localhost port=5433 user=postgres password=123456789 dbname=calc_test sslmode=disable".
+// Тест очищает таблицы базы, поэтому не указывайте рабочую базу.
+func TestPostgres(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN is not set")
+	}
+
+	storetest.Run(t, func(t *testing.T) domain.Store {
+		db, err := sqlx.Open(sqlstore.DriverPostgres, dsn)
+		require.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+
+		migrateUp(t, db)
+		_, err = db.Exec("TRUNCATE users, user_tasks, tasks, operations, settings, agents, refresh_tokens, revoked_tokens, idempotency_keys, revoked_sessions, result_cache RESTART IDENTITY CASCADE")
+		require.NoError(t, err)
+		return sqlstore.New(db)
+	})
+}
+
+// TestPostgres_Listen проверяет, что новая задача сразу приходит уведомлением
+func TestPostgres_Listen(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN is not set")
+	}
+
+	db, err := sqlx.Open(sqlstore.DriverPostgres, dsn)
+	require.NoError(t, err)
+	defer db.Close()
+	migrateUp(t, db)
+	_, err = db.Exec("TRUNCATE users, user_tasks, tasks, operations RESTART IDENTITY CASCADE")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	notifications, err := sqlstore.Listen(ctx, dsn)
+	require.NoError(t, err)
+
+	store := sqlstore.New(db)
+	require.NoError(t, store.CreateUser(ctx, "alice", "hash"))
+	operations, _, err := domain.DecomposeExpression("task-1", "2 + 3")
+	require.NoError(t, err)
+	task := domain.Task{ID: "task-1", Expression: "2 + 3", Status: "pending", CreatedAt: domain.Now()}
+	require.NoError(t, store.CreateTask(ctx, "alice", task, operations))
+
+	select {
+	case <-notifications:
+	case <-time.After(time.Second):
+		t.Fatal("No notification for the new task")
+	}
+
+	// После отмены контекста канал закрывается
+	cancel()
+	for range notifications {
+	}
+}
+
+func TestPostgres_ListenEvents(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN is not set")
+	}
+
+	db, err := sqlx.Open(sqlstore.DriverPostgres, dsn)
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := sqlstore.ListenEvents(ctx, dsn)
+	require.NoError(t, err)
+
+	// Событие, опубликованное агентом через базу, доходит до оркестратора
+	store := sqlstore.New(db)
+	require.NoError(t, store.PublishTaskEvent(ctx, domain.TaskEvent{TaskID: "task-1", Status: "processing", At: domain.Now()}))
+
+	select {
+	case event := <-events:
+		require.Equal(t, "task-1", event.TaskID)
+		require.Equal(t, "processing", event.Status)
+	case <-time.After(time.Second):
+		t.Fatal("No event from the database")
+	}
+
+	cancel()
+	for range events {
+	}
+}
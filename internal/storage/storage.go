@@ -0,0 +1,85 @@
+// Package storage выбирает хранилище оркестратора по конфигурации.
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Dadil/project/config"
+	"github.com/Dadil/project/internal/orchestra/domain"
+	"github.com/Dadil/project/internal/storage/memstore"
+	"github.com/Dadil/project/internal/storage/migrate"
+	"github.com/Dadil/project/internal/storage/sqlstore"
+	"github.com/jmoiron/sqlx"
+)
+
+// Store — хранилище, которое нужно закрыть после остановки сервиса
+type Store interface {
+	domain.Store
+	Close() error
+}
+
+// Open открывает хранилище из cfg.Storage и применяет к нему
+// непримененные миграции схемы
+func Open(ctx context.Context, cfg *config.Config) (Store, error) {
+	if cfg.Storage.Driver == config.StorageMemory {
+		return memstore.New(), nil
+	}
+
+	db, err := OpenDB(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	migrator, err := migrate.New(db)
+	if err == nil {
+		_, err = migrator.Up(ctx)
+	}
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
+
+	return sqlstore.New(db), nil
+}
+
+// Listen подписывается на уведомления о готовых операциях. Уведомления есть
+// только у PostgreSQL; для остальных хранилищ возвращается nil, и агенты
+// находят операции опросом.
+func Listen(ctx context.Context, cfg *config.Config) (<-chan struct{}, error) {
+	if cfg.Storage.Driver != config.StoragePostgres {
+		return nil, nil
+	}
+	return sqlstore.Listen(ctx, cfg.Database.DSN())
+}
+
+// ListenEvents подписывается на события задач, которые публикуют агенты,
+// работающие с PostgreSQL напрямую. Для остальных хранилищ возвращается nil.
+func ListenEvents(ctx context.Context, cfg *config.Config) (<-chan domain.TaskEvent, error) {
+	if cfg.Storage.Driver != config.StoragePostgres {
+		return nil, nil
+	}
+	return sqlstore.ListenEvents(ctx, cfg.Database.DSN())
+}
+
+// OpenDB подключается к базе PostgreSQL или SQLite из cfg.Storage без миграций
+func OpenDB(ctx context.Context, cfg *config.Config) (*sqlx.DB, error) {
+	switch cfg.Storage.Driver {
+	case config.StoragePostgres:
+		db, err := config.NewPostgreSQLDB(cfg.Database)
+		if err != nil {
+			return nil, err
+		}
+		if err := db.PingContext(ctx); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to connect to PostgreSQL: %w", err)
+		}
+		return sqlx.NewDb(db, sqlstore.DriverPostgres), nil
+	case config.StorageSQLite:
+		return sqlstore.OpenSQLite(cfg.Storage.SQLitePath)
+	case config.StorageMemory:
+		return nil, fmt.Errorf("storage driver %q has no database", cfg.Storage.Driver)
+	default:
+		return nil, fmt.Errorf("unknown storage driver: %q", cfg.Storage.Driver)
+	}
+}
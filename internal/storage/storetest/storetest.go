@@ -0,0 +1,1103 @@
+// Package storetest — общий набор тестов, который должна проходить каждая
+// реализация domain.Store. Хранилища подключают его в своих тестах:
+//
+//	storetest.Run(t, func(t *testing.T) domain.Store { return memstore.New() })
+package storetest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Dadil/project/internal/orchestra/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Factory создает пустое хранилище для одного теста
+type Factory func(t *testing.T) domain.Store
+
+const lease = time.Minute
+
+// Run запускает все проверки набора на хранилищах из newStore
+func Run(t *testing.T, newStore Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, store domain.Store)
+	}{
+		{"Users", testUsers},
+		{"UserRoles", testUserRoles},
+		{"DenyUserSessions", testDenyUserSessions},
+		{"LoginLockout", testLoginLockout},
+		{"Tasks", testTasks},
+		{"CreateTasks", testCreateTasks},
+		{"TaskListing", testTaskListing},
+		{"IdempotencyKeys", testIdempotencyKeys},
+		{"DeleteTasksForUser", testDeleteTasksForUser},
+		{"EvaluateGraph", testEvaluateGraph},
+		{"LeaseExpiry", testLeaseExpiry},
+		{"FailOperation", testFailOperation},
+		{"ReleaseOperation", testReleaseOperation},
+		{"NoCache", testNoCache},
+		{"GetOperation", testGetOperation},
+		{"SharedResults", testSharedResults},
+		{"CancelTask", testCancelTask},
+		{"RetryOperation", testRetryOperation},
+		{"DeadLetter", testDeadLetter},
+		{"ConcurrentClaims", testConcurrentClaims},
+		{"Durations", testDurations},
+		{"Agents", testAgents},
+		{"ExpireAgents", testExpireAgents},
+		{"RefreshTokens", testRefreshTokens},
+		{"RefreshTokenReuse", testRefreshTokenReuse},
+		{"AccessTokenDenylist", testAccessTokenDenylist},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStore(t))
+		})
+	}
+}
+
+// createTask сохраняет задачу с графом операций выражения и возвращает операции
+func createTask(t *testing.T, store domain.Store, login, taskID, expr string) []domain.Operation {
+	t.Helper()
+
+	operations, _, err := domain.DecomposeExpression(taskID, expr)
+	require.NoError(t, err)
+	task := domain.Task{ID: taskID, Expression: expr, Status: "pending", CreatedAt: domain.Now()}
+	require.NoError(t, store.CreateTask(context.Background(), login, task, operations))
+	return operations
+}
+
+func createUser(t *testing.T, store domain.Store, login string) {
+	t.Helper()
+	require.NoError(t, store.CreateUser(context.Background(), login, "hash-"+login))
+}
+
+func getTask(t *testing.T, store domain.Store, login, taskID string) *domain.Task {
+	t.Helper()
+	task, err := store.GetTaskForUser(context.Background(), login, taskID)
+	require.NoError(t, err)
+	return task
+}
+
+func testUsers(t *testing.T, store domain.Store) {
+	ctx := context.Background()
+
+	createUser(t, store, "alice")
+
+	user, err := store.GetUserByLogin(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", user.Login)
+	assert.Equal(t, "hash-alice", user.Password)
+
+	assert.ErrorIs(t, store.CreateUser(ctx, "alice", "other"), domain.ErrUserExists)
+
+	_, err = store.GetUserByLogin(ctx, "bob")
+	assert.ErrorIs(t, err, domain.ErrUserNotFound)
+}
+
+func testUserRoles(t *testing.T, store domain.Store) {
+	ctx := context.Background()
+	createUser(t, store, "bob")
+	createUser(t, store, "alice")
+
+	user, err := store.GetUserByLogin(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, domain.RoleUser, user.Role)
+	assert.False(t, user.Disabled)
+
+	require.NoError(t, store.SetUserRole(ctx, "alice", domain.RoleAdmin))
+	assert.ErrorIs(t, store.SetUserRole(ctx, "carol", domain.RoleAdmin), domain.ErrUserNotFound)
+
+	// Отключение отзывает сессии пользователя
+	createRefreshToken(t, store, "bob", "h1", "s1", time.Hour)
+	require.NoError(t, store.SetUserDisabled(ctx, "bob", true))
+	assert.ErrorIs(t, store.SetUserDisabled(ctx, "carol", true), domain.ErrUserNotFound)
+	_, err = store.RotateRefreshToken(ctx, "h1", "h2", domain.Now().Add(time.Hour))
+	assert.ErrorIs(t, err, domain.ErrTokenReused)
+
+	users, err := store.ListUsers(ctx)
+	require.NoError(t, err)
+	require.Len(t, users, 2)
+	assert.Equal(t, "alice", users[0].Login)
+	assert.Equal(t, domain.RoleAdmin, users[0].Role)
+	assert.Equal(t, "bob", users[1].Login)
+	assert.True(t, users[1].Disabled)
+
+	require.NoError(t, store.SetUserDisabled(ctx, "bob", false))
+	user, err = store.GetUserByLogin(ctx, "bob")
+	require.NoError(t, err)
+	assert.False(t, user.Disabled)
+}
+
+func testDenyUserSessions(t *testing.T, store domain.Store) {
+	ctx := context.Background()
+	createUser(t, store, "bob")
+	createUser(t, store, "alice")
+
+	// У сессии s1 несколько refresh-токенов после обновления, у bob две сессии
+	createRefreshToken(t, store, "bob", "h1", "s1", time.Hour)
+	_, err := store.RotateRefreshToken(ctx, "h1", "h2", domain.Now().Add(time.Hour))
+	require.NoError(t, err)
+	createRefreshToken(t, store, "bob", "h3", "s2", time.Hour)
+	createRefreshToken(t, store, "alice", "h4", "s3", time.Hour)
+
+	// Запрет сессий пользователя действует на access-токены всех его сессий
+	require.NoError(t, store.DenyUserSessions(ctx, "bob", domain.Now().Add(time.Hour)))
+	for sessionID, expected := range map[string]bool{"s1": true, "s2": true, "s3": false} {
+		denied, err := store.IsAccessTokenDenied(ctx, "jti", sessionID)
+		require.NoError(t, err)
+		assert.Equal(t, expected, denied, sessionID)
+	}
+
+	// Повторный запрет с меньшим сроком не сокращает прежний
+	require.NoError(t, store.DenyUserSessions(ctx, "bob", domain.Now().Add(-time.Minute)))
+	require.NoError(t, store.DeleteExpiredTokens(ctx, domain.Now()))
+	denied, err := store.IsAccessTokenDenied(ctx, "jti", "s2")
+	require.NoError(t, err)
+	assert.True(t, denied)
+
+	// Для неизвестного пользователя запрещать нечего
+	require.NoError(t, store.DenyUserSessions(ctx, "carol", domain.Now().Add(time.Hour)))
+}
+
+func testLoginLockout(t *testing.T, store domain.Store) {
+	ctx := context.Background()
+	createUser(t, store, "alice")
+
+	require.NoError(t, store.RecordLoginFailure(ctx, "alice", 3, time.Hour))
+	require.NoError(t, store.RecordLoginFailure(ctx, "alice", 3, time.Hour))
+	user, err := store.GetUserByLogin(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, 2, user.FailedLogins)
+	assert.Nil(t, user.LockedUntil)
+
+	// Третья неудача подряд блокирует вход и обнуляет счетчик
+	require.NoError(t, store.RecordLoginFailure(ctx, "alice", 3, time.Hour))
+	user, err = store.GetUserByLogin(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, 0, user.FailedLogins)
+	require.NotNil(t, user.LockedUntil)
+	assert.WithinDuration(t, domain.Now().Add(time.Hour), *user.LockedUntil, time.Minute)
+
+	require.NoError(t, store.ResetLoginFailures(ctx, "alice"))
+	user, err = store.GetUserByLogin(ctx, "alice")
+	require.NoError(t, err)
+	assert.Nil(t, user.LockedUntil)
+
+	assert.ErrorIs(t, store.RecordLoginFailure(ctx, "bob", 3, time.Hour), domain.ErrUserNotFound)
+	assert.ErrorIs(t, store.ResetLoginFailures(ctx, "bob"), domain.ErrUserNotFound)
+}
+
+func testTasks(t *testing.T, store domain.Store) {
+	ctx := context.Background()
+	createUser(t, store, "alice")
+	createUser(t, store, "bob")
+
+	createTask(t, store, "alice", "task-1", "2 + 3")
+	createTask(t, store, "alice", "task-2", "4 * 5")
+	createTask(t, store, "bob", "task-3", "1 - 1")
+
+	task := getTask(t, store, "alice", "task-1")
+	assert.Equal(t, "2 + 3", task.Expression)
+	assert.Equal(t, "pending", task.Status)
+	assert.False(t, task.CreatedAt.IsZero())
+	assert.Nil(t, task.StartedAt)
+	assert.Nil(t, task.FinishedAt)
+
+	tasks, err := store.ListTasksForUser(ctx, "alice")
+	require.NoError(t, err)
+	require.Len(t, tasks, 2)
+	assert.ElementsMatch(t, []string{"task-1", "task-2"}, []string{tasks[0].ID, tasks[1].ID})
+
+	all, err := store.ListTasks(ctx)
+	require.NoError(t, err)
+	assert.Len(t, all, 3)
+
+	// Чужая задача неотличима от несуществующей
+	_, err = store.GetTaskForUser(ctx, "bob", "task-1")
+	assert.ErrorIs(t, err, domain.ErrTaskNotFound)
+	_, err = store.GetTaskForUser(ctx, "alice", "missing")
+	assert.ErrorIs(t, err, domain.ErrTaskNotFound)
+
+	// Владелец задачи известен без логина; у задачи без владельца его нет
+	owner, err := store.GetTaskOwner(ctx, "task-3")
+	require.NoError(t, err)
+	assert.Equal(t, "bob", owner)
+	createTask(t, store, "", "task-6", "6 / 2")
+	_, err = store.GetTaskOwner(ctx, "task-6")
+	assert.ErrorIs(t, err, domain.ErrTaskNotFound)
+	_, err = store.GetTaskOwner(ctx, "missing")
+	assert.ErrorIs(t, err, domain.ErrTaskNotFound)
+
+	err = store.CreateTask(ctx, "carol", domain.Task{ID: "task-4", Expression: "1 + 1", Status: "pending", CreatedAt: domain.Now()}, nil)
+	assert.ErrorIs(t, err, domain.ErrUserNotFound)
+
+	// Вычисленная сразу задача хранит время начала и окончания, а результат —
+	// без потери точности
+	now := domain.Now()
+	literal := domain.Task{ID: "task-5", Expression: "-123456789.125", Status: "completed", Result: -123456789.125, CreatedAt: now, StartedAt: &now, FinishedAt: &now}
+	require.NoError(t, store.CreateTask(ctx, "alice", literal, nil))
+	task = getTask(t, store, "alice", "task-5")
+	assert.Equal(t, -123456789.125, task.Result)
+	require.NotNil(t, task.FinishedAt)
+	assert.True(t, now.Equal(*task.FinishedAt))
+}
+
+func testCreateTasks(t *testing.T, store domain.Store) {
+	ctx := context.Background()
+	createUser(t, store, "alice")
+
+	var tasks []domain.NewTask
+	for i, expr := range []string{"1 + 2", "3 * 4", "7"} {
+		taskID := fmt.Sprintf("task-%d", i+1)
+		operations, _, err := domain.DecomposeExpression(taskID, expr)
+		require.NoError(t, err)
+		tasks = append(tasks, domain.NewTask{
+			Task:       domain.Task{ID: taskID, Expression: expr, Status: "pending", CreatedAt: domain.Now()},
+			Operations: operations,
+		})
+	}
+
+	// Для неизвестного пользователя не сохраняется ни одна задача
+	assert.ErrorIs(t, store.CreateTasks(ctx, "bob", tasks), domain.ErrUserNotFound)
+	all, err := store.ListTasks(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, all)
+
+	require.NoError(t, store.CreateTasks(ctx, "alice", tasks))
+	listed, err := store.ListTasksForUser(ctx, "alice")
+	require.NoError(t, err)
+	assert.Len(t, listed, 3)
+
+	// Операции всех задач пакета доступны агентам
+	claimed := map[string]bool{}
+	for {
+		op, err := store.ClaimOperation(ctx, "agent-1", lease)
+		require.NoError(t, err)
+		if op == nil {
+			break
+		}
+		claimed[op.TaskID] = true
+	}
+	assert.Equal(t, map[string]bool{"task-1": true, "task-2": true}, claimed)
+
+	// Большой пакет не упирается в ограничение на число параметров запроса
+	const batchSize = 250
+	tasks = nil
+	for i := 0; i < batchSize; i++ {
+		taskID := fmt.Sprintf("batch-%d", i)
+		operations, _, err := domain.DecomposeExpression(taskID, "1 + 2")
+		require.NoError(t, err)
+		tasks = append(tasks, domain.NewTask{
+			Task:       domain.Task{ID: taskID, Expression: "1 + 2", Status: "pending", CreatedAt: domain.Now()},
+			Operations: operations,
+		})
+	}
+	require.NoError(t, store.CreateTasks(ctx, "alice", tasks))
+	listed, err = store.ListTasksForUser(ctx, "alice")
+	require.NoError(t, err)
+	assert.Len(t, listed, 3+batchSize)
+	claimed = map[string]bool{}
+	for {
+		op, err := store.ClaimOperation(ctx, "agent-1", lease)
+		require.NoError(t, err)
+		if op == nil {
+			break
+		}
+		claimed[op.TaskID] = true
+	}
+	assert.Len(t, claimed, batchSize)
+}
+
+func testIdempotencyKeys(t *testing.T, store domain.Store) {
+	ctx := context.Background()
+	createUser(t, store, "alice")
+	createUser(t, store, "bob")
+
+	newKey := func(key, login, taskID string, ttl time.Duration) domain.IdempotencyKey {
+		now := domain.Now()
+		return domain.IdempotencyKey{
+			Key: key, Login: login, Expression: "2 + 3", TaskID: taskID,
+			CreatedAt: now, ExpiresAt: now.Add(ttl),
+		}
+	}
+	newTask := func(taskID string) (domain.Task, []domain.Operation) {
+		operations, _, err := domain.DecomposeExpression(taskID, "2 + 3")
+		require.NoError(t, err)
+		return domain.Task{ID: taskID, Expression: "2 + 3", Status: "pending", CreatedAt: domain.Now()}, operations
+	}
+
+	_, err := store.GetIdempotencyKey(ctx, "alice", "key-1")
+	assert.ErrorIs(t, err, domain.ErrIdempotencyKeyNotFound)
+
+	task, operations := newTask("task-1")
+	require.NoError(t, store.CreateTaskWithKey(ctx, "alice", newKey("key-1", "alice", "task-1", time.Hour), task, operations))
+	key, err := store.GetIdempotencyKey(ctx, "alice", "key-1")
+	require.NoError(t, err)
+	assert.Equal(t, "task-1", key.TaskID)
+	assert.Equal(t, "2 + 3", key.Expression)
+	getTask(t, store, "alice", "task-1")
+
+	// Занятый ключ не создает задачу; у другого пользователя ключи свои
+	task, operations = newTask("task-2")
+	err = store.CreateTaskWithKey(ctx, "alice", newKey("key-1", "alice", "task-2", time.Hour), task, operations)
+	assert.ErrorIs(t, err, domain.ErrIdempotencyKeyExists)
+	_, err = store.GetTaskForUser(ctx, "alice", "task-2")
+	assert.ErrorIs(t, err, domain.ErrTaskNotFound)
+	_, err = store.GetIdempotencyKey(ctx, "bob", "key-1")
+	assert.ErrorIs(t, err, domain.ErrIdempotencyKeyNotFound)
+	require.NoError(t, store.CreateTaskWithKey(ctx, "bob", newKey("key-1", "bob", "task-2", time.Hour), task, operations))
+
+	// Истекший ключ не возвращается и может быть занят заново
+	task, operations = newTask("task-3")
+	require.NoError(t, store.CreateTaskWithKey(ctx, "alice", newKey("key-2", "alice", "task-3", -time.Second), task, operations))
+	_, err = store.GetIdempotencyKey(ctx, "alice", "key-2")
+	assert.ErrorIs(t, err, domain.ErrIdempotencyKeyNotFound)
+	task, operations = newTask("task-4")
+	require.NoError(t, store.CreateTaskWithKey(ctx, "alice", newKey("key-2", "alice", "task-4", time.Hour), task, operations))
+	key, err = store.GetIdempotencyKey(ctx, "alice", "key-2")
+	require.NoError(t, err)
+	assert.Equal(t, "task-4", key.TaskID)
+
+	task, operations = newTask("task-5")
+	require.NoError(t, store.CreateTaskWithKey(ctx, "alice", newKey("key-3", "alice", "task-5", -time.Second), task, operations))
+	require.NoError(t, store.DeleteExpiredIdempotencyKeys(ctx, domain.Now()))
+	_, err = store.GetIdempotencyKey(ctx, "alice", "key-1")
+	assert.NoError(t, err)
+
+	// Ключи удаляются вместе с задачами пользователя
+	require.NoError(t, store.DeleteTasksForUser(ctx, "alice"))
+	_, err = store.GetIdempotencyKey(ctx, "alice", "key-1")
+	assert.ErrorIs(t, err, domain.ErrIdempotencyKeyNotFound)
+	_, err = store.GetIdempotencyKey(ctx, "bob", "key-1")
+	assert.NoError(t, err)
+}
+
+// listTaskIDs проходит все страницы списка по limit задач и возвращает ID
+func listTaskIDs(t *testing.T, store domain.Store, query domain.TaskQuery, limit int) []string {
+	t.Helper()
+
+	var ids []string
+	query.Limit = limit
+	for {
+		tasks, total, err := store.QueryTasksForUser(context.Background(), "alice", query)
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(tasks), limit)
+		for _, task := range tasks {
+			ids = append(ids, task.ID)
+		}
+		if len(tasks) < limit {
+			assert.Equal(t, len(ids), total, "total counts tasks on all pages")
+			return ids
+		}
+		last := tasks[len(tasks)-1]
+		query.After = &domain.TaskCursor{Value: domain.TaskSortKey(last, query.Sort), ID: last.ID}
+	}
+}
+
+func testTaskListing(t *testing.T, store domain.Store) {
+	ctx := context.Background()
+	createUser(t, store, "alice")
+	createUser(t, store, "bob")
+
+	base := domain.Now()
+	at := func(seconds int) *time.Time {
+		moment := base.Add(time.Duration(seconds) * time.Second)
+		return &moment
+	}
+	// Равные время создания у task-2 и task-3 и время завершения у task-4
+	// и task-5 упорядочиваются по ID
+	tasks := []domain.Task{
+		{ID: "task-1", Expression: "1 + 2", Status: "completed", Result: 3, CreatedAt: *at(0), FinishedAt: at(5)},
+		{ID: "task-2", Expression: "0.05 + 0.05", Status: "completed", Result: 0.1, CreatedAt: *at(1), FinishedAt: at(3)},
+		{ID: "task-3", Expression: "2 * 2", Status: "pending", CreatedAt: *at(1)},
+		{ID: "task-4", Expression: "1 / 0", Status: "error", CreatedAt: *at(2), FinishedAt: at(4)},
+		{ID: "task-5", Expression: "2 - 3", Status: "completed", Result: -1, CreatedAt: *at(3), FinishedAt: at(4)},
+	}
+	for _, task := range tasks {
+		require.NoError(t, store.CreateTask(ctx, "alice", task, nil))
+	}
+	require.NoError(t, store.CreateTask(ctx, "bob", domain.Task{ID: "task-6", Expression: "1 + 1", Status: "pending", CreatedAt: *at(0)}, nil))
+
+	// Задачи без времени завершения или результата идут в конце
+	orders := []struct {
+		sort string
+		desc bool
+		want []string
+	}{
+		{domain.SortByCreated, false, []string{"task-1", "task-2", "task-3", "task-4", "task-5"}},
+		{domain.SortByCreated, true, []string{"task-5", "task-4", "task-3", "task-2", "task-1"}},
+		{domain.SortByFinished, false, []string{"task-2", "task-4", "task-5", "task-1", "task-3"}},
+		{domain.SortByFinished, true, []string{"task-1", "task-5", "task-4", "task-2", "task-3"}},
+		{domain.SortByResult, false, []string{"task-5", "task-2", "task-1", "task-3", "task-4"}},
+		{domain.SortByResult, true, []string{"task-1", "task-2", "task-5", "task-4", "task-3"}},
+	}
+	for _, order := range orders {
+		query := domain.TaskQuery{Sort: order.sort, Desc: order.desc}
+		for _, limit := range []int{1, 2, 10} {
+			assert.Equal(t, order.want, listTaskIDs(t, store, query, limit), "sort %s desc %v limit %d", order.sort, order.desc, limit)
+		}
+	}
+
+	filters := []struct {
+		filter domain.TaskFilter
+		want   []string
+	}{
+		{domain.TaskFilter{Status: "completed"}, []string{"task-1", "task-2", "task-5"}},
+		{domain.TaskFilter{CreatedFrom: at(1), CreatedTo: at(3)}, []string{"task-2", "task-3", "task-4"}},
+		{domain.TaskFilter{Expression: "+"}, []string{"task-1", "task-2"}},
+		{domain.TaskFilter{Expression: "0.05", Status: "completed"}, []string{"task-2"}},
+		// Спецсимволы LIKE ищутся буквально
+		{domain.TaskFilter{Expression: "%"}, nil},
+		{domain.TaskFilter{Expression: "_"}, nil},
+	}
+	for _, filter := range filters {
+		query := domain.TaskQuery{TaskFilter: filter.filter, Sort: domain.SortByCreated}
+		assert.Equal(t, filter.want, listTaskIDs(t, store, query, 2), "filter %+v", filter.filter)
+	}
+
+	// Общее число не зависит от курсора
+	query := domain.TaskQuery{Sort: domain.SortByCreated, Limit: 10, After: &domain.TaskCursor{Value: *at(1), ID: "task-2"}}
+	page, total, err := store.QueryTasksForUser(ctx, "alice", query)
+	require.NoError(t, err)
+	assert.Len(t, page, 3)
+	assert.Equal(t, 5, total)
+
+	page, total, err = store.QueryTasksForUser(ctx, "carol", domain.TaskQuery{Sort: domain.SortByCreated, Limit: 10})
+	require.NoError(t, err)
+	assert.Empty(t, page)
+	assert.Zero(t, total)
+}
+
+func testDeleteTasksForUser(t *testing.T, store domain.Store) {
+	ctx := context.Background()
+	createUser(t, store, "alice")
+	createUser(t, store, "bob")
+	createTask(t, store, "alice", "task-1", "2 + 3")
+	createTask(t, store, "bob", "task-2", "4 * 5")
+
+	require.NoError(t, store.DeleteTasksForUser(ctx, "alice"))
+
+	tasks, err := store.ListTasksForUser(ctx, "alice")
+	require.NoError(t, err)
+	assert.Empty(t, tasks)
+
+	// Операции удаленной задачи больше не выдаются агентам
+	op, err := store.ClaimOperation(ctx, "agent-1", lease)
+	require.NoError(t, err)
+	require.NotNil(t, op)
+	assert.Equal(t, "task-2", op.TaskID)
+
+	tasks, err = store.ListTasksForUser(ctx, "bob")
+	require.NoError(t, err)
+	assert.Len(t, tasks, 1)
+}
+
+func testEvaluateGraph(t *testing.T, store domain.Store) {
+	ctx := context.Background()
+	createUser(t, store, "alice")
+	createTask(t, store, "alice", "task-1", "(1 + 2) * (3 + 4)")
+
+	// Сложения независимы и выдаются разным агентам одновременно
+	first, err := store.ClaimOperation(ctx, "agent-1", lease)
+	require.NoError(t, err)
+	require.NotNil(t, first)
+	second, err := store.ClaimOperation(ctx, "agent-2", lease)
+	require.NoError(t, err)
+	require.NotNil(t, second)
+	assert.Equal(t, "+", first.Operator)
+	assert.Equal(t, "+", second.Operator)
+	assert.Equal(t, "agent-1", first.OwnerAgent)
+	assert.NotNil(t, first.LeaseExpiresAt)
+
+	// Умножение ждет результатов обоих сложений
+	none, err := store.ClaimOperation(ctx, "agent-3", lease)
+	require.NoError(t, err)
+	assert.Nil(t, none)
+
+	task := getTask(t, store, "alice", "task-1")
+	assert.Equal(t, "processing", task.Status)
+	assert.NotNil(t, task.StartedAt)
+
+	for _, op := range []struct {
+		op    *domain.Operation
+		owner string
+	}{{first, "agent-1"}, {second, "agent-2"}} {
+		result := *op.op.LeftValue + *op.op.RightValue
+		require.NoError(t, store.CompleteOperation(ctx, op.op.ID, op.owner, result))
+	}
+
+	root, err := store.ClaimOperation(ctx, "agent-3", lease)
+	require.NoError(t, err)
+	require.NotNil(t, root)
+	assert.Equal(t, "*", root.Operator)
+	assert.Empty(t, root.ParentID)
+	require.NotNil(t, root.LeftValue)
+	require.NotNil(t, root.RightValue)
+	assert.Equal(t, 3.0, *root.LeftValue)
+	assert.Equal(t, 7.0, *root.RightValue)
+
+	require.NoError(t, store.CompleteOperation(ctx, root.ID, "agent-3", 21))
+
+	task = getTask(t, store, "alice", "task-1")
+	assert.Equal(t, "completed", task.Status)
+	assert.Equal(t, 21.0, task.Result)
+	assert.Equal(t, "agent-3", task.AgentID)
+	assert.NotNil(t, task.FinishedAt)
+
+	none, err = store.ClaimOperation(ctx, "agent-1", lease)
+	require.NoError(t, err)
+	assert.Nil(t, none)
+}
+
+func testLeaseExpiry(t *testing.T, store domain.Store) {
+	ctx := context.Background()
+	createUser(t, store, "alice")
+	createTask(t, store, "alice", "task-1", "2 + 3")
+
+	op, err := store.ClaimOperation(ctx, "agent-1", 200*time.Millisecond)
+	require.NoError(t, err)
+	require.NotNil(t, op)
+
+	// Пока аренда действует, операцию никто не заберет
+	none, err := store.ClaimOperation(ctx, "agent-2", lease)
+	require.NoError(t, err)
+	assert.Nil(t, none)
+
+	time.Sleep(300 * time.Millisecond)
+
+	reclaimed, err := store.ClaimOperation(ctx, "agent-2", lease)
+	require.NoError(t, err)
+	require.NotNil(t, reclaimed)
+	assert.Equal(t, op.ID, reclaimed.ID)
+	assert.Equal(t, "agent-2", reclaimed.OwnerAgent)
+
+	// Прежний владелец больше не может ни продлить аренду, ни записать результат
+	assert.ErrorIs(t, store.RenewLease(ctx, op.ID, "agent-1", lease), domain.ErrLeaseLost)
+	assert.ErrorIs(t, store.CompleteOperation(ctx, op.ID, "agent-1", 5), domain.ErrLeaseLost)
+	assert.ErrorIs(t, store.FailOperation(ctx, op.ID, "agent-1", "late"), domain.ErrLeaseLost)
+	assert.ErrorIs(t, store.ReleaseOperation(ctx, op.ID, "agent-1"), domain.ErrLeaseLost)
+
+	require.NoError(t, store.RenewLease(ctx, op.ID, "agent-2", lease))
+	require.NoError(t, store.CompleteOperation(ctx, op.ID, "agent-2", 5))
+
+	task := getTask(t, store, "alice", "task-1")
+	assert.Equal(t, "completed", task.Status)
+	assert.Equal(t, 5.0, task.Result)
+	assert.Equal(t, "agent-2", task.AgentID)
+}
+
+func testFailOperation(t *testing.T, store domain.Store) {
+	ctx := context.Background()
+	createUser(t, store, "alice")
+	createTask(t, store, "alice", "task-1", "1 / 0 + 2")
+
+	op, err := store.ClaimOperation(ctx, "agent-1", lease)
+	require.NoError(t, err)
+	require.NotNil(t, op)
+	assert.Equal(t, "/", op.Operator)
+
+	require.NoError(t, store.FailOperation(ctx, op.ID, "agent-1", "division by zero"))
+
+	task := getTask(t, store, "alice", "task-1")
+	assert.Equal(t, "error", task.Status)
+	assert.Equal(t, "division by zero", task.ErrorMessage)
+	assert.NotNil(t, task.FinishedAt)
+
+	// Остальные операции задачи больше не вычисляются
+	none, err := store.ClaimOperation(ctx, "agent-2", lease)
+	require.NoError(t, err)
+	assert.Nil(t, none)
+}
+
+func testReleaseOperation(t *testing.T, store domain.Store) {
+	ctx := context.Background()
+	createUser(t, store, "alice")
+	createTask(t, store, "alice", "task-1", "2 + 3")
+
+	op, err := store.ClaimOperation(ctx, "agent-1", lease)
+	require.NoError(t, err)
+	require.NotNil(t, op)
+
+	require.NoError(t, store.ReleaseOperation(ctx, op.ID, "agent-1"))
+
+	task := getTask(t, store, "alice", "task-1")
+	assert.Equal(t, "pending", task.Status)
+
+	// Возвращенную операцию сразу забирает другой агент, прерванная попытка не засчитывается
+	reclaimed, err := store.ClaimOperation(ctx, "agent-2", lease)
+	require.NoError(t, err)
+	require.NotNil(t, reclaimed)
+	assert.Equal(t, op.ID, reclaimed.ID)
+	assert.Equal(t, 1, reclaimed.Attempts)
+}
+
+func testNoCache(t *testing.T, store domain.Store) {
+	ctx := context.Background()
+	createUser(t, store, "alice")
+
+	operations, _, err := domain.DecomposeExpression("task-1", "2 + 3")
+	require.NoError(t, err)
+	operations[0].NoCache = true
+	task := domain.Task{ID: "task-1", Expression: "2 + 3", Status: "pending", CreatedAt: domain.Now()}
+	require.NoError(t, store.CreateTask(ctx, "alice", task, operations))
+	createTask(t, store, "alice", "task-2", "4 + 5")
+
+	// Отказ от кеша доходит до агента вместе с операцией
+	noCache := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		op, err := store.ClaimOperation(ctx, "agent-1", lease)
+		require.NoError(t, err)
+		require.NotNil(t, op)
+		noCache[op.TaskID] = op.NoCache
+	}
+	assert.Equal(t, map[string]bool{"task-1": true, "task-2": false}, noCache)
+}
+
+func testGetOperation(t *testing.T, store domain.Store) {
+	ctx := context.Background()
+	createUser(t, store, "alice")
+	createTask(t, store, "alice", "task-1", "2 + 3")
+
+	_, err := store.GetOperation(ctx, "missing")
+	assert.ErrorIs(t, err, domain.ErrOperationNotFound)
+
+	claimed, err := store.ClaimOperation(ctx, "agent-1", lease)
+	require.NoError(t, err)
+	require.NotNil(t, claimed)
+	require.NoError(t, store.CompleteOperation(ctx, claimed.ID, "agent-1", 5))
+
+	// Завершенная операция сохраняет операнды и результат
+	op, err := store.GetOperation(ctx, claimed.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "task-1", op.TaskID)
+	assert.Equal(t, "+", op.Operator)
+	require.NotNil(t, op.LeftValue)
+	require.NotNil(t, op.RightValue)
+	assert.Equal(t, 2.0, *op.LeftValue)
+	assert.Equal(t, 3.0, *op.RightValue)
+	assert.Equal(t, domain.OperationCompleted, op.Status)
+	assert.Equal(t, 5.0, op.Result)
+	assert.Equal(t, 1, op.Attempts)
+}
+
+func testSharedResults(t *testing.T, store domain.Store) {
+	ctx := context.Background()
+
+	_, ok, err := store.GetCachedResult(ctx, "v:2+3")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, store.PutCachedResult(ctx, "v:2+3", 5, domain.Now().Add(time.Hour)))
+	result, ok, err := store.GetCachedResult(ctx, "v:2+3")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 5.0, result)
+
+	// Повторная запись заменяет результат и срок
+	require.NoError(t, store.PutCachedResult(ctx, "v:2+3", 6, domain.Now().Add(-time.Second)))
+	_, ok, err = store.GetCachedResult(ctx, "v:2+3")
+	require.NoError(t, err)
+	assert.False(t, ok, "expired entry must not be returned")
+
+	require.NoError(t, store.PutCachedResult(ctx, "v:4+5", 9, domain.Now().Add(time.Hour)))
+	require.NoError(t, store.DeleteExpiredResults(ctx, domain.Now()))
+	result, ok, err = store.GetCachedResult(ctx, "v:4+5")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 9.0, result)
+}
+
+func testRetryOperation(t *testing.T, store domain.Store) {
+	ctx := context.Background()
+	createUser(t, store, "alice")
+	createTask(t, store, "alice", "task-1", "2 + 3")
+
+	op, err := store.ClaimOperation(ctx, "agent-1", lease)
+	require.NoError(t, err)
+	require.NotNil(t, op)
+	assert.Equal(t, 1, op.Attempts)
+
+	assert.ErrorIs(t, store.RetryOperation(ctx, op.ID, "agent-2", "boom", domain.Now()), domain.ErrLeaseLost)
+
+	// Время повтора уже наступило: операцию сразу забирают со следующей попыткой
+	require.NoError(t, store.RetryOperation(ctx, op.ID, "agent-1", "connection refused", domain.Now().Add(-time.Second)))
+	assert.Equal(t, "pending", getTask(t, store, "alice", "task-1").Status)
+
+	retried, err := store.ClaimOperation(ctx, "agent-2", lease)
+	require.NoError(t, err)
+	require.NotNil(t, retried)
+	assert.Equal(t, op.ID, retried.ID)
+	assert.Equal(t, 2, retried.Attempts)
+	assert.Equal(t, "connection refused", retried.LastError)
+
+	// До времени повтора операция не выдается
+	require.NoError(t, store.RetryOperation(ctx, op.ID, "agent-2", "connection refused", domain.Now().Add(time.Hour)))
+	none, err := store.ClaimOperation(ctx, "agent-3", lease)
+	require.NoError(t, err)
+	assert.Nil(t, none)
+}
+
+func testDeadLetter(t *testing.T, store domain.Store) {
+	ctx := context.Background()
+	createUser(t, store, "alice")
+	createUser(t, store, "bob")
+	createTask(t, store, "alice", "task-1", "(1 + 2) * (3 + 4)")
+
+	op, err := store.ClaimOperation(ctx, "agent-1", lease)
+	require.NoError(t, err)
+	require.NotNil(t, op)
+
+	require.NoError(t, store.DeadLetterOperation(ctx, op.ID, "agent-1", "operation failed after 5 attempts"))
+
+	task := getTask(t, store, "alice", "task-1")
+	assert.Equal(t, "dead", task.Status)
+	assert.Equal(t, "operation failed after 5 attempts", task.ErrorMessage)
+	assert.NotNil(t, task.FinishedAt)
+
+	// Остальные операции задачи тоже не выдаются
+	none, err := store.ClaimOperation(ctx, "agent-2", lease)
+	require.NoError(t, err)
+	assert.Nil(t, none)
+
+	_, err = store.RetryTaskForUser(ctx, "bob", "task-1")
+	assert.ErrorIs(t, err, domain.ErrTaskNotFound)
+
+	// Ручной повтор возвращает задачу в очередь с обнуленными попытками
+	task, err = store.RetryTaskForUser(ctx, "alice", "task-1")
+	require.NoError(t, err)
+	assert.Equal(t, "pending", task.Status)
+	assert.Empty(t, task.ErrorMessage)
+	assert.Nil(t, task.FinishedAt)
+
+	for i := 0; i < 2; i++ {
+		retried, err := store.ClaimOperation(ctx, "agent-2", lease)
+		require.NoError(t, err)
+		require.NotNil(t, retried)
+		assert.Equal(t, "+", retried.Operator)
+		assert.Equal(t, 1, retried.Attempts)
+	}
+	// Умножение ждёт результатов сложений
+	none, err = store.ClaimOperation(ctx, "agent-2", lease)
+	require.NoError(t, err)
+	assert.Nil(t, none)
+
+	_, err = store.RetryTaskForUser(ctx, "alice", "task-1")
+	assert.ErrorIs(t, err, domain.ErrTaskNotDead)
+}
+
+func testCancelTask(t *testing.T, store domain.Store) {
+	ctx := context.Background()
+	createUser(t, store, "alice")
+	createUser(t, store, "bob")
+	createTask(t, store, "alice", "task-1", "(1 + 2) * (3 + 4)")
+
+	op, err := store.ClaimOperation(ctx, "agent-1", lease)
+	require.NoError(t, err)
+	require.NotNil(t, op)
+
+	// Чужую задачу отменить нельзя
+	_, err = store.CancelTaskForUser(ctx, "bob", "task-1")
+	assert.ErrorIs(t, err, domain.ErrTaskNotFound)
+	_, err = store.CancelTaskForUser(ctx, "alice", "missing")
+	assert.ErrorIs(t, err, domain.ErrTaskNotFound)
+
+	task, err := store.CancelTaskForUser(ctx, "alice", "task-1")
+	require.NoError(t, err)
+	assert.Equal(t, "cancelled", task.Status)
+	assert.NotNil(t, task.FinishedAt)
+	assert.Equal(t, "cancelled", getTask(t, store, "alice", "task-1").Status)
+
+	// Агент, вычисляющий операцию, теряет аренду и не может записать результат
+	assert.ErrorIs(t, store.RenewLease(ctx, op.ID, "agent-1", lease), domain.ErrLeaseLost)
+	assert.ErrorIs(t, store.CompleteOperation(ctx, op.ID, "agent-1", 3), domain.ErrLeaseLost)
+
+	// Остальные операции больше не выдаются
+	none, err := store.ClaimOperation(ctx, "agent-2", lease)
+	require.NoError(t, err)
+	assert.Nil(t, none)
+
+	// Завершенную задачу повторно не отменить
+	_, err = store.CancelTaskForUser(ctx, "alice", "task-1")
+	assert.ErrorIs(t, err, domain.ErrTaskFinished)
+}
+
+func testConcurrentClaims(t *testing.T, store domain.Store) {
+	ctx := context.Background()
+	createUser(t, store, "alice")
+
+	const tasks = 20
+	for i := 0; i < tasks; i++ {
+		createTask(t, store, "alice", fmt.Sprintf("task-%d", i), "1 + 1")
+	}
+
+	var mu sync.Mutex
+	claimed := make(map[string]int)
+	var wg sync.WaitGroup
+	for worker := 0; worker < 5; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			owner := fmt.Sprintf("agent-%d", worker)
+			for {
+				op, err := store.ClaimOperation(ctx, owner, lease)
+				if !assert.NoError(t, err) || op == nil {
+					return
+				}
+				mu.Lock()
+				claimed[op.ID]++
+				mu.Unlock()
+			}
+		}(worker)
+	}
+	wg.Wait()
+
+	// Каждая операция выдана ровно один раз
+	assert.Len(t, claimed, tasks)
+	for id, count := range claimed {
+		assert.Equal(t, 1, count, "operation %s claimed %d times", id, count)
+	}
+}
+
+func testDurations(t *testing.T, store domain.Store) {
+	ctx := context.Background()
+
+	durations, err := store.GetDurations(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, durations)
+
+	require.NoError(t, store.InitDurations(ctx, map[string]int{"+": 1000, "*": 2000}))
+	require.NoError(t, store.SetDurations(ctx, map[string]int{"+": 500, "/": 3000}))
+
+	// Повторная инициализация не затирает значения, измененные через API
+	require.NoError(t, store.InitDurations(ctx, map[string]int{"+": 1000, "*": 9999, "-": 4000}))
+
+	durations, err = store.GetDurations(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"+": 500, "*": 2000, "/": 3000, "-": 4000}, durations)
+}
+
+func registerAgent(t *testing.T, store domain.Store, id string) {
+	t.Helper()
+	agent := domain.AgentInfo{ID: id, Hostname: "host", Workers: 2, Operators: []string{"+", "-"}, Version: "1.0"}
+	require.NoError(t, store.RegisterAgent(context.Background(), agent))
+}
+
+func testAgents(t *testing.T, store domain.Store) {
+	ctx := context.Background()
+
+	agents, err := store.ListAgents(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, agents)
+
+	// Сигнал незарегистрированного агента отклоняется
+	assert.ErrorIs(t, store.HeartbeatAgent(ctx, "agent-1"), domain.ErrAgentNotFound)
+
+	registerAgent(t, store, "agent-2")
+	registerAgent(t, store, "agent-1")
+	require.NoError(t, store.HeartbeatAgent(ctx, "agent-1"))
+
+	createUser(t, store, "alice")
+	createTask(t, store, "alice", "task-1", "2 + 3")
+	createTask(t, store, "alice", "task-2", "4 + 5")
+	op, err := store.ClaimOperation(ctx, "agent-1", lease)
+	require.NoError(t, err)
+	require.NotNil(t, op)
+	require.NoError(t, store.CompleteOperation(ctx, op.ID, "agent-1", 5))
+	op, err = store.ClaimOperation(ctx, "agent-1", lease)
+	require.NoError(t, err)
+	require.NotNil(t, op)
+
+	agents, err = store.ListAgents(ctx)
+	require.NoError(t, err)
+	require.Len(t, agents, 2)
+	agent := agents[0]
+	assert.Equal(t, "agent-1", agent.ID)
+	assert.Equal(t, "host", agent.Hostname)
+	assert.Equal(t, 2, agent.Workers)
+	assert.Equal(t, []string{"+", "-"}, agent.Operators)
+	assert.Equal(t, "1.0", agent.Version)
+	assert.Equal(t, domain.AgentOnline, agent.Status)
+	assert.False(t, agent.RegisteredAt.IsZero())
+	assert.False(t, agent.LastHeartbeat.Before(agent.RegisteredAt))
+	assert.Equal(t, 1, agent.CompletedOperations)
+	assert.Equal(t, []string{"task-2"}, agent.CurrentTasks)
+	assert.Equal(t, "agent-2", agents[1].ID)
+	assert.Empty(t, agents[1].CurrentTasks)
+
+	// Повторная регистрация обновляет описание, но не сбрасывает статистику
+	require.NoError(t, store.RegisterAgent(ctx, domain.AgentInfo{ID: "agent-1", Hostname: "other", Workers: 4, Operators: []string{"*"}, Version: "1.1"}))
+	agents, err = store.ListAgents(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "other", agents[0].Hostname)
+	assert.Equal(t, 4, agents[0].Workers)
+	assert.Equal(t, []string{"*"}, agents[0].Operators)
+	assert.Equal(t, 1, agents[0].CompletedOperations)
+	assert.True(t, agents[0].RegisteredAt.Equal(agent.RegisteredAt))
+}
+
+func testExpireAgents(t *testing.T, store domain.Store) {
+	ctx := context.Background()
+	createUser(t, store, "alice")
+	createTask(t, store, "alice", "task-1", "2 + 3")
+
+	registerAgent(t, store, "agent-1")
+	op, err := store.ClaimOperation(ctx, "agent-1", lease)
+	require.NoError(t, err)
+	require.NotNil(t, op)
+
+	// Агенты с недавним сигналом остаются online
+	expired, err := store.ExpireAgents(ctx, domain.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	assert.Empty(t, expired)
+
+	expired, err = store.ExpireAgents(ctx, domain.Now().Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"agent-1"}, expired)
+
+	agents, err := store.ListAgents(ctx)
+	require.NoError(t, err)
+	require.Len(t, agents, 1)
+	assert.Equal(t, domain.AgentOffline, agents[0].Status)
+	assert.Empty(t, agents[0].CurrentTasks)
+	assert.Equal(t, "pending", getTask(t, store, "alice", "task-1").Status)
+
+	// Операцию offline-агента сразу, не дожидаясь аренды, забирает другой агент
+	reclaimed, err := store.ClaimOperation(ctx, "agent-2", lease)
+	require.NoError(t, err)
+	require.NotNil(t, reclaimed)
+	assert.Equal(t, op.ID, reclaimed.ID)
+	assert.Equal(t, 2, reclaimed.Attempts)
+	assert.ErrorIs(t, store.CompleteOperation(ctx, op.ID, "agent-1", 5), domain.ErrLeaseLost)
+
+	// Offline-агент уже не переводится в offline повторно, а сигнал возвращает его в online
+	expired, err = store.ExpireAgents(ctx, domain.Now().Add(time.Second))
+	require.NoError(t, err)
+	assert.Empty(t, expired)
+	require.NoError(t, store.HeartbeatAgent(ctx, "agent-1"))
+	agents, err = store.ListAgents(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, domain.AgentOnline, agents[0].Status)
+}
+
+func createRefreshToken(t *testing.T, store domain.Store, login, hash, sessionID string, ttl time.Duration) {
+	t.Helper()
+	now := domain.Now()
+	token := domain.RefreshToken{Hash: hash, Login: login, SessionID: sessionID, CreatedAt: now, ExpiresAt: now.Add(ttl)}
+	require.NoError(t, store.CreateRefreshToken(context.Background(), token))
+}
+
+func testRefreshTokens(t *testing.T, store domain.Store) {
+	ctx := context.Background()
+	createUser(t, store, "alice")
+
+	token := domain.RefreshToken{Hash: "h0", Login: "bob", SessionID: "s1", CreatedAt: domain.Now(), ExpiresAt: domain.Now().Add(time.Hour)}
+	assert.ErrorIs(t, store.CreateRefreshToken(ctx, token), domain.ErrUserNotFound)
+
+	// Ротация выдает токен той же сессии
+	createRefreshToken(t, store, "alice", "h1", "s1", time.Hour)
+	next, err := store.RotateRefreshToken(ctx, "h1", "h2", domain.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, "h2", next.Hash)
+	assert.Equal(t, "alice", next.Login)
+	assert.Equal(t, "s1", next.SessionID)
+	assert.Nil(t, next.RevokedAt)
+
+	next, err = store.RotateRefreshToken(ctx, "h2", "h3", domain.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, "h3", next.Hash)
+
+	_, err = store.RotateRefreshToken(ctx, "unknown", "h4", domain.Now().Add(time.Hour))
+	assert.ErrorIs(t, err, domain.ErrTokenNotFound)
+
+	// Истекший токен не обменивается, а после удаления истекших неизвестен
+	createRefreshToken(t, store, "alice", "expired", "s2", -time.Second)
+	_, err = store.RotateRefreshToken(ctx, "expired", "h5", domain.Now().Add(time.Hour))
+	assert.ErrorIs(t, err, domain.ErrTokenNotFound)
+	require.NoError(t, store.DeleteExpiredTokens(ctx, domain.Now()))
+	_, err = store.RotateRefreshToken(ctx, "h3", "h6", domain.Now().Add(time.Hour))
+	require.NoError(t, err)
+
+	// Выход отзывает все токены сессии, другие сессии продолжают работать
+	createRefreshToken(t, store, "alice", "other", "s3", time.Hour)
+	require.NoError(t, store.RevokeSession(ctx, "s1"))
+	_, err = store.RotateRefreshToken(ctx, "h6", "h7", domain.Now().Add(time.Hour))
+	assert.ErrorIs(t, err, domain.ErrTokenReused)
+	_, err = store.RotateRefreshToken(ctx, "other", "other-2", domain.Now().Add(time.Hour))
+	require.NoError(t, err)
+
+	// Удаляются токены, истекшие к переданному моменту
+	require.NoError(t, store.DeleteExpiredTokens(ctx, domain.Now().Add(2*time.Hour)))
+	_, err = store.RotateRefreshToken(ctx, "other-2", "other-3", domain.Now().Add(time.Hour))
+	assert.ErrorIs(t, err, domain.ErrTokenNotFound)
+}
+
+func testRefreshTokenReuse(t *testing.T, store domain.Store) {
+	ctx := context.Background()
+	createUser(t, store, "alice")
+	createRefreshToken(t, store, "alice", "h1", "s1", time.Hour)
+	createRefreshToken(t, store, "alice", "other", "s2", time.Hour)
+
+	_, err := store.RotateRefreshToken(ctx, "h1", "h2", domain.Now().Add(time.Hour))
+	require.NoError(t, err)
+
+	// Повторное предъявление обмененного токена отзывает всю сессию,
+	// в том числе токен, полученный при ротации
+	_, err = store.RotateRefreshToken(ctx, "h1", "h3", domain.Now().Add(time.Hour))
+	assert.ErrorIs(t, err, domain.ErrTokenReused)
+	_, err = store.RotateRefreshToken(ctx, "h2", "h4", domain.Now().Add(time.Hour))
+	assert.ErrorIs(t, err, domain.ErrTokenReused)
+
+	// Другие сессии пользователя не затрагиваются
+	_, err = store.RotateRefreshToken(ctx, "other", "other-2", domain.Now().Add(time.Hour))
+	assert.NoError(t, err)
+}
+
+func testAccessTokenDenylist(t *testing.T, store domain.Store) {
+	ctx := context.Background()
+
+	denied, err := store.IsAccessTokenDenied(ctx, "jti-1", "session-1")
+	require.NoError(t, err)
+	assert.False(t, denied)
+
+	require.NoError(t, store.DenyAccessToken(ctx, "jti-1", domain.Now().Add(time.Hour)))
+	require.NoError(t, store.DenyAccessToken(ctx, "jti-1", domain.Now().Add(time.Hour)))
+	require.NoError(t, store.DenyAccessToken(ctx, "jti-2", domain.Now().Add(-time.Second)))
+	denied, err = store.IsAccessTokenDenied(ctx, "jti-1", "session-1")
+	require.NoError(t, err)
+	assert.True(t, denied)
+
+	// Запрет сессии действует на любой её токен; повторный запрет с более
+	// ранним сроком его не сокращает
+	require.NoError(t, store.DenySession(ctx, "session-2", domain.Now().Add(time.Hour)))
+	require.NoError(t, store.DenySession(ctx, "session-2", domain.Now().Add(-time.Second)))
+	require.NoError(t, store.DenySession(ctx, "session-3", domain.Now().Add(-time.Second)))
+	denied, err = store.IsAccessTokenDenied(ctx, "jti-3", "session-2")
+	require.NoError(t, err)
+	assert.True(t, denied)
+	denied, err = store.IsAccessTokenDenied(ctx, "jti-3", "session-1")
+	require.NoError(t, err)
+	assert.False(t, denied)
+
+	// Запись нужна, только пока сам токен не истек
+	require.NoError(t, store.DeleteExpiredTokens(ctx, domain.Now()))
+	for _, tt := range []struct {
+		jti, sessionID string
+		denied         bool
+	}{
+		{"jti-1", "session-1", true},
+		{"jti-2", "session-1", false},
+		{"jti-3", "session-2", true},
+		{"jti-3", "session-3", false},
+	} {
+		denied, err = store.IsAccessTokenDenied(ctx, tt.jti, tt.sessionID)
+		require.NoError(t, err)
+		assert.Equal(t, tt.denied, denied, "%s %s", tt.jti, tt.sessionID)
+	}
+}